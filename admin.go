@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/adnanh/webhook/internal/hook"
+	"github.com/gorilla/mux"
+)
+
+// adminPrefix is the URL path under which the admin endpoints are served.
+const adminPrefix = "/_admin"
+
+// adminAuth rejects requests that don't carry the admin bearer token.
+func adminAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !adminAuthorized(r, token) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="webhook"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// adminAuthorized reports whether r carries the admin bearer token.  No request
+// is authorized if token is empty.
+func adminAuthorized(r *http.Request, token string) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// registerAdminRoutes adds the admin endpoints, authenticated with token, to
+// r:
+//
+//	GET  /_admin/services               state of the service hooks
+//	POST /_admin/services/{id}/reload   send SIGHUP to the service
+//	POST /_admin/services/{id}/stop     stop the service until restarted
+//	POST /_admin/services/{id}/restart  restart the service
+//
+//	GET  /_admin/usage                  resource usage of executions per hook,
+//	                                    optionally filtered with ?hook=id
+//	                                    or ?group=id
+//	GET  /_admin/detections             payloads sent with a wrong Content-Type
+//	GET  /_admin/executions/{id}        execution for the request id, ie. the
+//	                                    job ID of a response-deadline reply
+//
+//	GET  /_admin/hooks                  loaded hooks
+//	GET  /_admin/executions             recent executions, newest first,
+//	                                    optionally filtered with ?hook=id
+//	                                    and limited with ?limit=n
+//	POST /_admin/hooks/{id}/trigger     send the request described by the
+//	                                    JSON TriggerArgs in the body to the
+//	                                    hook and return the TriggerReply
+//
+//	GET    /_admin/hooks/{id}/callers           allowed callers of the hook
+//	PUT    /_admin/hooks/{id}/callers           replace them with a JSON array
+//	DELETE /_admin/hooks/{id}/callers           revert to the hook's allowed-callers
+//	PUT    /_admin/hooks/{id}/callers/{caller}  allow a caller
+//	DELETE /_admin/hooks/{id}/callers/{caller}  disallow a caller
+//
+//	GET    /_admin/hooks/{id}/disabled  whether the hook is disabled
+//	PUT    /_admin/hooks/{id}/disabled  disable or enable it with a JSON boolean
+//	DELETE /_admin/hooks/{id}/disabled  revert to the hook's disabled setting
+func registerAdminRoutes(r *mux.Router, token string) *mux.Router {
+	sr := r.PathPrefix(adminPrefix).Subrouter()
+	sr.Use(adminAuth(token))
+
+	sr.HandleFunc("/services", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, services.List())
+	}).Methods(http.MethodGet)
+
+	sr.HandleFunc("/usage", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, usage.List(req.URL.Query().Get("hook"), req.URL.Query().Get("group")))
+	}).Methods(http.MethodGet)
+
+	sr.HandleFunc("/detections", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, detections.List())
+	}).Methods(http.MethodGet)
+
+	sr.HandleFunc("/hooks", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, listHooks())
+	}).Methods(http.MethodGet)
+
+	sr.HandleFunc("/executions", func(w http.ResponseWriter, req *http.Request) {
+		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+		writeJSON(w, executions.List(req.URL.Query().Get("hook"), limit))
+	}).Methods(http.MethodGet)
+
+	sr.HandleFunc("/executions/{id}", func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+
+		e, ok := executions.Find(id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Execution %s not found.", id), http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, e)
+	}).Methods(http.MethodGet)
+
+	sr.HandleFunc("/services/{id}/{action:reload|stop|restart}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		id, action := vars["id"], vars["action"]
+
+		// A reload pruning the service between the lookup and the action
+		// would leave it running for a removed hook.
+		hooksMu.RLock()
+		defer hooksMu.RUnlock()
+
+		h := findLoadedHook(id)
+		if h == nil || h.Kind != hook.KindService {
+			http.Error(w, fmt.Sprintf("Service %s not found.", id), http.StatusNotFound)
+			return
+		}
+
+		var err error
+
+		switch action {
+		case "reload":
+			err = services.Signal(id, syscall.SIGHUP)
+		case "stop":
+			err = services.Stop(id)
+		case "restart":
+			err = services.Restart(h)
+		}
+
+		if err != nil {
+			log.Printf("admin: error performing %s on service %s: %s", action, id, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		fmt.Fprint(w, "OK")
+	}).Methods(http.MethodPost)
+
+	sr.HandleFunc("/hooks/{id}/trigger", func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+
+		if matchLoadedHook(id) == nil {
+			http.Error(w, fmt.Sprintf("Hook %s not found.", id), http.StatusNotFound)
+			return
+		}
+
+		var args TriggerArgs
+		if err := json.NewDecoder(req.Body).Decode(&args); err != nil && err != io.EOF {
+			http.Error(w, fmt.Sprintf("error decoding request: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		args.ID = id
+
+		log.Printf("admin: triggering hook %s", id)
+
+		var reply TriggerReply
+		if err := triggerHook(r, &args, &reply); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, reply)
+	}).Methods(http.MethodPost)
+
+	sr.HandleFunc("/hooks/{id}/callers", func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+
+		h := matchLoadedHook(id)
+		if h == nil {
+			http.Error(w, fmt.Sprintf("Hook %s not found.", id), http.StatusNotFound)
+			return
+		}
+
+		switch req.Method {
+		case http.MethodPut:
+			var list []string
+			if err := json.NewDecoder(req.Body).Decode(&list); err != nil {
+				http.Error(w, fmt.Sprintf("error decoding callers: %s", err), http.StatusBadRequest)
+				return
+			}
+
+			log.Printf("admin: setting allowed callers of hook %s to %q", id, list)
+			callers.Set(id, list)
+		case http.MethodDelete:
+			log.Printf("admin: resetting allowed callers of hook %s", id)
+			callers.Reset(id)
+		}
+
+		writeCallers(w, h)
+	}).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+
+	sr.HandleFunc("/hooks/{id}/callers/{caller}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		id, caller := vars["id"], vars["caller"]
+
+		h := matchLoadedHook(id)
+		if h == nil {
+			http.Error(w, fmt.Sprintf("Hook %s not found.", id), http.StatusNotFound)
+			return
+		}
+
+		if req.Method == http.MethodPut {
+			log.Printf("admin: allowing caller %q for hook %s", caller, id)
+			callers.Add(h, caller)
+		} else {
+			log.Printf("admin: disallowing caller %q for hook %s", caller, id)
+			callers.Remove(h, caller)
+		}
+
+		writeCallers(w, h)
+	}).Methods(http.MethodPut, http.MethodDelete)
+
+	sr.HandleFunc("/hooks/{id}/disabled", func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+
+		h := matchLoadedHook(id)
+		if h == nil {
+			http.Error(w, fmt.Sprintf("Hook %s not found.", id), http.StatusNotFound)
+			return
+		}
+
+		switch req.Method {
+		case http.MethodPut:
+			var disabled bool
+			if err := json.NewDecoder(req.Body).Decode(&disabled); err != nil {
+				http.Error(w, fmt.Sprintf("error decoding disabled: %s", err), http.StatusBadRequest)
+				return
+			}
+
+			log.Printf("admin: setting disabled of hook %s to %t", id, disabled)
+			switches.Set(id, disabled)
+		case http.MethodDelete:
+			log.Printf("admin: resetting disabled of hook %s", id)
+			switches.Reset(id)
+		}
+
+		writeJSON(w, struct {
+			Hook     string `json:"hook"`
+			Disabled bool   `json:"disabled"`
+		}{h.ID, switches.Disabled(h)})
+	}).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
+
+	return sr
+}
+
+// writeCallers writes the allowed callers of h as the JSON response body.
+func writeCallers(w http.ResponseWriter, h *hook.Hook) {
+	list, restricted := callers.Get(h)
+	if list == nil {
+		list = []string{}
+	}
+
+	writeJSON(w, struct {
+		Hook       string   `json:"hook"`
+		Restricted bool     `json:"restricted"`
+		Callers    []string `json:"callers"`
+	}{h.ID, restricted, list})
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding response: %s", err)
+	}
+}
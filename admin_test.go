@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+	"github.com/gorilla/mux"
+)
+
+func TestAdminAuth(t *testing.T) {
+	h := adminAuth("s3cret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tt := range []struct {
+		auth   string
+		status int
+	}{
+		{"Bearer s3cret", http.StatusOK},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/_admin/services", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("Authorization %q: expected status %d, got %d", tt.auth, tt.status, rec.Code)
+		}
+	}
+}
+
+func TestAdminServiceRestartWhileReloading(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "webhook-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "service.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexec cat >/dev/null\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	hooksFile := filepath.Join(dir, "hooks.json")
+	if err := ioutil.WriteFile(hooksFile, []byte(`[{"id": "svc", "kind": "service", "execute-command": "`+script+`"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(h map[string]hook.Hooks, files hook.HooksFiles, s *serviceSupervisor) {
+		loadedHooksFromFiles, hooksFiles, services = h, files, s
+	}(loadedHooksFromFiles, hooksFiles, services)
+
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+	hooksFiles = hook.HooksFiles{hooksFile}
+	services = &serviceSupervisor{}
+	defer services.Prune(func(string) *hook.Hook { return nil })
+
+	reloadAllHooks()
+
+	r := mux.NewRouter()
+	registerAdminRoutes(r, "s3cret")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 20; i++ {
+			reloadAllHooks()
+		}
+	}()
+
+	// Run with -race to check that the endpoints don't race with reloads.
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			req := httptest.NewRequest("POST", "/_admin/services/svc/restart", nil)
+			req.Header.Set("Authorization", "Bearer s3cret")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	// Once the service is removed, restarting it is refused.
+	if err := ioutil.WriteFile(hooksFile, []byte(`[{"id": "other", "execute-command": "/bin/true"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloadAllHooks()
+
+	req := httptest.NewRequest("POST", "/_admin/services/svc/restart", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d restarting a removed service, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	if info := services.List(); len(info) != 0 {
+		t.Errorf("expected no service left running, got %+v", info)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpPublishTimeout is how long publishing an event may take, including
+// connecting to the broker and waiting for its confirmation.
+var amqpPublishTimeout = 10 * time.Second
+
+// amqpPublisher publishes events to an AMQP 0-9-1 exchange, ie. of RabbitMQ,
+// as persistent JSON messages.  The connection and its channel are kept
+// between events, and opened again once they fail.  The channel is in confirm
+// mode, so events are only published once the broker acknowledged them.
+type amqpPublisher struct {
+	url        string
+	tls        *tls.Config
+	exchange   string
+	routingKey string
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// newAMQPPublisher returns a publisher to the broker given by the amqp:// or
+// amqps:// URL u, with the exchange and routing key of its query.  Events are
+// published to the default exchange by default, which routes them to the
+// queue named by the routing key, webhook.events by default.
+func newAMQPPublisher(u *url.URL, tlsConfig *tls.Config) *amqpPublisher {
+	q := u.Query()
+
+	p := &amqpPublisher{tls: tlsConfig, exchange: q.Get("exchange"), routingKey: q.Get("routing-key")}
+
+	if p.routingKey == "" {
+		p.routingKey = "webhook.events"
+	}
+
+	// The remaining parameters, such as heartbeat, are the client's.
+	q.Del("exchange")
+	q.Del("routing-key")
+
+	dial := *u
+	dial.RawQuery = q.Encode()
+	p.url = dial.String()
+
+	return p
+}
+
+// Publish implements eventPublisher.
+func (p *amqpPublisher) Publish(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), amqpPublishTimeout)
+	defer cancel()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	msg := amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+		Body:         data,
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.exchange, p.routingKey, false, false, msg)
+	if err != nil {
+		p.close()
+		return err
+	}
+
+	ok, err := confirm.WaitContext(ctx)
+	if err != nil {
+		p.close()
+		return err
+	}
+
+	if !ok {
+		return errors.New("amqp: event rejected by the broker")
+	}
+
+	return nil
+}
+
+// channel returns the channel events are published on, connecting to the
+// broker if the connection isn't open.
+func (p *amqpPublisher) channel() (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() && !p.conn.IsClosed() {
+		return p.ch, nil
+	}
+
+	p.close()
+
+	conn, err := amqp.DialConfig(p.url, amqp.Config{
+		Dial:            amqp.DefaultDial(5 * time.Second),
+		TLSClientConfig: p.tls.Clone(),
+		Properties:      amqp.Table{"product": "webhook"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p.conn, p.ch = conn, ch
+
+	return ch, nil
+}
+
+// close closes the connection to the broker, if open.
+func (p *amqpPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+
+	p.conn, p.ch = nil, nil
+}
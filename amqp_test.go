@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// amqpFrame is a frame of the AMQP 0-9-1 protocol.
+type amqpFrame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+func readAMQPFrame(r io.Reader) (amqpFrame, error) {
+	var h [7]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return amqpFrame{}, err
+	}
+
+	f := amqpFrame{typ: h[0], channel: binary.BigEndian.Uint16(h[1:3]), payload: make([]byte, binary.BigEndian.Uint32(h[3:7])+1)}
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return amqpFrame{}, err
+	}
+
+	f.payload = f.payload[:len(f.payload)-1]
+
+	return f, nil
+}
+
+// writeAMQPMethod writes the method class.method with the encoded arguments
+// args on the channel.
+func writeAMQPMethod(w io.Writer, channel, class, method uint16, args ...interface{}) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, class)
+	binary.Write(&b, binary.BigEndian, method)
+
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string: // short string
+			b.WriteByte(byte(len(v)))
+			b.WriteString(v)
+		case []byte: // long string
+			binary.Write(&b, binary.BigEndian, uint32(len(v)))
+			b.Write(v)
+		default:
+			binary.Write(&b, binary.BigEndian, v)
+		}
+	}
+
+	var h [7]byte
+	h[0] = 1
+	binary.BigEndian.PutUint16(h[1:3], channel)
+	binary.BigEndian.PutUint32(h[3:7], uint32(b.Len()))
+
+	w.Write(append(append(h[:], b.Bytes()...), 0xce))
+}
+
+// amqpShortString decodes a short string from b.
+func amqpShortString(b *bytes.Reader) string {
+	n, _ := b.ReadByte()
+	s := make([]byte, n)
+	io.ReadFull(b, s)
+	return string(s)
+}
+
+// fakeAMQPBroker accepts connections authenticated with user:secret, sends
+// "exchange routing-key body" of published messages to received, and
+// acknowledges them, except those with the routing key "reject".  It serves
+// over TLS if cfg is set, and sends the accepted connections to conns.
+func fakeAMQPBroker(t *testing.T, received chan<- string, conns chan<- net.Conn, cfg *tls.Config) string {
+	t.Helper()
+
+	return fakeEventServer(t, func(conn net.Conn) {
+		if cfg != nil {
+			conn = tls.Server(conn, cfg)
+		}
+
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil || string(header) != "AMQP\x00\x00\x09\x01" {
+			return
+		}
+
+		writeAMQPMethod(conn, 0, 10, 10, uint8(0), uint8(9), []byte{}, []byte("PLAIN"), []byte("en_US"))
+
+		// Connection.StartOk: client properties, mechanism, response.
+		f, err := readAMQPFrame(conn)
+		if err != nil {
+			return
+		}
+
+		b := bytes.NewReader(f.payload[4:])
+		var n uint32
+		binary.Read(b, binary.BigEndian, &n)
+		b.Seek(int64(n), io.SeekCurrent)
+		amqpShortString(b)
+		binary.Read(b, binary.BigEndian, &n)
+		response := make([]byte, n)
+		io.ReadFull(b, response)
+
+		// Like RabbitMQ, close the connection of failed logins.
+		if string(response) != "\x00user\x00secret" {
+			return
+		}
+
+		writeAMQPMethod(conn, 0, 10, 30, uint16(0), uint32(131072), uint16(0))
+
+		// Connection.TuneOk and Connection.Open.
+		for i := 0; i < 2; i++ {
+			if _, err := readAMQPFrame(conn); err != nil {
+				return
+			}
+		}
+
+		writeAMQPMethod(conn, 0, 10, 41, "")
+
+		if conns != nil {
+			conns <- conn
+		}
+
+		var tag uint64
+
+		for {
+			f, err := readAMQPFrame(conn)
+			if err != nil {
+				return
+			}
+
+			if f.typ != 1 {
+				continue // heartbeat
+			}
+
+			class, method := binary.BigEndian.Uint16(f.payload), binary.BigEndian.Uint16(f.payload[2:])
+			b := bytes.NewReader(f.payload[4:])
+
+			switch {
+			case class == 20 && method == 10: // Channel.Open
+				writeAMQPMethod(conn, f.channel, 20, 11, []byte{})
+
+			case class == 85 && method == 10: // Confirm.Select
+				writeAMQPMethod(conn, f.channel, 85, 11)
+
+			case class == 60 && method == 40: // Basic.Publish
+				b.Seek(2, io.SeekCurrent)
+				exchange, key := amqpShortString(b), amqpShortString(b)
+
+				// The content header, with the body size, and the body.
+				h, err := readAMQPFrame(conn)
+				if err != nil {
+					return
+				}
+
+				size := binary.BigEndian.Uint64(h.payload[4:12])
+
+				var body []byte
+				for uint64(len(body)) < size {
+					f, err := readAMQPFrame(conn)
+					if err != nil {
+						return
+					}
+					body = append(body, f.payload...)
+				}
+
+				tag++
+
+				if key == "reject" {
+					writeAMQPMethod(conn, f.channel, 60, 120, tag, uint8(0))
+					continue
+				}
+
+				received <- exchange + " " + key + " " + string(body)
+				writeAMQPMethod(conn, f.channel, 60, 80, tag, uint8(0))
+
+			case class == 20 && method == 40: // Channel.Close
+				writeAMQPMethod(conn, f.channel, 20, 41)
+
+			case class == 10 && method == 50: // Connection.Close
+				writeAMQPMethod(conn, 0, 10, 51)
+				return
+			}
+		}
+	})
+}
+
+func TestAMQPPublisher(t *testing.T) {
+	received := make(chan string, 10)
+	conns := make(chan net.Conn, 10)
+	addr := fakeAMQPBroker(t, received, conns, nil)
+
+	p, err := newEventPublisher("amqp://user:secret@" + addr + "/?exchange=webhook&routing-key=events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(*amqpPublisher).close()
+
+	// The connection is kept between events.
+	for _, data := range []string{`{"type":"delivery"}`, `{"type":"execution"}`} {
+		if err := p.Publish([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := <-received, "webhook events "+data; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	if n := len(conns); n != 1 {
+		t.Fatalf("got %d connections, want 1", n)
+	}
+
+	// Once the connection is lost, it is opened again.
+	(<-conns).Close()
+
+	for deadline := time.Now().Add(5 * time.Second); !p.(*amqpPublisher).conn.IsClosed(); {
+		if time.Now().After(deadline) {
+			t.Fatal("the closed connection wasn't noticed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := p.Publish([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := <-received, "webhook events {}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Events the broker doesn't acknowledge aren't published.
+	p, _ = newEventPublisher("amqp://user:secret@" + addr + "/?routing-key=reject")
+	defer p.(*amqpPublisher).close()
+
+	if err := p.Publish([]byte("{}")); err == nil {
+		t.Errorf("expected an error for a rejected event")
+	}
+
+	if p := newAMQPPublisher(&url.URL{Scheme: "amqp", Host: addr}, nil); p.exchange != "" || p.routingKey != "webhook.events" {
+		t.Errorf("expected the default exchange and the webhook.events routing key, got %q and %q", p.exchange, p.routingKey)
+	}
+}
+
+func TestAMQPPublisherTLSAndAuth(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	received := make(chan string, 1)
+	conns := make(chan net.Conn, 10)
+	addr := fakeAMQPBroker(t, received, conns, srv.TLS)
+
+	// The broker is verified against the system roots.
+	p, err := newEventPublisher("amqps://user:secret@" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Publish([]byte("{}")); err == nil {
+		t.Errorf("expected an error for an untrusted certificate")
+	}
+
+	cfg := &tls.Config{RootCAs: srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+
+	u, _ := url.Parse("amqps://user:secret@" + addr + "/")
+	ap := newAMQPPublisher(u, cfg)
+	defer ap.close()
+
+	if err := ap.Publish([]byte(`{"type":"delivery"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := <-received, ` webhook.events {"type":"delivery"}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	u, _ = url.Parse("amqps://user:wrong@" + addr + "/")
+	if err := newAMQPPublisher(u, cfg).Publish([]byte("{}")); err == nil {
+		t.Errorf("expected an error for a wrong password")
+	}
+
+	if n := len(conns); n != 1 {
+		t.Errorf("got %d logins, want 1", n)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// sendCallback POSTs the execution ex as JSON to the callback-url of h in the
+// background, unless the callback is for successful executions only and ex
+// failed.
+func sendCallback(h *hook.Hook, ex Execution) {
+	if h.CallbackURL == "" || (h.CallbackOn == hook.CallbackOnSuccess && ex.Error != "") {
+		return
+	}
+
+	// The environment may hold secrets.
+	ex.Env = nil
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		if err := postJSON(h.CallbackURL, ex); err != nil {
+			log.Printf("[%s] error sending the execution report of %s to its callback-url: %s\n", ex.RequestID, h.ID, err)
+		}
+	}()
+}
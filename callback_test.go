@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestSendCallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	bodies := make(chan []byte, 10)
+
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer h.Close()
+
+	for _, tt := range []struct {
+		command    string
+		callbackOn string
+		want       int
+	}{
+		{"true", "", 1},
+		{"false", "", 1},
+		{"true", hook.CallbackOnSuccess, 1},
+		{"false", hook.CallbackOnSuccess, 0},
+	} {
+		hk := &hook.Hook{
+			ID:             "deploy",
+			ExecuteCommand: tt.command,
+			PassArgumentsToCommand: []hook.Argument{
+				{Source: "string", Name: "production"},
+			},
+			CallbackURL: h.URL,
+			CallbackOn:  tt.callbackOn,
+		}
+
+		handleHook(hk, &hook.Request{ID: "abc"})
+		running.Wait()
+
+		if len(bodies) != tt.want {
+			t.Errorf("%s with callback-on %q: got %d callbacks, want %d", tt.command, tt.callbackOn, len(bodies), tt.want)
+		}
+
+		for len(bodies) > 0 {
+			var ex Execution
+			if err := json.Unmarshal(<-bodies, &ex); err != nil {
+				t.Fatal(err)
+			}
+
+			wantExit := 0
+			if tt.command == "false" {
+				wantExit = 1
+			}
+
+			if ex.HookID != "deploy" || ex.ExitCode != wantExit || len(ex.Args) != 2 || ex.Args[1] != "production" || ex.Started.IsZero() || ex.Env != nil {
+				t.Errorf("unexpected execution report %+v", ex)
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// callerLists holds the allowed callers of hooks set through the admin API,
+// which take precedence over the hooks' allowed-callers.  They are kept in
+// memory only, and survive reloading the hooks files.
+type callerLists struct {
+	mu    sync.Mutex
+	lists map[string][]string
+}
+
+// Get returns the allowed callers of h and whether h is restricted to them.
+func (c *callerLists) Get(h *hook.Hook) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(h)
+}
+
+func (c *callerLists) get(h *hook.Hook) ([]string, bool) {
+	if list, ok := c.lists[h.ID]; ok {
+		return list, true
+	}
+
+	return h.AllowedCallers, len(h.AllowedCallers) != 0
+}
+
+// Set replaces the allowed callers of the hook id.  An empty list denies all
+// callers.
+func (c *callerLists) Set(id string, list []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(id, list)
+}
+
+func (c *callerLists) set(id string, list []string) {
+	if c.lists == nil {
+		c.lists = make(map[string][]string)
+	}
+
+	res := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+
+	for _, caller := range list {
+		if caller = strings.TrimSpace(caller); caller != "" && !seen[caller] {
+			seen[caller] = true
+			res = append(res, caller)
+		}
+	}
+
+	sort.Strings(res)
+
+	c.lists[id] = res
+}
+
+// Add allows caller to trigger h.
+func (c *callerLists) Add(h *hook.Hook, caller string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, _ := c.get(h)
+	c.set(h.ID, append(append([]string(nil), list...), caller))
+}
+
+// Remove disallows caller from triggering h.
+func (c *callerLists) Remove(h *hook.Hook, caller string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list, _ := c.get(h)
+
+	res := make([]string, 0, len(list))
+	for _, v := range list {
+		if v != caller {
+			res = append(res, v)
+		}
+	}
+
+	c.set(h.ID, res)
+}
+
+// Reset discards the allowed callers set for the hook id, reverting to its
+// allowed-callers.
+func (c *callerLists) Reset(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.lists, id)
+}
+
+// Allowed reports whether the caller identity may trigger h.
+func (c *callerLists) Allowed(h *hook.Hook, identity *hook.Identity) bool {
+	list, restricted := c.Get(h)
+	if !restricted {
+		return true
+	}
+
+	if identity == nil {
+		return false
+	}
+
+	for _, caller := range list {
+		if caller == identity.User {
+			return true
+		}
+	}
+
+	return false
+}
+
+// callerIdentity returns the identity of the caller of r, taken from the SSO
+// proxy headers, the verified TLS client certificate's common name or the
+// subject of a JWT bearer token, in that order.  It returns nil if the caller
+// is unknown.
+func callerIdentity(r *http.Request, rid string) *hook.Identity {
+	if id := hook.NewIdentity(r.Header, *ssoUserHeader, *ssoGroupsHeader); id != nil {
+		return id
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		if cn := r.TLS.PeerCertificates[0].Subject.CommonName; cn != "" {
+			return &hook.Identity{User: cn}
+		}
+	}
+
+	if *jwtSecret != "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return nil
+		}
+
+		sub, err := verifyJWT(strings.TrimPrefix(auth, "Bearer "), []byte(*jwtSecret))
+		if err != nil {
+			log.Printf("[%s] ignoring bearer token: %s", rid, err)
+			return nil
+		}
+
+		return &hook.Identity{User: sub}
+	}
+
+	return nil
+}
+
+// verifyJWT verifies the HS256 signature and the expiry of the JSON Web Token
+// token, signed with secret, and returns its subject.
+func verifyJWT(token string, secret []byte) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", err
+	}
+
+	if header.Alg != "HS256" {
+		return "", fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed token signature: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("invalid token signature")
+	}
+
+	var claims struct {
+		Sub string   `json:"sub"`
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+
+	now := hook.Now()
+
+	if claims.Exp != nil && !now.Before(time.Unix(int64(*claims.Exp), 0)) {
+		return "", errors.New("token expired")
+	}
+
+	if claims.Nbf != nil && now.Before(time.Unix(int64(*claims.Nbf), 0)) {
+		return "", errors.New("token not valid yet")
+	}
+
+	if claims.Sub == "" {
+		return "", errors.New("token has no subject")
+	}
+
+	return claims.Sub, nil
+}
+
+// decodeJWTPart decodes the base64url encoded JSON token part s into v.
+func decodeJWTPart(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("malformed token: %w", err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("malformed token: %w", err)
+	}
+
+	return nil
+}
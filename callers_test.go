@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestCallerLists(t *testing.T) {
+	c := &callerLists{}
+
+	open := &hook.Hook{ID: "open"}
+	restricted := &hook.Hook{ID: "restricted", AllowedCallers: []string{"ci"}}
+
+	ci := &hook.Identity{User: "ci"}
+	bot := &hook.Identity{User: "bot"}
+
+	for _, tt := range []struct {
+		desc     string
+		h        *hook.Hook
+		identity *hook.Identity
+		ok       bool
+	}{
+		{"open anonymous", open, nil, true},
+		{"restricted anonymous", restricted, nil, false},
+		{"restricted allowed", restricted, ci, true},
+		{"restricted other", restricted, bot, false},
+	} {
+		if ok := c.Allowed(tt.h, tt.identity); ok != tt.ok {
+			t.Errorf("%s: expected %v, got %v", tt.desc, tt.ok, ok)
+		}
+	}
+
+	c.Add(restricted, "bot")
+	if !c.Allowed(restricted, ci) || !c.Allowed(restricted, bot) {
+		t.Error("expected ci and bot to be allowed after adding bot")
+	}
+
+	c.Remove(restricted, "ci")
+	c.Remove(restricted, "bot")
+	if c.Allowed(restricted, ci) || c.Allowed(restricted, bot) {
+		t.Error("expected an empty list to deny all callers")
+	}
+
+	c.Set(open.ID, []string{" bot ", "bot", ""})
+	if list, _ := c.Get(open); len(list) != 1 || list[0] != "bot" {
+		t.Errorf("unexpected list %q", list)
+	}
+
+	c.Reset(restricted.ID)
+	if !c.Allowed(restricted, ci) {
+		t.Error("expected reset to revert to allowed-callers")
+	}
+}
+
+func TestCallerListsConcurrentAdd(t *testing.T) {
+	c := &callerLists{}
+	h := &hook.Hook{ID: "deploy"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Add(h, fmt.Sprintf("caller%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	// No update is lost to a concurrent one.
+	if list, _ := c.Get(h); len(list) != 50 {
+		t.Errorf("expected 50 callers, got %d", len(list))
+	}
+}
+
+func TestVerifyJWT(t *testing.T) {
+	defer func(now func() time.Time) { hook.Now = now }(hook.Now)
+	hook.Now = func() time.Time { return time.Unix(1600000000, 0) }
+
+	secret := []byte("s3cret")
+
+	sign := func(header, claims string, secret []byte) string {
+		s := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(s))
+		return s + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+
+	for _, tt := range []struct {
+		desc  string
+		token string
+		sub   string
+	}{
+		{"valid", sign(hs256, `{"sub":"ci","exp":1600000100,"nbf":1599999900}`, secret), "ci"},
+		{"wrong secret", sign(hs256, `{"sub":"ci"}`, []byte("other")), ""},
+		{"expired", sign(hs256, `{"sub":"ci","exp":1600000000}`, secret), ""},
+		{"not yet valid", sign(hs256, `{"sub":"ci","nbf":1600000100}`, secret), ""},
+		{"no subject", sign(hs256, `{}`, secret), ""},
+		{"alg none", sign(`{"alg":"none"}`, `{"sub":"ci"}`, secret), ""},
+		{"malformed", "abc", ""},
+	} {
+		sub, err := verifyJWT(tt.token, secret)
+		if sub != tt.sub || (tt.sub == "") != (err != nil) {
+			t.Errorf("%s: expected subject %q, got %q, %v", tt.desc, tt.sub, sub, err)
+		}
+	}
+}
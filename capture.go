@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// defaultCaptureRequestsMax is the number of captured requests kept per hook
+// unless capture-requests-max is set.
+const defaultCaptureRequestsMax = 100
+
+// captureTimeFormat is the format of the time prefixing the names of
+// captured requests, which sort by it.
+const captureTimeFormat = "20060102T150405.000000000Z"
+
+// captureMu serializes writing and pruning captured requests.
+var captureMu sync.Mutex
+
+// capturedRequest is a request written by capture-requests-to-dir.  It
+// extends the request file format of the test subcommand, so captures can be
+// replayed as is.
+type capturedRequest struct {
+	TriggerArgs
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	RawQuery   string    `json:"raw_query,omitempty"`
+}
+
+// captureRequest writes the request r to h, with the raw body, to a new file
+// in the capture directory of h and returns its path.  The oldest captures
+// are removed to keep capture-requests-max of them.
+func captureRequest(h *hook.Hook, r *http.Request, req *hook.Request, body []byte) (string, error) {
+	c := capturedRequest{
+		TriggerArgs: TriggerArgs{
+			ID:      h.ID,
+			Method:  r.Method,
+			Headers: make(map[string]string, len(r.Header)),
+			Query:   make(map[string]string),
+		},
+		Time:       time.Now().UTC(),
+		RequestID:  req.ID,
+		RemoteAddr: r.RemoteAddr,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		RawQuery:   r.URL.RawQuery,
+	}
+
+	for k, v := range r.Header {
+		c.Headers[k] = strings.Join(v, ", ")
+	}
+
+	for k, v := range r.URL.Query() {
+		c.Query[k] = v[0]
+	}
+
+	// JSON strings can't hold arbitrary bytes, which would garble binary
+	// bodies and break signatures computed over them.
+	if utf8.Valid(body) {
+		c.Body = string(body)
+	} else {
+		c.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Captures hold secrets such as tokens and signatures.
+	if err := os.MkdirAll(h.CaptureRequestsToDir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(h.CaptureRequestsToDir, c.Time.Format(captureTimeFormat)+"-"+url.PathEscape(req.ID)+".json")
+
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return "", err
+	}
+
+	max := h.CaptureRequestsMax
+	if max == 0 {
+		max = defaultCaptureRequestsMax
+	}
+
+	return path, pruneCaptures(h.CaptureRequestsToDir, max)
+}
+
+// pruneCaptures removes the oldest captured requests in dir beyond max.
+// Other files are left alone.
+func pruneCaptures(dir string, max int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.Mode().IsRegular() && strings.HasSuffix(name, ".json") && len(name) > len(captureTimeFormat) {
+			if _, err := time.Parse(captureTimeFormat, name[:len(captureTimeFormat)]); err == nil {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(names) <= max {
+		return nil
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names[:len(names)-max] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
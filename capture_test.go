@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestCaptureRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		desc       string
+		body       string
+		wantBody   string
+		wantBase64 string
+	}{
+		{"text body", `{"ref": "main"}`, `{"ref": "main"}`, ""},
+		{"binary body", "\xff\x00\xfe", "", base64.StdEncoding.EncodeToString([]byte("\xff\x00\xfe"))},
+	} {
+		h := &hook.Hook{ID: "capture", CaptureRequestsToDir: filepath.Join(dir, tt.desc)}
+
+		r := httptest.NewRequest("POST", "/hooks/capture?token=abc", strings.NewReader(tt.body))
+		r.Header.Add("X-Signature", "sha1=1234")
+		r.Header.Add("X-Forwarded-For", "10.0.0.1")
+		r.Header.Add("X-Forwarded-For", "10.0.0.2")
+
+		path, err := captureRequest(h, r, &hook.Request{ID: "a/b"}, []byte(tt.body))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+
+		if !strings.HasPrefix(path, h.CaptureRequestsToDir+string(filepath.Separator)) || !strings.HasSuffix(path, "-a%2Fb.json") {
+			t.Errorf("%s: unexpected capture path %s", tt.desc, path)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.desc, err)
+		}
+
+		var c capturedRequest
+		if err := json.Unmarshal(data, &c); err != nil {
+			t.Fatalf("%s: %s", tt.desc, err)
+		}
+
+		if c.ID != "capture" || c.Method != "POST" || c.Query["token"] != "abc" || c.RawQuery != "token=abc" {
+			t.Errorf("%s: unexpected capture %+v", tt.desc, c)
+		}
+
+		if c.Headers["X-Signature"] != "sha1=1234" || c.Headers["X-Forwarded-For"] != "10.0.0.1, 10.0.0.2" {
+			t.Errorf("%s: unexpected headers %v", tt.desc, c.Headers)
+		}
+
+		if c.Body != tt.wantBody || c.BodyBase64 != tt.wantBase64 {
+			t.Errorf("%s: body = %q, body_base64 = %q", tt.desc, c.Body, c.BodyBase64)
+		}
+	}
+}
+
+func TestCaptureRequestsMax(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Other files in the directory are kept.
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &hook.Hook{ID: "capture", CaptureRequestsToDir: dir, CaptureRequestsMax: 3}
+
+	var paths []string
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest("POST", "/hooks/capture", strings.NewReader("{}"))
+
+		path, err := captureRequest(h, r, &hook.Request{ID: strconv.Itoa(i)}, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		paths = append(paths, filepath.Base(path))
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+
+	if want := append(append([]string(nil), paths[2:]...), "notes.json"); !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %v to be kept, got %v", want, names)
+	}
+}
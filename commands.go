@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// RunningCommand is a hook command that hasn't exited yet.
+type RunningCommand struct {
+	RequestID string    `json:"request-id"`
+	HookID    string    `json:"hook-id"`
+	Command   string    `json:"command"`
+	PID       int       `json:"pid"`
+	Started   time.Time `json:"started"`
+}
+
+// commandTracker runs the hook commands and tracks them while they run, so
+// stuck ones can be found with the debug endpoints.
+type commandTracker struct {
+	mu   sync.Mutex
+	next int
+	cmds map[int]RunningCommand
+}
+
+// Run runs cmd for the request rid to h like cmd.CombinedOutput, tracking it
+// while it runs.  If h parses its command output, the output written to
+// stdout alone is returned as well; stdout and stderr are then read from
+// separate pipes, so writes to both at about the same time may be combined
+// in either order.
+func (t *commandTracker) Run(cmd *exec.Cmd, rid string, h *hook.Hook) ([]byte, []byte, error) {
+	var (
+		b      bytes.Buffer
+		stdout *bytes.Buffer
+	)
+
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+
+	if h.CommandOutputFormat != "" {
+		// stdout and stderr are copied from separate goroutines.
+		combined := &lockedWriter{w: &b}
+		stdout = &bytes.Buffer{}
+
+		cmd.Stdout = io.MultiWriter(combined, stdout)
+		cmd.Stderr = combined
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	t.mu.Lock()
+	if t.cmds == nil {
+		t.cmds = make(map[int]RunningCommand)
+	}
+
+	id := t.next
+	t.next++
+	t.cmds[id] = RunningCommand{RequestID: rid, HookID: h.ID, Command: cmd.Path, PID: cmd.Process.Pid, Started: time.Now()}
+	t.mu.Unlock()
+
+	err := cmd.Wait()
+
+	t.mu.Lock()
+	delete(t.cmds, id)
+	t.mu.Unlock()
+
+	if stdout == nil {
+		return b.Bytes(), nil, err
+	}
+
+	return b.Bytes(), stdout.Bytes(), err
+}
+
+// lockedWriter serializes the writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+// List returns the running commands, oldest first.
+func (t *commandTracker) List() []RunningCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]RunningCommand, 0, len(t.cmds))
+	for _, c := range t.cmds {
+		res = append(res, c)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Started.Before(res[j].Started) })
+
+	return res
+}
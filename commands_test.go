@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestCommandTracker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	c := &commandTracker{}
+	h := &hook.Hook{ID: "slow"}
+
+	done := make(chan []byte)
+	go func() {
+		out, _, _ := c.Run(exec.Command("sh", "-c", "echo started; sleep 1"), "abc", h)
+		done <- out
+	}()
+
+	var list []RunningCommand
+	for i := 0; i < 50 && len(list) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		list = c.List()
+	}
+
+	if len(list) != 1 || list[0].RequestID != "abc" || list[0].HookID != "slow" || list[0].PID == 0 {
+		t.Fatalf("unexpected running commands %+v", list)
+	}
+
+	if out := <-done; string(out) != "started\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if list := c.List(); len(list) != 0 {
+		t.Errorf("expected no running commands, got %+v", list)
+	}
+}
+
+func TestCommandTrackerStdout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	c := &commandTracker{}
+	cmd := exec.Command("sh", "-c", `echo 'deploying' >&2; sleep 0.1; echo '{"version": "1.2.3"}'`)
+
+	out, stdout, err := c.Run(cmd, "abc", &hook.Hook{ID: "deploy", CommandOutputFormat: hook.OutputFormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "deploying\n{\"version\": \"1.2.3\"}\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if string(stdout) != "{\"version\": \"1.2.3\"}\n" {
+		t.Errorf("unexpected stdout %q", stdout)
+	}
+
+	if _, stdout, _ := c.Run(exec.Command("true"), "def", &hook.Hook{ID: "plain"}); stdout != nil {
+		t.Errorf("expected no stdout without command-output-format, got %q", stdout)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/ghodss/yaml"
+)
+
+// applyConfigFile sets the flags of fs that weren't set on the command line
+// or from the environment from the JSON or YAML config file at path, which
+// maps flag names to their values, or lists of values for flags that can be
+// given multiple times.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+
+	b, err = yaml.YAMLToJSON(b)
+	if err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var config map[string]interface{}
+	if err := d.Decode(&config); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown parameter %q in config file %s", name, path)
+		}
+
+		if set[name] {
+			continue
+		}
+
+		values, err := configValues(config[name], isListFlag(f.Value))
+		if err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %w", name, path, err)
+		}
+
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %s in config file %s: %v", value, name, path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// configValues returns the flag values set by the config file value v, which
+// may be a list if list is set.
+func configValues(v interface{}, list bool) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string, json.Number, bool:
+		return []string{fmt.Sprint(v)}, nil
+	case []interface{}:
+		if !list {
+			return nil, fmt.Errorf("expected a single value, got a list")
+		}
+
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			switch e.(type) {
+			case string, json.Number, bool:
+				values = append(values, fmt.Sprint(e))
+			default:
+				return nil, fmt.Errorf("expected a list of single values")
+			}
+		}
+
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("expected a value, got an object")
+}
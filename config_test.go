@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"webhook.yaml": `
+port: 9100
+ip: 127.0.0.1
+verbose: true
+read-timeout: 30s
+hooks:
+- /etc/webhook/a.json
+- /etc/webhook/b.yaml
+header: X-Served-By=webhook
+`,
+		"unknown.yaml": "prot: 9100\n",
+		"list.yaml":    "port: [9100, 9101]\n",
+		"invalid.yaml": "port: http\n",
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newFlagSet := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("webhook", flag.ContinueOnError)
+		fs.Int("port", 9000, "")
+		fs.String("ip", "0.0.0.0", "")
+		fs.Bool("verbose", false, "")
+		fs.Duration("read-timeout", 0, "")
+		fs.Var(&hook.HooksFiles{}, "hooks", "")
+		fs.Var(&hook.ResponseHeaders{}, "header", "")
+		return fs
+	}
+
+	fs := newFlagSet()
+	if err := fs.Parse([]string{"-ip", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyConfigFile(fs, filepath.Join(dir, "webhook.yaml")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	get := func(name string) interface{} {
+		return fs.Lookup(name).Value.(flag.Getter).Get()
+	}
+
+	if get("port") != 9100 || get("ip") != "::1" || get("verbose") != true || get("read-timeout") != 30*time.Second {
+		t.Errorf("unexpected flags: port %v, ip %v, verbose %v, read-timeout %v", get("port"), get("ip"), get("verbose"), get("read-timeout"))
+	}
+
+	if got, expect := *fs.Lookup("hooks").Value.(*hook.HooksFiles), (hook.HooksFiles{"/etc/webhook/a.json", "/etc/webhook/b.yaml"}); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected hooks files %q, got %q", expect, got)
+	}
+
+	if headers := *fs.Lookup("header").Value.(*hook.ResponseHeaders); !reflect.DeepEqual(headers, hook.ResponseHeaders{{Name: "X-Served-By", Value: "webhook"}}) {
+		t.Errorf("unexpected headers %v", headers)
+	}
+
+	for name, expect := range map[string]string{
+		"unknown.yaml": `unknown parameter "prot"`,
+		"list.yaml":    "expected a single value",
+		"invalid.yaml": `invalid value "http" for port`,
+		"missing.yaml": "error reading config file",
+	} {
+		if err := applyConfigFile(newFlagSet(), filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, expect, err)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Control is the receiver for the JSON-RPC control socket methods.  Methods
+// are invoked as "Control.<Name>" using JSON-RPC 1.0 framing, as implemented
+// by net/rpc/jsonrpc.
+type Control struct {
+	handler http.Handler
+}
+
+// HookInfo summarizes a loaded hook.
+type HookInfo struct {
+	ID             string `json:"id"`
+	File           string `json:"file"`
+	ExecuteCommand string `json:"execute-command"`
+	URL            string `json:"url"`
+	Group          string `json:"group,omitempty"`
+	Disabled       bool   `json:"disabled,omitempty"`
+}
+
+// ListHooksArgs are the arguments to Control.ListHooks.
+type ListHooksArgs struct{}
+
+// ListHooks returns all currently loaded hooks, ordered by ID.
+func (c *Control) ListHooks(args *ListHooksArgs, reply *[]HookInfo) error {
+	*reply = listHooks()
+	return nil
+}
+
+// listHooks returns all currently loaded hooks, ordered by ID.
+func listHooks() []HookInfo {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	res := make([]HookInfo, 0, countLoadedHooks())
+
+	for file, hooks := range loadedHooksFromFiles {
+		for _, h := range hooks {
+			res = append(res, HookInfo{
+				ID:             h.ID,
+				File:           file,
+				ExecuteCommand: h.Command(),
+				URL:            makeHookURL(&h),
+				Group:          h.Group,
+				Disabled:       switches.Disabled(&h),
+			})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+	return res
+}
+
+// ReloadArgs are the arguments to Control.Reload.
+type ReloadArgs struct {
+	// File restricts the reload to a single hooks file. All hooks files are
+	// reloaded if File is empty.
+	File string `json:"file"`
+}
+
+// ReloadReply is the result of Control.Reload.
+type ReloadReply struct {
+	Hooks int `json:"hooks"`
+}
+
+// Reload reloads hooks from the hooks files, just like sending SIGUSR1 does.
+func (c *Control) Reload(args *ReloadArgs, reply *ReloadReply) error {
+	if args.File == "" {
+		reloadAllHooks()
+	} else {
+		hooksMu.RLock()
+		found := isHooksFile(args.File)
+		hooksMu.RUnlock()
+
+		if !found {
+			return fmt.Errorf("hooks file %q is not loaded", args.File)
+		}
+
+		reloadHooks(args.File)
+	}
+
+	reply.Hooks = lenLoadedHooks()
+
+	return nil
+}
+
+// SwitchArgs are the arguments to Control.Disable, Control.Enable and
+// Control.ResetDisabled.
+type SwitchArgs struct {
+	ID string `json:"id"`
+}
+
+// Disable disables the hook, like PUT /_admin/hooks/{id}/disabled does.
+func (c *Control) Disable(args *SwitchArgs, reply *HookInfo) error {
+	disabled := true
+	return switchHook(args.ID, &disabled, reply)
+}
+
+// Enable enables the hook, even if it is disabled in its hooks file.
+func (c *Control) Enable(args *SwitchArgs, reply *HookInfo) error {
+	disabled := false
+	return switchHook(args.ID, &disabled, reply)
+}
+
+// ResetDisabled reverts the hook to the disabled setting of its hooks file.
+func (c *Control) ResetDisabled(args *SwitchArgs, reply *HookInfo) error {
+	return switchHook(args.ID, nil, reply)
+}
+
+// switchHook sets the switch of the hook id to disabled, or resets it if
+// disabled is nil, and records the hook in reply.
+func switchHook(id string, disabled *bool, reply *HookInfo) error {
+	if matchLoadedHook(id) == nil {
+		return fmt.Errorf("hook %q is not loaded", id)
+	}
+
+	if disabled != nil {
+		log.Printf("control: setting disabled of hook %s to %t", id, *disabled)
+		switches.Set(id, *disabled)
+	} else {
+		log.Printf("control: resetting disabled of hook %s", id)
+		switches.Reset(id)
+	}
+
+	for _, info := range listHooks() {
+		if info.ID == id {
+			*reply = info
+		}
+	}
+
+	return nil
+}
+
+// TriggerArgs are the arguments to Control.Trigger.
+type TriggerArgs struct {
+	ID      string            `json:"id"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Query   map[string]string `json:"query"`
+	Body    string            `json:"body"`
+
+	// BodyBase64 is the base64-encoded body, for bodies that aren't valid
+	// UTF-8.  It takes precedence over Body.
+	BodyBase64 string `json:"body_base64,omitempty"`
+}
+
+// TriggerReply is the result of Control.Trigger.
+type TriggerReply struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// Trigger sends a synthetic request for the given hook through the regular
+// HTTP handler chain, including rule evaluation.
+func (c *Control) Trigger(args *TriggerArgs, reply *TriggerReply) error {
+	return triggerHook(c.handler, args, reply)
+}
+
+// triggerHook sends the synthetic request described by args to handler and
+// records the response in reply.
+func triggerHook(handler http.Handler, args *TriggerArgs, reply *TriggerReply) error {
+	if args.ID == "" {
+		return fmt.Errorf("hook id is required")
+	}
+
+	method := args.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	q := url.Values{}
+	for k, v := range args.Query {
+		q.Set(k, v)
+	}
+
+	u := url.URL{
+		Path:     makeBaseURL(hooksURLPrefix) + "/" + args.ID,
+		RawQuery: q.Encode(),
+	}
+
+	reqBody := args.Body
+	if args.BodyBase64 != "" {
+		b, err := base64.StdEncoding.DecodeString(args.BodyBase64)
+		if err != nil {
+			return fmt.Errorf("invalid body_base64: %w", err)
+		}
+		reqBody = string(b)
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(method), u.String(), strings.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range args.Headers {
+		req.Header.Set(k, v)
+	}
+
+	req.RemoteAddr = "127.0.0.1:0"
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	reply.Status = res.StatusCode
+	reply.Body = string(body)
+	reply.Headers = make(map[string]string, len(res.Header))
+	for k := range res.Header {
+		reply.Headers[k] = res.Header.Get(k)
+	}
+
+	return nil
+}
+
+// ExecutionsArgs are the arguments to Control.Executions.
+type ExecutionsArgs struct {
+	// ID restricts the results to a single hook.
+	ID string `json:"id"`
+
+	// Limit is the maximum number of executions returned.
+	Limit int `json:"limit"`
+}
+
+// Executions returns recent hook executions, newest first.
+func (c *Control) Executions(args *ExecutionsArgs, reply *[]Execution) error {
+	*reply = executions.List(args.ID, args.Limit)
+	return nil
+}
+
+// UsageArgs are the arguments to Control.Usage.
+type UsageArgs struct {
+	// ID restricts the results to a single hook.
+	ID string `json:"id"`
+
+	// Group restricts the results to the hooks of a group.
+	Group string `json:"group,omitempty"`
+}
+
+// Usage returns the resource usage of hook executions since startup, per hook.
+func (c *Control) Usage(args *UsageArgs, reply *[]HookUsage) error {
+	*reply = usage.List(args.ID, args.Group)
+	return nil
+}
+
+// serveControlSocket listens on the Unix domain socket at path and serves the
+// JSON-RPC control interface on it.
+func serveControlSocket(path string, h http.Handler) error {
+	// Remove a stale socket left behind by a previous instance.
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	// The socket is created with the mode of the umask, so create it in a
+	// private directory and only move it into place once its mode is
+	// restricted, leaving no window in which others could connect.
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".webhook-control-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		ln.Close()
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return err
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.Register(&Control{handler: h}); err != nil {
+		ln.Close()
+		return err
+	}
+
+	log.Printf("serving JSON-RPC control interface on %s", path)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("control socket error: %s", err)
+				return
+			}
+
+			go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestControlSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "webhook-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(h map[string]hook.Hooks, files hook.HooksFiles, s *hookSwitches) {
+		loadedHooksFromFiles, hooksFiles, switches = h, files, s
+	}(loadedHooksFromFiles, hooksFiles, switches)
+
+	hooksFile := filepath.Join(dir, "hooks.json")
+	if err := ioutil.WriteFile(hooksFile, []byte(`[{"id": "deploy", "execute-command": "/bin/true"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+	hooksFiles = hook.HooksFiles{hooksFile}
+	switches = &hookSwitches{}
+
+	socket := filepath.Join(dir, "webhook.sock")
+	if err := serveControlSocket(socket, http.NotFoundHandler()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The socket is only accessible to the owner, and the directory it was
+	// created in is removed.
+	if fi, err := os.Stat(socket); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("expected a socket of mode 0600, got %v, %v", fi, err)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".webhook-control-*")); len(matches) != 0 {
+		t.Errorf("expected the temporary directory to be removed, got %v", matches)
+	}
+
+	client, err := jsonrpc.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reload ReloadReply
+	if err := client.Call("Control.Reload", &ReloadArgs{}, &reload); err != nil || reload.Hooks != 1 {
+		t.Fatalf("Reload: got %+v, %v", reload, err)
+	}
+
+	// Reloading a single file picks up its changes.
+	if err := ioutil.WriteFile(hooksFile, []byte(`[{"id": "deploy", "execute-command": "/bin/true", "disabled": true}, {"id": "test", "execute-command": "/bin/true"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Call("Control.Reload", &ReloadArgs{File: hooksFile}, &reload); err != nil || reload.Hooks != 2 {
+		t.Fatalf("Reload of %s: got %+v, %v", hooksFile, reload, err)
+	}
+
+	if err := client.Call("Control.Reload", &ReloadArgs{File: "missing.json"}, &reload); err == nil {
+		t.Errorf("expected an error reloading a hooks file that isn't loaded")
+	}
+
+	for _, tt := range []struct {
+		method   string
+		id       string
+		disabled bool
+	}{
+		{"Control.Enable", "deploy", false},
+		{"Control.Disable", "test", true},
+		{"Control.ResetDisabled", "deploy", true},
+		{"Control.Enable", "test", false},
+	} {
+		var info HookInfo
+		if err := client.Call(tt.method, &SwitchArgs{ID: tt.id}, &info); err != nil {
+			t.Fatalf("%s %s: %s", tt.method, tt.id, err)
+		}
+
+		if info.ID != tt.id || info.Disabled != tt.disabled || info.File != hooksFile {
+			t.Errorf("%s %s: unexpected reply %+v", tt.method, tt.id, info)
+		}
+
+		if got := switches.Disabled(matchLoadedHook(tt.id)); got != tt.disabled {
+			t.Errorf("%s %s: expected disabled %t, got %t", tt.method, tt.id, tt.disabled, got)
+		}
+	}
+
+	// Switches survive reloading.
+	if err := client.Call("Control.Reload", &ReloadArgs{}, &reload); err != nil {
+		t.Fatal(err)
+	}
+
+	var list []HookInfo
+	if err := client.Call("Control.ListHooks", &ListHooksArgs{}, &list); err != nil || len(list) != 2 || !list[0].Disabled || list[1].Disabled {
+		t.Errorf("ListHooks: got %+v, %v", list, err)
+	}
+
+	var info HookInfo
+	if err := client.Call("Control.Disable", &SwitchArgs{ID: "missing"}, &info); err == nil {
+		t.Errorf("expected an error disabling a hook that isn't loaded")
+	}
+}
+
+func TestControlReloadWhileServing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(h map[string]hook.Hooks, files hook.HooksFiles) {
+		loadedHooksFromFiles, hooksFiles = h, files
+	}(loadedHooksFromFiles, hooksFiles)
+
+	hooksFile := filepath.Join(dir, "hooks.json")
+	if err := ioutil.WriteFile(hooksFile, []byte(`[{"id": "deploy", "execute-command": "/bin/true"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+	hooksFiles = hook.HooksFiles{hooksFile}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		c := &Control{}
+		for i := 0; i < 50; i++ {
+			var reply ReloadReply
+			if err := c.Reload(&ReloadArgs{}, &reply); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Run with -race to check that requests don't race with reloads.
+	for {
+		select {
+		case <-done:
+			if matchLoadedHook("deploy") == nil || len(listHooks()) != 1 {
+				t.Errorf("expected the deploy hook to be loaded, got %+v", listHooks())
+			}
+			return
+		default:
+			matchLoadedHook("deploy")
+			matchLoadedHookByPath("/deploy")
+			listHooks()
+		}
+	}
+}
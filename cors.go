@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// handleCORS applies the CORS configuration of h to the request r.  It answers
+// preflight requests, and requests from origins that aren't allowed, and
+// returns true if it did; otherwise it sets the response headers for the
+// actual request and returns false.
+func handleCORS(w http.ResponseWriter, r *http.Request, h *hook.Hook, reqID string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	c := h.CORS
+
+	w.Header().Add("Vary", "Origin")
+
+	if !c.AllowsOrigin(origin) {
+		log.Printf("[%s] origin %q not allowed for hook %q", reqID, origin, h.ID)
+		http.Error(w, "Origin not allowed.", http.StatusForbidden)
+		return true
+	}
+
+	allowOrigin := origin
+	if !c.AllowCredentials && len(c.AllowedOrigins) == 1 && c.AllowedOrigins[0] == "*" {
+		allowOrigin = "*"
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if r.Method != http.MethodOptions || method == "" {
+		if len(c.ExposedHeaders) != 0 {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+
+		return false
+	}
+
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	methods := corsMethods(h)
+
+	var allowed bool
+	for _, m := range methods {
+		if m == method {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		log.Printf("[%s] preflight of HTTP %s not allowed for hook %q", reqID, method, h.ID)
+		http.Error(w, "Method not allowed.", http.StatusForbidden)
+		return true
+	}
+
+	var headers []string
+	for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if !c.AllowsHeader(name) {
+			log.Printf("[%s] preflight with header %q not allowed for hook %q", reqID, name, h.ID)
+			http.Error(w, "Header not allowed.", http.StatusForbidden)
+			return true
+		}
+
+		headers = append(headers, name)
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(headers) != 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return true
+}
+
+// corsMethods returns the methods allowed in preflight requests to h.
+func corsMethods(h *hook.Hook) []string {
+	methods := h.CORS.AllowedMethods
+
+	switch {
+	case len(methods) != 0:
+	case len(h.HTTPMethods) != 0:
+		methods = h.HTTPMethods
+	case *httpMethods != "":
+		methods = strings.Split(*httpMethods, ",")
+	default:
+		methods = []string{http.MethodPost}
+	}
+
+	res := make([]string, 0, len(methods))
+	for _, m := range methods {
+		res = append(res, strings.ToUpper(strings.TrimSpace(m)))
+	}
+
+	return res
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestHandleCORS(t *testing.T) {
+	h := &hook.Hook{ID: "deploy", CORS: &hook.CORS{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedHeaders: []string{"X-CSRF-Token"},
+		ExposedHeaders: []string{"X-Request-Id"},
+		MaxAge:         600,
+	}}
+
+	for _, tt := range []struct {
+		desc    string
+		method  string
+		headers map[string]string
+		handled bool
+		status  int
+		expect  map[string]string
+	}{
+		{"same origin", "POST", nil, false, http.StatusOK, map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"allowed origin", "POST", map[string]string{"Origin": "https://ops.example.com"}, false, http.StatusOK, map[string]string{
+			"Access-Control-Allow-Origin":   "https://ops.example.com",
+			"Access-Control-Expose-Headers": "X-Request-Id",
+		}},
+		{"other origin", "POST", map[string]string{"Origin": "https://evil.test"}, true, http.StatusForbidden, nil},
+		{"preflight", "OPTIONS", map[string]string{
+			"Origin":                         "https://ops.example.com",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "content-type, x-csrf-token",
+		}, true, http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "https://ops.example.com",
+			"Access-Control-Allow-Methods": "POST",
+			"Access-Control-Allow-Headers": "content-type, x-csrf-token",
+			"Access-Control-Max-Age":       "600",
+		}},
+		{"preflight method", "OPTIONS", map[string]string{"Origin": "https://ops.example.com", "Access-Control-Request-Method": "DELETE"}, true, http.StatusForbidden, nil},
+		{"preflight header", "OPTIONS", map[string]string{
+			"Origin":                         "https://ops.example.com",
+			"Access-Control-Request-Method":  "POST",
+			"Access-Control-Request-Headers": "authorization",
+		}, true, http.StatusForbidden, nil},
+	} {
+		r := httptest.NewRequest(tt.method, "/hooks/deploy", nil)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+
+		rec := httptest.NewRecorder()
+
+		if handled := handleCORS(rec, r, h, "test"); handled != tt.handled {
+			t.Errorf("%s: expected handled %v, got %v", tt.desc, tt.handled, handled)
+		}
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.desc, tt.status, rec.Code)
+		}
+
+		for k, v := range tt.expect {
+			if got := rec.Header().Get(k); got != v {
+				t.Errorf("%s: expected %s %q, got %q", tt.desc, k, v, got)
+			}
+		}
+	}
+
+	// Without allowed-methods, preflights allow the hook's methods.
+	h.HTTPMethods = []string{"put", "POST"}
+	if m := corsMethods(h); len(m) != 2 || m[0] != "PUT" {
+		t.Errorf("expected the hook's methods, got %q", m)
+	}
+}
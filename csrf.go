@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/adnanh/webhook/internal/hook"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// csrfPrefix is the URL path under which CSRF tokens are issued.
+	csrfPrefix = "/_csrf"
+
+	// csrfCookieName is the cookie holding the browser's CSRF nonce.
+	csrfCookieName = "webhook_csrf"
+
+	// csrfHeader and csrfField are the request header and payload field that
+	// carry the CSRF token.
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+// csrfKey signs CSRF tokens.
+var csrfKey []byte
+
+// initCSRF sets the key used to sign CSRF tokens to secret, or to a random key
+// if secret is empty.
+func initCSRF(secret string) error {
+	if secret != "" {
+		csrfKey = []byte(secret)
+		return nil
+	}
+
+	csrfKey = make([]byte, 32)
+	_, err := rand.Read(csrfKey)
+
+	return err
+}
+
+// csrfToken returns the CSRF token for the hook id and the browser nonce.
+func csrfToken(nonce, id string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(nonce + "\x00" + id))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// registerCSRFRoutes adds the CSRF token endpoint to r:
+//
+//	GET /_csrf/{id}  issue a token for hooks with csrf-protection
+//
+// The response sets the nonce cookie the token is bound to.  Pages on other
+// sites can't read the response, so they can't obtain a valid token.
+func registerCSRFRoutes(r *mux.Router) {
+	r.HandleFunc(csrfPrefix+"/{id:.*}", func(w http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+
+		h := matchLoadedHook(id)
+		if h == nil || !h.CSRFProtection || !hookAllowed(req.Context(), id) {
+			http.Error(w, "Hook not found.", http.StatusNotFound)
+			return
+		}
+
+		var nonce string
+
+		if c, err := req.Cookie(csrfCookieName); err == nil && c.Value != "" {
+			nonce = c.Value
+		} else {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				http.Error(w, "Error generating CSRF token.", http.StatusInternalServerError)
+				return
+			}
+
+			nonce = hex.EncodeToString(b)
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    nonce,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   req.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, map[string]string{"token": csrfToken(nonce, id)})
+	}).Methods(http.MethodGet)
+}
+
+// checkCSRF verifies the CSRF token of the request r for the hook h, taken from
+// the X-CSRF-Token header or the csrf_token payload field.
+func checkCSRF(h *hook.Hook, r *http.Request, req *hook.Request) error {
+	c, err := r.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return errors.New("missing CSRF cookie")
+	}
+
+	token := r.Header.Get(csrfHeader)
+	if token == "" && req.Payload != nil {
+		token, _ = hook.ExtractParameterAsString(csrfField, req.Payload)
+	}
+
+	if token == "" {
+		return errors.New("missing CSRF token")
+	}
+
+	if !hmac.Equal([]byte(token), []byte(csrfToken(c.Value, h.ID))) {
+		return errors.New("invalid CSRF token")
+	}
+
+	return nil
+}
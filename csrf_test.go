@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	if err := initCSRF("s3cret"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &hook.Hook{ID: "deploy", CSRFProtection: true}
+	token := csrfToken("nonce", h.ID)
+
+	for _, tt := range []struct {
+		desc    string
+		cookie  string
+		header  string
+		payload map[string]interface{}
+		ok      bool
+	}{
+		{"header", "nonce", token, nil, true},
+		{"payload", "nonce", "", map[string]interface{}{csrfField: token}, true},
+		{"no cookie", "", token, nil, false},
+		{"no token", "nonce", "", nil, false},
+		{"other nonce", "other", token, nil, false},
+		{"other hook", "nonce", csrfToken("nonce", "other"), nil, false},
+	} {
+		r := httptest.NewRequest("POST", "/hooks/deploy", nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set(csrfHeader, tt.header)
+		}
+
+		err := checkCSRF(h, r, &hook.Request{Payload: tt.payload})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v, got %v", tt.desc, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/adnanh/webhook/internal/hook"
+	"github.com/gorilla/mux"
+)
+
+// debugPrefix is the URL path under which the debug endpoints are served.
+const debugPrefix = "/debug"
+
+// debugState is the runtime state dumped by /debug/hooks.
+type debugState struct {
+	Goroutines int                   `json:"goroutines"`
+	Commands   []RunningCommand      `json:"commands"`
+	Services   []ServiceInfo         `json:"services"`
+	EventQueue int                   `json:"event-queue"`
+	Hooks      map[string]hook.Hooks `json:"hooks"`
+}
+
+// redactedValue replaces the values that may be secret in dumped hooks.
+const redactedValue = "xxxxx"
+
+// redactHooks returns copies of the hooks of each file with the values that
+// may be secret replaced by redactedValue: rule secrets, values, regular
+// expressions and options, keys, proxy header values and URL passwords.
+// Secret files are only referenced by path, so their contents are never
+// dumped.
+func redactHooks(files map[string]hook.Hooks) map[string]hook.Hooks {
+	res := make(map[string]hook.Hooks, len(files))
+
+	for file, hooks := range files {
+		redacted := make(hook.Hooks, len(hooks))
+		for i := range hooks {
+			redacted[i] = redactHook(hooks[i])
+		}
+
+		res[file] = redacted
+	}
+
+	return res
+}
+
+// redactHook returns h with the values that may be secret redacted.
+func redactHook(h hook.Hook) hook.Hook {
+	h.TriggerRule = redactRules(h.TriggerRule)
+	h.ProxyURL = redactURL(h.ProxyURL)
+	h.CallbackURL = redactURL(h.CallbackURL)
+
+	if h.ProxyHeaders != nil {
+		headers := make(hook.ResponseHeaders, len(h.ProxyHeaders))
+		for i, header := range h.ProxyHeaders {
+			header.Value = redactedValue
+			headers[i] = header
+		}
+		h.ProxyHeaders = headers
+	}
+
+	if h.OnFailure != nil {
+		urls := make([]string, len(h.OnFailure))
+		for i, u := range h.OnFailure {
+			urls[i] = redactURL(u)
+		}
+		h.OnFailure = urls
+	}
+
+	if h.DecryptPayload != nil {
+		d := *h.DecryptPayload
+		if d.Key != "" {
+			d.Key = redactedValue
+		}
+		h.DecryptPayload = &d
+	}
+
+	return h
+}
+
+// redactRules returns a copy of r with the values that may be secret
+// redacted.
+func redactRules(r *hook.Rules) *hook.Rules {
+	if r == nil {
+		return nil
+	}
+
+	c := *r
+
+	if r.And != nil {
+		and := make(hook.AndRule, len(*r.And))
+		for i := range *r.And {
+			and[i] = *redactRules(&(*r.And)[i])
+		}
+		c.And = &and
+	}
+
+	if r.Or != nil {
+		or := make(hook.OrRule, len(*r.Or))
+		for i := range *r.Or {
+			or[i] = *redactRules(&(*r.Or)[i])
+		}
+		c.Or = &or
+	}
+
+	if r.Not != nil {
+		not := hook.NotRule(*redactRules((*hook.Rules)(r.Not)))
+		c.Not = &not
+	}
+
+	if r.Any != nil {
+		any := *r.Any
+		any.Rule = *redactRules(&any.Rule)
+		c.Any = &any
+	}
+
+	if r.All != nil {
+		all := *r.All
+		all.Rule = *redactRules(&all.Rule)
+		c.All = &all
+	}
+
+	if r.Match != nil {
+		m := *r.Match
+
+		if m.Secret != nil {
+			m.Secret = make(hook.Secrets, len(r.Match.Secret))
+			for i := range m.Secret {
+				m.Secret[i] = redactedValue
+			}
+		}
+
+		if m.Value != "" {
+			m.Value = redactedValue
+		}
+
+		if m.Regex != "" {
+			m.Regex = redactedValue
+		}
+
+		if m.Options != nil {
+			m.Options = make(map[string]string, len(r.Match.Options))
+			for k := range r.Match.Options {
+				m.Options[k] = redactedValue
+			}
+		}
+
+		m.URL = redactURL(m.URL)
+		c.Match = &m
+	}
+
+	return &c
+}
+
+// registerDebugRoutes adds the debug endpoints, wrapped in the middlewares
+// mwf, to r:
+//
+//	GET /debug/pprof/  the profiles of net/http/pprof
+//	GET /debug/hooks   the loaded hooks, running commands, goroutine count
+//	                   and queue depths
+//
+// The command line isn't served, since it holds secrets like -admin-token
+// and the debug endpoints may be served without authentication.
+func registerDebugRoutes(r *mux.Router, mwf ...mux.MiddlewareFunc) *mux.Router {
+	sr := r.PathPrefix(debugPrefix).Subrouter()
+	sr.Use(mwf...)
+
+	sr.Handle("/pprof/cmdline", http.NotFoundHandler())
+	sr.HandleFunc("/pprof/profile", pprof.Profile)
+	sr.HandleFunc("/pprof/symbol", pprof.Symbol)
+	sr.HandleFunc("/pprof/trace", pprof.Trace)
+	sr.PathPrefix("/pprof/").HandlerFunc(pprof.Index)
+
+	sr.HandleFunc("/hooks", func(w http.ResponseWriter, req *http.Request) {
+		hooksMu.RLock()
+		hooks := redactHooks(loadedHooksFromFiles)
+		hooksMu.RUnlock()
+
+		state := debugState{
+			Goroutines: runtime.NumGoroutine(),
+			Commands:   commands.List(),
+			Services:   services.List(),
+			Hooks:      hooks,
+		}
+
+		if events != nil {
+			state.EventQueue = len(events.queue)
+		}
+
+		writeJSON(w, state)
+	}).Methods(http.MethodGet)
+
+	return sr
+}
+
+// serveDebug serves the debug endpoints without authentication on the
+// loopback address addr.
+func serveDebug(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("debug-addr %s is not a loopback address", addr)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	r := mux.NewRouter()
+	registerDebugRoutes(r)
+
+	go func() {
+		if err := http.Serve(ln, r); err != nil {
+			log.Printf("error serving debug endpoints: %s", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxPayloadDetections limits the number of distinct detections counted, as
+// the Content-Types are chosen by the clients.  Once reached, detections of
+// new Content-Types are counted as otherContentType.
+const maxPayloadDetections = 1000
+
+// otherContentType replaces the Content-Types of detections that aren't
+// counted separately.
+const otherContentType = "other"
+
+// supportedContentType reports whether payloads of the content type ct are
+// parsed without detecting their type.
+func supportedContentType(ct string) bool {
+	for _, t := range []string{"json", "x-www-form-urlencoded", "xml"} {
+		if strings.Contains(ct, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PayloadDetection counts the payloads sent to a hook with a Content-Type
+// that was overridden by the detected payload type.
+type PayloadDetection struct {
+	HookID      string `json:"hook-id"`
+	ContentType string `json:"content-type"`
+	Detected    string `json:"detected"`
+	Count       int    `json:"count"`
+}
+
+// payloadDetections accumulates payload type detections, so operators can
+// find the senders using wrong Content-Types.
+type payloadDetections struct {
+	mu    sync.Mutex
+	items map[PayloadDetection]int
+}
+
+// Add counts a payload for the hook id sent as ct and detected as detected.
+// ct is reduced to its media type, without parameters, and unparsable ones
+// are counted as otherContentType.
+func (d *payloadDetections) Add(id, ct, detected string) {
+	if ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err == nil {
+			ct = mt
+		} else {
+			ct = otherContentType
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.items == nil {
+		d.items = make(map[PayloadDetection]int)
+	}
+
+	k := PayloadDetection{HookID: id, ContentType: ct, Detected: detected}
+	if _, ok := d.items[k]; !ok && len(d.items) >= maxPayloadDetections {
+		k.ContentType = otherContentType
+	}
+
+	d.items[k]++
+}
+
+// List returns the detections, ordered by hook ID and content type.
+func (d *payloadDetections) List() []PayloadDetection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := make([]PayloadDetection, 0, len(d.items))
+
+	for k, n := range d.items {
+		k.Count = n
+		res = append(res, k)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].HookID != res[j].HookID {
+			return res[i].HookID < res[j].HookID
+		}
+		if res[i].ContentType != res[j].ContentType {
+			return res[i].ContentType < res[j].ContentType
+		}
+		return res[i].Detected < res[j].Detected
+	})
+
+	return res
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPayloadDetections(t *testing.T) {
+	var d payloadDetections
+
+	d.Add("b", "text/plain", "application/json")
+	d.Add("a", "text/plain", "application/json")
+	d.Add("b", "text/plain", "application/json")
+	d.Add("a", "application/octet-stream", "application/x-www-form-urlencoded")
+
+	expect := []PayloadDetection{
+		{"a", "application/octet-stream", "application/x-www-form-urlencoded", 1},
+		{"a", "text/plain", "application/json", 1},
+		{"b", "text/plain", "application/json", 2},
+	}
+
+	if got := d.List(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %+v, got %+v", expect, got)
+	}
+}
+
+func TestPayloadDetectionsNormalized(t *testing.T) {
+	var d payloadDetections
+
+	d.Add("a", "Text/Plain; charset=utf-8", "application/json")
+	d.Add("a", "text/plain", "application/json")
+	d.Add("a", "not a/media type", "application/json")
+
+	expect := []PayloadDetection{
+		{"a", "other", "application/json", 1},
+		{"a", "text/plain", "application/json", 2},
+	}
+
+	if got := d.List(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %+v, got %+v", expect, got)
+	}
+
+	for i := 0; i < 2*maxPayloadDetections; i++ {
+		d.Add("a", fmt.Sprintf("text/x-%d", i), "application/json")
+	}
+
+	if got := d.List(); len(got) > maxPayloadDetections+1 {
+		t.Errorf("expected at most %d detections, got %d", maxPayloadDetections+1, len(got))
+	}
+
+	if got := d.List()[0]; got.ContentType != "other" || got.Count != maxPayloadDetections+3 {
+		t.Errorf("expected the overflowing detections to be counted as other, got %+v", got)
+	}
+}
+
+func TestSupportedContentType(t *testing.T) {
+	for ct, expect := range map[string]bool{
+		"application/json":                  true,
+		"application/vnd.api+json":          true,
+		"application/x-www-form-urlencoded": true,
+		"text/xml; charset=utf-8":           true,
+		"text/plain":                        false,
+		"":                                  false,
+	} {
+		if got := supportedContentType(ct); got != expect {
+			t.Errorf("supportedContentType(%q): expected %t, got %t", ct, expect, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// hookSwitches holds hooks disabled or enabled through the admin API, which
+// takes precedence over the hooks' disabled setting.  Like callerLists, they
+// are kept in memory only, and survive reloading the hooks files.
+type hookSwitches struct {
+	mu       sync.Mutex
+	disabled map[string]bool
+}
+
+// Disabled reports whether h is disabled.
+func (s *hookSwitches) Disabled(h *hook.Hook) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if disabled, ok := s.disabled[h.ID]; ok {
+		return disabled
+	}
+
+	return h.Disabled
+}
+
+// Set disables or enables the hook id.
+func (s *hookSwitches) Set(id string, disabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.disabled == nil {
+		s.disabled = make(map[string]bool)
+	}
+
+	s.disabled[id] = disabled
+}
+
+// Reset discards the switch set for the hook id, reverting to its disabled
+// setting.
+func (s *hookSwitches) Reset(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.disabled, id)
+}
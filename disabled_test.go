@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestHookSwitches(t *testing.T) {
+	s := &hookSwitches{}
+
+	enabled := &hook.Hook{ID: "enabled"}
+	disabled := &hook.Hook{ID: "disabled", Disabled: true}
+
+	if s.Disabled(enabled) || !s.Disabled(disabled) {
+		t.Error("expected the disabled setting of hooks to apply")
+	}
+
+	s.Set(enabled.ID, true)
+	s.Set(disabled.ID, false)
+	if !s.Disabled(enabled) || s.Disabled(disabled) {
+		t.Error("expected switches to take precedence over the disabled setting")
+	}
+
+	s.Reset(disabled.ID)
+	if !s.Disabled(disabled) {
+		t.Error("expected reset to revert to the disabled setting")
+	}
+}
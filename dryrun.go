@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os/exec"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// dryRunReport describes what a request would have executed.
+type dryRunReport struct {
+	RequestID string                 `json:"request_id"`
+	Hook      string                 `json:"hook"`
+	Triggered bool                   `json:"triggered"`
+	Kind      string                 `json:"kind,omitempty"`
+	Command   string                 `json:"command,omitempty"`
+	Dir       string                 `json:"dir,omitempty"`
+	Args      []string               `json:"args,omitempty"`
+	Env       []string               `json:"env,omitempty"`
+	Files     []dryRunFile           `json:"files,omitempty"`
+	ProxyURL  string                 `json:"proxy_url,omitempty"`
+	Headers   map[string]interface{} `json:"headers,omitempty"`
+	Query     map[string]interface{} `json:"query,omitempty"`
+	Payload   map[string]interface{} `json:"payload,omitempty"`
+	Errors    []string               `json:"errors,omitempty"`
+}
+
+// dryRunFile describes a file that would have been passed to the command.
+type dryRunFile struct {
+	EnvName string `json:"envname"`
+	Size    int    `json:"size"`
+}
+
+// newDryRunReport returns the report for the request r to h, given the result
+// of evaluating the trigger rule.  Arguments are extracted even if the rule
+// isn't satisfied, to help debugging hook definitions.
+func newDryRunReport(h *hook.Hook, r *hook.Request, triggered bool, ruleErr error) *dryRunReport {
+	rep := &dryRunReport{
+		RequestID: r.ID,
+		Hook:      h.ID,
+		Triggered: triggered,
+		Kind:      h.Kind,
+		Headers:   make(map[string]interface{}, len(r.Headers)),
+		Query:     r.Query,
+		Payload:   r.Payload,
+	}
+
+	for k, v := range r.Headers {
+		// Don't echo the admin token.
+		if k != "Authorization" {
+			rep.Headers[k] = v
+		}
+	}
+
+	addErrors := func(prefix string, errs []error) {
+		for _, err := range errs {
+			rep.Errors = append(rep.Errors, prefix+err.Error())
+		}
+	}
+
+	if ruleErr != nil {
+		addErrors("error evaluating trigger rule: ", []error{ruleErr})
+	}
+
+	if h.Kind == hook.KindProxy {
+		rep.ProxyURL = h.ProxyURL
+		return rep
+	}
+
+	rep.Command = lookupCommand(h)
+	rep.Dir = h.CommandWorkingDirectory
+
+	if _, err := exec.LookPath(rep.Command); err != nil {
+		addErrors("error in ", []error{err})
+	}
+
+	var errs []error
+
+	rep.Args, errs = h.ExtractCommandArguments(r)
+	addErrors("error extracting command arguments: ", errs)
+
+	rep.Env, errs = h.ExtractCommandArgumentsForEnv(r)
+	addErrors("error extracting command arguments for environment: ", errs)
+
+	files, errs := h.ExtractCommandArgumentsForFile(r)
+	addErrors("error extracting command arguments for file: ", errs)
+
+	for _, f := range files {
+		rep.Files = append(rep.Files, dryRunFile{EnvName: f.EnvName, Size: len(f.Data)})
+	}
+
+	return rep
+}
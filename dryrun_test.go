@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestNewDryRunReport(t *testing.T) {
+	h := &hook.Hook{
+		ID:                       "deploy",
+		ExecuteCommand:           "/bin/true",
+		PassArgumentsToCommand:   []hook.Argument{{Source: "payload", Name: "ref"}, {Source: "payload", Name: "missing"}},
+		PassEnvironmentToCommand: []hook.Argument{{Source: "header", Name: "X-Event"}},
+		PassFileToCommand:        []hook.Argument{{Source: "payload", Name: "ref", EnvName: "REF_FILE"}},
+	}
+
+	r := &hook.Request{
+		ID:      "abc",
+		Headers: map[string]interface{}{"X-Event": "push", "Authorization": "Bearer s3cret"},
+		Payload: map[string]interface{}{"ref": "main"},
+	}
+
+	rep := newDryRunReport(h, r, false, errors.New("boom"))
+
+	if rep.Triggered || rep.Hook != "deploy" || rep.RequestID != "abc" {
+		t.Errorf("unexpected report %+v", rep)
+	}
+
+	if !reflect.DeepEqual(rep.Args, []string{"/bin/true", "main", ""}) {
+		t.Errorf("unexpected args %q", rep.Args)
+	}
+
+	if !reflect.DeepEqual(rep.Env, []string{"HOOK_X-Event=push"}) {
+		t.Errorf("unexpected env %q", rep.Env)
+	}
+
+	if !reflect.DeepEqual(rep.Files, []dryRunFile{{EnvName: "REF_FILE", Size: 4}}) {
+		t.Errorf("unexpected files %+v", rep.Files)
+	}
+
+	if _, ok := rep.Headers["Authorization"]; ok {
+		t.Error("report echoes the Authorization header")
+	}
+
+	// The rule error and the missing argument.
+	if len(rep.Errors) != 2 {
+		t.Errorf("expected 2 errors, got %q", rep.Errors)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// envPrefix prefixes the names of the environment variables setting flags.
+const envPrefix = "WEBHOOK_"
+
+// flagEnvName returns the name of the environment variable setting the flag
+// name, ie. WEBHOOK_TEMPLATE_MISSINGKEY for -template-missingkey.
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// applyEnvFlags sets the flags of fs that weren't given on the command line
+// from their environment variables, found with lookup.  Flags that can be
+// given multiple times, such as -hooks, take one value per line.
+func applyEnvFlags(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+
+		name := flagEnvName(f.Name)
+
+		value, ok := lookup(name)
+		if !ok {
+			return
+		}
+
+		values := []string{value}
+
+		if isListFlag(f.Value) {
+			values = nil
+			for _, line := range strings.Split(value, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values = append(values, line)
+				}
+			}
+		}
+
+		for _, value := range values {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, name, e)
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// isListFlag reports whether the flag value is a list, set by giving the flag
+// multiple times.
+func isListFlag(value flag.Value) bool {
+	v := reflect.ValueOf(value)
+
+	return v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestApplyEnvFlags(t *testing.T) {
+	fs := flag.NewFlagSet("webhook", flag.ContinueOnError)
+
+	port := fs.Int("port", 9000, "")
+	ip := fs.String("ip", "0.0.0.0", "")
+	verbose := fs.Bool("verbose", false, "")
+	missingKey := fs.String("template-missingkey", "default", "")
+
+	var files hook.HooksFiles
+	fs.Var(&files, "hooks", "")
+
+	env := map[string]string{
+		"WEBHOOK_PORT":                "9100",
+		"WEBHOOK_IP":                  "127.0.0.1",
+		"WEBHOOK_VERBOSE":             "true",
+		"WEBHOOK_TEMPLATE_MISSINGKEY": "error",
+		"WEBHOOK_HOOKS":               "/etc/webhook/a.json\n /etc/webhook/b.yaml\n",
+	}
+
+	if err := fs.Parse([]string{"-ip", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	if err := applyEnvFlags(fs, lookup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if *port != 9100 || *ip != "::1" || !*verbose || *missingKey != "error" {
+		t.Errorf("unexpected flags: port %d, ip %q, verbose %t, template-missingkey %q", *port, *ip, *verbose, *missingKey)
+	}
+
+	if expect := (hook.HooksFiles{"/etc/webhook/a.json", "/etc/webhook/b.yaml"}); !reflect.DeepEqual(files, expect) {
+		t.Errorf("expected hooks files %q, got %q", expect, files)
+	}
+
+	fs = flag.NewFlagSet("webhook", flag.ContinueOnError)
+	fs.Int("port", 9000, "")
+
+	env = map[string]string{"WEBHOOK_PORT": "http"}
+	if err := applyEnvFlags(fs, lookup); err == nil {
+		t.Error("expected an error for an invalid port")
+	}
+}
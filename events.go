@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// Event types published to the event bus.
+const (
+	EventDelivery  = "delivery"
+	EventExecution = "execution"
+)
+
+// eventQueueSize is the number of events buffered for the publishers.  Events
+// are dropped while the queue is full, so a slow or unreachable bus doesn't
+// hold up requests.
+const eventQueueSize = 1000
+
+// Event is a structured record of a delivery to a hook or of the outcome of
+// an execution, published to the buses set with -events-url.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request-id"`
+	HookID    string    `json:"hook-id"`
+	Group     string    `json:"group,omitempty"`
+
+	// Delivery is set for delivery events.
+	Delivery *DeliveryEvent `json:"delivery,omitempty"`
+
+	// Execution is set for execution events.  Its output, arguments and
+	// environment are omitted, as they may hold secrets.
+	Execution *Execution `json:"execution,omitempty"`
+}
+
+// DeliveryEvent describes a request to a hook whose trigger rule was
+// evaluated.
+type DeliveryEvent struct {
+	Method     string `json:"method"`
+	RemoteAddr string `json:"remote-addr"`
+	Triggered  bool   `json:"triggered"`
+}
+
+// eventURLs is the list of event buses set with -events-url.
+type eventURLs []string
+
+func (e *eventURLs) String() string {
+	return strings.Join(*e, ", ")
+}
+
+// Set implements the flag.Value interface.
+func (e *eventURLs) Set(value string) error {
+	*e = append(*e, value)
+	return nil
+}
+
+// eventPublisher publishes encoded events to a bus.
+type eventPublisher interface {
+	Publish(data []byte) error
+}
+
+// eventBus publishes events to all publishers from a background goroutine.
+// The nil *eventBus discards events.
+type eventBus struct {
+	urls       []string
+	publishers []eventPublisher
+	queue      chan Event
+}
+
+// newEventBus returns an eventBus publishing to the buses given by urls.
+func newEventBus(urls []string) (*eventBus, error) {
+	b := &eventBus{urls: urls, queue: make(chan Event, eventQueueSize)}
+
+	for _, rawurl := range urls {
+		p, err := newEventPublisher(rawurl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid events-url %q: %w", rawurl, err)
+		}
+
+		b.publishers = append(b.publishers, p)
+	}
+
+	go b.run()
+
+	return b, nil
+}
+
+// Publish queues e for publishing.
+func (b *eventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	e.Time = time.Now()
+
+	select {
+	case b.queue <- e:
+	default:
+		log.Printf("[%s] event queue is full, dropping %s event of %s\n", e.RequestID, e.Type, e.HookID)
+	}
+}
+
+// PublishDelivery publishes a delivery event for the request req to h.
+func (b *eventBus) PublishDelivery(h *hook.Hook, req *hook.Request, triggered bool) {
+	e := Event{
+		Type:      EventDelivery,
+		RequestID: req.ID,
+		HookID:    h.ID,
+		Group:     h.Group,
+		Delivery:  &DeliveryEvent{Triggered: triggered},
+	}
+
+	if req.RawRequest != nil {
+		e.Delivery.Method = req.RawRequest.Method
+		e.Delivery.RemoteAddr = req.RawRequest.RemoteAddr
+	}
+
+	b.Publish(e)
+}
+
+// PublishExecution publishes an execution event for ex.
+func (b *eventBus) PublishExecution(ex Execution) {
+	ex.Args, ex.Env, ex.Output, ex.Result = nil, nil, "", nil
+
+	b.Publish(Event{
+		Type:      EventExecution,
+		RequestID: ex.RequestID,
+		HookID:    ex.HookID,
+		Group:     ex.Group,
+		Execution: &ex,
+	})
+}
+
+func (b *eventBus) run() {
+	for e := range b.queue {
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("[%s] error encoding %s event: %s\n", e.RequestID, e.Type, err)
+			continue
+		}
+
+		for i, p := range b.publishers {
+			if err := p.Publish(data); err != nil {
+				log.Printf("[%s] error publishing %s event to %s: %s\n", e.RequestID, e.Type, redactURL(b.urls[i]), err)
+			}
+		}
+	}
+}
+
+// redactURL returns rawurl with its password, if any, replaced by "xxxxx".
+func redactURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil {
+		return rawurl
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return u.String()
+}
+
+// newEventPublisher returns the publisher of the bus given by rawurl: a Redis
+// channel or stream, a NATS subject, a Kafka topic, an AMQP exchange, or an
+// HTTP endpoint.
+func newEventPublisher(rawurl string) (eventPublisher, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "redis":
+		c, err := newRedisClient(u)
+		if err != nil {
+			return nil, err
+		}
+
+		p := &redisPublisher{client: c, channel: u.Query().Get("channel"), stream: u.Query().Get("stream")}
+		if p.channel == "" && p.stream == "" {
+			p.channel = "webhook:events"
+		}
+
+		return p, nil
+
+	case "nats":
+		p := &natsPublisher{addr: u.Host, subject: strings.TrimPrefix(u.Path, "/"), user: u.User}
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Hostname(), "4222")
+		}
+
+		if p.subject == "" {
+			p.subject = "webhook.events"
+		}
+
+		return p, nil
+
+	case "kafka", "kafkas":
+		var cfg *tls.Config
+		if u.Scheme == "kafkas" {
+			cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+
+		return newKafkaPublisher(u, cfg)
+
+	case "amqp", "amqps":
+		var cfg *tls.Config
+		if u.Scheme == "amqps" {
+			cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+
+		return newAMQPPublisher(u, cfg), nil
+
+	case "http", "https":
+		return &httpPublisher{url: rawurl}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+}
+
+// redisPublisher publishes events to a Redis channel with PUBLISH, or appends
+// them to a stream with XADD.
+type redisPublisher struct {
+	client  *redisClient
+	channel string
+	stream  string
+}
+
+// redisStreamMaxLen approximately limits the length of event streams.
+const redisStreamMaxLen = "100000"
+
+// Publish implements eventPublisher.
+func (p *redisPublisher) Publish(data []byte) error {
+	if p.stream != "" {
+		_, err := p.client.do("XADD", p.stream, "MAXLEN", "~", redisStreamMaxLen, "*", "event", string(data))
+		return err
+	}
+
+	_, err := p.client.do("PUBLISH", p.channel, string(data))
+	return err
+}
+
+// natsPublisher publishes events to a NATS subject, using the text protocol
+// on a new connection for every event.
+type natsPublisher struct {
+	addr    string
+	subject string
+	user    *url.Userinfo
+}
+
+// Publish implements eventPublisher.
+func (p *natsPublisher) Publish(data []byte) error {
+	conn, err := net.DialTimeout("tcp", p.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	r := bufio.NewReader(conn)
+
+	// The server greets with its INFO.
+	if line, err := r.ReadString('\n'); err != nil {
+		return err
+	} else if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected nats greeting %q", strings.TrimSpace(line))
+	}
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "webhook"}
+	if p.user != nil {
+		if pass, ok := p.user.Password(); ok {
+			opts["user"], opts["pass"] = p.user.Username(), pass
+		} else {
+			opts["auth_token"] = p.user.Username()
+		}
+	}
+
+	connect, _ := json.Marshal(opts)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "CONNECT %s\r\n", connect)
+	fmt.Fprintf(&b, "PUB %s %d\r\n%s\r\n", p.subject, len(data), data)
+	b.WriteString("PING\r\n")
+
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	// The PONG confirms the server processed the publication.
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// httpPublisher POSTs events as JSON to an HTTP endpoint, ie. a log
+// collector.
+type httpPublisher struct {
+	url string
+}
+
+var eventsHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Publish implements eventPublisher.
+func (p *httpPublisher) Publish(data []byte) error {
+	resp, err := eventsHTTPClient.Post(p.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
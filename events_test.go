@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// fakeEventServer accepts connections on a local port and passes them to
+// serve, returning the address.
+func fakeEventServer(t *testing.T, serve func(net.Conn)) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestEventPublishers(t *testing.T) {
+	received := make(chan string, 10)
+
+	redisAddr := fakeEventServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+
+		var args []string
+		line, _ := r.ReadString('\n')
+		n, _ := strconv.Atoi(strings.TrimRight(strings.TrimPrefix(line, "*"), "\r\n"))
+		for i := 0; i < n; i++ {
+			r.ReadString('\n')
+			arg, _ := r.ReadString('\n')
+			args = append(args, strings.TrimRight(arg, "\r\n"))
+		}
+
+		received <- strings.Join(args, " ")
+		conn.Write([]byte(":1\r\n"))
+	})
+
+	natsAddr := fakeEventServer(t, func(conn net.Conn) {
+		conn.Write([]byte("INFO {}\r\n"))
+
+		r := bufio.NewReader(conn)
+		var pub []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(line, "PUB "):
+				payload, _ := r.ReadString('\n')
+				pub = append(pub, line, strings.TrimRight(payload, "\r\n"))
+			case line == "PING":
+				received <- strings.Join(pub, " ")
+				conn.Write([]byte("PONG\r\n"))
+				return
+			}
+		}
+	})
+
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r.Header.Get("Content-Type") + " " + string(body)
+	}))
+	defer h.Close()
+
+	for _, tt := range []struct {
+		url  string
+		want string
+	}{
+		{"redis://" + redisAddr, `PUBLISH webhook:events {}`},
+		{"redis://" + redisAddr + "?stream=events", `XADD events MAXLEN ~ 100000 * event {}`},
+		{"nats://" + natsAddr + "/audit.webhook", `PUB audit.webhook 2 {}`},
+		{h.URL, `application/json {}`},
+	} {
+		p, err := newEventPublisher(tt.url)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.url, err)
+		}
+
+		if err := p.Publish([]byte("{}")); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.url, err)
+			continue
+		}
+
+		if got := <-received; got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.url, got, tt.want)
+		}
+	}
+
+	if _, err := newEventPublisher("mqtt://localhost:1883/events"); err == nil {
+		t.Errorf("expected an error for an unsupported scheme")
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bodies := make(chan []byte, 10)
+
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer h.Close()
+
+	b, err := newEventBus([]string{h.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &hook.Request{ID: "abc", RawRequest: httptest.NewRequest("POST", "/hooks/deploy", nil)}
+	b.PublishDelivery(&hook.Hook{ID: "deploy"}, req, true)
+	b.PublishExecution(Execution{RequestID: "abc", HookID: "deploy", ExitCode: 1, Error: "exit status 1", Output: "secret", Env: []string{"TOKEN=secret"}})
+
+	for _, want := range []Event{
+		{Type: EventDelivery, RequestID: "abc", HookID: "deploy", Delivery: &DeliveryEvent{Method: "POST", RemoteAddr: "192.0.2.1:1234", Triggered: true}},
+		{Type: EventExecution, RequestID: "abc", HookID: "deploy", Execution: &Execution{RequestID: "abc", HookID: "deploy", ExitCode: 1, Error: "exit status 1"}},
+	} {
+		var body []byte
+		select {
+		case body = <-bodies:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %s event", want.Type)
+		}
+
+		if strings.Contains(string(body), "secret") {
+			t.Errorf("expected the output and environment to be omitted, got %s", body)
+		}
+
+		var got Event
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Time.IsZero() {
+			t.Errorf("expected the %s event to have a time", got.Type)
+		}
+		got.Time = time.Time{}
+
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("got %s, want %s", gotJSON, wantJSON)
+		}
+	}
+
+	// The nil bus discards events.
+	var nilBus *eventBus
+	nilBus.PublishDelivery(&hook.Hook{ID: "deploy"}, req, false)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Execution describes the outcome of a single hook command execution.
+type Execution struct {
+	RequestID string        `json:"request-id"`
+	HookID    string        `json:"hook-id"`
+	Group     string        `json:"group,omitempty"`
+	Command   string        `json:"command"`
+	Args      []string      `json:"args,omitempty"`
+	Env       []string      `json:"env,omitempty"`
+	Started   time.Time     `json:"started"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  int           `json:"exit-code"`
+	Error     string        `json:"error,omitempty"`
+	Output    string        `json:"output,omitempty"`
+
+	// UserTime and SystemTime are the CPU time used by the command, and
+	// MaxRSS its maximum resident set size in bytes, where supported.
+	UserTime   time.Duration `json:"user-time"`
+	SystemTime time.Duration `json:"system-time"`
+	MaxRSS     int64         `json:"max-rss,omitempty"`
+
+	// Result is the parsed command output of hooks with a
+	// command-output-format.
+	Result map[string]interface{} `json:"result,omitempty"`
+}
+
+// executionHistory is a fixed-size, concurrency-safe ring of the most recent
+// executions.
+type executionHistory struct {
+	mu    sync.Mutex
+	items []Execution
+	next  int
+	full  bool
+}
+
+func newExecutionHistory(size int) *executionHistory {
+	if size < 0 {
+		size = 0
+	}
+
+	return &executionHistory{items: make([]Execution, size)}
+}
+
+// Add appends e to the history, evicting the oldest entry if the history is
+// full.
+func (h *executionHistory) Add(e Execution) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.items) == 0 {
+		return
+	}
+
+	h.items[h.next] = e
+	h.next = (h.next + 1) % len(h.items)
+
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// List returns the recorded executions, newest first. If hookID is not empty,
+// only executions of that hook are returned. A limit of zero or less returns
+// all matching executions.
+func (h *executionHistory) List(hookID string, limit int) []Execution {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := h.next
+	if h.full {
+		n = len(h.items)
+	}
+
+	res := make([]Execution, 0, n)
+
+	for i := 0; i < n; i++ {
+		e := h.items[(h.next-1-i+len(h.items))%len(h.items)]
+
+		if hookID != "" && e.HookID != hookID {
+			continue
+		}
+
+		res = append(res, e)
+
+		if limit > 0 && len(res) == limit {
+			break
+		}
+	}
+
+	return res
+}
+
+// Find returns the most recent execution for the request requestID.
+func (h *executionHistory) Find(requestID string) (Execution, bool) {
+	for _, e := range h.List("", 0) {
+		if e.RequestID == requestID {
+			return e, true
+		}
+	}
+
+	return Execution{}, false
+}
+
+// HookUsage is the resource usage of all executions of a hook since startup.
+type HookUsage struct {
+	HookID     string        `json:"hook-id"`
+	Group      string        `json:"group,omitempty"`
+	Executions int           `json:"executions"`
+	Failures   int           `json:"failures"`
+	WallTime   time.Duration `json:"wall-time"`
+	UserTime   time.Duration `json:"user-time"`
+	SystemTime time.Duration `json:"system-time"`
+	MaxRSS     int64         `json:"max-rss,omitempty"`
+}
+
+// usageAccounting accumulates the resource usage of executions per hook.
+// Unlike the execution history, it covers every execution.
+type usageAccounting struct {
+	mu    sync.Mutex
+	hooks map[string]*HookUsage
+}
+
+// Add accounts for the resources used by e.
+func (u *usageAccounting) Add(e Execution) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.hooks == nil {
+		u.hooks = make(map[string]*HookUsage)
+	}
+
+	hu, ok := u.hooks[e.HookID]
+	if !ok {
+		hu = &HookUsage{HookID: e.HookID, Group: e.Group}
+		u.hooks[e.HookID] = hu
+	}
+
+	hu.Executions++
+	if e.Error != "" {
+		hu.Failures++
+	}
+
+	hu.WallTime += e.Duration
+	hu.UserTime += e.UserTime
+	hu.SystemTime += e.SystemTime
+
+	if e.MaxRSS > hu.MaxRSS {
+		hu.MaxRSS = e.MaxRSS
+	}
+}
+
+// List returns the usage of the hook hookID, or of all hooks ordered by ID if
+// hookID is empty.  If group is not empty, only hooks of that group are
+// returned.
+func (u *usageAccounting) List(hookID, group string) []HookUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	res := make([]HookUsage, 0, len(u.hooks))
+
+	for id, hu := range u.hooks {
+		if (hookID == "" || id == hookID) && (group == "" || hu.Group == group) {
+			res = append(res, *hu)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].HookID < res[j].HookID })
+
+	return res
+}
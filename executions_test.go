@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestExecutionHistory(t *testing.T) {
+	h := newExecutionHistory(3)
+
+	for _, id := range []string{"a", "b", "a", "c"} {
+		h.Add(Execution{HookID: id})
+	}
+
+	for _, tt := range []struct {
+		hookID string
+		limit  int
+		expect []string
+	}{
+		{"", 0, []string{"c", "a", "b"}},
+		{"", 2, []string{"c", "a"}},
+		{"a", 0, []string{"a"}},
+		{"z", 0, []string{}},
+	} {
+		res := h.List(tt.hookID, tt.limit)
+
+		ids := make([]string, len(res))
+		for i := range res {
+			ids[i] = res[i].HookID
+		}
+
+		if len(ids) != len(tt.expect) {
+			t.Errorf("List(%q, %d): expected %v, got %v", tt.hookID, tt.limit, tt.expect, ids)
+			continue
+		}
+
+		for i := range ids {
+			if ids[i] != tt.expect[i] {
+				t.Errorf("List(%q, %d): expected %v, got %v", tt.hookID, tt.limit, tt.expect, ids)
+				break
+			}
+		}
+	}
+
+	if res := newExecutionHistory(0).List("", 0); len(res) != 0 {
+		t.Errorf("expected empty history, got %v", res)
+	}
+}
+
+func TestUsageAccounting(t *testing.T) {
+	var u usageAccounting
+
+	u.Add(Execution{HookID: "b", Group: "g", Duration: 3, UserTime: 2, SystemTime: 1, MaxRSS: 100})
+	u.Add(Execution{HookID: "a", Duration: 5, UserTime: 4, SystemTime: 1, MaxRSS: 300, Error: "exit status 1"})
+	u.Add(Execution{HookID: "a", Duration: 7, UserTime: 6, SystemTime: 2, MaxRSS: 200})
+
+	res := u.List("", "")
+	if len(res) != 2 || res[0].HookID != "a" || res[1].HookID != "b" {
+		t.Fatalf("expected usage of a and b, got %+v", res)
+	}
+
+	expect := HookUsage{HookID: "a", Executions: 2, Failures: 1, WallTime: 12, UserTime: 10, SystemTime: 3, MaxRSS: 300}
+	if res[0] != expect {
+		t.Errorf("expected %+v, got %+v", expect, res[0])
+	}
+
+	if res := u.List("b", ""); len(res) != 1 || res[0].Executions != 1 {
+		t.Errorf("expected usage of b only, got %+v", res)
+	}
+
+	if res := u.List("", "g"); len(res) != 1 || res[0].HookID != "b" {
+		t.Errorf("expected usage of the hooks of group g, got %+v", res)
+	}
+}
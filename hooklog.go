@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// hookLogs writes the log-file of hooks.  Hooks sharing a path share the
+// file.
+var hookLogs = &hookLogFiles{files: make(map[string]*hookLogFile)}
+
+// hookLogFiles holds the open hook log files by path.
+type hookLogFiles struct {
+	mu    sync.Mutex
+	files map[string]*hookLogFile
+}
+
+// logger returns the logger writing to the log-file of h, or nil if h doesn't
+// have one.  The rotation settings of the file are updated to those of h, so
+// they follow reloads.
+func (l *hookLogFiles) logger(h *hook.Hook) *log.Logger {
+	if h.LogFile == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.files[h.LogFile.Path]
+	if !ok {
+		f = &hookLogFile{now: time.Now}
+		f.logger = log.New(f, "", log.Ldate|log.Ltime)
+		l.files[h.LogFile.Path] = f
+	}
+
+	f.configure(*h.LogFile)
+
+	return f.logger
+}
+
+// Delivery logs the request req to h and whether it triggered the command.
+func (l *hookLogFiles) Delivery(h *hook.Hook, req *hook.Request, triggered bool) {
+	logger := l.logger(h)
+	if logger == nil {
+		return
+	}
+
+	outcome := "didn't get triggered because the trigger rules were not satisfied"
+	if triggered {
+		outcome = "triggered"
+	}
+
+	if r := req.RawRequest; r != nil {
+		logger.Printf("[%s] %s %s from %s: %s %s\n", req.ID, r.Method, r.URL.Path, r.RemoteAddr, h.ID, outcome)
+	} else {
+		logger.Printf("[%s] %s %s\n", req.ID, h.ID, outcome)
+	}
+}
+
+// Execution logs the outcome and the full output of the execution ex of h.
+func (l *hookLogFiles) Execution(h *hook.Hook, ex Execution) {
+	logger := l.logger(h)
+	if logger == nil {
+		return
+	}
+
+	logger.Printf("[%s] executed %s with arguments %q: exit code %d after %s\n", ex.RequestID, ex.Command, ex.Args, ex.ExitCode, ex.Duration)
+
+	if ex.Error != "" {
+		logger.Printf("[%s] error occurred: %s\n", ex.RequestID, ex.Error)
+	}
+
+	logger.Printf("[%s] command output: %s\n", ex.RequestID, ex.Output)
+}
+
+// hookLogFile is a log file rotated by size and time.  Rotated files are
+// renamed with the time of the rotation appended.
+type hookLogFile struct {
+	mu       sync.Mutex
+	settings hook.LogFile
+	logger   *log.Logger
+	file     *os.File
+	size     int64
+	last     time.Time // time of the last write
+	now      func() time.Time
+}
+
+func (f *hookLogFile) configure(settings hook.LogFile) {
+	f.mu.Lock()
+	f.settings = settings
+	f.mu.Unlock()
+}
+
+// Write implements io.Writer.
+func (f *hookLogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.size > 0 && (f.size+int64(len(p)) > f.settings.MaxBytes() || f.periodPassed(now)) {
+		if err := f.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	f.last = now
+
+	return n, err
+}
+
+// periodPassed reports whether the rotation period of the file changed since
+// the last write.
+func (f *hookLogFile) periodPassed(now time.Time) bool {
+	d := f.settings.Interval()
+	if d == 0 {
+		return false
+	}
+
+	return !now.UTC().Truncate(d).Equal(f.last.UTC().Truncate(d))
+}
+
+// open opens the log file for appending, continuing the period of its last
+// write.
+func (f *hookLogFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.settings.Path), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.settings.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file, f.size, f.last = file, fi.Size(), fi.ModTime()
+
+	return nil
+}
+
+// backupTimeFormat is appended to the names of rotated files.  It sorts
+// chronologically and avoids colons, which aren't allowed on Windows.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotate renames the log file, opens a new one and removes the rotated files
+// exceeding max-backups.
+func (f *hookLogFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	f.file = nil
+
+	backup := fmt.Sprintf("%s.%s", f.settings.Path, now.UTC().Format(backupTimeFormat))
+	if err := os.Rename(f.settings.Path, backup); err != nil {
+		return err
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.settings.MaxBackups > 0 {
+		backups, _ := filepath.Glob(f.settings.Path + ".*")
+		sort.Strings(backups)
+
+		if n := len(backups) - f.settings.MaxBackups; n > 0 {
+			for _, backup := range backups[:n] {
+				os.Remove(backup)
+			}
+		}
+	}
+
+	return nil
+}
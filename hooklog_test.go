@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestHookLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-hooklog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "deploy", "deploy.log")
+
+	var settings hook.LogFile
+	if err := json.Unmarshal([]byte(`{"path": "`+filepath.ToSlash(path)+`", "max-size": 1, "rotate-every": "24h", "max-backups": 2}`), &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	f := &hookLogFile{settings: settings, now: func() time.Time { return now }}
+
+	write := func(s string) {
+		t.Helper()
+
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups := func() []string {
+		names, _ := filepath.Glob(path + ".*")
+		return names
+	}
+
+	write("first\n")
+	write("second\n")
+	if n := len(backups()); n != 0 {
+		t.Fatalf("expected no rotation within the size and the day, got %d backups", n)
+	}
+
+	// Exceeding the size rotates the file.
+	write(strings.Repeat("x", 1<<20) + "\n")
+	if n := len(backups()); n != 1 {
+		t.Fatalf("expected the file to be rotated by size, got %d backups", n)
+	}
+
+	// Writing on the next day rotates the file.
+	now = now.Add(13 * time.Hour)
+	write("next day\n")
+	if n := len(backups()); n != 2 {
+		t.Fatalf("expected the file to be rotated by time, got %d backups", n)
+	}
+
+	// Backups beyond max-backups are removed, oldest first.
+	now = now.Add(24 * time.Hour)
+	write("day after\n")
+
+	names := backups()
+	if len(names) != 2 || !strings.HasSuffix(names[0], "2024-03-02T01-00-00.000") {
+		t.Errorf("expected the 2 newest backups to be kept, got %q", names)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "day after\n" {
+		t.Errorf("got %q in the log file", data)
+	}
+}
+
+func TestHookLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-hooklog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var h hook.Hook
+	if err := json.Unmarshal([]byte(`{"id": "deploy", "log-file": "`+filepath.ToSlash(filepath.Join(dir, "deploy.log"))+`"}`), &h); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := &hookLogFiles{files: make(map[string]*hookLogFile)}
+
+	req := &hook.Request{ID: "abc", RawRequest: httptest.NewRequest("POST", "/hooks/deploy", nil)}
+	logs.Delivery(&h, req, true)
+	logs.Execution(&h, Execution{RequestID: "abc", HookID: "deploy", Command: "/bin/deploy.sh", ExitCode: 1, Error: "exit status 1", Output: "deploying\nfailed\n"})
+
+	// Hooks without a log-file are not logged.
+	logs.Delivery(&hook.Hook{ID: "other"}, req, true)
+
+	data, err := ioutil.ReadFile(h.LogFile.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"[abc] POST /hooks/deploy from 192.0.2.1:1234: deploy triggered",
+		"[abc] executed /bin/deploy.sh with arguments []: exit code 1",
+		"[abc] error occurred: exit status 1",
+		"[abc] command output: deploying\nfailed\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected the log to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	if len(logs.files) != 1 {
+		t.Errorf("expected 1 open log file, got %d", len(logs.files))
+	}
+}
@@ -0,0 +1,21 @@
+package hooksrv
+
+import "github.com/adnanh/webhook/internal/hook"
+
+// PayloadDecryption defines how the encrypted request body of a hook is
+// decrypted.
+type PayloadDecryption = hook.PayloadDecryption
+
+// Decrypter decrypts request bodies of a custom decrypt-payload format.
+type Decrypter = hook.Decrypter
+
+// DecrypterFunc is a function implementing Decrypter.
+type DecrypterFunc = hook.DecrypterFunc
+
+// RegisterDecrypter makes the Decrypter d decrypt request bodies of hooks with
+// {"decrypt-payload": {"format": name}}, ie. to support PGP.  It is meant to be
+// called from init functions, and panics if name is empty, a built-in format
+// or already registered.
+func RegisterDecrypter(name string, d Decrypter) {
+	hook.RegisterDecrypter(name, d)
+}
@@ -0,0 +1,466 @@
+// Package hooksrv serves webhook hooks from an http.Handler, so that other Go
+// programs can embed hooks without running the webhook command.
+//
+// The handler matches the hook by the request path, ie. "/deploy" or the
+// hook's incoming-path, evaluates its trigger rule and runs its command like
+// the webhook command does.  Mount it under a prefix with http.StripPrefix:
+//
+//	hooks, err := hooksrv.LoadHooks("hooks.json")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	http.Handle("/hooks/", http.StripPrefix("/hooks", hooksrv.New(hooks)))
+//
+// Features of the webhook command that depend on its server, such as services,
+// proxy hooks, caller identities, CORS and CSRF protection, request capturing,
+// the admin endpoints and the execution history, are not available.
+package hooksrv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+	"github.com/adnanh/webhook/internal/middleware"
+)
+
+// Hook is a hook definition.
+type Hook = hook.Hook
+
+// Hooks is a list of hook definitions.
+type Hooks = hook.Hooks
+
+// LoadHooks loads the hooks from the JSON or YAML hooks file path.
+func LoadHooks(path string) (Hooks, error) {
+	var hooks Hooks
+
+	if err := hooks.LoadFromFile(path, false); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// Server is an http.Handler serving hooks.
+type Server struct {
+	mu    sync.RWMutex
+	hooks Hooks
+
+	logger             *log.Logger
+	metrics            Metrics
+	executor           Executor
+	maxMultipartMemory int64
+
+	handler http.Handler
+	running sync.WaitGroup
+}
+
+// New returns a Server serving hooks, configured with the options opts.
+func New(hooks Hooks, opts ...Option) *Server {
+	s := &Server{
+		hooks:              hooks,
+		logger:             log.New(ioutil.Discard, "", 0),
+		metrics:            nopMetrics{},
+		executor:           ExecExecutor{},
+		maxMultipartMemory: 1 << 20,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.handler = middleware.RequestID()(http.HandlerFunc(s.serveHook))
+
+	return s
+}
+
+// SetHooks replaces the served hooks, ie. after reloading the hooks file.
+func (s *Server) SetHooks(hooks Hooks) {
+	s.mu.Lock()
+	s.hooks = hooks
+	s.mu.Unlock()
+}
+
+// Wait waits for the commands of hooks that don't capture their output, which
+// run after the response is sent, to exit.
+func (s *Server) Wait() {
+	s.running.Wait()
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// match returns the hook served at path, or nil if there is none.
+func (s *Server) match(path string) *Hook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.hooks {
+		if s.hooks[i].IncomingPath != "" && s.hooks[i].IncomingPath == path {
+			return &s.hooks[i]
+		}
+	}
+
+	if h := s.hooks.Match(strings.TrimPrefix(path, "/")); h != nil && !h.HideID {
+		return h
+	}
+
+	return nil
+}
+
+func (s *Server) serveHook(w http.ResponseWriter, r *http.Request) {
+	req := &hook.Request{
+		ID:         middleware.GetReqID(r.Context()),
+		RawRequest: r,
+		Received:   hook.Now(),
+	}
+
+	s.logger.Printf("[%s] incoming HTTP %s request from %s\n", req.ID, r.Method, r.RemoteAddr)
+
+	h := s.match(r.URL.Path)
+	if h == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Hook not found.")
+		return
+	}
+
+	if !methodAllowed(h, r.Method) {
+		s.logger.Printf("[%s] HTTP %s method not allowed for hook %q", req.ID, r.Method, h.ID)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.logger.Printf("[%s] %s got matched\n", req.ID, h.ID)
+
+	if err := s.parseRequest(h, req); err != nil {
+		s.logger.Printf("[%s] %s", req.ID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error occurred while reading the request.")
+		return
+	}
+
+	for _, err := range h.ParseJSONParameters(req) {
+		s.logger.Printf("[%s] error parsing JSON parameters: %s\n", req.ID, err)
+	}
+
+	for _, err := range h.NormalizePayload(req) {
+		s.logger.Printf("[%s] error normalizing payload: %s\n", req.ID, err)
+	}
+
+	if err := h.RunPlugins(req); err != nil {
+		if hook.IsVetoError(err) {
+			s.logger.Printf("[%s] request for hook %q %s", req.ID, h.ID, err)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Request rejected.")
+			return
+		}
+
+		s.logger.Printf("[%s] error running plugins of hook %q: %s", req.ID, h.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error occurred while running the hook's plugins.")
+		return
+	}
+
+	ok := true
+
+	if h.TriggerRule != nil {
+		req.AllowSignatureErrors = h.TriggerSignatureSoftFailures
+
+		var err error
+
+		ok, err = h.TriggerRule.Evaluate(req)
+		if err != nil {
+			if !hook.IsParameterNodeError(err) {
+				s.logger.Printf("[%s] error evaluating hook: %s", req.ID, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, "Error occurred while evaluating hook rules.")
+				return
+			}
+
+			s.logger.Printf("[%s] %v", req.ID, err)
+		}
+	}
+
+	if ok && h.ReplayProtection != nil {
+		replay, err := h.IsReplay(req)
+		if err != nil {
+			s.logger.Printf("[%s] rejecting request for hook %q: %s", req.ID, h.ID, err)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "Missing delivery ID.")
+			return
+		}
+
+		if replay {
+			s.logger.Printf("[%s] rejecting replayed delivery to hook %q", req.ID, h.ID)
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "Duplicate delivery.")
+			return
+		}
+	}
+
+	if ok && !req.RecordNonces() {
+		s.logger.Printf("[%s] rejecting replayed delivery to hook %q", req.ID, h.ID)
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, "Duplicate delivery.")
+		return
+	}
+
+	s.metrics.Request(h.ID, ok)
+
+	if !ok {
+		s.logger.Printf("[%s] %s got matched, but didn't get triggered because the trigger rules were not satisfied\n", req.ID, h.ID)
+
+		if h.TriggerRuleMismatchHttpResponseCode != 0 {
+			s.writeStatus(w, req.ID, h, h.TriggerRuleMismatchHttpResponseCode)
+		}
+
+		fmt.Fprint(w, "Hook rules were not satisfied.")
+		return
+	}
+
+	s.logger.Printf("[%s] %s hook triggered successfully\n", req.ID, h.ID)
+
+	for _, header := range h.ResponseHeaders {
+		w.Header().Set(header.Name, header.Value)
+	}
+
+	if !h.CaptureCommandOutput {
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			s.execute(h, req)
+		}()
+
+		if h.SuccessHttpResponseCode != 0 {
+			s.writeStatus(w, req.ID, h, h.SuccessHttpResponseCode)
+		}
+
+		fmt.Fprint(w, h.ResponseMessage)
+		return
+	}
+
+	out, err := s.execute(h, req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		if h.CaptureCommandOutputOnError {
+			fmt.Fprint(w, out)
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, "Error occurred while executing the hook's command. Please check your logs for more details.")
+		}
+
+		return
+	}
+
+	out = s.applyOutput(w, h, req.ID, out)
+
+	if h.SuccessHttpResponseCode != 0 {
+		s.writeStatus(w, req.ID, h, h.SuccessHttpResponseCode)
+	}
+
+	fmt.Fprint(w, out)
+}
+
+// methodAllowed reports whether h accepts requests with the HTTP method.
+func methodAllowed(h *Hook, method string) bool {
+	if len(h.HTTPMethods) == 0 {
+		return true
+	}
+
+	for _, m := range h.HTTPMethods {
+		if method == strings.ToUpper(strings.TrimSpace(m)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRequest reads the body of the request req for h and parses its
+// headers, query and payload.
+func (s *Server) parseRequest(h *Hook, req *hook.Request) error {
+	r := req.RawRequest
+
+	req.ContentType = r.Header.Get("Content-Type")
+	if h.IncomingPayloadContentType != "" {
+		req.ContentType = h.IncomingPayloadContentType
+	}
+
+	req.ParseHeaders(r.Header)
+	req.ParseQuery(r.URL.Query())
+
+	if strings.HasPrefix(req.ContentType, "multipart/form-data;") {
+		if err := r.ParseMultipartForm(s.maxMultipartMemory); err != nil {
+			return fmt.Errorf("error parsing multipart form: %w", err)
+		}
+
+		req.Payload = make(map[string]interface{})
+		for k, v := range r.MultipartForm.Value {
+			req.Payload[k] = v[0]
+		}
+
+		return nil
+	}
+
+	var err error
+
+	req.Body, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("error reading the request body: %w", err)
+	}
+
+	if h.DecryptPayload != nil {
+		if err := h.DecryptRequest(req); err != nil {
+			return fmt.Errorf("error decrypting the payload: %w", err)
+		}
+	}
+
+	switch {
+	case strings.Contains(req.ContentType, "json"):
+		err = req.ParseJSONPayload()
+	case strings.Contains(req.ContentType, "x-www-form-urlencoded"):
+		err = req.ParseFormPayload()
+	case strings.Contains(req.ContentType, "xml"):
+		err = req.ParseXMLPayload()
+	default:
+		s.logger.Printf("[%s] error parsing body payload due to unsupported content type header: %s\n", req.ID, req.ContentType)
+	}
+
+	if err != nil {
+		s.logger.Printf("[%s] %s", req.ID, err)
+	}
+
+	return nil
+}
+
+// execute runs the command of h for the request req and returns its output.
+func (s *Server) execute(h *Hook, req *hook.Request) (string, error) {
+	cmd := &Command{
+		HookID: h.ID,
+		Path:   h.CommandPath(),
+		Dir:    h.CommandWorkingDirectory,
+	}
+
+	var errors []error
+
+	cmd.Args, errors = h.ExtractCommandArguments(req)
+	for _, err := range errors {
+		s.logger.Printf("[%s] error extracting command arguments: %s\n", req.ID, err)
+	}
+
+	cmd.Env, errors = h.ExtractCommandArgumentsForEnv(req)
+	for _, err := range errors {
+		s.logger.Printf("[%s] error extracting command arguments for environment: %s\n", req.ID, err)
+	}
+
+	files, errors := h.ExtractCommandArgumentsForFile(req)
+	for _, err := range errors {
+		s.logger.Printf("[%s] error extracting command arguments for file: %s\n", req.ID, err)
+	}
+
+	for i := range files {
+		f, err := ioutil.TempFile(h.CommandWorkingDirectory, files[i].EnvName)
+		if err != nil {
+			s.logger.Printf("[%s] error creating temp file [%s]", req.ID, err)
+			continue
+		}
+
+		files[i].File = f
+		defer os.Remove(f.Name())
+
+		_, err = f.Write(files[i].Data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			s.logger.Printf("[%s] error writing file %s [%s]", req.ID, f.Name(), err)
+			continue
+		}
+
+		cmd.Env = append(cmd.Env, files[i].EnvName+"="+f.Name())
+	}
+
+	s.logger.Printf("[%s] executing %s with arguments %q and environment %s using %s as cwd\n", req.ID, cmd.Path, cmd.Args, cmd.Env, cmd.Dir)
+
+	started := time.Now()
+	res, err := s.executor.Execute(req.RawRequest.Context(), cmd)
+
+	s.logger.Printf("[%s] command output: %s\n", req.ID, res.Output)
+
+	out := hook.SanitizeOutput(string(res.Output), h.SanitizeOutput)
+
+	// Commands that exited are judged by the hook's success criteria.
+	if h.SuccessCriteria != nil && res.ExitCode >= 0 {
+		err = h.SuccessCriteria.Check(res.ExitCode, out)
+	}
+
+	if err != nil {
+		s.logger.Printf("[%s] error occurred: %+v\n", req.ID, err)
+
+		// Let the sender retry with the same delivery ID.
+		req.ForgetNonces()
+	}
+
+	s.metrics.Execution(h.ID, time.Since(started), err)
+
+	s.logger.Printf("[%s] finished handling %s\n", req.ID, h.ID)
+
+	return out, err
+}
+
+// applyOutput sets the response headers mapped from the parsed command output
+// out of h and returns the response body.
+func (s *Server) applyOutput(w http.ResponseWriter, h *Hook, rid, out string) string {
+	output, err := h.ParseCommandOutput(out)
+	if err != nil {
+		s.logger.Printf("[%s] %s", rid, err)
+		return out
+	}
+
+	if output == nil {
+		return out
+	}
+
+	headers, errors := h.OutputHeaders(output)
+	for _, err := range errors {
+		s.logger.Printf("[%s] error mapping command output to response header: %s\n", rid, err)
+	}
+
+	for _, header := range headers {
+		w.Header().Set(header.Name, header.Value)
+	}
+
+	if h.ResponseTemplate == "" {
+		return out
+	}
+
+	body, err := h.ExecuteResponseTemplate(output)
+	if err != nil {
+		s.logger.Printf("[%s] error executing response template: %s\n", rid, err)
+		return out
+	}
+
+	return body
+}
+
+// writeStatus writes the response status code, unless the http package
+// doesn't know it.
+func (s *Server) writeStatus(w http.ResponseWriter, rid string, h *Hook, code int) {
+	if http.StatusText(code) == "" {
+		s.logger.Printf("[%s] %s got matched, but the configured return code %d is unknown - defaulting to 200\n", rid, h.ID, code)
+		return
+	}
+
+	w.WriteHeader(code)
+}
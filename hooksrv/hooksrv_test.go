@@ -0,0 +1,534 @@
+package hooksrv
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+type fakeExecutor struct {
+	mu   sync.Mutex
+	cmds []*Command
+	res  Result
+	err  error
+}
+
+func (e *fakeExecutor) Execute(ctx context.Context, cmd *Command) (Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.cmds = append(e.cmds, cmd)
+
+	return e.res, e.err
+}
+
+type fakeMetrics struct {
+	mu         sync.Mutex
+	requests   []string
+	executions []string
+}
+
+func (m *fakeMetrics) Request(id string, triggered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if triggered {
+		id += " triggered"
+	}
+	m.requests = append(m.requests, id)
+}
+
+func (m *fakeMetrics) Execution(id string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		id += " failed"
+	}
+	m.executions = append(m.executions, id)
+}
+
+func testHooks() Hooks {
+	return Hooks{
+		{
+			ID:             "deploy",
+			ExecuteCommand: "deploy.sh",
+			HTTPMethods:    []string{"POST"},
+			PassArgumentsToCommand: []hook.Argument{
+				{Source: "payload", Name: "ref"},
+			},
+			PassEnvironmentToCommand: []hook.Argument{
+				{Source: "header", Name: "X-Event", EnvName: "EVENT"},
+			},
+			TriggerRule: &hook.Rules{
+				Match: &hook.MatchRule{
+					Type:      "value",
+					Value:     "refs/heads/main",
+					Parameter: hook.Argument{Source: "payload", Name: "ref"},
+				},
+			},
+			TriggerRuleMismatchHttpResponseCode: http.StatusAccepted,
+			CaptureCommandOutput:                true,
+		},
+		{
+			ID:              "async",
+			ExecuteCommand:  "async.sh",
+			ResponseMessage: "started",
+		},
+		{
+			ID:             "hidden",
+			ExecuteCommand: "hidden.sh",
+			IncomingPath:   "/integrations/github",
+			HideID:         true,
+		},
+	}
+}
+
+func TestServer(t *testing.T) {
+	e := &fakeExecutor{res: Result{Output: []byte("deployed"), ExitCode: 0}}
+	m := &fakeMetrics{}
+
+	s := New(testHooks(), WithExecutor(e), WithMetrics(m))
+
+	for _, tt := range []struct {
+		desc           string
+		method, path   string
+		body           string
+		wantStatus     int
+		wantBody       string
+		wantExecutions int
+	}{
+		{"unknown hook", "POST", "/missing", "", http.StatusNotFound, "Hook not found.", 0},
+		{"method not allowed", "GET", "/deploy", "", http.StatusMethodNotAllowed, "", 0},
+		{"rules not satisfied", "POST", "/deploy", `{"ref": "refs/heads/dev"}`, http.StatusAccepted, "Hook rules were not satisfied.", 0},
+		{"captured output", "POST", "/deploy", `{"ref": "refs/heads/main"}`, http.StatusOK, "deployed", 1},
+		{"response message", "POST", "/async", "", http.StatusOK, "started", 2},
+		{"hidden id", "POST", "/hidden", "", http.StatusNotFound, "Hook not found.", 2},
+		{"incoming path", "POST", "/integrations/github", "", http.StatusOK, "", 3},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Event", "push")
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			s.Wait()
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+
+			if len(e.cmds) != tt.wantExecutions {
+				t.Errorf("%d executions, want %d", len(e.cmds), tt.wantExecutions)
+			}
+		})
+	}
+
+	cmd := e.cmds[0]
+	if cmd.HookID != "deploy" || cmd.Path != "deploy.sh" {
+		t.Errorf("command = %+v", cmd)
+	}
+
+	if want := []string{"deploy.sh", "refs/heads/main"}; strings.Join(cmd.Args, " ") != strings.Join(want, " ") {
+		t.Errorf("args = %q, want %q", cmd.Args, want)
+	}
+
+	if want := []string{"EVENT=push"}; len(cmd.Env) != 1 || cmd.Env[0] != want[0] {
+		t.Errorf("env = %q, want %q", cmd.Env, want)
+	}
+
+	if want := "deploy deploy triggered async triggered hidden triggered"; strings.Join(m.requests, " ") != want {
+		t.Errorf("requests = %q, want %q", m.requests, want)
+	}
+
+	if want := "deploy async hidden"; strings.Join(m.executions, " ") != want {
+		t.Errorf("executions = %q, want %q", m.executions, want)
+	}
+}
+
+func TestServerCommandError(t *testing.T) {
+	hooks := testHooks()
+	e := &fakeExecutor{res: Result{Output: []byte("boom"), ExitCode: 1}, err: errors.New("exit status 1")}
+
+	s := New(hooks, WithExecutor(e))
+
+	req := httptest.NewRequest("POST", "/deploy", strings.NewReader(`{"ref": "refs/heads/main"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "boom") {
+		t.Errorf("got %d %q, want 500 without the output", rec.Code, rec.Body.String())
+	}
+
+	// Exit codes listed in the success criteria are successful.
+	hooks[0].SuccessCriteria = &hook.SuccessRule{ExitCodes: []int{0, 1}}
+	s.SetHooks(hooks)
+
+	req = httptest.NewRequest("POST", "/deploy", strings.NewReader(`{"ref": "refs/heads/main"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "boom" {
+		t.Errorf("got %d %q, want 200 %q", rec.Code, rec.Body.String(), "boom")
+	}
+}
+
+func TestExecExecutor(t *testing.T) {
+	res, err := ExecExecutor{}.Execute(context.Background(), &Command{
+		Path: "sh",
+		Args: []string{"sh", "-c", `echo "$GREETING"; exit 3`},
+		Env:  []string{"GREETING=hello"},
+	})
+
+	if err == nil || res.ExitCode != 3 || string(res.Output) != "hello\n" {
+		t.Errorf("got %q, exit code %d, error %v", res.Output, res.ExitCode, err)
+	}
+}
+
+type teamMatcher struct{}
+
+func (teamMatcher) Match(r *MatchRule, req *Request) (bool, error) {
+	team, err := r.Parameter.Get(req)
+	if err != nil {
+		return false, err
+	}
+
+	for _, t := range strings.Split(r.Options["teams"], ",") {
+		if t == team {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (teamMatcher) Validate(r *MatchRule) error {
+	if r.Options["teams"] == "" {
+		return errors.New("missing teams option")
+	}
+
+	return nil
+}
+
+func TestRegisterMatcher(t *testing.T) {
+	RegisterMatcher("team", teamMatcher{})
+
+	rule := &hook.Rules{
+		Match: &hook.MatchRule{
+			Type:      "team",
+			Parameter: hook.Argument{Source: "payload", Name: "team"},
+			Options:   map[string]string{"teams": "infra,web"},
+		},
+	}
+
+	if errs := rule.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+
+	e := &fakeExecutor{}
+	s := New(Hooks{{ID: "team", ExecuteCommand: "team.sh", TriggerRule: rule}}, WithExecutor(e))
+
+	for _, body := range []string{`{"team": "web"}`, `{"team": "sales"}`} {
+		req := httptest.NewRequest("POST", "/team", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	s.Wait()
+
+	if len(e.cmds) != 1 {
+		t.Errorf("expected 1 execution, got %d", len(e.cmds))
+	}
+
+	rule.Match.Options = nil
+	if errs := rule.Validate(); len(errs) != 1 {
+		t.Errorf("expected a validation error, got %v", errs)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a built-in type to panic")
+		}
+	}()
+
+	RegisterMatcher("value", teamMatcher{})
+}
+
+func TestRegisterSource(t *testing.T) {
+	owners := map[string]string{"api": "infra"}
+
+	RegisterSource("owner", SourceFunc(func(name string, req *Request) (string, error) {
+		if owner, ok := owners[name]; ok {
+			return owner, nil
+		}
+
+		return "", errors.New("unknown service " + name)
+	}))
+
+	e := &fakeExecutor{}
+	s := New(Hooks{{
+		ID:             "owned",
+		ExecuteCommand: "notify.sh",
+		PassArgumentsToCommand: []hook.Argument{
+			{Source: "owner", Name: "api"},
+		},
+		TriggerRule: &hook.Rules{
+			Match: &hook.MatchRule{
+				Type:      "value",
+				Value:     "infra",
+				Parameter: hook.Argument{Source: "owner", Name: "api"},
+			},
+		},
+	}}, WithExecutor(e))
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/owned", nil))
+	s.Wait()
+
+	if len(e.cmds) != 1 || strings.Join(e.cmds[0].Args, " ") != "notify.sh infra" {
+		t.Errorf("expected notify.sh to be executed with the owner, got %+v", e.cmds)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a built-in source to panic")
+		}
+	}()
+
+	RegisterSource("payload", SourceFunc(nil))
+}
+
+func TestServerReplayProtection(t *testing.T) {
+	e := &fakeExecutor{}
+	s := New(Hooks{{
+		ID:               "github",
+		ExecuteCommand:   "deploy.sh",
+		ReplayProtection: &hook.ReplayProtection{Keys: []hook.Argument{{Source: "header", Name: "X-GitHub-Delivery"}}},
+	}}, WithExecutor(e))
+
+	for _, tt := range []struct {
+		delivery   string
+		wantStatus int
+	}{
+		{"72d3162e-cc78-11e3-81ab-4c9367dc0958", http.StatusOK},
+		{"72d3162e-cc78-11e3-81ab-4c9367dc0958", http.StatusConflict},
+		{"", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/github", nil)
+		if tt.delivery != "" {
+			req.Header.Set("X-GitHub-Delivery", tt.delivery)
+		}
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("delivery %q: status = %d, want %d", tt.delivery, rec.Code, tt.wantStatus)
+		}
+	}
+
+	s.Wait()
+
+	if len(e.cmds) != 1 {
+		t.Errorf("expected 1 execution, got %d", len(e.cmds))
+	}
+}
+
+func TestServerReplayRetry(t *testing.T) {
+	e := &fakeExecutor{err: errors.New("exit status 1"), res: Result{ExitCode: 1}}
+	s := New(Hooks{{
+		ID:                   "github",
+		ExecuteCommand:       "deploy.sh",
+		CaptureCommandOutput: true,
+		ReplayProtection:     &hook.ReplayProtection{Keys: []hook.Argument{{Source: "header", Name: "X-GitHub-Delivery"}}},
+	}}, WithExecutor(e))
+
+	deliver := func() int {
+		req := httptest.NewRequest("POST", "/github", nil)
+		req.Header.Set("X-GitHub-Delivery", "a7b1c3d2-cc78-11e3-81ab-4c9367dc0958")
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	// A delivery whose command failed can be retried.
+	if code := deliver(); code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", code, http.StatusInternalServerError)
+	}
+
+	e.err, e.res = nil, Result{}
+
+	if code := deliver(); code != http.StatusOK {
+		t.Fatalf("retry: status = %d, want %d", code, http.StatusOK)
+	}
+
+	if code := deliver(); code != http.StatusConflict {
+		t.Fatalf("replay: status = %d, want %d", code, http.StatusConflict)
+	}
+
+	if len(e.cmds) != 2 {
+		t.Errorf("expected 2 executions, got %d", len(e.cmds))
+	}
+}
+
+func TestServerHooksFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "hooksrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "greet.sh"), []byte("#!/bin/sh\necho \"hello $NAME from $(pwd)\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "hooks.yaml")
+	err = ioutil.WriteFile(path, []byte(`
+- id: greet
+  execute-command: greet.sh
+  command-working-directory: `+dir+`
+  include-command-output-in-response: true
+  pass-environment-to-command:
+  - source: payload
+    name: name
+    envname: NAME
+  trigger-rule:
+    match:
+      type: value
+      value: secret
+      parameter:
+        source: header
+        name: X-Token
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hooks, err := LoadHooks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/hooks/", http.StripPrefix("/hooks", New(hooks)))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"wrong", http.StatusOK, "Hook rules were not satisfied."},
+		{"secret", http.StatusOK, "hello gopher from " + dir + "\n"},
+	} {
+		req, err := http.NewRequest("POST", srv.URL+"/hooks/greet", strings.NewReader(`{"name": "gopher"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Token", tt.token)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+			t.Errorf("token %q: got %d %q, want %d %q", tt.token, res.StatusCode, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+
+	if _, err := LoadHooks(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected an error loading a missing hooks file")
+	}
+}
+
+func TestServerPlugins(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping building a WebAssembly module in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "hooksrv-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plugin.wasm")
+
+	cmd := exec.Command("go", "build", "-o", path, "../internal/hook/testdata/plugin")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building the plugin: %v\n%s", err, out)
+	}
+
+	e := &fakeExecutor{}
+	s := New(Hooks{{
+		ID:                     "deploy",
+		ExecuteCommand:         "deploy.sh",
+		Plugins:                []hook.Plugin{{Path: path}},
+		PassArgumentsToCommand: []hook.Argument{{Source: "plugin", Name: "runs"}},
+	}}, WithExecutor(e))
+
+	for _, tt := range []struct {
+		action     string
+		wantStatus int
+	}{
+		{"rewrite", http.StatusOK},
+		{"veto", http.StatusForbidden},
+		{"fail", http.StatusInternalServerError},
+	} {
+		req := httptest.NewRequest("POST", "/deploy", strings.NewReader(`{"action": "`+tt.action+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.action, rec.Code, tt.wantStatus)
+		}
+	}
+
+	s.Wait()
+
+	if len(e.cmds) != 1 || strings.Join(e.cmds[0].Args, " ") != "deploy.sh 1" {
+		t.Errorf("expected one execution with the plugin parameter, got %+v", e.cmds)
+	}
+}
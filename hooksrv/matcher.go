@@ -0,0 +1,21 @@
+package hooksrv
+
+import "github.com/adnanh/webhook/internal/hook"
+
+// Matcher evaluates match rules of a custom type.
+type Matcher = hook.Matcher
+
+// MatchRule is a match rule of a trigger rule.  Custom rules are configured
+// with its value, regex, secret, parameter and options properties.
+type MatchRule = hook.MatchRule
+
+// Request is a webhook request being evaluated.
+type Request = hook.Request
+
+// RegisterMatcher makes the Matcher m evaluate match rules of type typ, ie.
+// {"match": {"type": typ, ...}}.  It is meant to be called from init
+// functions, and panics if typ is empty, a built-in type or already
+// registered.
+func RegisterMatcher(typ string, m Matcher) {
+	hook.RegisterMatcher(typ, m)
+}
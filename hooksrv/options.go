@@ -0,0 +1,123 @@
+package hooksrv
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLogger sets the logger of the request handling log, which is discarded
+// by default.
+func WithLogger(l *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithMetrics sets the Metrics notified of requests and executions.
+func WithMetrics(m Metrics) Option {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithExecutor sets the Executor running hook commands, which defaults to
+// ExecExecutor.
+func WithExecutor(e Executor) Option {
+	return func(s *Server) {
+		s.executor = e
+	}
+}
+
+// WithMaxMultipartMemory sets the maximum memory used to parse multipart
+// forms in bytes, the rest being stored in temporary files.  It defaults to
+// 1 MiB.
+func WithMaxMultipartMemory(n int64) Option {
+	return func(s *Server) {
+		s.maxMultipartMemory = n
+	}
+}
+
+// Metrics is notified of the requests to hooks and their executions.
+type Metrics interface {
+	// Request is called for each request matching the hook id, with whether
+	// its trigger rule was satisfied.
+	Request(id string, triggered bool)
+
+	// Execution is called when the command of the hook id exited after d,
+	// with the error of an unsuccessful execution.
+	Execution(id string, d time.Duration, err error)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Request(string, bool)                   {}
+func (nopMetrics) Execution(string, time.Duration, error) {}
+
+// Command is the command of a hook to run for a request.
+type Command struct {
+	// HookID is the ID of the triggered hook.
+	HookID string
+
+	// Path is the command to run, as given in the hook definition.
+	Path string
+
+	// Args holds the command line arguments, including the command as
+	// Args[0].
+	Args []string
+
+	// Env holds the environment variables passed to the command in addition
+	// to those of the process.
+	Env []string
+
+	// Dir is the working directory of the command.
+	Dir string
+}
+
+// Result is the outcome of a command.
+type Result struct {
+	// Output holds the combined standard output and standard error.
+	Output []byte
+
+	// ExitCode is the exit status of the command, or -1 if it didn't start.
+	ExitCode int
+}
+
+// Executor runs hook commands, ie. locally, in containers or on a job queue.
+type Executor interface {
+	// Execute runs cmd and returns its result.  An error is returned if the
+	// command couldn't be run or didn't exit successfully.  ctx is the context
+	// of the request, which is canceled when the client goes away.
+	Execute(ctx context.Context, cmd *Command) (Result, error)
+}
+
+// ExecExecutor runs commands as local processes.  Commands run to completion
+// even if the client goes away.
+type ExecExecutor struct{}
+
+// Execute implements Executor.
+func (ExecExecutor) Execute(ctx context.Context, cmd *Command) (Result, error) {
+	res := Result{ExitCode: -1}
+
+	path, err := exec.LookPath(cmd.Path)
+	if err != nil {
+		return res, err
+	}
+
+	c := exec.Command(path)
+	c.Args = cmd.Args
+	c.Env = append(os.Environ(), cmd.Env...)
+	c.Dir = cmd.Dir
+
+	res.Output, err = c.CombinedOutput()
+	if c.ProcessState != nil {
+		res.ExitCode = c.ProcessState.ExitCode()
+	}
+
+	return res, err
+}
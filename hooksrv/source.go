@@ -0,0 +1,17 @@
+package hooksrv
+
+import "github.com/adnanh/webhook/internal/hook"
+
+// SourceResolver resolves argument values of a custom source.
+type SourceResolver = hook.SourceResolver
+
+// SourceFunc is a function implementing SourceResolver.
+type SourceFunc = hook.SourceFunc
+
+// RegisterSource makes the SourceResolver s resolve arguments with the source
+// name, ie. {"source": name, "name": "key"}, in rules and command arguments.
+// It is meant to be called from init functions, and panics if name is empty,
+// a built-in source or already registered.
+func RegisterSource(name string, s SourceResolver) {
+	hook.RegisterSource(name, s)
+}
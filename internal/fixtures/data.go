@@ -0,0 +1,385 @@
+package fixtures
+
+var fixtures = []Fixture{
+	{
+		Name:        "github-push",
+		Description: "GitHub push of one commit to main",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type":      "application/json",
+			"User-Agent":        "GitHub-Hookshot/a1b2c3d",
+			"X-GitHub-Event":    "push",
+			"X-GitHub-Delivery": "72d3162e-cc78-11e3-81ab-4c9367dc0958",
+		},
+		Body: `{
+  "ref": "refs/heads/main",
+  "before": "6113728f27ae82c7b1a177c8d03f9e96e0adf246",
+  "after": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+  "created": false,
+  "deleted": false,
+  "forced": false,
+  "compare": "https://github.com/octo-org/octo-repo/compare/6113728f27ae...0d1a26e67d8f",
+  "commits": [
+    {
+      "id": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+      "tree_id": "f9d2a07e9488b91af2641b26b9407fe22a451433",
+      "distinct": true,
+      "message": "Update README.md",
+      "timestamp": "2024-03-01T12:00:27+01:00",
+      "url": "https://github.com/octo-org/octo-repo/commit/0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+      "author": {"name": "Monalisa Octocat", "email": "mona@github.com", "username": "octocat"},
+      "committer": {"name": "GitHub", "email": "noreply@github.com", "username": "web-flow"},
+      "added": [],
+      "removed": [],
+      "modified": ["README.md"]
+    }
+  ],
+  "head_commit": {
+    "id": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+    "tree_id": "f9d2a07e9488b91af2641b26b9407fe22a451433",
+    "distinct": true,
+    "message": "Update README.md",
+    "timestamp": "2024-03-01T12:00:27+01:00",
+    "url": "https://github.com/octo-org/octo-repo/commit/0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+    "author": {"name": "Monalisa Octocat", "email": "mona@github.com", "username": "octocat"},
+    "committer": {"name": "GitHub", "email": "noreply@github.com", "username": "web-flow"},
+    "added": [],
+    "removed": [],
+    "modified": ["README.md"]
+  },
+  "repository": {
+    "id": 186853002,
+    "name": "octo-repo",
+    "full_name": "octo-org/octo-repo",
+    "private": false,
+    "owner": {"login": "octo-org", "id": 6811672, "type": "Organization"},
+    "html_url": "https://github.com/octo-org/octo-repo",
+    "clone_url": "https://github.com/octo-org/octo-repo.git",
+    "ssh_url": "git@github.com:octo-org/octo-repo.git",
+    "default_branch": "main"
+  },
+  "pusher": {"name": "octocat", "email": "mona@github.com"},
+  "sender": {"login": "octocat", "id": 583231, "type": "User"}
+}
+`,
+		sign: signGitHub,
+	},
+	{
+		Name:        "github-pull-request",
+		Description: "GitHub pull request opened from a feature branch",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type":      "application/json",
+			"User-Agent":        "GitHub-Hookshot/a1b2c3d",
+			"X-GitHub-Event":    "pull_request",
+			"X-GitHub-Delivery": "9b1ee2a0-d7f1-11ee-8f6b-1e4b2c6d9a10",
+		},
+		Body: `{
+  "action": "opened",
+  "number": 42,
+  "pull_request": {
+    "id": 1752332880,
+    "number": 42,
+    "state": "open",
+    "title": "Add health check endpoint",
+    "html_url": "https://github.com/octo-org/octo-repo/pull/42",
+    "draft": false,
+    "merged": false,
+    "user": {"login": "octocat", "id": 583231, "type": "User"},
+    "head": {
+      "label": "octocat:health-check",
+      "ref": "health-check",
+      "sha": "c3c7d91ac0fd1c0d1a26e67d8f5eaf1f6ba5c57f",
+      "repo": {"full_name": "octo-org/octo-repo", "clone_url": "https://github.com/octo-org/octo-repo.git"}
+    },
+    "base": {
+      "label": "octo-org:main",
+      "ref": "main",
+      "sha": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+      "repo": {"full_name": "octo-org/octo-repo", "clone_url": "https://github.com/octo-org/octo-repo.git"}
+    }
+  },
+  "repository": {
+    "id": 186853002,
+    "name": "octo-repo",
+    "full_name": "octo-org/octo-repo",
+    "private": false,
+    "owner": {"login": "octo-org", "id": 6811672, "type": "Organization"},
+    "default_branch": "main"
+  },
+  "sender": {"login": "octocat", "id": 583231, "type": "User"}
+}
+`,
+		sign: signGitHub,
+	},
+	{
+		Name:        "gitlab-push",
+		Description: "GitLab push of one commit to main",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type":      "application/json",
+			"User-Agent":        "GitLab/16.9.1",
+			"X-Gitlab-Event":    "Push Hook",
+			"X-Gitlab-Instance": "https://gitlab.com",
+		},
+		Body: `{
+  "object_kind": "push",
+  "event_name": "push",
+  "before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+  "after": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "ref": "refs/heads/main",
+  "checkout_sha": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+  "user_id": 4,
+  "user_name": "John Smith",
+  "user_username": "jsmith",
+  "user_email": "john@example.com",
+  "project_id": 15,
+  "project": {
+    "id": 15,
+    "name": "Diaspora",
+    "path_with_namespace": "mike/diaspora",
+    "web_url": "https://gitlab.com/mike/diaspora",
+    "git_ssh_url": "git@gitlab.com:mike/diaspora.git",
+    "git_http_url": "https://gitlab.com/mike/diaspora.git",
+    "default_branch": "main"
+  },
+  "commits": [
+    {
+      "id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+      "message": "Fix the build",
+      "title": "Fix the build",
+      "timestamp": "2024-03-01T14:27:31+02:00",
+      "url": "https://gitlab.com/mike/diaspora/-/commit/da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+      "author": {"name": "John Smith", "email": "john@example.com"},
+      "added": [],
+      "modified": ["Makefile"],
+      "removed": []
+    }
+  ],
+  "total_commits_count": 1
+}
+`,
+		sign: signGitLab,
+	},
+	{
+		Name:        "gitlab-merge-request",
+		Description: "GitLab merge request opened from a feature branch",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type":      "application/json",
+			"User-Agent":        "GitLab/16.9.1",
+			"X-Gitlab-Event":    "Merge Request Hook",
+			"X-Gitlab-Instance": "https://gitlab.com",
+		},
+		Body: `{
+  "object_kind": "merge_request",
+  "event_type": "merge_request",
+  "user": {"id": 1, "name": "Administrator", "username": "root", "email": "admin@example.com"},
+  "project": {
+    "id": 1,
+    "name": "Gitlab Test",
+    "path_with_namespace": "gitlabhq/gitlab-test",
+    "web_url": "https://gitlab.com/gitlabhq/gitlab-test",
+    "default_branch": "main"
+  },
+  "object_attributes": {
+    "id": 99,
+    "iid": 1,
+    "title": "MS-Viewport",
+    "state": "opened",
+    "action": "open",
+    "source_branch": "ms-viewport",
+    "target_branch": "main",
+    "merge_status": "unchecked",
+    "url": "https://gitlab.com/gitlabhq/gitlab-test/-/merge_requests/1",
+    "last_commit": {
+      "id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+      "message": "fixed readme",
+      "author": {"name": "GitLab dev user", "email": "gitlabdev@dv6700.(none)"}
+    }
+  },
+  "labels": [{"id": 206, "title": "API"}]
+}
+`,
+		sign: signGitLab,
+	},
+	{
+		Name:        "gitea-push",
+		Description: "Gitea push of one commit to main",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type":     "application/json",
+			"X-Gitea-Event":    "push",
+			"X-Gitea-Delivery": "f6266f16-1bf3-46a5-9ea4-602e06ead473",
+		},
+		Body: `{
+  "ref": "refs/heads/main",
+  "before": "28e1879d029cb852e4844d9c718537df08844e03",
+  "after": "bffeb74224043ba2feb48d137756c8a9331c449a",
+  "compare_url": "https://gitea.example.com/gitea/webhooks/compare/28e1879d029cb852e4844d9c718537df08844e03...bffeb74224043ba2feb48d137756c8a9331c449a",
+  "commits": [
+    {
+      "id": "bffeb74224043ba2feb48d137756c8a9331c449a",
+      "message": "Webhooks Yay!",
+      "url": "https://gitea.example.com/gitea/webhooks/commit/bffeb74224043ba2feb48d137756c8a9331c449a",
+      "author": {"name": "Gitea", "email": "someone@gitea.io", "username": "gitea"},
+      "committer": {"name": "Gitea", "email": "someone@gitea.io", "username": "gitea"},
+      "timestamp": "2024-03-01T15:15:01+01:00"
+    }
+  ],
+  "repository": {
+    "id": 140,
+    "name": "webhooks",
+    "full_name": "gitea/webhooks",
+    "private": false,
+    "html_url": "https://gitea.example.com/gitea/webhooks",
+    "clone_url": "https://gitea.example.com/gitea/webhooks.git",
+    "ssh_url": "git@gitea.example.com:gitea/webhooks.git",
+    "default_branch": "main",
+    "owner": {"id": 1, "login": "gitea", "username": "gitea"}
+  },
+  "pusher": {"id": 1, "login": "gitea", "username": "gitea", "email": "someone@gitea.io"},
+  "sender": {"id": 1, "login": "gitea", "username": "gitea", "email": "someone@gitea.io"}
+}
+`,
+		sign: signGitea,
+	},
+	{
+		Name:        "bitbucket-push",
+		Description: "Bitbucket Cloud push of one commit to main",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type":   "application/json",
+			"User-Agent":     "Bitbucket-Webhooks/2.0",
+			"X-Event-Key":    "repo:push",
+			"X-Request-UUID": "8d0f5ccf-22cd-4a4c-8dce-b5a5d4b4a0d2",
+		},
+		Body: `{
+  "actor": {"display_name": "Jane Doe", "nickname": "jdoe", "type": "user", "uuid": "{b3bd8a8e-7d2a-4b5b-96a5-4b6c3a7d8e20}"},
+  "repository": {
+    "name": "app",
+    "full_name": "acme/app",
+    "is_private": true,
+    "type": "repository",
+    "uuid": "{0f1e2d3c-4b5a-6978-8a9b-0c1d2e3f4a5b}",
+    "links": {"html": {"href": "https://bitbucket.org/acme/app"}}
+  },
+  "push": {
+    "changes": [
+      {
+        "closed": false,
+        "created": false,
+        "forced": false,
+        "old": {"type": "branch", "name": "main", "target": {"type": "commit", "hash": "1e65c05c1d5171631d92438a13901ca7dae9618c"}},
+        "new": {
+          "type": "branch",
+          "name": "main",
+          "target": {
+            "type": "commit",
+            "hash": "709d658dc5b6d6afcd46049c2f332ee3f515a67d",
+            "message": "Bump version\n",
+            "date": "2024-03-01T13:04:20+00:00",
+            "author": {"raw": "Jane Doe <jane@example.com>"}
+          }
+        },
+        "commits": [
+          {"type": "commit", "hash": "709d658dc5b6d6afcd46049c2f332ee3f515a67d", "message": "Bump version\n"}
+        ]
+      }
+    ]
+  }
+}
+`,
+		sign: signGitHub,
+	},
+	{
+		Name:        "stripe-invoice-paid",
+		Description: "Stripe invoice.paid event of a subscription",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/json; charset=utf-8",
+			"User-Agent":   "Stripe/1.0 (+https://stripe.com/docs/webhooks)",
+		},
+		Body: `{
+  "id": "evt_1OpQ8f2eZvKYlo2C0Kp8mJqz",
+  "object": "event",
+  "api_version": "2023-10-16",
+  "created": 1709294400,
+  "type": "invoice.paid",
+  "livemode": false,
+  "pending_webhooks": 1,
+  "request": {"id": null, "idempotency_key": null},
+  "data": {
+    "object": {
+      "id": "in_1OpQ8d2eZvKYlo2CvXbGQ3aR",
+      "object": "invoice",
+      "amount_due": 2000,
+      "amount_paid": 2000,
+      "amount_remaining": 0,
+      "currency": "usd",
+      "customer": "cus_PeJ7xRZ4sWqT1K",
+      "customer_email": "jenny.rosen@example.com",
+      "number": "C1F2A3B4-0001",
+      "paid": true,
+      "status": "paid",
+      "subscription": "sub_1OpQ8c2eZvKYlo2CkDp1sYfL",
+      "hosted_invoice_url": "https://invoice.stripe.com/i/acct_1032D82eZvKYlo2C/test_YWNjdF8x",
+      "lines": {
+        "object": "list",
+        "data": [
+          {
+            "id": "il_1OpQ8d2eZvKYlo2C5nV9aB7c",
+            "amount": 2000,
+            "currency": "usd",
+            "description": "1 × Pro plan (at $20.00 / month)",
+            "price": {"id": "price_1OpQ7z2eZvKYlo2CpQ2uZ0aA", "product": "prod_PeJ6qW1n7bXgYh"},
+            "quantity": 1
+          }
+        ]
+      }
+    }
+  }
+}
+`,
+		sign: signStripe,
+	},
+	{
+		Name:        "slack-slash-command",
+		Description: "Slack slash command, sent as a form",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/x-www-form-urlencoded",
+			"User-Agent":   "Slackbot 1.0 (+https://api.slack.com/robots)",
+		},
+		Body: "token=gIkuvaNzQIHg97ATvDxqgjtO&team_id=T0001&team_domain=example&channel_id=C2147483705&channel_name=deploys" +
+			"&user_id=U2147483697&user_name=steve&command=%2Fdeploy&text=api+production" +
+			"&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2F1234%2F5678&trigger_id=13345224609.738474920.8088930838d88f008e0",
+		sign: signSlack,
+	},
+	{
+		Name:        "docker-hub-push",
+		Description: "Docker Hub push of a new image tag",
+		Method:      "POST",
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: `{
+  "callback_url": "https://registry.hub.docker.com/u/acme/app/hook/2141b5bi5i5b02bec211i4eeih0242eg11000a/",
+  "push_data": {
+    "pushed_at": 1709294400,
+    "pusher": "trustedbuilder",
+    "tag": "1.4.2"
+  },
+  "repository": {
+    "name": "app",
+    "namespace": "acme",
+    "owner": "acme",
+    "repo_name": "acme/app",
+    "repo_url": "https://registry.hub.docker.com/u/acme/app/",
+    "is_private": true,
+    "status": "Active"
+  }
+}
+`,
+	},
+}
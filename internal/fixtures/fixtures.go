@@ -0,0 +1,109 @@
+// Package fixtures provides sample webhook requests of popular providers, so
+// hooks can be checked against realistic events without the provider.
+//
+// The payloads are trimmed versions of real deliveries: they keep the fields
+// hooks commonly use, such as the ref, repository and commit of a push, but
+// not every field the provider sends.
+package fixtures
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Fixture is a sample request of a provider.
+type Fixture struct {
+	Name        string
+	Description string
+	Method      string
+	Headers     map[string]string
+	Body        string
+
+	// sign returns the headers authenticating body with secret at time now,
+	// or is nil if the provider doesn't sign its requests.
+	sign func(secret, body string, now time.Time) map[string]string
+}
+
+// Signed reports whether the provider signs the fixture's requests.
+func (f *Fixture) Signed() bool {
+	return f.sign != nil
+}
+
+// Request returns the headers and body of the fixture.  If secret is not
+// empty, the headers include the signature computed like the provider does.
+func (f *Fixture) Request(secret string) (map[string]string, string) {
+	headers := make(map[string]string, len(f.Headers)+2)
+	for k, v := range f.Headers {
+		headers[k] = v
+	}
+
+	if secret != "" && f.sign != nil {
+		for k, v := range f.sign(secret, f.Body, time.Now()) {
+			headers[k] = v
+		}
+	}
+
+	return headers, f.Body
+}
+
+// Get returns the fixture name, or nil if there is none.
+func Get(name string) *Fixture {
+	for i := range fixtures {
+		if fixtures[i].Name == name {
+			return &fixtures[i]
+		}
+	}
+
+	return nil
+}
+
+// List returns all fixtures ordered by name.
+func List() []*Fixture {
+	res := make([]*Fixture, 0, len(fixtures))
+	for i := range fixtures {
+		res = append(res, &fixtures[i])
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res
+}
+
+func hmacSHA256(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signGitHub signs like GitHub and Bitbucket Cloud.
+func signGitHub(secret, body string, _ time.Time) map[string]string {
+	return map[string]string{"X-Hub-Signature-256": "sha256=" + hmacSHA256(secret, body)}
+}
+
+func signGitea(secret, body string, _ time.Time) map[string]string {
+	return map[string]string{"X-Gitea-Signature": hmacSHA256(secret, body)}
+}
+
+// signGitLab sets the secret token, which GitLab sends as is.
+func signGitLab(secret, _ string, _ time.Time) map[string]string {
+	return map[string]string{"X-Gitlab-Token": secret}
+}
+
+func signStripe(secret, body string, now time.Time) map[string]string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return map[string]string{"Stripe-Signature": "t=" + ts + ",v1=" + hmacSHA256(secret, ts+"."+body)}
+}
+
+func signSlack(secret, body string, now time.Time) map[string]string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	return map[string]string{
+		"X-Slack-Request-Timestamp": ts,
+		"X-Slack-Signature":         "v0=" + hmacSHA256(secret, "v0:"+ts+":"+body),
+	}
+}
@@ -0,0 +1,91 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestFixtures(t *testing.T) {
+	seen := make(map[string]bool)
+
+	for _, f := range List() {
+		if seen[f.Name] {
+			t.Errorf("duplicate fixture %s", f.Name)
+		}
+		seen[f.Name] = true
+
+		if Get(f.Name) != f {
+			t.Errorf("%s: Get returned another fixture", f.Name)
+		}
+
+		// The payloads must be parsed like webhook parses requests of their
+		// content type.
+		switch ct := f.Headers["Content-Type"]; {
+		case strings.Contains(ct, "json"):
+			var v map[string]interface{}
+			if err := json.Unmarshal([]byte(f.Body), &v); err != nil {
+				t.Errorf("%s: invalid JSON: %s", f.Name, err)
+			}
+		case strings.Contains(ct, "x-www-form-urlencoded"):
+			if _, err := url.ParseQuery(f.Body); err != nil {
+				t.Errorf("%s: invalid form: %s", f.Name, err)
+			}
+		default:
+			t.Errorf("%s: unsupported content type %q", f.Name, ct)
+		}
+	}
+
+	if Get("missing") != nil {
+		t.Error("expected no fixture named missing")
+	}
+}
+
+func TestFixtureSignatures(t *testing.T) {
+	const secret = "s3cret"
+
+	sig := func(name string) (map[string]string, string) {
+		f := Get(name)
+		if f == nil || !f.Signed() {
+			t.Fatalf("expected signed fixture %s", name)
+		}
+
+		return f.Request(secret)
+	}
+
+	for _, name := range []string{"github-push", "github-pull-request", "bitbucket-push"} {
+		headers, body := sig(name)
+		if _, err := hook.CheckPayloadSignature256([]byte(body), secret, headers["X-Hub-Signature-256"]); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+
+	headers, body := sig("gitea-push")
+	r := &hook.Request{Body: []byte(body), Headers: map[string]interface{}{"X-Gitea-Signature": headers["X-Gitea-Signature"]}}
+	if ok, err := hook.CheckGiteaSignature(r, secret); !ok || err != nil {
+		t.Errorf("gitea-push: signature rejected: %v", err)
+	}
+
+	if headers, _ := sig("gitlab-push"); headers["X-Gitlab-Token"] != secret {
+		t.Errorf("gitlab-push: expected the secret token, got %q", headers["X-Gitlab-Token"])
+	}
+
+	headers, body = sig("stripe-invoice-paid")
+	parts := strings.Split(headers["Stripe-Signature"], ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") || parts[1] != "v1="+hmacSHA256(secret, parts[0][2:]+"."+body) {
+		t.Errorf("stripe-invoice-paid: unexpected signature %q", headers["Stripe-Signature"])
+	}
+
+	headers, body = sig("slack-slash-command")
+	if expect := "v0=" + hmacSHA256(secret, "v0:"+headers["X-Slack-Request-Timestamp"]+":"+body); headers["X-Slack-Signature"] != expect {
+		t.Errorf("slack-slash-command: expected signature %s, got %s", expect, headers["X-Slack-Signature"])
+	}
+
+	// Unsigned requests carry no signature headers.
+	if headers, _ := Get("github-push").Request(""); headers["X-Hub-Signature-256"] != "" {
+		t.Error("expected no signature without a secret")
+	}
+}
@@ -0,0 +1,129 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AtlassianIPRangesURL is the endpoint listing the IP ranges of Atlassian
+// cloud products, including those Bitbucket Cloud sends webhooks from.
+var AtlassianIPRangesURL = "https://ip-ranges.atlassian.com/"
+
+// DefaultAtlassianIPRangesRefresh is the default interval after which the
+// Atlassian IP ranges are fetched again.
+const DefaultAtlassianIPRangesRefresh = time.Hour
+
+// BitbucketUUIDHeader is the header holding the UUID of the Bitbucket Cloud
+// webhook that sent a request.
+const BitbucketUUIDHeader = "X-Hook-Uuid"
+
+var atlassianIPRanges = &ipRangeCache{
+	name:   "Atlassian",
+	client: &http.Client{Timeout: 10 * time.Second},
+	fetch:  fetchBitbucketRanges,
+}
+
+// fetchBitbucketRanges returns the egress IP ranges of Bitbucket listed by the
+// Atlassian IP ranges endpoint.  Ranges without product or direction details
+// are all included.
+func fetchBitbucketRanges(client *http.Client) (string, error) {
+	res, err := client.Get(AtlassianIPRangesURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching %s: %s", AtlassianIPRangesURL, res.Status)
+	}
+
+	var ranges struct {
+		Items []struct {
+			CIDR      string   `json:"cidr"`
+			Product   []string `json:"product"`
+			Direction []string `json:"direction"`
+		} `json:"items"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&ranges); err != nil {
+		return "", fmt.Errorf("error decoding %s: %w", AtlassianIPRangesURL, err)
+	}
+
+	var cidrs []string
+
+	for _, item := range ranges.Items {
+		if item.CIDR == "" {
+			continue
+		}
+
+		if len(item.Product) != 0 && !containsString(item.Product, "bitbucket") {
+			continue
+		}
+
+		if len(item.Direction) != 0 && !containsString(item.Direction, "egress") {
+			continue
+		}
+
+		cidrs = append(cidrs, item.CIDR)
+	}
+
+	if len(cidrs) == 0 {
+		return "", fmt.Errorf("no Bitbucket IP ranges found in %s", AtlassianIPRangesURL)
+	}
+
+	return strings.Join(cidrs, " "), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckBitbucketUUID reports whether the X-Hook-UUID header of r holds one of
+// the comma-separated webhook UUIDs.  UUIDs are compared case-insensitively,
+// with or without the braces Bitbucket encloses them in.
+func CheckBitbucketUUID(r *Request, uuids string) bool {
+	uuid, ok := r.Headers[BitbucketUUIDHeader].(string)
+	if !ok {
+		return false
+	}
+
+	uuid = strings.Trim(uuid, "{} ")
+
+	for _, u := range strings.Split(uuids, ",") {
+		if u = strings.Trim(u, "{} "); u != "" && strings.EqualFold(u, uuid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckBitbucket reports whether the request r was sent by Bitbucket Cloud,
+// from its published IP ranges, and, if uuids isn't empty, by one of the
+// webhooks with the comma-separated UUIDs.
+func CheckBitbucket(r *Request, uuids string, refresh time.Duration) (bool, error) {
+	if r.RawRequest == nil {
+		return false, fmt.Errorf("request is nil")
+	}
+
+	ipRange, err := atlassianIPRanges.Ranges(refresh)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := CheckIPWhitelist(r.RawRequest.RemoteAddr, ipRange)
+	if !ok || err != nil {
+		return false, err
+	}
+
+	return uuids == "" || CheckBitbucketUUID(r, uuids), nil
+}
@@ -0,0 +1,78 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// DefaultCommandRuleTimeout is how long the validator of command rules may
+// run by default before it is killed and the rule fails.
+const DefaultCommandRuleTimeout = 10 * time.Second
+
+// checkCommand runs the validator of a command rule with the request body
+// on stdin, and reports whether it exited with status 0.  Other exit
+// statuses don't match; validators that can't be started or time out are
+// errors.
+func (r *MatchRule) checkCommand(req *Request) (bool, error) {
+	timeout := DefaultCommandRuleTimeout
+	if r.Timeout != "" {
+		d, err := time.ParseDuration(r.Timeout)
+		if err != nil {
+			return false, fmt.Errorf("invalid timeout: %w", err)
+		}
+		timeout = d
+	}
+
+	args := make([]string, 0, len(r.PassArgumentsToCommand))
+	for i := range r.PassArgumentsToCommand {
+		arg, err := r.PassArgumentsToCommand[i].Get(req)
+		if err != nil {
+			return false, &ArgumentError{r.PassArgumentsToCommand[i]}
+		}
+
+		args = append(args, arg)
+	}
+
+	env := os.Environ()
+	for i := range r.PassEnvironmentToCommand {
+		a := &r.PassEnvironmentToCommand[i]
+
+		arg, err := a.Get(req)
+		if err != nil {
+			return false, &ArgumentError{*a}
+		}
+
+		if a.EnvName != "" {
+			env = append(env, a.EnvName+"="+arg)
+		} else {
+			env = append(env, EnvNamespace+a.Name+"="+arg)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, r.Command, args...)
+	cmd.Stdin = bytes.NewReader(req.Body)
+	cmd.Env = env
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return false, fmt.Errorf("command %s timed out after %s", r.Command, timeout)
+	case errors.As(err, &exitErr):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("error running command %s: %w", r.Command, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,92 @@
+package hook
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CORS configures cross-origin requests to a hook, so pages served from other
+// origins can trigger it with fetch.
+type CORS struct {
+	// AllowedOrigins lists the origins allowed to send requests, ie.
+	// "https://dashboard.example.com".  Origins may contain * wildcards, and
+	// "*" allows all origins.
+	AllowedOrigins []string `json:"allowed-origins,omitempty"`
+
+	// AllowedMethods lists the methods allowed in preflight requests.
+	// Defaults to the hook's http-methods, or POST.
+	AllowedMethods []string `json:"allowed-methods,omitempty"`
+
+	// AllowedHeaders lists the request headers allowed in preflight
+	// requests, in addition to the CORS-safelisted headers.
+	AllowedHeaders []string `json:"allowed-headers,omitempty"`
+
+	// ExposedHeaders lists the response headers readable by the page.
+	ExposedHeaders []string `json:"exposed-headers,omitempty"`
+
+	// AllowCredentials allows requests with cookies and HTTP authentication.
+	AllowCredentials bool `json:"allow-credentials,omitempty"`
+
+	// MaxAge is the number of seconds browsers may cache preflight results.
+	MaxAge int `json:"max-age,omitempty"`
+}
+
+// AllowsOrigin reports whether requests from origin are allowed.
+func (c *CORS) AllowsOrigin(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+
+		if ok, _ := path.Match(strings.ToLower(o), strings.ToLower(origin)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsHeader reports whether the request header name is allowed.
+func (c *CORS) AllowsHeader(name string) bool {
+	// Browsers send these headers without asking, but a Content-Type other
+	// than those of HTML forms still requires a preflight.
+	switch strings.ToLower(name) {
+	case "accept", "accept-language", "content-language", "content-type":
+		return true
+	}
+
+	for _, h := range c.AllowedHeaders {
+		if h == "*" || strings.EqualFold(h, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks the CORS configuration.
+func (c *CORS) Validate() []error {
+	var errs []error
+
+	if len(c.AllowedOrigins) == 0 {
+		errs = append(errs, fmt.Errorf("cors: missing allowed-origins"))
+	}
+
+	for _, o := range c.AllowedOrigins {
+		if _, err := path.Match(o, ""); err != nil {
+			errs = append(errs, fmt.Errorf("cors: invalid allowed-origins pattern %q: %w", o, err))
+		}
+
+		// Browsers refuse credentialed responses allowing any origin.
+		if o == "*" && c.AllowCredentials {
+			errs = append(errs, fmt.Errorf("cors: allow-credentials can't be used with allowed-origins \"*\""))
+		}
+	}
+
+	if c.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("cors: negative max-age"))
+	}
+
+	return errs
+}
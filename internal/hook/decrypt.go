@@ -0,0 +1,250 @@
+package hook
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Constants for the decrypt-payload formats.
+const (
+	DecryptJWE = "jwe"
+)
+
+// PayloadDecryption defines how the encrypted request body of a hook is
+// decrypted before it is parsed and the trigger rule is evaluated.
+type PayloadDecryption struct {
+	// Format is the encryption format of the body, ie. "jwe".
+	Format string `json:"format"`
+
+	// KeyFile is the path of a file holding the key, either a PEM encoded
+	// private key or the raw bytes of a symmetric key.
+	KeyFile string `json:"key-file,omitempty"`
+
+	// Key is a base64 encoded symmetric key, used if KeyFile is not set.  It
+	// may reference a Vault secret.
+	Key string `json:"key,omitempty"`
+
+	// Algorithms are the key management algorithms accepted in the alg
+	// header of JWEs, ie. "RSA-OAEP-256".
+	Algorithms []string `json:"algorithms,omitempty"`
+
+	// Encryptions are the content encryption algorithms accepted in the enc
+	// header of JWEs, ie. "A256GCM".
+	Encryptions []string `json:"encryptions,omitempty"`
+}
+
+// Decrypter decrypts request bodies of a custom decrypt-payload format, such as
+// PGP, registered with RegisterDecrypter.
+type Decrypter interface {
+	// Decrypt returns the plaintext of body and its content type, or an empty
+	// content type if the format doesn't record it.
+	Decrypt(d *PayloadDecryption, body []byte) ([]byte, string, error)
+}
+
+// DecrypterFunc is a function implementing Decrypter.
+type DecrypterFunc func(d *PayloadDecryption, body []byte) ([]byte, string, error)
+
+// Decrypt implements Decrypter.
+func (f DecrypterFunc) Decrypt(d *PayloadDecryption, body []byte) ([]byte, string, error) {
+	return f(d, body)
+}
+
+// builtinDecrypters are the decrypt-payload formats implemented by this
+// package.
+var builtinDecrypters = map[string]Decrypter{
+	DecryptJWE: DecrypterFunc(decryptJWE),
+}
+
+var decrypters = struct {
+	sync.RWMutex
+	m map[string]Decrypter
+}{m: make(map[string]Decrypter)}
+
+// RegisterDecrypter makes the Decrypter d decrypt bodies of the decrypt-payload
+// format name.  It is meant to be called from init functions, and panics if
+// name is empty, a built-in format or already registered.
+func RegisterDecrypter(name string, d Decrypter) {
+	decrypters.Lock()
+	defer decrypters.Unlock()
+
+	switch {
+	case name == "":
+		panic("hook: RegisterDecrypter with an empty name")
+	case d == nil:
+		panic("hook: RegisterDecrypter with a nil Decrypter for format " + name)
+	case builtinDecrypters[name] != nil:
+		panic("hook: RegisterDecrypter with built-in format " + name)
+	}
+
+	if _, dup := decrypters.m[name]; dup {
+		panic("hook: RegisterDecrypter called twice for format " + name)
+	}
+
+	decrypters.m[name] = d
+}
+
+// Decrypters returns the names of the registered decrypt-payload formats,
+// sorted.
+func Decrypters() []string {
+	decrypters.RLock()
+	defer decrypters.RUnlock()
+
+	names := make([]string, 0, len(decrypters.m))
+	for name := range decrypters.m {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// lookupDecrypter returns the Decrypter of the format name, or nil.
+func lookupDecrypter(name string) Decrypter {
+	if d := builtinDecrypters[name]; d != nil {
+		return d
+	}
+
+	decrypters.RLock()
+	defer decrypters.RUnlock()
+
+	return decrypters.m[name]
+}
+
+// Decrypt decrypts the request body and returns the plaintext and its content
+// type, or an empty content type if the format doesn't record it.
+func (d *PayloadDecryption) Decrypt(body []byte) ([]byte, string, error) {
+	dec := lookupDecrypter(d.Format)
+	if dec == nil {
+		return nil, "", fmt.Errorf("unknown decrypt-payload format %q", d.Format)
+	}
+
+	return dec.Decrypt(d, body)
+}
+
+// Validate returns the problems found in the decryption settings.
+func (d *PayloadDecryption) Validate() []error {
+	var errs []error
+
+	switch {
+	case d.Format == "pgp" && lookupDecrypter(d.Format) == nil:
+		errs = append(errs, errors.New("decrypt-payload format pgp is not supported, it requires a custom build registering a decrypter"))
+	case lookupDecrypter(d.Format) == nil:
+		errs = append(errs, fmt.Errorf("unknown decrypt-payload format %q", d.Format))
+	}
+
+	if d.Format != DecryptJWE {
+		return errs
+	}
+
+	if d.KeyFile == "" && d.Key == "" {
+		errs = append(errs, errors.New("decrypt-payload requires a key-file or key"))
+	}
+
+	// The algorithms must be configured, as the JWE header choosing them is
+	// controlled by the sender.
+	if len(d.Algorithms) == 0 {
+		errs = append(errs, errors.New("decrypt-payload requires the accepted algorithms"))
+	}
+
+	for _, alg := range d.Algorithms {
+		if !jweAlgorithms[alg] {
+			errs = append(errs, fmt.Errorf("unsupported JWE algorithm %q", alg))
+		}
+	}
+
+	if len(d.Encryptions) == 0 {
+		errs = append(errs, errors.New("decrypt-payload requires the accepted encryptions"))
+	}
+
+	for _, enc := range d.Encryptions {
+		if !jweEncryptions[enc] {
+			errs = append(errs, fmt.Errorf("unsupported JWE encryption %q", enc))
+		}
+	}
+
+	return errs
+}
+
+// ReadKey returns the decryption key, which is an *rsa.PrivateKey for PEM
+// encoded RSA keys, or the symmetric key as a []byte.  Key files are read on
+// every call, so keys can be rotated without reloading hooks.
+func (d *PayloadDecryption) ReadKey() (interface{}, error) {
+	if d.KeyFile == "" {
+		key, err := resolveSecret(d.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			b, err = base64.RawURLEncoding.DecodeString(key)
+		}
+
+		if err != nil {
+			return nil, errors.New("decrypt-payload key is not base64 encoded")
+		}
+
+		return b, nil
+	}
+
+	data, err := ioutil.ReadFile(d.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return data, nil
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := key.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("unsupported private key type %T in %s", key, d.KeyFile)
+		}
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PEM block %q in %s", strings.ToLower(block.Type), d.KeyFile)
+}
+
+// DecryptRequest replaces the body of req with the decrypted payload.  Unless
+// the hook sets incoming-payload-content-type, the content type of req is set
+// to that recorded by the format, if any.
+func (h *Hook) DecryptRequest(req *Request) error {
+	plaintext, contentType, err := h.DecryptPayload.Decrypt(req.Body)
+	if err != nil {
+		return err
+	}
+
+	req.Body = plaintext
+
+	if contentType != "" && h.IncomingPayloadContentType == "" {
+		// A content type without a slash omits the "application/" prefix.
+		if !strings.Contains(contentType, "/") {
+			contentType = "application/" + contentType
+		}
+
+		req.ContentType = contentType
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// hooksFileType describes the object form of hooks files, which lists the
+// hooks next to defaults applying to all of them.
+var hooksFileType = reflect.TypeOf(struct {
+	Defaults Hook  `json:"defaults"`
+	Hooks    Hooks `json:"hooks"`
+}{})
+
+// decodeHooksFile returns the entries of the JSON hooks file contents in file,
+// a list of hooks and groups or an object listing them in hooks, with the
+// properties of its defaults applied.
+func decodeHooksFile(file []byte) ([]json.RawMessage, error) {
+	var entries []json.RawMessage
+
+	if !isObject(file) {
+		if err := json.Unmarshal(file, &entries); err != nil {
+			return nil, err
+		}
+
+		return entries, nil
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(file, &doc); err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for k := range doc {
+		if k != "hooks" && k != "defaults" {
+			unknown = append(unknown, k)
+		}
+	}
+
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unexpected top-level keys %s, hooks must be listed in hooks", strings.Join(unknown, ", "))
+	}
+
+	if b, ok := doc["hooks"]; ok {
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return nil, fmt.Errorf("error decoding hooks: %w", err)
+		}
+	}
+
+	var defaults map[string]json.RawMessage
+	if b, ok := doc["defaults"]; ok {
+		if err := json.Unmarshal(b, &defaults); err != nil {
+			return nil, fmt.Errorf("error decoding defaults: %w", err)
+		}
+	}
+
+	for _, k := range []string{"id", "group", "hooks"} {
+		if _, ok := defaults[k]; ok {
+			return nil, fmt.Errorf("defaults can't set %s", k)
+		}
+	}
+
+	return applyDefaults(entries, defaults)
+}
+
+// applyDefaults sets the properties of defaults the hooks file entries don't
+// set.  The members of groups get the defaults except for the properties the
+// group sets, so group settings take the place of the defaults.
+func applyDefaults(entries []json.RawMessage, defaults map[string]json.RawMessage) ([]json.RawMessage, error) {
+	if len(defaults) == 0 {
+		return entries, nil
+	}
+
+	res := make([]json.RawMessage, len(entries))
+
+	for i, e := range entries {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(e, &m); err != nil {
+			return nil, err
+		}
+
+		if _, ok := m["group"]; ok {
+			memberDefaults := make(map[string]json.RawMessage, len(defaults))
+			for k, v := range defaults {
+				if _, ok := m[k]; !ok {
+					memberDefaults[k] = v
+				}
+			}
+
+			var members []json.RawMessage
+			if b, ok := m["hooks"]; ok {
+				if err := json.Unmarshal(b, &members); err != nil {
+					return nil, err
+				}
+			}
+
+			members, err := applyDefaults(members, memberDefaults)
+			if err != nil {
+				return nil, err
+			}
+
+			if m["hooks"], err = json.Marshal(members); err != nil {
+				return nil, err
+			}
+		} else {
+			for k, v := range defaults {
+				if _, ok := m[k]; !ok {
+					m[k] = v
+				}
+			}
+		}
+
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+
+		res[i] = b
+	}
+
+	return res, nil
+}
+
+// isObject reports whether the JSON value data is an object.
+func isObject(data []byte) bool {
+	for _, c := range data {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{':
+			return true
+		}
+
+		return false
+	}
+
+	return false
+}
@@ -0,0 +1,443 @@
+package hook
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bounds of the time resolved hostnames of dns-whitelist rules are cached
+// for, whatever the TTL of their records.
+const (
+	MinDNSCacheTTL = 5 * time.Second
+	MaxDNSCacheTTL = time.Hour
+)
+
+// dnsTimeout is the time a DNS server has to answer a query.
+const dnsTimeout = 2 * time.Second
+
+// dnsResolver resolves and caches the addresses of hostnames, for as long
+// as the TTL of their records.  It queries the name servers of
+// /etc/resolv.conf directly, so hostnames must be fully qualified: /etc/hosts,
+// nsswitch.conf and the search and ndots options are not used.
+type dnsResolver struct {
+	mu    sync.Mutex
+	cache map[string]dnsEntry
+
+	// inflight holds the resolutions in progress, so concurrent lookups of
+	// a hostname wait for the same resolution.
+	inflight map[string]*dnsLookup
+
+	// servers are the addresses of the DNS servers queried in order,
+	// those of /etc/resolv.conf if empty.
+	servers []string
+}
+
+// dnsEntry is a cached DNS resolution.
+type dnsEntry struct {
+	ips      []net.IP
+	resolved time.Time
+	expires  time.Time
+}
+
+// dnsLookup is a resolution of a hostname in progress.
+type dnsLookup struct {
+	done chan struct{}
+	ips  []net.IP
+	err  error
+}
+
+var resolver = &dnsResolver{}
+
+// validateHostnames returns an error if hostnames isn't a comma-separated
+// list of hostnames.
+func validateHostnames(hostnames string) error {
+	if strings.TrimSpace(hostnames) == "" {
+		return fmt.Errorf("missing value")
+	}
+
+	for _, h := range strings.Split(hostnames, ",") {
+		if _, err := dnsMessage(0, strings.TrimSuffix(strings.TrimSpace(h), "."), dnsTypeA); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckDNSWhitelist reports whether the provided remote address (of the
+// form IP:port) is one of the addresses the comma-separated hostnames
+// resolve to.  Hostnames are resolved again once the TTL of their records
+// expires; if resolving fails, the previously resolved addresses are used.
+func CheckDNSWhitelist(remoteAddr, hostnames string) (bool, error) {
+	ip, err := parseRemoteIP(remoteAddr)
+	if err != nil {
+		return false, err
+	}
+
+	var lookupErr error
+
+	for _, h := range strings.Split(hostnames, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+
+		ips, err := resolver.Lookup(h)
+		if err != nil {
+			lookupErr = err
+			continue
+		}
+
+		for _, a := range ips {
+			if a.Equal(ip) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, lookupErr
+}
+
+// Lookup returns the IPv4 and IPv6 addresses of host.  Hosts are resolved
+// without holding the lock, and concurrent lookups of a host share a
+// resolution.
+func (r *dnsResolver) Lookup(host string) ([]net.IP, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.mu.Lock()
+
+	e, ok := r.cache[host]
+	if ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.ips, nil
+	}
+
+	if l, resolving := r.inflight[host]; resolving {
+		r.mu.Unlock()
+		<-l.done
+		return l.ips, l.err
+	}
+
+	l := &dnsLookup{done: make(chan struct{})}
+	if r.inflight == nil {
+		r.inflight = make(map[string]*dnsLookup)
+	}
+	r.inflight[host] = l
+	r.mu.Unlock()
+
+	ips, ttl, err := r.resolve(host)
+
+	r.mu.Lock()
+	delete(r.inflight, host)
+
+	switch {
+	case err == nil:
+		if ttl < MinDNSCacheTTL {
+			ttl = MinDNSCacheTTL
+		} else if ttl > MaxDNSCacheTTL {
+			ttl = MaxDNSCacheTTL
+		}
+
+		e = dnsEntry{ips: ips, resolved: time.Now(), expires: time.Now().Add(ttl)}
+
+	case !ok:
+		err = fmt.Errorf("error resolving %s: %w", host, err)
+
+	default:
+		log.Printf("error resolving %s, using the addresses resolved at %s: %s", host, e.resolved.Format(time.RFC3339), err)
+
+		// Retry shortly rather than on every request.
+		e.expires = time.Now().Add(MinDNSCacheTTL)
+		err = nil
+	}
+
+	if err == nil {
+		if r.cache == nil {
+			r.cache = make(map[string]dnsEntry)
+		}
+		r.cache[host] = e
+		l.ips = e.ips
+	}
+
+	l.err = err
+	r.mu.Unlock()
+	close(l.done)
+
+	return l.ips, l.err
+}
+
+// resolve queries the A and AAAA records of host, returning the addresses
+// and the lowest TTL of the answers.  Hostnames that don't exist or have no
+// addresses are cached for MinDNSCacheTTL.
+func (r *dnsResolver) resolve(host string) ([]net.IP, time.Duration, error) {
+	servers := r.servers
+	if len(servers) == 0 {
+		servers = resolvConfServers("/etc/resolv.conf")
+	}
+
+	var (
+		ips []net.IP
+		ttl = MaxDNSCacheTTL
+	)
+
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		var (
+			answers []net.IP
+			t       time.Duration
+			err     error
+		)
+
+		for _, server := range servers {
+			answers, t, err = dnsQuery(server, host, qtype)
+			if err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(answers) > 0 {
+			ips = append(ips, answers...)
+			if t < ttl {
+				ttl = t
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		ttl = MinDNSCacheTTL
+	}
+
+	return ips, ttl, nil
+}
+
+// resolvConfServers returns the name servers of the resolv.conf file at
+// path, or the local server if there are none.
+func resolvConfServers(path string) []string {
+	var servers []string
+
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+
+	return servers
+}
+
+// DNS record types and flags.
+const (
+	dnsTypeA        = 1
+	dnsTypeCNAME    = 5
+	dnsTypeAAAA     = 28
+	dnsFlagResponse = 0x8000
+	dnsFlagTrunc    = 0x0200
+	dnsRcodeNXName  = 3
+)
+
+// dnsQuery asks server for the records of type qtype of host, over UDP
+// first and over TCP if the answer is truncated, and returns the addresses
+// answered with the lowest TTL of the records.
+func dnsQuery(server, host string, qtype uint16) ([]net.IP, time.Duration, error) {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, 0, err
+	}
+
+	id := binary.BigEndian.Uint16(b[:])
+
+	msg, err := dnsMessage(id, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := dnsExchange("udp", server, msg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(resp) >= 4 && binary.BigEndian.Uint16(resp[2:])&dnsFlagTrunc != 0 {
+		if resp, err = dnsExchange("tcp", server, msg); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return parseDNSResponse(resp, id, qtype)
+}
+
+// dnsMessage returns a recursive query for the records of type qtype of
+// host.
+func dnsMessage(id uint16, host string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(host)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(msg[4:], 1)      // one question
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid hostname %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, 1)
+
+	return msg, nil
+}
+
+// dnsExchange sends msg to server over network and returns the response.
+func dnsExchange(network, server string, msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if network == "udp" {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	}
+
+	// Messages over TCP are prefixed with their length.
+	if _, err := conn.Write(append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)); err != nil {
+		return nil, err
+	}
+
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+var errDNSMessage = errors.New("malformed DNS response")
+
+// parseDNSResponse returns the addresses of the records of type qtype in
+// the answers of the DNS response msg to the query id, and the lowest TTL
+// of the answers.
+func parseDNSResponse(msg []byte, id, qtype uint16) ([]net.IP, time.Duration, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+		return nil, 0, errDNSMessage
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, 0, errDNSMessage
+	}
+
+	switch rcode := flags & 0xf; rcode {
+	case 0:
+	case dnsRcodeNXName:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("DNS server answered with error code %d", rcode)
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+
+	for i := 0; i < qdcount; i++ {
+		if off = skipDNSName(msg, off); off < 0 || off+4 > len(msg) {
+			return nil, 0, errDNSMessage
+		}
+		off += 4
+	}
+
+	var ips []net.IP
+	ttl := MaxDNSCacheTTL
+
+	for i := 0; i < ancount; i++ {
+		if off = skipDNSName(msg, off); off < 0 || off+10 > len(msg) {
+			return nil, 0, errDNSMessage
+		}
+
+		typ := binary.BigEndian.Uint16(msg[off:])
+		t := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+
+		if off+rdlen > len(msg) {
+			return nil, 0, errDNSMessage
+		}
+
+		rdata := msg[off : off+rdlen]
+		off += rdlen
+
+		// The answers hold the addresses of the canonical name, following
+		// the CNAME records leading to it, whose TTLs apply as well.
+		if typ != qtype && typ != dnsTypeCNAME {
+			continue
+		}
+
+		if typ == qtype && (qtype == dnsTypeA && rdlen == net.IPv4len || qtype == dnsTypeAAAA && rdlen == net.IPv6len) {
+			ips = append(ips, net.IP(append([]byte(nil), rdata...)))
+		}
+
+		if t < ttl {
+			ttl = t
+		}
+	}
+
+	return ips, ttl, nil
+}
+
+// skipDNSName returns the offset following the possibly compressed domain
+// name at off of msg, or -1 if it is malformed.
+func skipDNSName(msg []byte, off int) int {
+	for off < len(msg) {
+		switch l := int(msg[off]); {
+		case l == 0:
+			return off + 1
+		case l&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return -1
+			}
+			return off + 2
+		default:
+			off += 1 + l
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,50 @@
+package hook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+)
+
+// DocuSignSignatureHeader is the prefix of the headers holding the signatures
+// of DocuSign Connect messages, numbered from 1 for each active HMAC key.
+const DocuSignSignatureHeader = "X-Docusign-Signature-"
+
+// CheckDocuSignSignature checks the base64 encoded HMAC-SHA256 signatures of
+// the request body in the X-DocuSign-Signature-1, -2, ... headers.  DocuSign
+// signs messages with every active key of the Connect configuration, so the
+// request is verified if any of the signatures was computed with secret.
+func CheckDocuSignSignature(r *Request, secret string) (bool, error) {
+	var signatures []string
+
+	for i := 1; ; i++ {
+		signature, ok := r.Headers[DocuSignSignatureHeader+strconv.Itoa(i)].(string)
+		if !ok {
+			break
+		}
+
+		signatures = append(signatures, signature)
+	}
+
+	if len(signatures) == 0 {
+		return false, nil
+	}
+
+	if secret == "" {
+		return false, errors.New("signature validation secret can not be empty")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(r.Body)
+	sum := mac.Sum(nil)
+
+	for _, s := range signatures {
+		if b, err := base64.StdEncoding.DecodeString(s); err == nil && hmac.Equal(b, sum) {
+			return true, nil
+		}
+	}
+
+	return false, &SignatureError{Signatures: signatures}
+}
@@ -0,0 +1,53 @@
+package hook
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileCache caches file contents in memory.  A file is re-read when its
+// modification time or size changes, so callers can look it up on every
+// request without reading it each time.
+type fileCache struct {
+	mu      sync.Mutex
+	entries map[string]fileCacheEntry
+}
+
+type fileCacheEntry struct {
+	modTime time.Time
+	size    int64
+	data    []byte
+}
+
+// files is the cache used for files referenced from hooks.
+var files = &fileCache{}
+
+// Get returns the contents of the file at path.
+func (c *fileCache) Get(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[path]; ok && e.modTime.Equal(fi.ModTime()) && e.size == fi.Size() {
+		return e.data, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]fileCacheEntry)
+	}
+
+	c.entries[path] = fileCacheEntry{modTime: fi.ModTime(), size: fi.Size(), data: data}
+
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package hook
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// hooksFileJSON converts the hooks file contents in file to JSON if path has
+// a .toml or .hcl extension, optionally followed by .tmpl, and returns it
+// unchanged otherwise, as JSON and YAML are decoded as is.
+//
+// TOML files list the hooks as the hooks array of tables ([[hooks]]), and HCL
+// files as hook and group blocks, their labels being the hook IDs and group
+// names, so both are converted to the object form of hooks files.
+func hooksFileJSON(path string, file []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	var err error
+
+	switch strings.ToLower(filepath.Ext(strings.TrimSuffix(path, ".tmpl"))) {
+	case ".toml":
+		doc, err = parseTOML(file)
+	case ".hcl":
+		doc, err = parseHCL(file)
+	default:
+		return file, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fitHooksFile(doc))
+}
+
+// fitHooksFile applies fitBlocks to the decoded hooks file v, a list of hooks
+// or an object listing them in hooks next to their defaults.
+func fitHooksFile(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fitBlocks(v, hooksType)
+	}
+
+	if hooks, ok := m["hooks"]; ok {
+		m["hooks"] = fitBlocks(hooks, hooksType)
+	}
+
+	if defaults, ok := m["defaults"]; ok {
+		m["defaults"] = fitBlocks(defaults, hooksFileType.Field(0).Type)
+	}
+
+	return m
+}
+
+// fitBlocks wraps the objects in the decoded hooks file value v in lists
+// where the type t it is decoded into expects one, as a single HCL block,
+// such as a pass-arguments-to-command block, is decoded as an object.
+func fitBlocks(v interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch v := v.(type) {
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return v
+		}
+
+		for i, e := range v {
+			et := t.Elem()
+
+			// Hooks files mix hooks and groups.
+			if m, ok := e.(map[string]interface{}); ok && t == hooksType {
+				if _, ok := m["group"]; ok {
+					et = groupType
+				}
+			}
+
+			v[i] = fitBlocks(e, et)
+		}
+
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array:
+			return fitBlocks([]interface{}{v}, t)
+
+		case reflect.Map:
+			for k, e := range v {
+				v[k] = fitBlocks(e, t.Elem())
+			}
+
+		case reflect.Struct:
+			fields := make(map[string]reflect.Type, t.NumField())
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+					fields[name] = f.Type
+				}
+			}
+
+			// The entries of groups are hooks and groups.
+			if t == groupType {
+				fields["hooks"] = hooksType
+			}
+
+			for k, e := range v {
+				if ft, ok := fields[k]; ok {
+					v[k] = fitBlocks(e, ft)
+				}
+			}
+		}
+	}
+
+	return v
+}
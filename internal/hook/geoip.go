@@ -0,0 +1,141 @@
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoipDB is the MaxMind database geoip-country rules look addresses up in.
+type geoipDB struct {
+	mu   sync.Mutex
+	path string
+	data []byte
+	db   *maxminddb.Reader
+}
+
+var geoip = &geoipDB{}
+
+// ConfigureGeoIP sets the path of the MaxMind database, such as
+// GeoLite2-Country.mmdb, geoip-country rules look remote addresses up in,
+// and checks that it can be read.  The database is read again whenever the
+// file changes, so it can be updated in place, ie. by geoipupdate.
+func ConfigureGeoIP(path string) error {
+	geoip.mu.Lock()
+	geoip.path, geoip.db = path, nil
+	geoip.mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	_, err := geoip.open()
+
+	return err
+}
+
+// open returns the database, parsing it again if the file changed.
+func (g *geoipDB) open() (*maxminddb.Reader, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.path == "" {
+		return nil, errors.New("no GeoIP database configured; set -geoip-db")
+	}
+
+	data, err := files.Get(g.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GeoIP database: %w", err)
+	}
+
+	if g.db != nil && len(g.data) == len(data) && &g.data[0] == &data[0] {
+		return g.db, nil
+	}
+
+	// The reader checks that the search tree and data section fit in the
+	// file, and bounds-checks every record it follows.
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GeoIP database %s: %w", g.path, err)
+	}
+
+	g.data, g.db = data, db
+
+	return db, nil
+}
+
+// geoipRecord holds the fields of GeoIP2 and GeoLite2 Country records used
+// by geoip-country rules.  The country the address is registered in is used
+// when its location is unknown.
+type geoipRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// CheckGeoIPCountry reports whether the provided remote address (of the
+// form IP:port) is located in one of the comma-separated ISO 3166-1 country
+// codes, ie. "DE, FR", according to the configured GeoIP database.
+// Addresses missing from the database match no country.
+func CheckGeoIPCountry(remoteAddr, countries string) (bool, error) {
+	ip, err := parseRemoteIP(remoteAddr)
+	if err != nil {
+		return false, err
+	}
+
+	db, err := geoip.open()
+	if err != nil {
+		return false, err
+	}
+
+	// IPv4 databases don't hold IPv6 addresses.
+	if ip.To4() == nil && db.Metadata.IPVersion == 4 {
+		return false, nil
+	}
+
+	var record geoipRecord
+
+	if err := db.Lookup(ip, &record); err != nil {
+		return false, fmt.Errorf("error looking up %s in GeoIP database: %w", ip, err)
+	}
+
+	country := record.Country.ISOCode
+	if country == "" {
+		country = record.RegisteredCountry.ISOCode
+	}
+
+	if country == "" {
+		return false, nil
+	}
+
+	for _, c := range strings.Split(countries, ",") {
+		if strings.EqualFold(strings.TrimSpace(c), country) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// validateCountries returns an error if countries isn't a comma-separated
+// list of two-letter country codes.
+func validateCountries(countries string) error {
+	if strings.TrimSpace(countries) == "" {
+		return fmt.Errorf("missing value")
+	}
+
+	for _, c := range strings.Split(countries, ",") {
+		c = strings.TrimSpace(c)
+		if len(c) != 2 || strings.Trim(strings.ToUpper(c), "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return fmt.Errorf("invalid country code %q", c)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package hook
+
+import "strings"
+
+// Headers of Gitea webhooks.  Gogs, which Gitea was forked from, sends the
+// same values in X-Gogs-* headers.
+const (
+	GiteaSignatureHeader = "X-Gitea-Signature"
+	GiteaEventHeader     = "X-Gitea-Event"
+
+	gogsSignatureHeader = "X-Gogs-Signature"
+	gogsEventHeader     = "X-Gogs-Event"
+)
+
+// giteaHeader returns the value of the Gitea header name of r, or of the Gogs
+// header fallback if it is missing.
+func giteaHeader(r *Request, name, fallback string) (string, bool) {
+	if v, ok := r.Headers[name].(string); ok {
+		return v, true
+	}
+
+	v, ok := r.Headers[fallback].(string)
+	return v, ok
+}
+
+// CheckGiteaSignature checks the hex encoded HMAC-SHA256 signature of the
+// request body in the X-Gitea-Signature header, or the X-Gogs-Signature
+// header of Gogs.
+func CheckGiteaSignature(r *Request, secret string) (bool, error) {
+	signature, ok := giteaHeader(r, GiteaSignatureHeader, gogsSignatureHeader)
+	if !ok {
+		return false, nil
+	}
+
+	if _, err := CheckPayloadSignature256(r.Body, secret, signature); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CheckGiteaEvent reports whether the event in the X-Gitea-Event header, or
+// the X-Gogs-Event header of Gogs, is one of the comma-separated events.
+func CheckGiteaEvent(r *Request, events string) bool {
+	event, ok := giteaHeader(r, GiteaEventHeader, gogsEventHeader)
+	if !ok {
+		return false
+	}
+
+	for _, e := range strings.Split(events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+
+	return false
+}
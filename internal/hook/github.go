@@ -0,0 +1,57 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GitHubMetaURL is the GitHub API endpoint listing the IP ranges GitHub sends
+// webhook deliveries from.
+var GitHubMetaURL = "https://api.github.com/meta"
+
+// DefaultGitHubMetaRefresh is the default interval after which the GitHub IP
+// ranges are fetched again.
+const DefaultGitHubMetaRefresh = time.Hour
+
+var githubMeta = &ipRangeCache{
+	name:   "GitHub",
+	client: &http.Client{Timeout: 10 * time.Second},
+	fetch:  fetchGitHubHooks,
+}
+
+// fetchGitHubHooks returns the "hooks" IP ranges of the GitHub meta API.
+func fetchGitHubHooks(client *http.Client) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, GitHubMetaURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching %s: %s", GitHubMetaURL, res.Status)
+	}
+
+	var meta struct {
+		Hooks []string `json:"hooks"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&meta); err != nil {
+		return "", fmt.Errorf("error decoding %s: %w", GitHubMetaURL, err)
+	}
+
+	if len(meta.Hooks) == 0 {
+		return "", fmt.Errorf("no hooks IP ranges found in %s", GitHubMetaURL)
+	}
+
+	return strings.Join(meta.Hooks, " "), nil
+}
@@ -0,0 +1,201 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+)
+
+// Group defines settings shared by its member hooks.  Groups appear in the
+// hooks file next to hooks, and are expanded into their members when it is
+// loaded.
+type Group struct {
+	ID string `json:"group"`
+
+	// URLPrefix is prepended to the IDs of the member hooks, so they are
+	// served under /hooks/{url-prefix}/{id}.
+	URLPrefix string `json:"url-prefix,omitempty"`
+
+	// TriggerRule must be satisfied in addition to the trigger rule of each
+	// member hook.
+	TriggerRule *Rules `json:"trigger-rule,omitempty"`
+
+	// PassEnvironmentToCommand is passed to the commands of all member hooks,
+	// before their own environment.
+	PassEnvironmentToCommand []Argument `json:"pass-environment-to-command,omitempty"`
+
+	// AllowedCallers applies to member hooks without allowed-callers.
+	AllowedCallers []string `json:"allowed-callers,omitempty"`
+
+	// Disabled groups are skipped, so none of their hooks are served.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Entries holds the member hooks and groups, which are decoded
+	// separately so YAML values are converted to the types of the fields
+	// they are decoded into.
+	Entries []json.RawMessage `json:"hooks"`
+}
+
+var (
+	hooksType = reflect.TypeOf(Hooks{})
+	groupType = reflect.TypeOf(Group{})
+)
+
+// unmarshalHooks decodes the JSON or YAML hooks file contents in file into h,
+// applying defaults and expanding groups into their member hooks.
+func unmarshalHooks(file []byte, h *Hooks) error {
+	file, err := yaml.YAMLToJSON(file)
+	if err != nil {
+		return err
+	}
+
+	entries, err := decodeHooksFile(file)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := decodeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	*h = hooks
+
+	return nil
+}
+
+// parallelDecodeMin is the number of hooks file entries from which they are
+// decoded in parallel.
+const parallelDecodeMin = 64
+
+// decodeEntries decodes the hooks file entries, which are hooks or groups.
+// Large files are decoded by several goroutines, preserving the order of the
+// entries.
+func decodeEntries(entries []json.RawMessage) (Hooks, error) {
+	decoded := make([]Hooks, len(entries))
+	errs := make([]error, len(entries))
+
+	if len(entries) < parallelDecodeMin {
+		for i := range entries {
+			decoded[i], errs[i] = decodeEntry(entries[i])
+		}
+	} else {
+		next := make(chan int)
+
+		var wg sync.WaitGroup
+
+		for n := runtime.GOMAXPROCS(0); n > 0; n-- {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					decoded[i], errs[i] = decodeEntry(entries[i])
+				}
+			}()
+		}
+
+		for i := range entries {
+			next <- i
+		}
+
+		close(next)
+		wg.Wait()
+	}
+
+	hooks := make(Hooks, 0, len(entries))
+
+	for i := range decoded {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		hooks = append(hooks, decoded[i]...)
+	}
+
+	return hooks, nil
+}
+
+// decodeEntry decodes the hooks file entry e, returning the hook or the
+// members of the group it defines.
+func decodeEntry(e json.RawMessage) (Hooks, error) {
+	if !isGroup(e) {
+		var hook Hook
+		if err := yaml.Unmarshal(e, &hook); err != nil {
+			return nil, err
+		}
+
+		return Hooks{hook}, nil
+	}
+
+	var g Group
+	if err := yaml.Unmarshal(e, &g); err != nil {
+		return nil, err
+	}
+
+	if g.Disabled {
+		return nil, nil
+	}
+
+	return g.Members()
+}
+
+// isGroup reports whether the hooks file entry data is a group.
+func isGroup(data []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return false
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+
+	_, ok := probe["group"]
+
+	return ok
+}
+
+// Members returns the hooks of the group with the group's settings applied.
+func (g *Group) Members() (Hooks, error) {
+	hooks, err := decodeEntries(g.Entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range hooks {
+		h := &hooks[i]
+
+		if g.URLPrefix != "" {
+			h.ID = strings.Trim(g.URLPrefix, "/") + "/" + h.ID
+		}
+
+		// Hooks of nested groups belong to the innermost group.
+		if h.Group == "" {
+			h.Group = g.ID
+		}
+
+		switch {
+		case g.TriggerRule == nil:
+		case h.TriggerRule == nil:
+			rule := *g.TriggerRule
+			h.TriggerRule = &rule
+		default:
+			h.TriggerRule = &Rules{And: &AndRule{*g.TriggerRule, *h.TriggerRule}}
+		}
+
+		if len(g.PassEnvironmentToCommand) != 0 {
+			h.PassEnvironmentToCommand = append(append([]Argument(nil), g.PassEnvironmentToCommand...), h.PassEnvironmentToCommand...)
+		}
+
+		if len(h.AllowedCallers) == 0 {
+			h.AllowedCallers = g.AllowedCallers
+		}
+	}
+
+	return hooks, nil
+}
@@ -0,0 +1,221 @@
+package hook
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+// parseHCL decodes the HCL 1 document data into a map.  Only literal values
+// are supported: strings, heredocs, numbers, booleans, lists and objects, and
+// blocks.  Strings are taken literally, including ${...} sequences.
+//
+// Blocks named hook or group are collected, in order, into the "hooks" list,
+// their label setting the id or group property.  Other unlabeled blocks
+// become objects, or lists of objects if there are several of the same name,
+// and other labeled blocks become objects keyed by their labels.
+func parseHCL(data []byte) (m map[string]interface{}, err error) {
+	f, err := hcl.ParseBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("hcl: %w", err)
+	}
+
+	// The hcl package panics on some values its scanner accepts.
+	defer func() {
+		if r := recover(); r != nil {
+			m, err = nil, fmt.Errorf("hcl: %v", r)
+		}
+	}()
+
+	list, ok := f.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, fmt.Errorf("hcl: unexpected document")
+	}
+
+	m, err = hclBody(list)
+	if err != nil {
+		return nil, fmt.Errorf("hcl: %w", err)
+	}
+
+	return m, nil
+}
+
+// hclBody decodes the attributes and blocks of list into a map.
+func hclBody(list *ast.ObjectList) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	blocks := make(map[string]bool)
+
+	for _, item := range list.Items {
+		name := hclKey(item.Keys[0])
+
+		if item.Assign.IsValid() {
+			if len(item.Keys) > 1 {
+				return nil, fmt.Errorf("%s: attribute %s has labels", item.Pos(), name)
+			}
+
+			if _, ok := m[name]; ok {
+				return nil, fmt.Errorf("%s: duplicate attribute %s", item.Pos(), name)
+			}
+
+			v, err := hclValue(item.Val)
+			if err != nil {
+				return nil, err
+			}
+
+			m[name] = v
+			continue
+		}
+
+		obj, ok := item.Val.(*ast.ObjectType)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected a block body for %s", item.Pos(), name)
+		}
+
+		body, err := hclBody(obj.List)
+		if err != nil {
+			return nil, err
+		}
+
+		labels := make([]string, 0, len(item.Keys)-1)
+		for _, k := range item.Keys[1:] {
+			labels = append(labels, hclKey(k))
+		}
+
+		if err := addHCLBlock(m, blocks, name, labels, body); err != nil {
+			return nil, fmt.Errorf("%s: %w", item.Pos(), err)
+		}
+	}
+
+	return m, nil
+}
+
+// hclKey returns the name of the attribute, block or label k.
+func hclKey(k *ast.ObjectKey) string {
+	if k.Token.Type == token.STRING {
+		return k.Token.Value().(string)
+	}
+
+	return k.Token.Text
+}
+
+// hclValue decodes the attribute value n.
+func hclValue(n ast.Node) (interface{}, error) {
+	switch n := n.(type) {
+	case *ast.LiteralType:
+		switch n.Token.Type {
+		case token.NUMBER:
+			if v, err := strconv.ParseInt(n.Token.Text, 0, 64); err == nil {
+				return v, nil
+			}
+
+			return strconv.ParseFloat(n.Token.Text, 64)
+		case token.FLOAT:
+			return strconv.ParseFloat(n.Token.Text, 64)
+		case token.STRING, token.HEREDOC, token.BOOL:
+			return n.Token.Value(), nil
+		}
+
+	case *ast.ListType:
+		list := make([]interface{}, 0, len(n.List))
+
+		for _, e := range n.List {
+			v, err := hclValue(e)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, v)
+		}
+
+		return list, nil
+
+	case *ast.ObjectType:
+		return hclBody(n.List)
+	}
+
+	return nil, fmt.Errorf("%s: unsupported value", n.Pos())
+}
+
+// addHCLBlock adds the block name with labels and body to m, which holds the
+// blocks named in blocks.
+func addHCLBlock(m map[string]interface{}, blocks map[string]bool, name string, labels []string, body map[string]interface{}) error {
+	if _, ok := m[name]; ok && !blocks[name] {
+		return fmt.Errorf("%s is both an attribute and a block", name)
+	}
+
+	if name == "hook" || name == "group" {
+		if len(labels) > 1 {
+			return fmt.Errorf("%s block has more than one label", name)
+		}
+
+		if len(labels) == 1 {
+			key := "id"
+			if name == "group" {
+				key = "group"
+			}
+
+			body[key] = labels[0]
+		}
+
+		name = "hooks"
+		if _, ok := m[name]; ok && !blocks[name] {
+			return fmt.Errorf("hooks is both an attribute and a block")
+		}
+
+		blocks[name] = true
+
+		list, _ := m[name].([]interface{})
+		m[name] = append(list, body)
+
+		return nil
+	}
+
+	blocks[name] = true
+
+	if len(labels) == 0 {
+		switch v := m[name].(type) {
+		case nil:
+			m[name] = body
+		case []interface{}:
+			m[name] = append(v, body)
+		case map[string]interface{}:
+			m[name] = []interface{}{v, body}
+		}
+
+		return nil
+	}
+
+	// Labeled blocks nest objects keyed by each label.
+	parent, ok := m[name].(map[string]interface{})
+	if !ok {
+		if m[name] != nil {
+			return fmt.Errorf("%s blocks with and without labels", name)
+		}
+
+		parent = make(map[string]interface{})
+		m[name] = parent
+	}
+
+	for _, label := range labels[:len(labels)-1] {
+		child, ok := parent[label].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			parent[label] = child
+		}
+
+		parent = child
+	}
+
+	last := labels[len(labels)-1]
+	if _, ok := parent[last]; ok {
+		return fmt.Errorf("duplicate %s block %s", name, strings.Join(labels, " "))
+	}
+
+	parent[last] = body
+
+	return nil
+}
@@ -1,8 +1,8 @@
 package hook
 
 import (
-	"bytes"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -15,18 +15,18 @@ import (
 	"hash"
 	"io/ioutil"
 	"log"
-	"math"
 	"net"
 	"net/textproto"
 	"os"
+	"path"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync"
 	"time"
 
-	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
 // Constants used to specify the parameter source
@@ -41,6 +41,11 @@ const (
 	SourceEntirePayload  string = "entire-payload"
 	SourceEntireQuery    string = "entire-query"
 	SourceEntireHeaders  string = "entire-headers"
+	SourceElement        string = "element"
+	SourceNormalized     string = "normalized"
+	SourceIdentity       string = "identity"
+	SourceVault          string = "vault"
+	SourcePlugin         string = "plugin"
 )
 
 const (
@@ -155,19 +160,24 @@ func ExtractCommaSeparatedValues(source, prefix string) []string {
 	return values
 }
 
-// ExtractSignatures will extract all the signatures from the source.
+// ExtractSignatures will extract all the signatures from the source.  Senders
+// rotating keys may send several comma separated signatures, ie.
+// "sha256=a, sha256=b", so each of them is returned with the prefix (if it
+// even exists) trimmed.
 func ExtractSignatures(source, prefix string) []string {
-	// If there are multiple possible matches, let the comma seperated extractor
-	// do it's work.
-	if strings.Contains(source, ",") {
-		return ExtractCommaSeparatedValues(source, prefix)
-	}
+	parts := strings.Split(source, ",")
+	signatures := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
 
-	// There were no commas, so just trim the prefix (if it even exists) and
-	// pass it back.
-	return []string{
-		strings.TrimPrefix(source, prefix),
+		signatures = append(signatures, strings.TrimPrefix(part, prefix))
 	}
+
+	return signatures
 }
 
 // ValidateMAC will verify that the expected mac for the given hash will match
@@ -195,46 +205,189 @@ func ValidateMAC(payload []byte, mac hash.Hash, signatures []string) (string, er
 	return actualMAC, e
 }
 
-// CheckPayloadSignature calculates and verifies SHA1 signature of the given payload
-func CheckPayloadSignature(payload []byte, secret, signature string) (string, error) {
-	if secret == "" {
-		return "", errors.New("signature validation secret can not be empty")
+// hashAlgorithms maps the algorithm names of payload-hmac rules to their hash
+// functions.
+var hashAlgorithms = struct {
+	sync.RWMutex
+	m map[string]func() hash.Hash
+}{m: map[string]func() hash.Hash{
+	"md5":         md5.New,
+	"sha1":        sha1.New,
+	"sha256":      sha256.New,
+	"sha512":      sha512.New,
+	"sha3-256":    sha3.New256,
+	"sha3-512":    sha3.New512,
+	"blake2b":     unkeyedBLAKE2b(blake2b.New512),
+	"blake2b-256": unkeyedBLAKE2b(blake2b.New256),
+}}
+
+// unkeyedBLAKE2b returns a hash function creating unkeyed BLAKE2b hashes with
+// newHash, which only fails for keys that are too long.
+func unkeyedBLAKE2b(newHash func(key []byte) (hash.Hash, error)) func() hash.Hash {
+	return func() hash.Hash {
+		h, _ := newHash(nil)
+		return h
 	}
+}
 
-	// Extract the signatures.
-	signatures := ExtractSignatures(signature, "sha1=")
+// RegisterHashAlgorithm makes the hash function fn available to payload-hmac
+// rules as the algorithm name, replacing any algorithm of the same name.
+func RegisterHashAlgorithm(name string, fn func() hash.Hash) {
+	hashAlgorithms.Lock()
+	defer hashAlgorithms.Unlock()
 
-	// Validate the MAC.
-	return ValidateMAC(payload, hmac.New(sha1.New, []byte(secret)), signatures)
+	hashAlgorithms.m[name] = fn
 }
 
-// CheckPayloadSignature256 calculates and verifies SHA256 signature of the given payload
-func CheckPayloadSignature256(payload []byte, secret, signature string) (string, error) {
+// HashAlgorithm returns the hash function of the algorithm name.
+func HashAlgorithm(name string) (func() hash.Hash, bool) {
+	hashAlgorithms.RLock()
+	defer hashAlgorithms.RUnlock()
+
+	fn, ok := hashAlgorithms.m[name]
+
+	return fn, ok
+}
+
+// Constants for the signature encodings of payload-hmac rules.
+const (
+	EncodingHex    = "hex"
+	EncodingBase64 = "base64"
+)
+
+// HMACOptions configures how CheckPayloadHMACOptions verifies signatures.
+type HMACOptions struct {
+	// Algorithm is the name of the hash algorithm, ie. "sha256".
+	Algorithm string
+
+	// Encoding is the encoding of the signatures, EncodingHex (the default)
+	// or EncodingBase64.  Hex signatures may be upper case.
+	Encoding string
+
+	// Prefix is removed from the signatures if present.  Defaults to the
+	// algorithm name and "=", ie. "sha256=".  SignaturePrefixNone compares
+	// the signatures as given.
+	Prefix string
+}
+
+// SignaturePrefixNone is the HMACOptions prefix of signatures sent as bare
+// digests.
+const SignaturePrefixNone = "none"
+
+// CheckPayloadHMAC calculates and verifies the HMAC signature of the given
+// payload using the hash algorithm.  Signatures may be prefixed with the
+// algorithm name and "=", ie. "sha3-256=".
+func CheckPayloadHMAC(payload []byte, algorithm, secret, signature string) (string, error) {
+	return CheckPayloadHMACOptions(payload, secret, signature, HMACOptions{Algorithm: algorithm})
+}
+
+// CheckPayloadHMACOptions calculates and verifies the HMAC signature of the
+// given payload as configured by o, and returns the expected signature in the
+// configured encoding.  Multiple comma separated signatures are accepted if
+// any of them is valid.
+func CheckPayloadHMACOptions(payload []byte, secret, signature string, o HMACOptions) (string, error) {
 	if secret == "" {
 		return "", errors.New("signature validation secret can not be empty")
 	}
 
-	// Extract the signatures.
-	signatures := ExtractSignatures(signature, "sha256=")
+	fn, ok := HashAlgorithm(o.Algorithm)
+	if !ok {
+		return "", fmt.Errorf("unknown hash algorithm %q", o.Algorithm)
+	}
+
+	prefix := o.Prefix
+	switch prefix {
+	case "":
+		prefix = o.Algorithm + "="
+	case SignaturePrefixNone:
+		prefix = ""
+	}
+
+	signatures := ExtractSignatures(signature, prefix)
 
-	// Validate the MAC.
-	return ValidateMAC(payload, hmac.New(sha256.New, []byte(secret)), signatures)
+	mac := hmac.New(fn, []byte(secret))
+	mac.Write(payload)
+	sum := mac.Sum(nil)
+
+	var expected string
+
+	switch o.Encoding {
+	case "", EncodingHex:
+		expected = hex.EncodeToString(sum)
+	case EncodingBase64:
+		expected = base64.StdEncoding.EncodeToString(sum)
+	default:
+		return "", fmt.Errorf("unknown signature encoding %q", o.Encoding)
+	}
+
+	for _, s := range signatures {
+		if b, err := decodeSignature(strings.TrimSpace(s), o.Encoding); err == nil && hmac.Equal(b, sum) {
+			return expected, nil
+		}
+	}
+
+	e := &SignatureError{Signatures: signatures}
+	if len(payload) == 0 {
+		e.emptyPayload = true
+	}
+
+	return expected, e
 }
 
-// CheckPayloadSignature512 calculates and verifies SHA512 signature of the given payload
-func CheckPayloadSignature512(payload []byte, secret, signature string) (string, error) {
-	if secret == "" {
-		return "", errors.New("signature validation secret can not be empty")
+// decodeSignature decodes the signature s in the encoding, accepting both
+// standard and URL-safe base64, with or without padding.
+func decodeSignature(s, encoding string) ([]byte, error) {
+	if encoding != EncodingBase64 {
+		return hex.DecodeString(s)
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err = enc.DecodeString(s); err == nil {
+			return b, nil
+		}
 	}
 
-	// Extract the signatures.
-	signatures := ExtractSignatures(signature, "sha512=")
+	return nil, err
+}
+
+// CheckPayloadSignature calculates and verifies SHA1 signature of the given payload
+func CheckPayloadSignature(payload []byte, secret, signature string) (string, error) {
+	return CheckPayloadHMAC(payload, "sha1", secret, signature)
+}
+
+// CheckPayloadSignature256 calculates and verifies SHA256 signature of the given payload
+func CheckPayloadSignature256(payload []byte, secret, signature string) (string, error) {
+	return CheckPayloadHMAC(payload, "sha256", secret, signature)
+}
 
-	// Validate the MAC.
-	return ValidateMAC(payload, hmac.New(sha512.New, []byte(secret)), signatures)
+// CheckPayloadSignature512 calculates and verifies SHA512 signature of the given payload
+func CheckPayloadSignature512(payload []byte, secret, signature string) (string, error) {
+	return CheckPayloadHMAC(payload, "sha512", secret, signature)
 }
 
+// Now returns the current time used by time-dependent rules.  It may be
+// replaced to evaluate rules against a fixed clock.
+var Now = time.Now
+
+// ScalrMaxAge is how far the Date header of Scalr requests may be from the
+// current time if the scalr-signature rule doesn't set a max-age.
+const ScalrMaxAge = 5 * time.Minute
+
+// CheckScalrSignature checks the signature of a Scalr request and, if
+// checkDate is set, that it was signed at most ScalrMaxAge from the current
+// time.
 func CheckScalrSignature(r *Request, signingKey string, checkDate bool) (bool, error) {
+	return CheckScalrSignatureMaxAge(r, signingKey, checkDate, ScalrMaxAge)
+}
+
+// CheckScalrSignatureMaxAge is like CheckScalrSignature, with the maximum
+// difference between the Date header and the current time given by maxAge.
+func CheckScalrSignatureMaxAge(r *Request, signingKey string, checkDate bool, maxAge time.Duration) (bool, error) {
 	if r.Headers == nil {
 		return false, nil
 	}
@@ -269,10 +422,7 @@ func CheckScalrSignature(r *Request, signingKey string, checkDate bool) (bool, e
 	if err != nil {
 		return false, err
 	}
-	now := time.Now()
-	delta := math.Abs(now.Sub(date).Seconds())
-
-	if delta > 300 {
+	if d := Now().Sub(date); d > maxAge || d < -maxAge {
 		return false, &SignatureError{Signature: "outdated"}
 	}
 	return true, nil
@@ -281,39 +431,221 @@ func CheckScalrSignature(r *Request, signingKey string, checkDate bool) (bool, e
 // CheckIPWhitelist makes sure the provided remote address (of the form IP:port) falls within the provided IP range
 // (in CIDR form or a single IP address).
 func CheckIPWhitelist(remoteAddr, ipRange string) (bool, error) {
-	// Extract IP address from remote address.
+	ip, err := parseRemoteIP(remoteAddr)
+	if err != nil {
+		return false, err
+	}
 
-	// IPv6 addresses will likely be surrounded by [].
-	ip := strings.Trim(remoteAddr, " []")
+	for _, r := range strings.Fields(ipRange) {
+		cidr, err := parseIPRange(r)
+		if err != nil {
+			return false, err
+		}
 
-	if i := strings.LastIndex(ip, ":"); i != -1 {
-		ip = ip[:i]
-		ip = strings.Trim(ip, " []")
+		if ipNetContains(cidr, ip) {
+			return true, nil
+		}
 	}
 
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
-		return false, fmt.Errorf("invalid IP address found in remote address '%s'", remoteAddr)
+	return false, nil
+}
+
+// CheckIPRules reports whether the provided remote address (of the form IP:port) is allowed by the ordered rules,
+// "allow <ranges>" or "deny <ranges>" with space-separated IP ranges like those of CheckIPWhitelist.  The first rule
+// whose ranges contain the address decides; addresses matching no rule are allowed if def is set.
+func CheckIPRules(remoteAddr string, rules []string, def bool) (bool, error) {
+	ip, err := parseRemoteIP(remoteAddr)
+	if err != nil {
+		return false, err
 	}
 
-	for _, r := range strings.Fields(ipRange) {
-		// Extract IP range in CIDR form.  If a single IP address is provided, turn it into CIDR form.
+	for _, rule := range rules {
+		allow, ranges, err := parseIPRule(rule)
+		if err != nil {
+			return false, err
+		}
+
+		ranges, err = ExpandIPRange(ranges)
+		if err != nil {
+			return false, err
+		}
+
+		for _, r := range strings.Fields(ranges) {
+			cidr, err := parseIPRange(r)
+			if err != nil {
+				return false, err
+			}
+
+			if ipNetContains(cidr, ip) {
+				return allow, nil
+			}
+		}
+	}
+
+	return def, nil
+}
+
+// parseIPRule returns whether the ip-rules entry rule allows or denies its
+// IP ranges, and the ranges.
+func parseIPRule(rule string) (bool, string, error) {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 {
+		return false, "", fmt.Errorf("invalid ip-rules entry %q: expected allow or deny followed by IP ranges", rule)
+	}
+
+	switch ranges := strings.Join(fields[1:], " "); fields[0] {
+	case "allow":
+		return true, ranges, nil
+	case "deny":
+		return false, ranges, nil
+	}
 
-		if !strings.Contains(r, "/") {
-			r = r + "/32"
+	return false, "", fmt.Errorf("invalid ip-rules entry %q: expected allow or deny followed by IP ranges", rule)
+}
+
+// parseRemoteIP returns the IP address of the remote address, of the form
+// IP:port or [IP]:port, or a bare IP address.  The zone of IPv6 link-local
+// addresses, ie. "%eth0", is ignored.
+func parseRemoteIP(remoteAddr string) (net.IP, error) {
+	addr := strings.TrimSpace(remoteAddr)
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Addresses without a port, possibly bracketed, or bracketed
+		// with their port.
+		host = strings.Trim(addr, " []")
+
+		if parseIPZone(host) == nil {
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
 		}
+	}
+
+	ip := parseIPZone(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address found in remote address '%s'", remoteAddr)
+	}
+
+	return ip, nil
+}
+
+// parseIPZone parses the IP address s, ignoring its IPv6 zone if any.
+func parseIPZone(s string) net.IP {
+	if i := strings.LastIndexByte(s, '%'); i != -1 && strings.Contains(s, ":") {
+		s = s[:i]
+	}
+
+	return net.ParseIP(s)
+}
 
+// parseIPRange parses the IP range r, in CIDR form or a single IP address.
+func parseIPRange(r string) (*net.IPNet, error) {
+	if strings.Contains(r, "/") {
 		_, cidr, err := net.ParseCIDR(r)
+		return cidr, err
+	}
+
+	ip := net.ParseIP(r)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q in IP range", r)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ipNetContains reports whether the network n contains ip.  Unlike
+// n.Contains, IPv4 addresses are also matched against IPv6 networks of
+// IPv4-mapped addresses, ie. ::ffff:0:0/96.
+func ipNetContains(n *net.IPNet, ip net.IP) bool {
+	if n.Contains(ip) {
+		return true
+	}
+
+	if ip.To4() == nil || len(n.IP) != net.IPv6len || len(n.Mask) != net.IPv6len {
+		return false
+	}
+
+	ip = ip.To16()
+	for i := range ip {
+		if ip[i]&n.Mask[i] != n.IP[i]&n.Mask[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ipRangeFilePrefix marks an ip-range entry referencing a file of IP ranges.
+const ipRangeFilePrefix = "file:"
+
+// ExpandIPRange replaces "file:<path>" entries of the space-separated ipRange
+// with the IP ranges listed in the file, one or more per line.  Text following
+// a "#" in the file is ignored.  Files are re-read when they change.
+func ExpandIPRange(ipRange string) (string, error) {
+	if !strings.Contains(ipRange, ipRangeFilePrefix) {
+		return ipRange, nil
+	}
+
+	var res []string
+
+	for _, r := range strings.Fields(ipRange) {
+		if !strings.HasPrefix(r, ipRangeFilePrefix) {
+			res = append(res, r)
+			continue
+		}
+
+		data, err := files.Get(strings.TrimPrefix(r, ipRangeFilePrefix))
 		if err != nil {
-			return false, err
+			return "", err
 		}
 
-		if cidr.Contains(parsedIP) {
-			return true, nil
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.IndexByte(line, '#'); i != -1 {
+				line = line[:i]
+			}
+
+			res = append(res, strings.Fields(line)...)
 		}
 	}
 
-	return false, nil
+	return strings.Join(res, " "), nil
+}
+
+// CheckHTTPMethod reports whether method is one of the comma-separated
+// methods, ignoring case and surrounding whitespace.
+func CheckHTTPMethod(method, methods string) bool {
+	for _, m := range strings.Split(methods, ",") {
+		if strings.EqualFold(method, strings.TrimSpace(m)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckGroups reports whether identity is a member of any of the
+// comma-separated groups.
+func CheckGroups(identity *Identity, groups string) bool {
+	if identity == nil {
+		return false
+	}
+
+	for _, g := range strings.Split(groups, ",") {
+		g = strings.TrimSpace(g)
+
+		for _, m := range identity.Groups {
+			if g != "" && g == m {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // ReplaceParameter replaces parameter value with the passed value in the passed map
@@ -417,9 +749,15 @@ func ExtractParameterAsString(s string, params interface{}) (string, error) {
 		return "", err
 	}
 
-	switch v := reflect.ValueOf(pValue); v.Kind() {
+	return valueAsString(pValue)
+}
+
+// valueAsString renders v as a string.  Complex data types are rendered as
+// JSON instead of the Go Stringer format.
+func valueAsString(v interface{}) (string, error) {
+	switch reflect.ValueOf(v).Kind() {
 	case reflect.Array, reflect.Map, reflect.Slice:
-		r, err := json.Marshal(pValue)
+		r, err := json.Marshal(v)
 		if err != nil {
 			return "", err
 		}
@@ -427,7 +765,7 @@ func ExtractParameterAsString(s string, params interface{}) (string, error) {
 		return string(r), nil
 
 	default:
-		return fmt.Sprintf("%v", pValue), nil
+		return fmt.Sprintf("%v", v), nil
 	}
 }
 
@@ -457,12 +795,46 @@ func (ha *Argument) Get(r *Request) (string, error) {
 	case SourcePayload:
 		source = &r.Payload
 
+	case SourceNormalized:
+		source = &r.Normalized
+
+	case SourcePlugin:
+		source = &r.Plugin
+
 	case SourceString:
 		return ha.Name, nil
 
+	case SourceVault:
+		return vault.Secret(ha.Name)
+
+	case SourceElement:
+		if r.Element == nil {
+			return "", errors.New("element source used outside of an any or all rule")
+		}
+
+		if ha.Name == "" {
+			return valueAsString(r.Element)
+		}
+
+		return ExtractParameterAsString(ha.Name, r.Element)
+
 	case SourceRawRequestBody:
 		return string(r.Body), nil
 
+	case SourceIdentity:
+		if r.Identity == nil {
+			return "", errors.New("no caller identity")
+		}
+
+		switch strings.ToLower(ha.Name) {
+		case "user":
+			return r.Identity.User, nil
+		case "groups":
+			return strings.Join(r.Identity.Groups, ","), nil
+		default:
+			return "", fmt.Errorf("unsupported identity key: %q", ha.Name)
+		}
+
 	case SourceRequest:
 		if r == nil || r.RawRequest == nil {
 			return "", errors.New("request is nil")
@@ -473,6 +845,28 @@ func (ha *Argument) Get(r *Request) (string, error) {
 			return r.RawRequest.RemoteAddr, nil
 		case "method":
 			return r.RawRequest.Method, nil
+		case "url":
+			return requestURL(r.RawRequest), nil
+		case "host":
+			return r.RawRequest.Host, nil
+		case "tls-version":
+			if r.RawRequest.TLS == nil {
+				return "", nil
+			}
+
+			return tlsVersionName(r.RawRequest.TLS.Version), nil
+		case "content-length":
+			if r.RawRequest.ContentLength < 0 {
+				return strconv.Itoa(len(r.Body)), nil
+			}
+
+			return strconv.FormatInt(r.RawRequest.ContentLength, 10), nil
+		case "received":
+			if r.Received.IsZero() {
+				return "", errors.New("no received time")
+			}
+
+			return r.Received.UTC().Format(time.RFC3339Nano), nil
 		default:
 			return "", fmt.Errorf("unsupported request key: %q", ha.Name)
 		}
@@ -506,9 +900,70 @@ func (ha *Argument) Get(r *Request) (string, error) {
 		return ExtractParameterAsString(key, *source)
 	}
 
+	if s := lookupSource(ha.Source); s != nil {
+		return s.Resolve(ha.Name, r)
+	}
+
 	return "", errors.New("no source for value retrieval")
 }
 
+// GetValue returns the value of the argument without converting it to a
+// string, so arrays and objects can be inspected.  Values of sources other
+// than header, url, payload and element are decoded as JSON.
+func (ha *Argument) GetValue(r *Request) (interface{}, error) {
+	var source interface{}
+	key := ha.Name
+
+	switch ha.Source {
+	case SourceHeader:
+		source = r.Headers
+		key = textproto.CanonicalMIMEHeaderKey(ha.Name)
+
+	case SourceQuery, SourceQueryAlias:
+		source = r.Query
+
+	case SourcePayload:
+		source = r.Payload
+
+	case SourceNormalized:
+		source = r.Normalized
+
+	case SourcePlugin:
+		source = r.Plugin
+
+	case SourceElement:
+		if r.Element == nil {
+			return nil, errors.New("element source used outside of an any or all rule")
+		}
+
+		if key == "" {
+			return r.Element, nil
+		}
+
+		source = r.Element
+	}
+
+	if source != nil {
+		return GetParameter(key, source)
+	}
+
+	s, err := ha.Get(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
 // Header is a structure containing header name and it's value
 type Header struct {
 	Name  string `json:"name"`
@@ -562,10 +1017,15 @@ func (h *HooksFiles) Set(value string) error {
 	return nil
 }
 
+// PayloadMapping maps target keys to payload paths.
+type PayloadMapping map[string]string
+
 // Hook type is a structure containing details for a single hook
 type Hook struct {
 	ID                                  string          `json:"id,omitempty"`
 	ExecuteCommand                      string          `json:"execute-command,omitempty"`
+	ExecuteShell                        string          `json:"execute-shell,omitempty"`
+	Shell                               string          `json:"shell,omitempty"`
 	CommandWorkingDirectory             string          `json:"command-working-directory,omitempty"`
 	ResponseMessage                     string          `json:"response-message,omitempty"`
 	ResponseHeaders                     ResponseHeaders `json:"response-headers,omitempty"`
@@ -581,39 +1041,194 @@ type Hook struct {
 	IncomingPayloadContentType          string          `json:"incoming-payload-content-type,omitempty"`
 	SuccessHttpResponseCode             int             `json:"success-http-response-code,omitempty"`
 	HTTPMethods                         []string        `json:"http-methods"`
+	ReadTimeout                         string          `json:"read-timeout,omitempty"`
+	CommandOutputFormat                 string          `json:"command-output-format,omitempty"`
+	ResponseHeadersFromOutput           []OutputHeader  `json:"response-headers-from-output,omitempty"`
+	ResponseTemplate                    string          `json:"response-template,omitempty"`
+	NotificationFieldsFromOutput        []OutputHeader  `json:"notification-fields-from-output,omitempty"`
+	Normalize                           PayloadMapping  `json:"normalize,omitempty"`
+	Kind                                string          `json:"kind,omitempty"`
+	ServiceSocket                       string          `json:"service-socket,omitempty"`
+	ProxyURL                            string          `json:"proxy-url,omitempty"`
+	ProxyHeaders                        ResponseHeaders `json:"proxy-headers,omitempty"`
+	ProxyBodyTemplate                   string          `json:"proxy-body-template,omitempty"`
+	SuccessCriteria                     *SuccessRule    `json:"success-criteria,omitempty"`
+	AllowedCallers                      []string        `json:"allowed-callers,omitempty"`
+	CSRFProtection                      bool            `json:"csrf-protection,omitempty"`
+	CaptureRequestsToDir                string          `json:"capture-requests-to-dir,omitempty"`
+	CaptureRequestsMax                  int             `json:"capture-requests-max,omitempty"`
+	IncomingPath                        string          `json:"incoming-path,omitempty"`
+	HideID                              bool            `json:"hide-id,omitempty"`
+	CORS                                *CORS           `json:"cors,omitempty"`
+	SanitizeOutput                      []string        `json:"sanitize-output,omitempty"`
+	AckFirst                            bool            `json:"ack-first,omitempty"`
+	Retry                               *RetryRule      `json:"retry,omitempty"`
+	ResponseDeadline                    string          `json:"response-deadline,omitempty"`
+
+	// DecryptPayload decrypts the request body before it is parsed, if set.
+	DecryptPayload *PayloadDecryption `json:"decrypt-payload,omitempty"`
+
+	// ReplayProtection rejects repeated deliveries, if set.
+	ReplayProtection *ReplayProtection `json:"replay-protection,omitempty"`
+
+	// LogFile logs the requests to the hook and its command output to a
+	// dedicated file, if set.
+	LogFile *LogFile `json:"log-file,omitempty"`
+
+	// PayloadFile writes the whole payload to a temporary file for the
+	// command, if set.
+	PayloadFile *PayloadFile `json:"payload-file,omitempty"`
+
+	// FileRetention is how long the files of pass-file-to-command and
+	// payload-file are kept once the command exited: FileRetentionDelete,
+	// the default, FileRetentionKeep or a duration.
+	FileRetention string `json:"file-retention,omitempty"`
+
+	// OnFailure lists the URLs of the notifiers notified of failed
+	// executions, in addition to those set with -on-failure.
+	OnFailure []string `json:"on-failure,omitempty"`
+
+	// Disabled hooks are loaded, but answer requests with 503 Service
+	// Unavailable until enabled through the admin API.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// CallbackURL is the URL an execution report is POSTed to once the
+	// command finished, and CallbackOn whether to do so on completion, the
+	// default, or on success only.
+	CallbackURL string `json:"callback-url,omitempty"`
+	CallbackOn  string `json:"callback-on,omitempty"`
+
+	// DetectPayloadType parses payloads sent with a missing or unsupported
+	// Content-Type as JSON, XML or form data if they look like one.
+	DetectPayloadType bool `json:"detect-payload-type,omitempty"`
+
+	// Plugins are WebAssembly modules run before the trigger rule is
+	// evaluated, which can rewrite the payload, add parameters and veto the
+	// request.
+	Plugins []Plugin `json:"plugins,omitempty"`
+
+	// Group is the ID of the group the hook was defined in, if any.
+	Group string `json:"-"`
 }
 
-// ParseJSONParameters decodes specified arguments to JSON objects and replaces the
-// string with the newly created object
-func (h *Hook) ParseJSONParameters(r *Request) []error {
-	errors := make([]error, 0)
+// SuccessRule defines when a command execution is considered successful.
+type SuccessRule struct {
+	// ExitCodes lists the successful exit codes.  Defaults to 0 only.
+	ExitCodes []int `json:"exit-codes,omitempty"`
 
-	for i := range h.JSONStringParameters {
-		arg, err := h.JSONStringParameters[i].Get(r)
-		if err != nil {
-			errors = append(errors, &ArgumentError{h.JSONStringParameters[i]})
-		} else {
-			var newArg map[string]interface{}
+	// OutputRegex, if set, must match the command output.
+	OutputRegex string `json:"output-regex,omitempty"`
+}
 
-			decoder := json.NewDecoder(strings.NewReader(string(arg)))
-			decoder.UseNumber()
+// Check returns an error describing why an execution that exited with
+// exitCode and produced output is not successful, or nil if it is.
+func (s *SuccessRule) Check(exitCode int, output string) error {
+	codes := s.ExitCodes
+	if len(codes) == 0 {
+		codes = []int{0}
+	}
 
-			err := decoder.Decode(&newArg)
-			if err != nil {
-				errors = append(errors, &ParseError{err})
-				continue
-			}
+	var ok bool
+	for _, c := range codes {
+		if c == exitCode {
+			ok = true
+			break
+		}
+	}
 
-			var source *map[string]interface{}
+	if !ok {
+		return fmt.Errorf("exit status %d is not one of the successful exit codes %v", exitCode, codes)
+	}
 
-			switch h.JSONStringParameters[i].Source {
-			case SourceHeader:
-				source = &r.Headers
-			case SourcePayload:
-				source = &r.Payload
-			case SourceQuery, SourceQueryAlias:
-				source = &r.Query
-			}
+	if s.OutputRegex != "" {
+		matched, err := matchRegex(s.OutputRegex, output)
+		if err != nil {
+			return err
+		}
+
+		if !matched {
+			return fmt.Errorf("command output doesn't match %q", s.OutputRegex)
+		}
+	}
+
+	return nil
+}
+
+// Constants for the Hook kind
+const (
+	KindCommand string = "command"
+	KindService string = "service"
+	KindProxy   string = "proxy"
+)
+
+// NormalizePayload builds r.Normalized from the hook's normalize mapping of target
+// keys to payload paths.  Dotted target keys create nested objects.  Targets
+// whose payload path doesn't exist are left out.
+func (h *Hook) NormalizePayload(r *Request) []error {
+	if len(h.Normalize) == 0 {
+		return nil
+	}
+
+	var errors []error
+
+	r.Normalized = make(map[string]interface{}, len(h.Normalize))
+
+	for target, path := range h.Normalize {
+		v, err := GetParameter(path, r.Payload)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+
+		m := r.Normalized
+		keys := strings.Split(target, ".")
+
+		for _, k := range keys[:len(keys)-1] {
+			next, ok := m[k].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				m[k] = next
+			}
+			m = next
+		}
+
+		m[keys[len(keys)-1]] = v
+	}
+
+	return errors
+}
+
+// ParseJSONParameters decodes specified arguments to JSON objects and replaces the
+// string with the newly created object
+func (h *Hook) ParseJSONParameters(r *Request) []error {
+	errors := make([]error, 0)
+
+	for i := range h.JSONStringParameters {
+		arg, err := h.JSONStringParameters[i].Get(r)
+		if err != nil {
+			errors = append(errors, &ArgumentError{h.JSONStringParameters[i]})
+		} else {
+			var newArg map[string]interface{}
+
+			decoder := json.NewDecoder(strings.NewReader(string(arg)))
+			decoder.UseNumber()
+
+			err := decoder.Decode(&newArg)
+			if err != nil {
+				errors = append(errors, &ParseError{err})
+				continue
+			}
+
+			var source *map[string]interface{}
+
+			switch h.JSONStringParameters[i].Source {
+			case SourceHeader:
+				source = &r.Headers
+			case SourcePayload:
+				source = &r.Payload
+			case SourceQuery, SourceQueryAlias:
+				source = &r.Query
+			}
 
 			if source != nil {
 				key := h.JSONStringParameters[i].Name
@@ -642,7 +1257,7 @@ func (h *Hook) ExtractCommandArguments(r *Request) ([]string, []error) {
 	args := make([]string, 0)
 	errors := make([]error, 0)
 
-	args = append(args, h.ExecuteCommand)
+	args = append(args, h.Command())
 
 	for i := range h.PassArgumentsToCommand {
 		arg, err := h.PassArgumentsToCommand[i].Get(r)
@@ -655,6 +1270,10 @@ func (h *Hook) ExtractCommandArguments(r *Request) ([]string, []error) {
 		args = append(args, arg)
 	}
 
+	if h.ExecuteShell != "" {
+		args = h.shellArgs(args[1:])
+	}
+
 	if len(errors) > 0 {
 		return args, errors
 	}
@@ -731,6 +1350,15 @@ func (h *Hook) ExtractCommandArgumentsForFile(r *Request) ([]FileParameter, []er
 		args = append(args, FileParameter{EnvName: h.PassFileToCommand[i].EnvName, Data: fileContent})
 	}
 
+	if h.PayloadFile != nil {
+		fp, err := h.PayloadFile.FileParameter(r)
+		if err != nil {
+			errors = append(errors, err)
+		} else {
+			args = append(args, fp)
+		}
+	}
+
 	if len(errors) > 0 {
 		return args, errors
 	}
@@ -738,13 +1366,48 @@ func (h *Hook) ExtractCommandArgumentsForFile(r *Request) ([]FileParameter, []er
 	return args, nil
 }
 
+// FileEnvNames returns the environment variables passing the paths of the
+// files written for the command.
+func (h *Hook) FileEnvNames() []string {
+	var names []string
+
+	for _, a := range h.PassFileToCommand {
+		if a.EnvName != "" {
+			names = append(names, a.EnvName)
+		} else {
+			names = append(names, EnvNamespace+strings.ToUpper(a.Name))
+		}
+	}
+
+	if h.PayloadFile != nil {
+		names = append(names, h.PayloadFile.envName())
+	}
+
+	return names
+}
+
+// FileRetentionDelay returns how long the files written for the command are
+// kept once it exited, and false if they are kept forever.
+func (h *Hook) FileRetentionDelay() (time.Duration, bool) {
+	switch h.FileRetention {
+	case "", FileRetentionDelete:
+		return 0, true
+	case FileRetentionKeep:
+		return 0, false
+	}
+
+	d, _ := time.ParseDuration(h.FileRetention)
+
+	return d, true
+}
+
 // Hooks is an array of Hook objects
 type Hooks []Hook
 
 // LoadFromFile attempts to load hooks from the specified file, which
 // can be either JSON or YAML.  The asTemplate parameter causes the file
 // contents to be parsed as a Go text/template prior to unmarshalling.
-func (h *Hooks) LoadFromFile(path string, asTemplate bool) error {
+func (h *Hooks) LoadFromFile(path string, asTemplate bool, options ...LoadOption) error {
 	if path == "" {
 		return nil
 	}
@@ -756,25 +1419,70 @@ func (h *Hooks) LoadFromFile(path string, asTemplate bool) error {
 		return e
 	}
 
+	return h.Load(path, file, asTemplate, options...)
+}
+
+// Load loads hooks from the hooks file contents in file, like LoadFromFile.
+// The path of the file determines its format and resolves relative includes.
+func (h *Hooks) Load(path string, file []byte, asTemplate bool, options ...LoadOption) error {
+	var e error
+
+	o := newLoadOptions(options...)
+	src := file
+
+	if isSOPSEncrypted(file) {
+		file, e = decryptSOPS(path, file, o.sopsCommand)
+		if e != nil {
+			return e
+		}
+	}
+
 	if asTemplate {
-		funcMap := template.FuncMap{"getenv": getenv}
+		var err error
 
-		tmpl, err := template.New("hooks").Funcs(funcMap).Parse(string(file))
+		o.templateValues, err = readTemplateValues(o.templateValuesFiles)
 		if err != nil {
 			return err
 		}
 
-		var buf bytes.Buffer
-
-		err = tmpl.Execute(&buf, nil)
+		file, err = executeTemplate(file, path, o, 0)
 		if err != nil {
 			return err
 		}
+	}
+
+	file, e = hooksFileJSON(path, file)
+	if e != nil {
+		return e
+	}
+
+	file, e = resolveIncludes(path, file)
+	if e != nil {
+		return e
+	}
+
+	if err := validateHooksFile(file, src, o.strict); err != nil {
+		return err
+	}
+
+	if err := unmarshalHooks(file, h); err != nil {
+		return err
+	}
+
+	if err := h.checkLoaded(); err != nil {
+		return err
+	}
 
-		file = buf.Bytes()
+	return h.resolveVaultSecrets()
+}
+
+// joinFieldPath appends the object key k to the field path.
+func joinFieldPath(path, k string) string {
+	if path == "" {
+		return k
 	}
 
-	return yaml.Unmarshal(file, h)
+	return path + "." + k
 }
 
 // Append appends hooks unless the new hooks contain a hook with an ID that already exists
@@ -807,6 +1515,8 @@ type Rules struct {
 	And   *AndRule   `json:"and,omitempty"`
 	Or    *OrRule    `json:"or,omitempty"`
 	Not   *NotRule   `json:"not,omitempty"`
+	Any   *AnyRule   `json:"any,omitempty"`
+	All   *AllRule   `json:"all,omitempty"`
 	Match *MatchRule `json:"match,omitempty"`
 }
 
@@ -820,6 +1530,10 @@ func (r Rules) Evaluate(req *Request) (bool, error) {
 		return r.Or.Evaluate(req)
 	case r.Not != nil:
 		return r.Not.Evaluate(req)
+	case r.Any != nil:
+		return r.Any.Evaluate(req)
+	case r.All != nil:
+		return r.All.Evaluate(req)
 	case r.Match != nil:
 		return r.Match.Evaluate(req)
 	}
@@ -827,6 +1541,62 @@ func (r Rules) Evaluate(req *Request) (bool, error) {
 	return false, nil
 }
 
+// MatchRules returns all match rules in the rule tree, in depth-first order.
+// It returns nil if r is nil.
+func (r *Rules) MatchRules() []*MatchRule {
+	if r == nil {
+		return nil
+	}
+
+	var res []*MatchRule
+
+	switch {
+	case r.And != nil:
+		for i := range *r.And {
+			res = append(res, (*r.And)[i].MatchRules()...)
+		}
+	case r.Or != nil:
+		for i := range *r.Or {
+			res = append(res, (*r.Or)[i].MatchRules()...)
+		}
+	case r.Not != nil:
+		res = append(res, (*Rules)(r.Not).MatchRules()...)
+	case r.Any != nil:
+		res = append(res, r.Any.Rule.MatchRules()...)
+	case r.All != nil:
+		res = append(res, r.All.Rule.MatchRules()...)
+	case r.Match != nil:
+		res = append(res, r.Match)
+	}
+
+	return res
+}
+
+// ReliesOnSHA1 reports whether the trigger rule of h verifies signatures with
+// SHA-1 or MD5 only, such as payload-hmac-sha1 or scalr-signature rules,
+// without also using a stronger hash.
+func (h *Hook) ReliesOnSHA1() bool {
+	var weak, strong bool
+
+	for _, m := range h.TriggerRule.MatchRules() {
+		switch m.Type {
+		case MatchHMACSHA1, MatchHashSHA1, ScalrSignature:
+			weak = true
+		case MatchHMACSHA256, MatchHashSHA256, MatchHMACSHA512, MatchHashSHA512, ShopifySignature,
+			GiteaSignature, StandardWebhooks, MailgunSignature, PayPalSignature, DocuSignHMAC:
+			strong = true
+		case MatchHMAC:
+			if m.Algorithm == "sha1" || m.Algorithm == "md5" {
+				weak = true
+			} else {
+				strong = true
+			}
+		}
+	}
+
+	return weak && !strong
+}
+
 // AndRule will evaluate to true if and only if all of the ChildRules evaluate to true
 type AndRule []Rules
 
@@ -884,75 +1654,601 @@ func (r NotRule) Evaluate(req *Request) (bool, error) {
 	return !rv, err
 }
 
+// ElementRule evaluates Rule against each element of the array referenced by
+// Parameter.  Arguments with the element source refer to the element being
+// evaluated.
+type ElementRule struct {
+	Parameter Argument `json:"parameter"`
+	Rule      Rules    `json:"rule"`
+}
+
+// elements returns the elements of the array referenced by r.Parameter.
+func (r ElementRule) elements(req *Request) ([]interface{}, error) {
+	v, err := r.Parameter.GetValue(req)
+	if err != nil {
+		return nil, err
+	}
+
+	elems, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("parameter %q is not an array", r.Parameter.Name)
+	}
+
+	return elems, nil
+}
+
+// evaluate evaluates r.Rule against elem.
+func (r ElementRule) evaluate(req *Request, elem interface{}) (bool, error) {
+	elemReq := *req
+	elemReq.Element = elem
+
+	return r.Rule.Evaluate(&elemReq)
+}
+
+// AnyRule will evaluate to true if the ChildRule evaluates to true for any
+// element of the array
+type AnyRule ElementRule
+
+// Evaluate AnyRule will return true if ChildRule evaluates to true for any
+// element of the array
+func (r AnyRule) Evaluate(req *Request) (bool, error) {
+	elems, err := ElementRule(r).elements(req)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range elems {
+		rv, err := ElementRule(r).evaluate(req, e)
+		if err != nil {
+			if !IsParameterNodeError(err) {
+				if !req.AllowSignatureErrors || (req.AllowSignatureErrors && !IsSignatureError(err)) {
+					return false, err
+				}
+			}
+		}
+
+		if rv {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// AllRule will evaluate to true if and only if the ChildRule evaluates to
+// true for all elements of the array
+type AllRule ElementRule
+
+// Evaluate AllRule will return true if and only if ChildRule evaluates to
+// true for all elements of the array.  It returns true for an empty array.
+func (r AllRule) Evaluate(req *Request) (bool, error) {
+	elems, err := ElementRule(r).elements(req)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range elems {
+		rv, err := ElementRule(r).evaluate(req, e)
+		if err != nil {
+			return false, err
+		}
+
+		if !rv {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Secrets holds the secrets accepted by a signature rule.  It is given as a
+// single string, or as a list to rotate secrets without downtime.
+type Secrets []string
+
+// UnmarshalJSON implements json.Unmarshaler, accepting a string or a list of
+// strings.
+func (s *Secrets) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = Secrets{one}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("secret must be a string or a list of strings")
+	}
+
+	*s = list
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding a single secret as a
+// string.
+func (s Secrets) MarshalJSON() ([]byte, error) {
+	if len(s) == 1 {
+		return json.Marshal(s[0])
+	}
+
+	return json.Marshal([]string(s))
+}
+
+// check calls verify with each secret until one of them verifies the request,
+// and returns the result of the last call otherwise.
+func (s Secrets) check(verify func(secret string) (bool, error)) (bool, error) {
+	if len(s) == 0 {
+		return verify("")
+	}
+
+	var (
+		ok  bool
+		err error
+	)
+
+	for _, secret := range s {
+		if ok, err = verify(secret); ok {
+			return true, nil
+		}
+	}
+
+	return ok, err
+}
+
+// checkSecrets calls verify with each secret of the rule, given inline or read
+// from its secret files, until one of them verifies the request.  Secret files
+// are re-read when they change, so secrets can be rotated without reloading
+// hooks.
+func (r MatchRule) checkSecrets(verify func(secret string) (bool, error)) (bool, error) {
+	secrets := make(Secrets, 0, len(r.Secret)+len(r.SecretFile))
+
+	for _, secret := range r.Secret {
+		secret, err := resolveSecret(secret)
+		if err != nil {
+			return false, err
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	if len(r.SecretFile) > 0 {
+		for _, path := range r.SecretFile {
+			data, err := files.Get(path)
+			if err != nil {
+				return false, fmt.Errorf("error reading secret-file: %w", err)
+			}
+
+			// Editors and tools like kubectl usually end files with a newline.
+			secrets = append(secrets, strings.TrimRight(string(data), "\r\n"))
+		}
+	}
+
+	return secrets.check(verify)
+}
+
 // MatchRule will evaluate to true based on the type
 type MatchRule struct {
 	Type      string   `json:"type,omitempty"`
 	Regex     string   `json:"regex,omitempty"`
-	Secret    string   `json:"secret,omitempty"`
+	Secret    Secrets  `json:"secret,omitempty"`
 	Value     string   `json:"value,omitempty"`
 	Parameter Argument `json:"parameter,omitempty"`
 	IPRange   string   `json:"ip-range,omitempty"`
+
+	// IPRules lists "allow <ranges>" and "deny <ranges>" entries checked in
+	// order by ip-whitelist and ip-blacklist rules, before ip-range, which
+	// they allow and deny, respectively.  The first entry whose ranges
+	// contain the remote address decides.
+	IPRules []string `json:"ip-rules,omitempty"`
+
+	// Algorithm is the hash algorithm of payload-hmac rules, ie. "sha256".
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Encoding is the signature encoding of payload-hmac rules, "hex" (the
+	// default) or "base64".
+	Encoding string `json:"encoding,omitempty"`
+
+	// SignaturePrefix is removed from the signatures of payload-hmac and
+	// payload-hmac-* rules if present, or "none" for bare digests.  Defaults
+	// to the algorithm name and "=".
+	SignaturePrefix string `json:"signature-prefix,omitempty"`
+
+	// SecretFile lists files holding additional secrets, ie. mounted
+	// Kubernetes Secrets or systemd credentials.
+	SecretFile Secrets `json:"secret-file,omitempty"`
+
+	// RefreshInterval is how often github-ip-whitelist and bitbucket rules
+	// fetch the provider's IP ranges, as a duration string.  Defaults to an
+	// hour.
+	RefreshInterval string `json:"refresh-interval,omitempty"`
+
+	// Layout is the format of the timestamp checked by timestamp-fresh
+	// rules, see ParseTimestamp.
+	Layout string `json:"layout,omitempty"`
+
+	// MaxAge is how far the timestamp checked by timestamp-fresh and
+	// scalr-signature rules may be from the current time, as a duration
+	// string.  Defaults to DefaultMaxAge and ScalrMaxAge, respectively.
+	MaxAge string `json:"max-age,omitempty"`
+
+	// CheckDate is whether scalr-signature rules check the Date header of
+	// the request against max-age.  Defaults to true.
+	CheckDate *bool `json:"check-date,omitempty"`
+
+	// CompareTo is the parameter the parameter of compare rules is
+	// compared to, with Operator.
+	CompareTo Argument `json:"compare-to,omitempty"`
+
+	// Operator is how compare rules compare their parameters, "equals"
+	// (the default), "not-equals" or "contains".
+	Operator string `json:"operator,omitempty"`
+
+	// Command is the validator run by command rules, which match if it
+	// exits with status 0.  It is passed the request body on stdin, and the
+	// arguments and environment in PassArgumentsToCommand and
+	// PassEnvironmentToCommand.
+	Command                  string     `json:"command,omitempty"`
+	PassArgumentsToCommand   []Argument `json:"pass-arguments-to-command,omitempty"`
+	PassEnvironmentToCommand []Argument `json:"pass-environment-to-command,omitempty"`
+
+	// URL is the authorization service http-auth rules post a summary of
+	// the request to, and FailOpen whether they match rather than fail
+	// when it can't be reached or answers with an error.
+	URL      string `json:"url,omitempty"`
+	FailOpen bool   `json:"fail-open,omitempty"`
+
+	// Timeout is how long the validator of command rules may run, or the
+	// service of http-auth rules may take to answer, as a duration string.
+	// Defaults to DefaultCommandRuleTimeout and DefaultHTTPAuthTimeout,
+	// respectively.
+	Timeout string `json:"timeout,omitempty"`
+
+	// CompareToEnv is the name of the environment variable holding the
+	// value or regex of value and regex rules, read when they are evaluated
+	// instead of being given in the hooks file.
+	CompareToEnv string `json:"compare-to-env,omitempty"`
+
+	// Options holds the settings of match rules of types registered with
+	// RegisterMatcher.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Operators of compare rules.
+const (
+	CompareEquals    = "equals"
+	CompareNotEquals = "not-equals"
+	CompareContains  = "contains"
+)
+
+// compareParameters reports whether the parameter value arg of a compare
+// rule compares to its compare-to parameter with its operator.  Empty
+// compare-to values are contained in no value.
+func (r *MatchRule) compareParameters(req *Request, arg string) (bool, error) {
+	other, err := r.CompareTo.Get(req)
+	if err != nil {
+		return false, err
+	}
+
+	switch r.Operator {
+	case "", CompareEquals:
+		return compare(arg, other), nil
+	case CompareNotEquals:
+		return !compare(arg, other), nil
+	case CompareContains:
+		return other != "" && strings.Contains(arg, other), nil
+	}
+
+	return false, fmt.Errorf("unknown operator %q", r.Operator)
+}
+
+// expected returns the value or regex s the parameter of value and regex
+// rules is matched against, read from the compare-to-env environment
+// variable if set.  Unset and empty variables are errors rather than
+// matching empty parameters.
+func (r *MatchRule) expected(s string) (string, error) {
+	if r.CompareToEnv == "" {
+		return s, nil
+	}
+
+	if v := os.Getenv(r.CompareToEnv); v != "" {
+		return v, nil
+	}
+
+	return "", fmt.Errorf("environment variable %s of compare-to-env is not set", r.CompareToEnv)
+}
+
+// evaluateIPRules evaluates ip-whitelist and ip-blacklist rules, which
+// allow and deny the remote addresses in ip-range, respectively, after the
+// entries of ip-rules.
+func (r *MatchRule) evaluateIPRules(req *Request) (bool, error) {
+	rules := r.IPRules
+
+	if r.IPRange != "" {
+		action := "allow "
+		if r.Type == IPBlacklist {
+			action = "deny "
+		}
+
+		rules = append(rules[:len(rules):len(rules)], action+r.IPRange)
+	}
+
+	return CheckIPRules(req.RawRequest.RemoteAddr, rules, r.Type == IPBlacklist)
 }
 
+// Constants for the file-retention setting of hooks.
+const (
+	FileRetentionDelete = "delete"
+	FileRetentionKeep   = "keep"
+)
+
+// Constants for the callback-on setting of hooks.
+const (
+	CallbackOnCompletion = "completion"
+	CallbackOnSuccess    = "success"
+)
+
 // Constants for the MatchRule type
 const (
-	MatchValue      string = "value"
-	MatchRegex      string = "regex"
-	MatchHMACSHA1   string = "payload-hmac-sha1"
-	MatchHMACSHA256 string = "payload-hmac-sha256"
-	MatchHMACSHA512 string = "payload-hmac-sha512"
-	MatchHashSHA1   string = "payload-hash-sha1"
-	MatchHashSHA256 string = "payload-hash-sha256"
-	MatchHashSHA512 string = "payload-hash-sha512"
-	IPWhitelist     string = "ip-whitelist"
-	ScalrSignature  string = "scalr-signature"
+	MatchValue       string = "value"
+	MatchRegex       string = "regex"
+	MatchHMACSHA1    string = "payload-hmac-sha1"
+	MatchHMACSHA256  string = "payload-hmac-sha256"
+	MatchHMACSHA512  string = "payload-hmac-sha512"
+	MatchHMAC        string = "payload-hmac"
+	MatchHashSHA1    string = "payload-hash-sha1"
+	MatchHashSHA256  string = "payload-hash-sha256"
+	MatchHashSHA512  string = "payload-hash-sha512"
+	IPWhitelist      string = "ip-whitelist"
+	IPBlacklist      string = "ip-blacklist"
+	GeoIPCountry     string = "geoip-country"
+	DNSWhitelist     string = "dns-whitelist"
+	MatchCompare     string = "compare"
+	MatchSemver      string = "semver"
+	MatchCommand     string = "command"
+	MatchHTTPAuth    string = "http-auth"
+	ScalrSignature   string = "scalr-signature"
+	MatchHTTPMethod  string = "http-method"
+	MatchURLPath     string = "url-path-regex"
+	MatchGT          string = "gt"
+	MatchLT          string = "lt"
+	MatchGTE         string = "gte"
+	MatchLTE         string = "lte"
+	MatchBetween     string = "between"
+	MatchGlob        string = "glob"
+	GitHubWhitelist  string = "github-ip-whitelist"
+	MatchSSOGroup    string = "sso-group"
+	ShopifySignature string = "shopify-signature"
+	GiteaSignature   string = "gitea-signature"
+	GiteaEvent       string = "gitea-event"
+	MatchBitbucket   string = "bitbucket"
+	StandardWebhooks string = "standard-webhooks"
+	MailgunSignature string = "mailgun-signature"
+	PayPalSignature  string = "paypal-signature"
+	DocuSignHMAC     string = "docusign-signature"
+	MatchTimestamp   string = "timestamp-fresh"
 )
 
 // Evaluate MatchRule will return based on the type
 func (r MatchRule) Evaluate(req *Request) (bool, error) {
-	if r.Type == IPWhitelist {
-		return CheckIPWhitelist(req.RawRequest.RemoteAddr, r.IPRange)
+	if m := lookupMatcher(r.Type); m != nil {
+		return m.Match(&r, req)
+	}
+	if r.Type == IPWhitelist || r.Type == IPBlacklist {
+		return r.evaluateIPRules(req)
+	}
+	if r.Type == GitHubWhitelist {
+		refresh, err := parseRefreshInterval(r.RefreshInterval, DefaultGitHubMetaRefresh)
+		if err != nil {
+			return false, err
+		}
+
+		ipRange, err := githubMeta.Ranges(refresh)
+		if err != nil {
+			return false, err
+		}
+
+		return CheckIPWhitelist(req.RawRequest.RemoteAddr, ipRange)
+	}
+	if r.Type == MatchBitbucket {
+		refresh, err := parseRefreshInterval(r.RefreshInterval, DefaultAtlassianIPRangesRefresh)
+		if err != nil {
+			return false, err
+		}
+
+		return CheckBitbucket(req, r.Value, refresh)
 	}
 	if r.Type == ScalrSignature {
-		return CheckScalrSignature(req, r.Secret, true)
+		return r.checkSecrets(func(secret string) (bool, error) {
+			return CheckScalrSignatureMaxAge(req, secret, r.CheckDate == nil || *r.CheckDate, r.maxAge(ScalrMaxAge))
+		})
+	}
+	if r.Type == ShopifySignature {
+		return r.checkSecrets(func(secret string) (bool, error) {
+			return CheckShopifySignature(req, secret)
+		})
+	}
+	if r.Type == StandardWebhooks {
+		return r.checkSecrets(func(secret string) (bool, error) {
+			return CheckStandardWebhooksSignature(req, secret)
+		})
+	}
+	if r.Type == PayPalSignature {
+		return CheckPayPalSignature(req, r.Value)
+	}
+	if r.Type == DocuSignHMAC {
+		return r.checkSecrets(func(secret string) (bool, error) {
+			return CheckDocuSignSignature(req, secret)
+		})
+	}
+	if r.Type == MailgunSignature {
+		return r.checkSecrets(func(secret string) (bool, error) {
+			return CheckMailgunSignature(req, secret)
+		})
+	}
+	if r.Type == GiteaSignature {
+		return r.checkSecrets(func(secret string) (bool, error) {
+			return CheckGiteaSignature(req, secret)
+		})
+	}
+	if r.Type == GiteaEvent {
+		return CheckGiteaEvent(req, r.Value), nil
+	}
+	if r.Type == MatchSSOGroup {
+		return CheckGroups(req.Identity, r.Value), nil
+	}
+	if r.Type == GeoIPCountry {
+		return CheckGeoIPCountry(req.RawRequest.RemoteAddr, r.Value)
+	}
+	if r.Type == DNSWhitelist {
+		return CheckDNSWhitelist(req.RawRequest.RemoteAddr, r.Value)
+	}
+	if r.Type == MatchCommand {
+		return r.checkCommand(req)
+	}
+	if r.Type == MatchHTTPAuth {
+		return r.checkHTTPAuth(req)
+	}
+	if r.Type == MatchHTTPMethod || r.Type == MatchURLPath {
+		if req.RawRequest == nil {
+			return false, errors.New("request is nil")
+		}
+
+		if r.Type == MatchHTTPMethod {
+			return CheckHTTPMethod(req.RawRequest.Method, r.Value), nil
+		}
+
+		return matchRegex(r.Regex, req.RawRequest.URL.Path)
 	}
 
 	arg, err := r.Parameter.Get(req)
 	if err == nil {
 		switch r.Type {
 		case MatchValue:
-			return compare(arg, r.Value), nil
+			value, err := r.expected(r.Value)
+			if err != nil {
+				return false, err
+			}
+			return compare(arg, value), nil
 		case MatchRegex:
-			return regexp.MatchString(r.Regex, arg)
+			regex, err := r.expected(r.Regex)
+			if err != nil {
+				return false, err
+			}
+			return matchRegex(regex, arg)
+		case MatchGlob:
+			return path.Match(r.Value, arg)
+		case MatchCompare:
+			return r.compareParameters(req, arg)
+		case MatchSemver:
+			return CheckSemver(arg, r.Value)
 		case MatchHashSHA1:
 			log.Print(`warn: use of deprecated option payload-hash-sha1; use payload-hmac-sha1 instead`)
 			fallthrough
 		case MatchHMACSHA1:
-			_, err := CheckPayloadSignature(req.Body, r.Secret, arg)
-			return err == nil, err
+			return r.checkHMAC(req, "sha1", arg)
 		case MatchHashSHA256:
 			log.Print(`warn: use of deprecated option payload-hash-sha256: use payload-hmac-sha256 instead`)
 			fallthrough
 		case MatchHMACSHA256:
-			_, err := CheckPayloadSignature256(req.Body, r.Secret, arg)
-			return err == nil, err
+			return r.checkHMAC(req, "sha256", arg)
 		case MatchHashSHA512:
 			log.Print(`warn: use of deprecated option payload-hash-sha512: use payload-hmac-sha512 instead`)
 			fallthrough
 		case MatchHMACSHA512:
-			_, err := CheckPayloadSignature512(req.Body, r.Secret, arg)
-			return err == nil, err
+			return r.checkHMAC(req, "sha512", arg)
+		case MatchHMAC:
+			return r.checkHMAC(req, r.Algorithm, arg)
+		case MatchGT, MatchLT, MatchGTE, MatchLTE, MatchBetween:
+			return CheckNumber(arg, r.Type, r.Value)
+		case MatchTimestamp:
+			return CheckTimestampFresh(arg, r.Layout, r.maxAge(DefaultMaxAge))
 		}
 	}
 	return false, err
 }
 
+// checkHMAC verifies the HMAC signature of the request body in signature with
+// the secrets of the rule, using its encoding and signature prefix.
+func (r MatchRule) checkHMAC(req *Request, algorithm, signature string) (bool, error) {
+	return r.checkSecrets(func(secret string) (bool, error) {
+		_, err := CheckPayloadHMACOptions(req.Body, secret, signature, HMACOptions{Algorithm: algorithm, Encoding: r.Encoding, Prefix: r.SignaturePrefix})
+		return err == nil, err
+	})
+}
+
+// parseNumber parses s as a number.  If s is a JSON array or object, as
+// returned by Argument.Get for such values, its number of elements is used.
+func parseNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		return n, nil
+	}
+
+	if strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{") {
+		var v interface{}
+		if json.Unmarshal([]byte(s), &v) == nil {
+			switch v := v.(type) {
+			case []interface{}:
+				return float64(len(v)), nil
+			case map[string]interface{}:
+				return float64(len(v)), nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("%q is not a number", s)
+}
+
+// CheckNumber compares the numeric value of arg to value using the given
+// operator: gt, lt, gte, lte or between.  For between, value holds the
+// inclusive lower and upper bounds separated by a comma.
+func CheckNumber(arg, operator, value string) (bool, error) {
+	n, err := parseNumber(arg)
+	if err != nil {
+		return false, err
+	}
+
+	if operator == MatchBetween {
+		bounds := strings.Split(value, ",")
+		if len(bounds) != 2 {
+			return false, fmt.Errorf("invalid between value %q: expected \"min,max\"", value)
+		}
+
+		min, err := parseNumber(bounds[0])
+		if err != nil {
+			return false, err
+		}
+
+		max, err := parseNumber(bounds[1])
+		if err != nil {
+			return false, err
+		}
+
+		return n >= min && n <= max, nil
+	}
+
+	v, err := parseNumber(value)
+	if err != nil {
+		return false, err
+	}
+
+	switch operator {
+	case MatchGT:
+		return n > v, nil
+	case MatchLT:
+		return n < v, nil
+	case MatchGTE:
+		return n >= v, nil
+	case MatchLTE:
+		return n <= v, nil
+	}
+
+	return false, fmt.Errorf("unknown numeric operator %q", operator)
+}
+
 // compare is a helper function for constant time string comparisons.
 func compare(a, b string) bool {
 	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
-
-// getenv provides a template function to retrieve OS environment variables.
-func getenv(s string) string {
-	return os.Getenv(s)
-}
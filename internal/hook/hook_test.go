@@ -1,11 +1,44 @@
 package hook
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
 )
 
 func TestGetParameter(t *testing.T) {
@@ -205,6 +238,23 @@ var checkIPWhitelistTests = []struct {
 	{" [2001:db8:1:2::1:1234] ", "  2001:db8:1::/48 ", true, true},
 	{" [2001:db8:1:2::1:1234] ", "  2001:db8:1::/48 2001:db8:1::/64", true, true},
 	{" [2001:db8:1:2::1:1234] ", "  2001:db8:1::/64 ", false, true},
+	{"10.0.0.1:1234", "10.0.0.1", true, true},
+	{"10.0.0.1", "10.0.0.0/8", true, true},
+	{"[2001:db8::1]:443", "2001:db8::/32", true, true},
+	{"[2001:db8::1]:443", "2001:db8::1", true, true},
+	{"[2001:db8::1]:443", "2001:db8::2", false, true},
+	{"2001:db8::1", "2001:db8::1", true, true},
+	{"[2001:db8::1]", "2001:db8::1", true, true},
+	{"[fe80::1%eth0]:1234", "fe80::/10", true, true},
+	{"fe80::1%eth0", "fe80::1", true, true},
+	{"[::ffff:10.0.0.1]:1234", "10.0.0.0/8", true, true},
+	{"[::ffff:10.0.0.1]:1234", "10.0.0.1", true, true},
+	{"[::ffff:10.0.0.1]:1234", "::ffff:0:0/96", true, true},
+	{"10.0.0.1:1234", "::ffff:10.0.0.0/104", true, true},
+	{"10.0.0.1:1234", "::/96", false, true},
+	{"localhost:1234", "10.0.0.0/8", false, false},
+	{"10.0.0.1:1234", "10.0.0.0/33", false, false},
+	{"10.0.0.1:1234", "example.com", false, false},
 }
 
 func TestCheckIPWhitelist(t *testing.T) {
@@ -216,6 +266,329 @@ func TestCheckIPWhitelist(t *testing.T) {
 	}
 }
 
+var checkIPRulesTests = []struct {
+	addr   string
+	rules  []string
+	def    bool
+	expect bool
+	ok     bool
+}{
+	{"10.0.0.5:80", []string{"deny 10.1.2.0/24", "allow 10.0.0.0/8"}, false, true, true},
+	{"10.1.2.3:80", []string{"deny 10.1.2.0/24", "allow 10.0.0.0/8"}, false, false, true},
+	{"192.168.0.1:80", []string{"deny 10.1.2.0/24", "allow 10.0.0.0/8"}, false, false, true},
+	{"192.168.0.1:80", []string{"deny 10.0.0.0/8 172.16.0.0/12"}, true, true, true},
+	{"172.16.5.4:80", []string{"deny 10.0.0.0/8 172.16.0.0/12"}, true, false, true},
+	{"[::1]:80", []string{"allow ::1", "deny ::/0"}, false, true, true},
+	{"[::2]:80", []string{"allow ::1", "deny ::/0"}, true, false, true},
+	{"10.0.0.5:80", nil, true, true, true},
+	// errors
+	{"10.0.0.5:80", []string{"permit 10.0.0.0/8"}, false, false, false},
+	{"10.0.0.5:80", []string{"allow"}, false, false, false},
+	{"10.0.0.5:80", []string{"allow 10.0.0.a"}, false, false, false},
+	{"10.0.0.a:80", []string{"allow 10.0.0.0/8"}, false, false, false},
+}
+
+func TestCheckIPRules(t *testing.T) {
+	for _, tt := range checkIPRulesTests {
+		result, err := CheckIPRules(tt.addr, tt.rules, tt.def)
+		if (err == nil) != tt.ok || result != tt.expect {
+			t.Errorf("ip rules test failed {%q, %q, %v}:\nwant {expect:%#v, ok:%#v},\ngot {result:%#v, ok:%#v}", tt.addr, tt.rules, tt.def, tt.expect, tt.ok, result, err)
+		}
+	}
+}
+
+// buildMMDB returns a MaxMind DB of the given IP version with 24-bit
+// records, mapping the networks to records with their country codes.
+func buildMMDB(t *testing.T, ipVersion int, countries map[string]string) []byte {
+	type node struct{ records [2]int } // node index, -1 if empty, or -2-data
+
+	nodes := []node{{[2]int{-1, -1}}}
+
+	var data []byte
+	countryKey := -1
+
+	for cidr, code := range countries {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ip := []byte(n.IP)
+		ones, _ := n.Mask.Size()
+		if ipVersion == 6 && len(ip) == net.IPv4len {
+			ip, ones = append(make([]byte, 12), ip...), ones+96
+		}
+
+		// {"country": {"iso_code": code}}, with the "country" key written
+		// once and then referenced with a pointer.
+		off := len(data)
+		data = append(data, 7<<5|1)
+		if countryKey == -1 {
+			countryKey = len(data)
+			data = append(data, 2<<5|7)
+			data = append(data, "country"...)
+		} else {
+			data = append(data, 1<<5|byte(countryKey>>8), byte(countryKey))
+		}
+		data = append(data, 7<<5|1, 2<<5|8)
+		data = append(data, "iso_code"...)
+		data = append(data, 2<<5|byte(len(code)))
+		data = append(data, code...)
+
+		cur := 0
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - uint(i%8)) & 1
+			if i == ones-1 {
+				nodes[cur].records[bit] = -2 - off
+				break
+			}
+			if nodes[cur].records[bit] < 0 {
+				nodes = append(nodes, node{[2]int{-1, -1}})
+				nodes[cur].records[bit] = len(nodes) - 1
+			}
+			cur = nodes[cur].records[bit]
+		}
+	}
+
+	var buf []byte
+	for _, n := range nodes {
+		for _, r := range n.records {
+			v := r
+			switch {
+			case r == -1:
+				v = len(nodes)
+			case r < -1:
+				v = len(nodes) + 16 + (-2 - r)
+			}
+			buf = append(buf, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, "\xab\xcd\xefMaxMind.com"...)
+	buf = append(buf, 7<<5|3)
+	buf = append(buf, 2<<5|10)
+	buf = append(buf, "node_count"...)
+	buf = append(buf, 6<<5|4, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(len(nodes)))
+	buf = append(buf, 2<<5|11)
+	buf = append(buf, "record_size"...)
+	buf = append(buf, 5<<5|2, 0, 24)
+	buf = append(buf, 2<<5|10)
+	buf = append(buf, "ip_version"...)
+	buf = append(buf, 5<<5|2, 0, byte(ipVersion))
+
+	return buf
+}
+
+// serveDNS answers the DNS queries received on conn with the A and AAAA
+// records of the hosts, with a TTL of ttl seconds, and NXDOMAIN for other
+// hosts, counting the queries.
+func serveDNS(conn net.PacketConn, hosts map[string][]string, ttl uint32, queries *int32) {
+	buf := make([]byte, 512)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		atomic.AddInt32(queries, 1)
+
+		q := buf[:n]
+		end := skipDNSName(q, 12)
+		qtype := binary.BigEndian.Uint16(q[end:])
+
+		var labels []string
+		for off := 12; q[off] != 0; off += 1 + int(q[off]) {
+			labels = append(labels, string(q[off+1:off+1+int(q[off])]))
+		}
+
+		resp := append([]byte(nil), q[:end+4]...)
+		binary.BigEndian.PutUint16(resp[2:], 0x8180)
+
+		ips, ok := hosts[strings.Join(labels, ".")]
+		if !ok {
+			resp[3] |= dnsRcodeNXName
+		}
+
+		var ancount uint16
+		for _, s := range ips {
+			ip := net.ParseIP(s)
+			typ, rdata := uint16(dnsTypeAAAA), []byte(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				typ, rdata = dnsTypeA, ip4
+			}
+			if typ != qtype {
+				continue
+			}
+
+			resp = append(resp, 0xc0, 12, byte(typ>>8), byte(typ), 0, 1, 0, 0, 0, 0, 0, byte(len(rdata)))
+			binary.BigEndian.PutUint32(resp[len(resp)-6:], ttl)
+			resp = append(resp, rdata...)
+			ancount++
+		}
+
+		binary.BigEndian.PutUint16(resp[6:], ancount)
+
+		conn.WriteTo(resp, addr)
+	}
+}
+
+func TestCheckDNSWhitelist(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	hosts := map[string][]string{
+		"office.example.com": {"192.0.2.10", "2001:db8::10"},
+		"home.example.net":   {"198.51.100.7"},
+		"ci.example.org":     {"203.0.113.20"},
+	}
+
+	var queries int32
+	go serveDNS(conn, hosts, 300, &queries)
+
+	defer func(r *dnsResolver) { resolver = r }(resolver)
+	resolver = &dnsResolver{servers: []string{conn.LocalAddr().String()}}
+
+	for _, tt := range []struct {
+		addr, value string
+		expect, ok  bool
+	}{
+		{"192.0.2.10:80", "office.example.com", true, true},
+		{"[2001:db8::10]:80", "office.example.com.", true, true},
+		{"198.51.100.7:80", "office.example.com, home.example.net", true, true},
+		{"198.51.100.8:80", "office.example.com, home.example.net", false, true},
+		{"192.0.2.10:80", "missing.example.com", false, true},
+		{"192.0.2.a:80", "office.example.com", false, false},
+	} {
+		ok, err := MatchRule{Type: DNSWhitelist, Value: tt.value}.Evaluate(&Request{RawRequest: &http.Request{RemoteAddr: tt.addr}})
+		if ok != tt.expect || (err == nil) != tt.ok {
+			t.Errorf("%s in %q: expected %v, %v, got %v, %v", tt.addr, tt.value, tt.expect, tt.ok, ok, err)
+		}
+	}
+
+	// Each host is resolved once with an A and an AAAA query, and then
+	// cached for the TTL.
+	if n := atomic.LoadInt32(&queries); n != 6 {
+		t.Errorf("expected 6 queries, got %d", n)
+	}
+
+	// Concurrent lookups of a host share a resolution.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := CheckDNSWhitelist("203.0.113.20:80", "ci.example.org"); !ok || err != nil {
+				t.Errorf("expected ci.example.org to match, got %v, %v", ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&queries); n != 8 {
+		t.Errorf("expected 8 queries, got %d", n)
+	}
+
+	// Cached addresses are used when resolving fails.
+	resolver.cache["home.example.net"] = dnsEntry{ips: []net.IP{net.ParseIP("198.51.100.9")}, resolved: time.Now().Add(-time.Hour), expires: time.Now().Add(-time.Second)}
+	conn.Close()
+
+	if ok, err := CheckDNSWhitelist("198.51.100.9:80", "home.example.net"); !ok || err != nil {
+		t.Errorf("expected the cached address to match, got %v, %v", ok, err)
+	}
+
+	if _, err := CheckDNSWhitelist("198.51.100.9:80", "other.example.org"); err == nil {
+		t.Error("expected an error resolving an uncached host")
+	}
+
+	for value, ok := range map[string]bool{"office.example.com": true, "a.example, b.example.": true, "": false, "a..example": false} {
+		if err := (&MatchRule{Type: DNSWhitelist, Value: value}).Validate(); (err == nil) != ok {
+			t.Errorf("%q: unexpected validation result %v", value, err)
+		}
+	}
+}
+
+func TestCheckGeoIPCountry(t *testing.T) {
+	defer ConfigureGeoIP("")
+
+	if _, err := CheckGeoIPCountry("1.2.3.4:80", "DE"); err == nil {
+		t.Error("expected an error without a GeoIP database")
+	}
+
+	dir, err := ioutil.TempDir("", "geoip-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		ipVersion int
+		countries map[string]string
+		addr      string
+		value     string
+		expect    bool
+	}{
+		{4, map[string]string{"1.2.0.0/16": "DE", "5.0.0.0/8": "FR"}, "1.2.3.4:80", "de, fr", true},
+		{4, map[string]string{"1.2.0.0/16": "DE", "5.0.0.0/8": "FR"}, "5.6.7.8:80", "FR", true},
+		{4, map[string]string{"1.2.0.0/16": "DE", "5.0.0.0/8": "FR"}, "5.6.7.8:80", "DE", false},
+		{4, map[string]string{"1.2.0.0/16": "DE", "5.0.0.0/8": "FR"}, "9.9.9.9:80", "DE", false},
+		{4, map[string]string{"1.2.0.0/16": "DE", "5.0.0.0/8": "FR"}, "[2001:db8::1]:80", "DE", false},
+		{6, map[string]string{"1.2.0.0/16": "DE", "2001:db8::/32": "NL"}, "[2001:db8::1]:80", "NL", true},
+		{6, map[string]string{"1.2.0.0/16": "DE", "2001:db8::/32": "NL"}, "1.2.3.4:80", "DE", true},
+		{6, map[string]string{"1.2.0.0/16": "DE", "2001:db8::/32": "NL"}, "[::ffff:1.2.3.4]:80", "DE", true},
+		{6, map[string]string{"1.2.0.0/16": "DE", "2001:db8::/32": "NL"}, "[2001:db9::1]:80", "NL", false},
+	} {
+		path := filepath.Join(dir, fmt.Sprintf("GeoLite2-Country-%d.mmdb", tt.ipVersion))
+		if err := ioutil.WriteFile(path, buildMMDB(t, tt.ipVersion, tt.countries), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ConfigureGeoIP(path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ok, err := MatchRule{Type: GeoIPCountry, Value: tt.value}.Evaluate(&Request{RawRequest: &http.Request{RemoteAddr: tt.addr}})
+		if ok != tt.expect || err != nil {
+			t.Errorf("IPv%d database, %s in %q: expected %v, got %v, %v", tt.ipVersion, tt.addr, tt.value, tt.expect, ok, err)
+		}
+	}
+
+	path := filepath.Join(dir, "invalid.mmdb")
+	if err := ioutil.WriteFile(path, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ConfigureGeoIP(path); err == nil {
+		t.Error("expected an error for an invalid database")
+	}
+
+	// A node count whose search tree exceeds the file.
+	db := buildMMDB(t, 4, map[string]string{"1.2.0.0/16": "DE"})
+	i := bytes.Index(db, []byte("node_count")) + len("node_count") + 1
+	binary.BigEndian.PutUint32(db[i:], 1<<28)
+
+	path = filepath.Join(dir, "truncated.mmdb")
+	if err := ioutil.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ConfigureGeoIP(path); err == nil {
+		t.Error("expected an error for a search tree exceeding the database")
+	}
+
+	for value, ok := range map[string]bool{"DE": true, "de, FR": true, "": false, "DEU": false, "D1": false} {
+		if err := (&MatchRule{Type: GeoIPCountry, Value: value}).Validate(); (err == nil) != ok {
+			t.Errorf("%q: unexpected validation result %v", value, err)
+		}
+	}
+}
+
 var extractParameterTests = []struct {
 	s      string
 	params interface{}
@@ -264,12 +637,17 @@ var argumentGetTests = []struct {
 	{"payload", "a", nil, nil, map[string]interface{}{"a": "z"}, nil, "z", true},
 	{"request", "METHOD", nil, nil, map[string]interface{}{"a": "z"}, &http.Request{Method: "POST", RemoteAddr: "127.0.0.1:1234"}, "POST", true},
 	{"request", "remote-addr", nil, nil, map[string]interface{}{"a": "z"}, &http.Request{Method: "POST", RemoteAddr: "127.0.0.1:1234"}, "127.0.0.1:1234", true},
+	{"request", "url", nil, nil, nil, &http.Request{Host: "example.com", RequestURI: "/hooks/a?b=c", TLS: &tls.ConnectionState{Version: tls.VersionTLS13}}, "https://example.com/hooks/a?b=c", true},
+	{"request", "tls-version", nil, nil, nil, &http.Request{TLS: &tls.ConnectionState{Version: tls.VersionTLS13}}, "TLS 1.3", true},
+	{"request", "tls-version", nil, nil, nil, &http.Request{}, "", true},
+	{"request", "content-length", nil, nil, nil, &http.Request{ContentLength: 42}, "42", true},
 	{"string", "a", nil, nil, map[string]interface{}{"a": "z"}, nil, "a", true},
 	// failures
 	{"header", "a", nil, map[string]interface{}{"a": "z"}, map[string]interface{}{"a": "z"}, nil, "", false},  // nil headers
 	{"url", "a", map[string]interface{}{"A": "z"}, nil, map[string]interface{}{"a": "z"}, nil, "", false},     // nil query
 	{"payload", "a", map[string]interface{}{"A": "z"}, map[string]interface{}{"a": "z"}, nil, nil, "", false}, // nil payload
 	{"foo", "a", map[string]interface{}{"A": "z"}, nil, nil, nil, "", false},                                  // invalid source
+	{"request", "received", nil, nil, nil, &http.Request{}, "", false},                                        // no received time
 }
 
 func TestArgumentGet(t *testing.T) {
@@ -288,6 +666,45 @@ func TestArgumentGet(t *testing.T) {
 	}
 }
 
+func TestArgumentGetReceived(t *testing.T) {
+	a := Argument{Source: "request", Name: "received"}
+	r := &Request{RawRequest: &http.Request{}, Received: time.Date(2024, 5, 2, 10, 15, 4, 0, time.FixedZone("CEST", 2*3600))}
+
+	if value, err := a.Get(r); err != nil || value != "2024-05-02T08:15:04Z" {
+		t.Errorf("unexpected received time %q: %v", value, err)
+	}
+}
+
+func TestPayloadFile(t *testing.T) {
+	r := &Request{
+		Body:       []byte(`{"a": "z"}`),
+		Payload:    map[string]interface{}{"a": "z"},
+		Normalized: map[string]interface{}{"b": "z"},
+	}
+
+	for _, tt := range []struct {
+		def, env, data string
+	}{
+		{`"raw"`, "HOOK_PAYLOAD_FILE", `{"a": "z"}`},
+		{`{}`, "HOOK_PAYLOAD_FILE", `{"a":"z"}`},
+		{`{"format": "normalized", "envname": "NORMALIZED"}`, "NORMALIZED", `{"b":"z"}`},
+	} {
+		h := &Hook{}
+		if err := json.Unmarshal([]byte(`{"payload-file": `+tt.def+`}`), h); err != nil {
+			t.Fatalf("%s: %s", tt.def, err)
+		}
+
+		files, errs := h.ExtractCommandArgumentsForFile(r)
+		if len(errs) != 0 || len(files) != 1 || files[0].EnvName != tt.env || string(files[0].Data) != tt.data {
+			t.Errorf("%s: unexpected files %+v, errors %v", tt.def, files, errs)
+		}
+	}
+
+	if errs := (&PayloadFile{Format: "xml"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected an error for an unknown format, got %v", errs)
+	}
+}
+
 var hookParseJSONParametersTests = []struct {
 	params                     []Argument
 	headers, query, payload    map[string]interface{}
@@ -456,273 +873,3316 @@ func TestHooksTemplateLoadFromFile(t *testing.T) {
 		}
 
 		s := (*h.Match("webhook").TriggerRule.And)[0].Match.Secret
-		if s != secret {
+		if len(s) != 1 || s[0] != secret {
 			t.Errorf("Expected secret of %q, got %q", secret, s)
 		}
 	}
 }
 
-var hooksMatchTests = []struct {
-	id    string
-	hooks Hooks
-	value *Hook
+var hooksTemplateLoadOptionsTests = []struct {
+	desc    string
+	tmpl    string
+	options []LoadOption
+	ok      bool
 }{
-	{"a", Hooks{Hook{ID: "a"}}, &Hook{ID: "a"}},
-	{"X", Hooks{Hook{ID: "a"}}, new(Hook)},
+	{"missing key allowed by default", `[{"id": "a", "execute-command": "{{ .secret }}"}]`, nil, true},
+	{"unset env allowed by default", `[{"id": "a", "execute-command": "{{ getenv "XXXTEST_UNSET" }}"}]`, nil, true},
+	{"set env with missingkey=error", `[{"id": "a", "execute-command": "{{ getenv "XXXTEST_SECRET" | js }}"}]`, []LoadOption{TemplateMissingKeyOption("error")}, true},
+	{"allowed funcs", `[{"id": "a", "execute-command": "{{ getenv "XXXTEST_SECRET" | js }}"}]`, []LoadOption{TemplateFuncsOption([]string{"getenv", "js"})}, true},
+	{"within max size", `[{"id": "a"}]`, []LoadOption{TemplateMaxSizeOption(100)}, true},
+	{"unset env with default and missingkey=error", `[{"id": "a", "execute-command": "{{ getenv "XXXTEST_UNSET" "x" }}"}]`, []LoadOption{TemplateMissingKeyOption("error")}, true},
+	// failures
+	{"missing key with missingkey=error", `[{"id": "a", "execute-command": "{{ .secret }}"}]`, []LoadOption{TemplateMissingKeyOption("error")}, false},
+	{"unset env with missingkey=error", `[{"id": "a", "execute-command": "{{ getenv "XXXTEST_UNSET" }}"}]`, []LoadOption{TemplateMissingKeyOption("error")}, false},
+	{"disallowed func", `[{"id": "a", "execute-command": "{{ getenv "XXXTEST_SECRET" | js }}"}]`, []LoadOption{TemplateFuncsOption([]string{"getenv"})}, false},
+	{"disallowed func in branch", `[{"id": "a"{{ if true }}{{ else }}{{ printf "" }}{{ end }}}]`, []LoadOption{TemplateFuncsOption([]string{"getenv"})}, false},
+	{"exceeds max size", `[{"id": "a"}]`, []LoadOption{TemplateMaxSizeOption(5)}, false},
 }
 
-func TestHooksMatch(t *testing.T) {
-	for _, tt := range hooksMatchTests {
-		value := tt.hooks.Match(tt.id)
-		if reflect.DeepEqual(reflect.ValueOf(value), reflect.ValueOf(tt.value)) {
-			t.Errorf("failed to match %q:\nexpected %#v,\ngot %#v", tt.id, tt.value, value)
+func TestHooksTemplateLoadOptions(t *testing.T) {
+	os.Setenv("XXXTEST_SECRET", "secret")
+	os.Unsetenv("XXXTEST_UNSET")
+
+	for _, tt := range hooksTemplateLoadOptionsTests {
+		f, err := ioutil.TempFile("", "hooks-*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+
+		if _, err := f.WriteString(tt.tmpl); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		h := &Hooks{}
+		err = h.LoadFromFile(f.Name(), true, tt.options...)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok: %v, got err: %v", tt.desc, tt.ok, err)
 		}
 	}
 }
 
-var matchRuleTests = []struct {
-	typ, regex, secret, value, ipRange string
-	param                              Argument
-	headers, query, payload            map[string]interface{}
-	body                               []byte
-	remoteAddr                         string
-	ok                                 bool
-	err                                bool
-}{
-	{"value", "", "", "z", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", true, false},
-	{"regex", "^z", "", "z", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", true, false},
-	{"payload-hmac-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "b17e04cbb22afa8ffbff8796fc1894ed27badd9e"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
-	{"payload-hash-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "b17e04cbb22afa8ffbff8796fc1894ed27badd9e"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
-	{"payload-hmac-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "f417af3a21bd70379b5796d5f013915e7029f62c580fb0f500f59a35a6f04c89"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
-	{"payload-hash-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "f417af3a21bd70379b5796d5f013915e7029f62c580fb0f500f59a35a6f04c89"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
-	// failures
-	{"value", "", "", "X", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, false},
-	{"regex", "^X", "", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, false},
-	{"value", "", "2", "X", "", Argument{"header", "a", "", false}, map[string]interface{}{"Y": "z"}, nil, nil, []byte{}, "", false, true}, // reference invalid header
-	// errors
-	{"regex", "*", "", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, true},                   // invalid regex
-	{"payload-hmac-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true},   // invalid hmac
-	{"payload-hash-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true},   // invalid hmac
-	{"payload-hmac-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
-	{"payload-hash-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
-	{"payload-hmac-sha512", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
-	{"payload-hash-sha512", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
-	// IP whitelisting, valid cases
-	{"ip-whitelist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", true, false}, // valid IPv4, with range
-	{"ip-whitelist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", true, false}, // valid IPv4, with range
-	{"ip-whitelist", "", "", "", "192.168.0.1", Argument{}, nil, nil, nil, []byte{}, "192.168.0.1:9000", true, false},    // valid IPv4, no range
-	{"ip-whitelist", "", "", "", "::1/24", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", true, false},               // valid IPv6, with range
-	{"ip-whitelist", "", "", "", "::1", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", true, false},                  // valid IPv6, no range
-	// IP whitelisting, invalid cases
-	{"ip-whitelist", "", "", "", "192.168.0.1/a", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", false, true},  // invalid IPv4, with range
-	{"ip-whitelist", "", "", "", "192.168.0.a", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", false, true},    // invalid IPv4, no range
-	{"ip-whitelist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.0.a:9000", false, true}, // invalid IPv4 address
-	{"ip-whitelist", "", "", "", "::1/a", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", false, true},                // invalid IPv6, with range
-	{"ip-whitelist", "", "", "", "::z", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", false, true},                  // invalid IPv6, no range
-	{"ip-whitelist", "", "", "", "::1/24", Argument{}, nil, nil, nil, []byte{}, "[::z]:9000", false, true},               // invalid IPv6 address
-}
+func TestHooksTemplateValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-values-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 
-func TestMatchRule(t *testing.T) {
-	for i, tt := range matchRuleTests {
-		r := MatchRule{tt.typ, tt.regex, tt.secret, tt.value, tt.param, tt.ipRange}
-		req := &Request{
-			Headers: tt.headers,
-			Query:   tt.query,
-			Payload: tt.payload,
-			Body:    tt.body,
-			RawRequest: &http.Request{
-				RemoteAddr: tt.remoteAddr,
-			},
+	files := map[string]string{
+		"hooks.yaml":   "- id: deploy-{{ .env }}\n  execute-command: '{{ .deploy.command }}'\n  command-working-directory: '{{ .deploy.dir }}'\n",
+		"values.yaml":  "env: staging\ndeploy:\n  command: /usr/local/bin/deploy\n  dir: /srv/staging\n",
+		"prod.json":    `{"env": "prod", "deploy": {"dir": "/srv/prod"}}`,
+		"invalid.yaml": "env: [",
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
 		}
-		ok, err := r.Evaluate(req)
-		if ok != tt.ok || (err != nil) != tt.err {
-			t.Errorf("%d failed to match %#v:\nexpected ok: %#v, err: %v\ngot ok: %#v, err: %v", i, r, tt.ok, tt.err, ok, err)
+	}
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	h := &Hooks{}
+	if err := h.LoadFromFile(path("hooks.yaml"), true, TemplateValuesOption(path("values.yaml"), path("prod.json"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(*h) != 1 || (*h)[0].ID != "deploy-prod" || (*h)[0].ExecuteCommand != "/usr/local/bin/deploy" || (*h)[0].CommandWorkingDirectory != "/srv/prod" {
+		t.Errorf("unexpected hooks with merged values: %+v", *h)
+	}
+
+	for _, name := range []string{"invalid.yaml", "missing.yaml"} {
+		h := &Hooks{}
+		if err := h.LoadFromFile(path("hooks.yaml"), true, TemplateValuesOption(path(name))); err == nil {
+			t.Errorf("%s: expected an error", name)
 		}
 	}
 }
 
-var andRuleTests = []struct {
-	desc                    string // description of the test case
-	rule                    AndRule
-	headers, query, payload map[string]interface{}
-	body                    []byte
-	ok                      bool
-	err                     bool
-}{
-	{
-		"(a=z, b=y): a=z && b=y",
-		AndRule{
-			{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}},
-			{Match: &MatchRule{"value", "", "", "y", Argument{"header", "b", "", false}, ""}},
-		},
-		map[string]interface{}{"A": "z", "B": "y"}, nil, nil,
-		[]byte{},
-		true, false,
-	},
-	{
-		"(a=z, b=Y): a=z && b=y",
-		AndRule{
-			{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}},
-			{Match: &MatchRule{"value", "", "", "y", Argument{"header", "b", "", false}, ""}},
-		},
-		map[string]interface{}{"A": "z", "B": "Y"}, nil, nil,
-		[]byte{},
-		false, false,
-	},
-	// Complex test to cover Rules.Evaluate
-	{
-		"(a=z, b=y, c=x, d=w=, e=X, f=X): a=z && (b=y && c=x) && (d=w || e=v) && !f=u",
-		AndRule{
-			{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}},
-			{
-				And: &AndRule{
-					{Match: &MatchRule{"value", "", "", "y", Argument{"header", "b", "", false}, ""}},
-					{Match: &MatchRule{"value", "", "", "x", Argument{"header", "c", "", false}, ""}},
-				},
-			},
-			{
-				Or: &OrRule{
-					{Match: &MatchRule{"value", "", "", "w", Argument{"header", "d", "", false}, ""}},
-					{Match: &MatchRule{"value", "", "", "v", Argument{"header", "e", "", false}, ""}},
-				},
-			},
-			{
-				Not: &NotRule{
-					Match: &MatchRule{"value", "", "", "u", Argument{"header", "f", "", false}, ""},
-				},
-			},
-		},
-		map[string]interface{}{"A": "z", "B": "y", "C": "x", "D": "w", "E": "X", "F": "X"}, nil, nil,
-		[]byte{},
-		true, false,
-	},
-	{"empty rule", AndRule{{}}, nil, nil, nil, nil, false, false},
-	// failures
-	{
-		"invalid rule",
-		AndRule{{Match: &MatchRule{"value", "", "", "X", Argument{"header", "a", "", false}, ""}}},
-		map[string]interface{}{"Y": "z"}, nil, nil, nil,
-		false, true,
-	},
-}
+var hooksFileFormatsTests = map[string]string{
+	"hooks.yaml": `
+- id: deploy
+  execute-command: /srv/deploy.sh
+  http-methods: [POST]
+  success-http-response-code: 202
+  response-headers:
+  - name: X-Deployed
+    value: "yes"
+  pass-arguments-to-command:
+  - source: payload
+    name: ref
+  trigger-rule:
+    and:
+    - match:
+        type: value
+        value: "refs/heads/main"
+        parameter:
+          source: payload
+          name: ref
+    - match:
+        type: payload-hmac-sha256
+        secret: s3cr3t
+        parameter:
+          source: header
+          name: X-Signature
+- group: ops
+  url-prefix: ops
+  hooks:
+  - id: restart
+    execute-command: "/bin/echo \"restart\"\n"
+`,
+	"hooks.toml": `
+# Deploys the main branch.
+[[hooks]]
+id = "deploy"
+execute-command = '/srv/deploy.sh'
+http-methods = ["POST"]
+success-http-response-code = 202
+response-headers = [{ name = "X-Deployed", value = "yes" }]
+pass-arguments-to-command = [
+  { source = "payload", name = "ref" }, # the pushed ref
+]
 
-func TestAndRule(t *testing.T) {
-	for _, tt := range andRuleTests {
-		r := &Request{
+[[hooks.trigger-rule.and]]
+match = { type = "value", value = "refs/heads/main", parameter = { source = "payload", name = "ref" } }
+
+[[hooks.trigger-rule.and]]
+[hooks.trigger-rule.and.match]
+type = "payload-hmac-sha256"
+secret = "s3cr3t"
+parameter.source = "header"
+parameter.name = "X-Signature"
+
+[[hooks]]
+group = "ops"
+url-prefix = "ops"
+
+[[hooks.hooks]]
+id = "restart"
+execute-command = """
+/bin/echo "restart"
+"""
+`,
+	"hooks.hcl": `
+// Deploys the main branch.
+hook "deploy" {
+  execute-command            = "/srv/deploy.sh"
+  http-methods               = ["POST"]
+  success-http-response-code = 202
+
+  response-headers {
+    name  = "X-Deployed"
+    value = "yes"
+  }
+
+  pass-arguments-to-command {
+    source = "payload"
+    name   = "ref"
+  }
+
+  trigger-rule {
+    and = [
+      { match = { type = "value", value = "refs/heads/main", parameter = { source = "payload", name = "ref" } } },
+      {
+        match = {
+          type      = "payload-hmac-sha256"
+          secret    = "s3cr3t"
+          parameter = { source = "header", name = "X-Signature" }
+        }
+      },
+    ]
+  }
+}
+
+/* Operations hooks. */
+group "ops" {
+  url-prefix = "ops"
+
+  hook "restart" {
+    execute-command = <<-EOT
+    /bin/echo "restart"
+    EOT
+  }
+}
+`,
+}
+
+func TestHooksFileFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-formats-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	loaded := make(map[string]Hooks)
+
+	for name, content := range hooksFileFormatsTests {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		h := &Hooks{}
+		if err := h.LoadFromFile(path, false, StrictOption()); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		loaded[name] = *h
+	}
+
+	want := loaded["hooks.yaml"]
+	if len(want) != 2 || want[1].ID != "ops/restart" {
+		t.Fatalf("unexpected hooks from YAML: %+v", want)
+	}
+
+	for _, name := range []string{"hooks.toml", "hooks.hcl"} {
+		if !reflect.DeepEqual(loaded[name], want) {
+			t.Errorf("%s: expected %+v, got %+v", name, want, loaded[name])
+		}
+	}
+
+	for name, content := range map[string]string{
+		"unknown.toml":   "[[hook]]\nid = \"a\"\n",
+		"duplicate.toml": "[[hooks]]\nid = \"a\"\nid = \"b\"\n",
+		"invalid.toml":   "[[hooks]]\nid = \"a\n",
+		"invalid.hcl":    "hook \"a\" {\n  execute-command = var.command\n}\n",
+		"unclosed.hcl":   "hook \"a\" {\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		h := &Hooks{}
+		if err := h.LoadFromFile(path, false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHooksFileIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-includes-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"hooks.yaml": `
+- id: api
+  include: shared/deploy.yaml
+  execute-command: /srv/api/deploy.sh
+  pass-environment-to-command:
+  - source: string
+    envname: APP
+    name: api
+  - include: shared/env.yaml
+- id: web
+  include: [shared/deploy.yaml, web.toml]
+`,
+		"shared/deploy.yaml": `
+http-methods: [POST]
+response-headers:
+  include: headers.json
+trigger-rule:
+  include: rules.hcl
+`,
+		"shared/headers.json": `[{"name": "X-Deployed", "value": "yes"}]`,
+		"shared/rules.hcl": `
+match {
+  type   = "value"
+  value  = "refs/heads/main"
+  parameter = { source = "payload", name = "ref" }
+}
+`,
+		"shared/env.yaml": `
+- source: payload
+  envname: REF
+  name: ref
+`,
+		"web.toml": `
+execute-command = "/srv/web/deploy.sh"
+http-methods = ["PUT"]
+`,
+		"cycle.yaml":   `[{"id": "a", "include": "cycle.yaml"}]`,
+		"mixed.yaml":   `[{"id": "a", "response-headers": {"include": "shared/headers.json", "name": "X"}}]`,
+		"missing.yaml": `[{"id": "a", "include": "missing.yaml"}]`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &Hooks{}
+	if err := h.LoadFromFile(filepath.Join(dir, "hooks.yaml"), false, StrictOption()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rule := &Rules{Match: &MatchRule{Type: "value", Value: "refs/heads/main", Parameter: Argument{Source: "payload", Name: "ref"}}}
+	headers := ResponseHeaders{{Name: "X-Deployed", Value: "yes"}}
+
+	api := h.Match("api")
+	if api == nil || api.ExecuteCommand != "/srv/api/deploy.sh" || !reflect.DeepEqual(api.TriggerRule, rule) || !reflect.DeepEqual(api.ResponseHeaders, headers) {
+		t.Errorf("unexpected api hook: %+v", api)
+	}
+
+	env := []Argument{{Source: "string", EnvName: "APP", Name: "api"}, {Source: "payload", EnvName: "REF", Name: "ref"}}
+	if api != nil && !reflect.DeepEqual(api.PassEnvironmentToCommand, env) {
+		t.Errorf("expected environment %+v, got %+v", env, api.PassEnvironmentToCommand)
+	}
+
+	web := h.Match("web")
+	if web == nil || web.ExecuteCommand != "/srv/web/deploy.sh" || !reflect.DeepEqual(web.HTTPMethods, []string{"PUT"}) || !reflect.DeepEqual(web.TriggerRule, rule) {
+		t.Errorf("unexpected web hook: %+v", web)
+	}
+
+	for _, name := range []string{"cycle.yaml", "mixed.yaml", "missing.yaml"} {
+		h := &Hooks{}
+		if err := h.LoadFromFile(filepath.Join(dir, name), false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHooksFileDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-defaults-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"hooks.yaml": `
+defaults:
+  command-working-directory: /srv
+  success-http-response-code: 202
+  allowed-callers: [ci]
+  pass-environment-to-command:
+  - source: string
+    envname: STAGE
+    name: production
+hooks:
+- id: api
+  execute-command: /srv/api/deploy.sh
+- id: web
+  execute-command: /srv/web/deploy.sh
+  success-http-response-code: 200
+- group: ops
+  url-prefix: ops
+  allowed-callers: [ops]
+  hooks:
+  - id: restart
+    execute-command: /srv/restart.sh
+`,
+		"hooks.toml": `
+[defaults]
+command-working-directory = "/srv"
+
+[[hooks]]
+id = "api"
+execute-command = "/srv/api/deploy.sh"
+`,
+		"id.yaml":      "defaults: {id: a}\nhooks: []\n",
+		"unknown.yaml": "default: {}\nhooks: []\n",
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &Hooks{}
+	if err := h.LoadFromFile(filepath.Join(dir, "hooks.yaml"), false, StrictOption()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	env := []Argument{{Source: "string", EnvName: "STAGE", Name: "production"}}
+
+	for _, tt := range []struct {
+		id      string
+		code    int
+		callers []string
+	}{
+		{"api", 202, []string{"ci"}},
+		{"web", 200, []string{"ci"}},
+		{"ops/restart", 202, []string{"ops"}},
+	} {
+		hook := h.Match(tt.id)
+		if hook == nil {
+			t.Errorf("%s: hook not found", tt.id)
+			continue
+		}
+
+		if hook.CommandWorkingDirectory != "/srv" || hook.SuccessHttpResponseCode != tt.code || !reflect.DeepEqual(hook.AllowedCallers, tt.callers) || !reflect.DeepEqual(hook.PassEnvironmentToCommand, env) {
+			t.Errorf("%s: unexpected hook with defaults: %+v", tt.id, hook)
+		}
+	}
+
+	h = &Hooks{}
+	if err := h.LoadFromFile(filepath.Join(dir, "hooks.toml"), false, StrictOption()); err != nil || h.Match("api") == nil || h.Match("api").CommandWorkingDirectory != "/srv" {
+		t.Errorf("unexpected TOML hooks with defaults: %+v (err: %v)", h, err)
+	}
+
+	for _, name := range []string{"id.yaml", "unknown.yaml"} {
+		h := &Hooks{}
+		if err := h.LoadFromFile(filepath.Join(dir, name), false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestHooksFileSOPS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake sops command is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "hooks-sops-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	encrypted := `hooks:
+- id: ENC[AES256_GCM,data:ZGVwbG95,type:str]
+  execute-command: ENC[AES256_GCM,data:L3Nydi9kZXBsb3kuc2g=,type:str]
+sops:
+  age:
+  - recipient: age1example
+  mac: ENC[AES256_GCM,data:bWFj,type:str]
+  version: 3.7.3
+`
+
+	// The fake sops command checks its arguments and prints the plaintext.
+	script := `#!/bin/sh
+[ "$1 $2 $3 $4 $5" = "--decrypt --input-type yaml --output-type json" ] || exit 2
+grep -q 'sops:' "$6" || exit 3
+echo '{"hooks": [{"id": "deploy", "execute-command": "/srv/deploy.sh"}]}'
+`
+
+	files := map[string]string{
+		"hooks.yaml": encrypted,
+		"sops":       script,
+		"broken":     "#!/bin/sh\necho 'no key' >&2\nexit 128\n",
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		file string
+		want bool
+	}{
+		{encrypted, true},
+		{"hooks: []\nsops: {version: 3.7.3}\n", false},
+		{"- id: sops\n", false},
+		{`{"hooks": [], "sops": {"mac": "ENC[]"}}`, true},
+	} {
+		if got := isSOPSEncrypted([]byte(tt.file)); got != tt.want {
+			t.Errorf("isSOPSEncrypted(%q) = %t, want %t", tt.file, got, tt.want)
+		}
+	}
+
+	h := &Hooks{}
+	if err := h.LoadFromFile(filepath.Join(dir, "hooks.yaml"), false, SOPSCommandOption(filepath.Join(dir, "sops"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if hook := h.Match("deploy"); hook == nil || hook.ExecuteCommand != "/srv/deploy.sh" {
+		t.Errorf("unexpected decrypted hooks: %+v", h)
+	}
+
+	h = &Hooks{}
+	if err := h.LoadFromFile(filepath.Join(dir, "hooks.yaml"), false, SOPSCommandOption(filepath.Join(dir, "broken"))); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Errorf("expected the sops error, got %v", err)
+	}
+}
+
+func TestHooksFileSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-schema-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"hooks.yaml": `# Deployments
+- id: deploy
+  execute-command: /srv/deploy.sh
+  http-methods: POST
+  trigger-rule:
+    match:
+      type: value
+      value: main
+      parameter: {source: payload, name: ref, envname: [REF]}
+- id: "web"
+  execute-comand: /srv/web.sh
+  success-http-response-code: "202"
+`,
+		"hooks.json": `{
+  "hooks": [
+    {
+      "id": "a",
+      "pass-arguments-to-command": [{"source": "string", "name": 1}, {"source": "string", "name": {}}]
+    }
+  ]
+}
+`,
+		"hooks.yaml.tmpl": "- id: a\n  http-methods: {{ \"POST\" }}\n",
+		"shorthand.yaml": `
+- id: a
+  payload-file: [raw]
+- id: b
+  payload-file: {format: raw, envname: [PAYLOAD]}
+  response-message: null
+`,
+	}
+
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		file     string
+		template bool
+		options  []LoadOption
+		want     []SchemaProblem
+	}{
+		{"hooks.yaml", false, nil, []SchemaProblem{
+			{4, 3, "[0].http-methods", "expected array, got string"},
+			{9, 47, "[0].trigger-rule.match.parameter.envname", "expected string, got array"},
+			{12, 3, "[1].success-http-response-code", "expected integer, got string"},
+		}},
+		{"hooks.yaml", false, []LoadOption{StrictOption()}, []SchemaProblem{
+			{4, 3, "[0].http-methods", "expected array, got string"},
+			{9, 47, "[0].trigger-rule.match.parameter.envname", "expected string, got array"},
+			{11, 3, "[1].execute-comand", "unknown field"},
+			{12, 3, "[1].success-http-response-code", "expected integer, got string"},
+		}},
+		{"hooks.json", false, nil, []SchemaProblem{
+			{5, 91, "hooks[0].pass-arguments-to-command[1].name", "expected string, got object"},
+		}},
+		{"hooks.yaml.tmpl", true, nil, []SchemaProblem{
+			{0, 0, "[0].http-methods", "expected array, got string"},
+		}},
+		{"shorthand.yaml", false, nil, []SchemaProblem{
+			{3, 3, "[0].payload-file", "expected string or object, got array"},
+			{5, 31, "[1].payload-file.envname", "expected string, got array"},
+		}},
+	} {
+		h := &Hooks{}
+
+		err := h.LoadFromFile(filepath.Join(dir, tt.file), tt.template, tt.options...)
+
+		serr, ok := err.(*SchemaError)
+		if !ok {
+			t.Errorf("%s: expected a schema error, got %v", tt.file, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(serr.Problems, tt.want) {
+			t.Errorf("%s: expected problems %+v, got %+v", tt.file, tt.want, serr.Problems)
+		}
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(Schema(), &schema); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+
+	if defs, _ := schema["definitions"].(map[string]interface{}); defs["Hook"] == nil || defs["Group"] == nil || defs["Rules"] == nil {
+		t.Errorf("expected definitions of hooks, groups and rules, got %v", defs)
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	src := `%YAML 1.1
+---
+hooks:
+- &deploy
+  id: deploy
+  response-message: |
+    Deploying:
+      the app
+  "quoted key": 'it''s'
+  command:
+    multi-line
+    plain
+  list:
+  - [a, {b: c}]
+  -
+    nested: true # comment
+- id: other # comment: here
+defaults: {"a": 1}
+`
+
+	want := map[string]position{
+		"hooks":                     {3, 1},
+		"hooks[0]":                  {4, 3},
+		"hooks[0].id":               {5, 3},
+		"hooks[0].response-message": {6, 3},
+		"hooks[0].quoted key":       {9, 3},
+		"hooks[0].command":          {10, 3},
+		"hooks[0].list":             {13, 3},
+		"hooks[0].list[0]":          {14, 5},
+		"hooks[0].list[0][0]":       {14, 6},
+		"hooks[0].list[0][1]":       {14, 9},
+		"hooks[0].list[0][1].b":     {14, 10},
+		"hooks[0].list[1]":          {15, 3},
+		"hooks[0].list[1].nested":   {16, 5},
+		"hooks[1]":                  {17, 3},
+		"hooks[1].id":               {17, 3},
+		"defaults":                  {18, 1},
+		"defaults.a":                {18, 12},
+	}
+
+	if got := nodePositions([]byte(src)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected positions %v, got %v", want, got)
+	}
+}
+
+var sprigFuncsTests = []struct {
+	tmpl, want string
+}{
+	{`{{ getenv "XXXTEST_UNSET" | default "main" | quote }}`, `"main"`},
+	{`{{ "  x  " | trim | upper }}`, `X`},
+	{`{{ "a,b" | splitList "," | join "+" }}`, `a+b`},
+	{`{{ (split "/" "refs/heads/main")._2 }}`, `main`},
+	{`{{ "secret" | b64enc }} {{ "c2VjcmV0" | b64dec }}`, `c2VjcmV0 secret`},
+	{`{{ dict "a" 1 | toJson }}`, `{"a":1}`},
+	{`{{ list "x" "y" | has "y" }} {{ empty "" }} {{ coalesce "" "z" }}`, `true true z`},
+	{`{{ ternary "prod" "staging" true }}`, `prod`},
+	{`{{ "refs/heads/main" | trimPrefix "refs/heads/" | replace "main" "master" }}`, `master`},
+	{`{{ "a\nb" | nindent 2 }}`, "\n  a\n  b"},
+	{`{{ regexReplaceAll "[^a-z]" "a-b_c" "" }}`, `abc`},
+	{`{{ "v1.2.3" | trimPrefix "v" | semverCompare ">=1.2" }}`, `true`},
+	{`{{ list 3 1 2 | sortAlpha | join "," }}`, `1,2,3`},
+}
+
+func TestSprigFuncs(t *testing.T) {
+	os.Unsetenv("XXXTEST_UNSET")
+
+	for _, tt := range sprigFuncsTests {
+		got, err := executeTemplate([]byte(tt.tmpl), "hooks.json", newLoadOptions(), 0)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: expected %q, got %q (err: %v)", tt.tmpl, tt.want, got, err)
+		}
+	}
+
+	if _, err := executeTemplate([]byte(`{{ required "secret is required" "" }}`), "hooks.json", newLoadOptions(), 0); err == nil {
+		t.Error("expected an error for an empty required value")
+	}
+}
+
+func TestHooksTemplateInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-include-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Unsetenv("XXXTEST_UNSET")
+
+	files := map[string]string{
+		"hooks.yaml": `- id: a
+  execute-command: '{{ getenv "XXXTEST_UNSET" "/bin/true" }}'
+  trigger-rule:
+{{ include "partials/rule.yaml" }}
+`,
+		"partials/rule.yaml": `    match:
+      type: value
+      value: '{{ include "value.txt" }}'
+      parameter:
+        source: header
+        name: X-Token`,
+		"partials/value.txt": `secret`,
+		"cycle.yaml":         `{{ include "cycle.yaml" }}`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &Hooks{}
+	if err := h.LoadFromFile(filepath.Join(dir, "hooks.yaml"), true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	a := h.Match("a")
+	if a == nil || a.ExecuteCommand != "/bin/true" || a.TriggerRule == nil || a.TriggerRule.Match == nil || a.TriggerRule.Match.Value != "secret" {
+		t.Errorf("unexpected hook: %#v", a)
+	}
+
+	if err := h.LoadFromFile(filepath.Join(dir, "cycle.yaml"), true); err == nil {
+		t.Error("expected error for include cycle")
+	}
+}
+
+var hooksMatchTests = []struct {
+	id    string
+	hooks Hooks
+	value *Hook
+}{
+	{"a", Hooks{Hook{ID: "a"}}, &Hook{ID: "a"}},
+	{"X", Hooks{Hook{ID: "a"}}, new(Hook)},
+}
+
+func TestHooksMatch(t *testing.T) {
+	for _, tt := range hooksMatchTests {
+		value := tt.hooks.Match(tt.id)
+		if reflect.DeepEqual(reflect.ValueOf(value), reflect.ValueOf(tt.value)) {
+			t.Errorf("failed to match %q:\nexpected %#v,\ngot %#v", tt.id, tt.value, value)
+		}
+	}
+}
+
+var matchRuleTests = []struct {
+	typ, regex, secret, value, ipRange string
+	param                              Argument
+	headers, query, payload            map[string]interface{}
+	body                               []byte
+	remoteAddr                         string
+	ok                                 bool
+	err                                bool
+}{
+	{"value", "", "", "z", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", true, false},
+	{"regex", "^z", "", "z", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", true, false},
+	{"payload-hmac-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "b17e04cbb22afa8ffbff8796fc1894ed27badd9e"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
+	{"payload-hash-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "b17e04cbb22afa8ffbff8796fc1894ed27badd9e"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
+	{"payload-hmac-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "f417af3a21bd70379b5796d5f013915e7029f62c580fb0f500f59a35a6f04c89"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
+	{"payload-hash-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "f417af3a21bd70379b5796d5f013915e7029f62c580fb0f500f59a35a6f04c89"}, nil, nil, []byte(`{"a": "z"}`), "", true, false},
+	{"gt", "", "", "1000", "", Argument{"payload", "size", "", false}, nil, nil, map[string]interface{}{"size": "1000001"}, []byte{}, "", true, false},
+	{"lt", "", "", "10", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "-2.5"}, nil, nil, []byte{}, "", true, false},
+	{"gte", "", "", "1", "", Argument{"payload", "commits", "", false}, nil, nil, map[string]interface{}{"commits": []interface{}{"a"}}, []byte{}, "", true, false},
+	{"lte", "", "", "1", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "1"}, nil, nil, []byte{}, "", true, false},
+	{"between", "", "", "1,10", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "10"}, nil, nil, []byte{}, "", true, false},
+	{"glob", "", "", "refs/tags/v*", "", Argument{"payload", "ref", "", false}, nil, nil, map[string]interface{}{"ref": "refs/tags/v1.2.0"}, []byte{}, "", true, false},
+	{"glob", "", "", "release/*", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "release/2.x"}, nil, nil, []byte{}, "", true, false},
+	// failures
+	{"glob", "", "", "refs/tags/v*", "", Argument{"payload", "ref", "", false}, nil, nil, map[string]interface{}{"ref": "refs/heads/v1"}, []byte{}, "", false, false},
+	{"glob", "", "", "release/*", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "release/2.x/hotfix"}, nil, nil, []byte{}, "", false, false},
+	{"gt", "", "", "1000", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "1000"}, nil, nil, []byte{}, "", false, false},
+	{"gte", "", "", "1", "", Argument{"payload", "commits", "", false}, nil, nil, map[string]interface{}{"commits": []interface{}{}}, []byte{}, "", false, false},
+	{"between", "", "", "1,10", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "0.5"}, nil, nil, []byte{}, "", false, false},
+	{"value", "", "", "X", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, false},
+	{"regex", "^X", "", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, false},
+	{"value", "", "2", "X", "", Argument{"header", "a", "", false}, map[string]interface{}{"Y": "z"}, nil, nil, []byte{}, "", false, true}, // reference invalid header
+	// errors
+	{"glob", "", "", "[", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, true},                    // invalid pattern
+	{"gt", "", "", "1", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, true},                      // not a number
+	{"gt", "", "", "z", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "1"}, nil, nil, []byte{}, "", false, true},                      // invalid value
+	{"between", "", "", "1", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "1"}, nil, nil, []byte{}, "", false, true},                 // invalid bounds
+	{"regex", "*", "", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, "", false, true},                   // invalid regex
+	{"payload-hmac-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true},   // invalid hmac
+	{"payload-hash-sha1", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true},   // invalid hmac
+	{"payload-hmac-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
+	{"payload-hash-sha256", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
+	{"payload-hmac-sha512", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
+	{"payload-hash-sha512", "", "secret", "", "", Argument{"header", "a", "", false}, map[string]interface{}{"A": ""}, nil, nil, []byte{}, "", false, true}, // invalid hmac
+	// IP whitelisting, valid cases
+	{"ip-whitelist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", true, false}, // valid IPv4, with range
+	{"ip-whitelist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", true, false}, // valid IPv4, with range
+	{"ip-whitelist", "", "", "", "192.168.0.1", Argument{}, nil, nil, nil, []byte{}, "192.168.0.1:9000", true, false},    // valid IPv4, no range
+	{"ip-whitelist", "", "", "", "::1/24", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", true, false},               // valid IPv6, with range
+	{"ip-whitelist", "", "", "", "::1", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", true, false},                  // valid IPv6, no range
+	// IP whitelisting, invalid cases
+	{"ip-whitelist", "", "", "", "192.168.0.1/a", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", false, true},  // invalid IPv4, with range
+	{"ip-whitelist", "", "", "", "192.168.0.a", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", false, true},    // invalid IPv4, no range
+	{"ip-whitelist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.0.a:9000", false, true}, // invalid IPv4 address
+	{"ip-whitelist", "", "", "", "::1/a", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", false, true},                // invalid IPv6, with range
+	{"ip-whitelist", "", "", "", "::z", Argument{}, nil, nil, nil, []byte{}, "[::1]:9000", false, true},                  // invalid IPv6, no range
+	{"ip-whitelist", "", "", "", "::1/24", Argument{}, nil, nil, nil, []byte{}, "[::z]:9000", false, true},               // invalid IPv6 address
+	// IP blacklisting
+	{"ip-blacklist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.1.2:9000", true, false},  // outside range
+	{"ip-blacklist", "", "", "", "192.168.0.1/24", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", false, false}, // inside range
+	{"ip-blacklist", "", "", "", "192.168.0.a", Argument{}, nil, nil, nil, []byte{}, "192.168.0.2:9000", false, true},     // invalid range
+}
+
+func TestMatchRule(t *testing.T) {
+	for i, tt := range matchRuleTests {
+		r := MatchRule{Type: tt.typ, Regex: tt.regex, Secret: Secrets{tt.secret}, Value: tt.value, Parameter: tt.param, IPRange: tt.ipRange}
+		req := &Request{
 			Headers: tt.headers,
 			Query:   tt.query,
 			Payload: tt.payload,
 			Body:    tt.body,
+			RawRequest: &http.Request{
+				RemoteAddr: tt.remoteAddr,
+			},
+		}
+		ok, err := r.Evaluate(req)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%d failed to match %#v:\nexpected ok: %#v, err: %v\ngot ok: %#v, err: %v", i, r, tt.ok, tt.err, ok, err)
+		}
+	}
+}
+
+func TestMatchRuleCompareToEnv(t *testing.T) {
+	os.Setenv("WEBHOOK_TEST_TOKEN", "s3cr3t")
+	defer os.Unsetenv("WEBHOOK_TEST_TOKEN")
+	os.Setenv("WEBHOOK_TEST_REF", "^refs/heads/(main|release)$")
+	defer os.Unsetenv("WEBHOOK_TEST_REF")
+
+	for _, tt := range []struct {
+		rule    MatchRule
+		payload map[string]interface{}
+		ok, err bool
+	}{
+		{MatchRule{Type: MatchValue, CompareToEnv: "WEBHOOK_TEST_TOKEN", Parameter: Argument{"payload", "token", "", false}}, map[string]interface{}{"token": "s3cr3t"}, true, false},
+		{MatchRule{Type: MatchValue, CompareToEnv: "WEBHOOK_TEST_TOKEN", Parameter: Argument{"payload", "token", "", false}}, map[string]interface{}{"token": "guess"}, false, false},
+		{MatchRule{Type: MatchRegex, CompareToEnv: "WEBHOOK_TEST_REF", Parameter: Argument{"payload", "ref", "", false}}, map[string]interface{}{"ref": "refs/heads/main"}, true, false},
+		{MatchRule{Type: MatchRegex, CompareToEnv: "WEBHOOK_TEST_REF", Parameter: Argument{"payload", "ref", "", false}}, map[string]interface{}{"ref": "refs/heads/dev"}, false, false},
+		// unset variables don't match empty parameters
+		{MatchRule{Type: MatchValue, CompareToEnv: "WEBHOOK_TEST_UNSET", Parameter: Argument{"payload", "token", "", false}}, map[string]interface{}{"token": ""}, false, true},
+	} {
+		ok, err := tt.rule.Evaluate(&Request{Payload: tt.payload})
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%#v: expected ok: %v, err: %v, got ok: %v, err: %v", tt.rule, tt.ok, tt.err, ok, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		rule MatchRule
+		ok   bool
+	}{
+		{MatchRule{Type: MatchValue, CompareToEnv: "TOKEN", Parameter: Argument{Source: "header", Name: "X-Token"}}, true},
+		{MatchRule{Type: MatchRegex, CompareToEnv: "REF", Parameter: Argument{Source: "payload", Name: "ref"}}, true},
+		{MatchRule{Type: MatchValue, CompareToEnv: "TOKEN", Value: "x", Parameter: Argument{Source: "header", Name: "X-Token"}}, false},
+		{MatchRule{Type: MatchRegex, CompareToEnv: "REF", Regex: ".*", Parameter: Argument{Source: "payload", Name: "ref"}}, false},
+		{MatchRule{Type: MatchGlob, CompareToEnv: "REF", Parameter: Argument{Source: "payload", Name: "ref"}}, false},
+	} {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%#v: unexpected validation result %v", tt.rule, err)
+		}
+	}
+}
+
+func TestMatchRuleCompare(t *testing.T) {
+	req := &Request{
+		Payload: map[string]interface{}{"repository": map[string]interface{}{"full_name": "acme/site"}, "empty": ""},
+		Query:   map[string]interface{}{"repo": "acme/site", "owner": "acme", "other": "acme/api"},
+	}
+
+	repo := Argument{Source: "payload", Name: "repository.full_name"}
+
+	for _, tt := range []struct {
+		compareTo Argument
+		operator  string
+		ok, err   bool
+	}{
+		{Argument{Source: "url", Name: "repo"}, "", true, false},
+		{Argument{Source: "url", Name: "repo"}, CompareEquals, true, false},
+		{Argument{Source: "url", Name: "other"}, CompareEquals, false, false},
+		{Argument{Source: "url", Name: "other"}, CompareNotEquals, true, false},
+		{Argument{Source: "url", Name: "repo"}, CompareNotEquals, false, false},
+		{Argument{Source: "url", Name: "owner"}, CompareContains, true, false},
+		{Argument{Source: "url", Name: "other"}, CompareContains, false, false},
+		{Argument{Source: "payload", Name: "empty"}, CompareContains, false, false},
+		// errors
+		{Argument{Source: "url", Name: "missing"}, CompareEquals, false, true},
+		{Argument{Source: "url", Name: "repo"}, "matches", false, true},
+	} {
+		r := MatchRule{Type: MatchCompare, Parameter: repo, CompareTo: tt.compareTo, Operator: tt.operator}
+
+		ok, err := r.Evaluate(req)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s %s: expected ok: %v, err: %v, got ok: %v, err: %v", tt.operator, tt.compareTo.Name, tt.ok, tt.err, ok, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		rule MatchRule
+		ok   bool
+	}{
+		{MatchRule{Type: MatchCompare, Parameter: repo, CompareTo: Argument{Source: "url", Name: "repo"}}, true},
+		{MatchRule{Type: MatchCompare, Parameter: repo, CompareTo: Argument{Source: "url", Name: "repo"}, Operator: CompareContains}, true},
+		{MatchRule{Type: MatchCompare, Parameter: repo}, false},
+		{MatchRule{Type: MatchCompare, CompareTo: Argument{Source: "url", Name: "repo"}}, false},
+		{MatchRule{Type: MatchCompare, Parameter: repo, CompareTo: Argument{Source: "url", Name: "repo"}, Operator: "matches"}, false},
+	} {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%#v: unexpected validation result %v", tt.rule, err)
+		}
+	}
+}
+
+func TestMatchRuleCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the validator is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "match-command-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The validator accepts payloads of the repository given as its
+	// argument, from the branch given in $BRANCH.
+	validator := filepath.Join(dir, "validate.sh")
+	script := `#!/bin/sh
+[ "$1" = "sleep" ] && exec sleep 5
+grep -q "\"repository\": \"$1\"" && [ "$BRANCH" = "main" ]
+`
+	if err := ioutil.WriteFile(validator, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := []Argument{{Source: "url", Name: "repo"}}
+	branch := []Argument{{Source: "url", Name: "branch", EnvName: "BRANCH"}}
+
+	for _, tt := range []struct {
+		desc    string
+		rule    MatchRule
+		ok, err bool
+	}{
+		{"match", MatchRule{Type: MatchCommand, Command: validator, PassArgumentsToCommand: repo, PassEnvironmentToCommand: branch}, true, false},
+		{"exit status", MatchRule{Type: MatchCommand, Command: validator, PassArgumentsToCommand: []Argument{{Source: "string", Name: "acme/api"}}, PassEnvironmentToCommand: branch}, false, false},
+		{"missing argument", MatchRule{Type: MatchCommand, Command: validator, PassArgumentsToCommand: []Argument{{Source: "url", Name: "missing"}}}, false, true},
+		{"timeout", MatchRule{Type: MatchCommand, Command: validator, PassArgumentsToCommand: []Argument{{Source: "string", Name: "sleep"}}, Timeout: "100ms"}, false, true},
+		{"missing command", MatchRule{Type: MatchCommand, Command: filepath.Join(dir, "missing")}, false, true},
+	} {
+		req := &Request{
+			Body:  []byte(`{"repository": "acme/site"}`),
+			Query: map[string]interface{}{"repo": "acme/site", "branch": "main"},
+		}
+
+		ok, err := tt.rule.Evaluate(req)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: expected ok: %v, err: %v, got ok: %v, err: %v", tt.desc, tt.ok, tt.err, ok, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		rule MatchRule
+		ok   bool
+	}{
+		{MatchRule{Type: MatchCommand, Command: validator}, true},
+		{MatchRule{Type: MatchCommand, Command: validator, Timeout: "30s"}, true},
+		{MatchRule{Type: MatchCommand}, false},
+		{MatchRule{Type: MatchCommand, Command: validator, Timeout: "0s"}, false},
+	} {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%#v: unexpected validation result %v", tt.rule, err)
+		}
+	}
+}
+
+func TestMatchRuleHTTPAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input struct {
+				Method  string                 `json:"method"`
+				Path    string                 `json:"path"`
+				Payload map[string]interface{} `json:"payload"`
+			} `json:"input"`
+		}
+
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil || body.Input.Method != "POST" || body.Input.Path != "/hooks/deploy" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch body.Input.Payload["answer"] {
+		case "allow":
+			w.Write([]byte(`{"allow": true}`))
+		case "opa":
+			w.Write([]byte(`{"result": true}`))
+		case "opa-deny":
+			w.Write([]byte(`{"result": {"allow": false}}`))
+		case "empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "undecided":
+			w.Write([]byte(`{}`))
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"allow": true}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		answer   string
+		timeout  string
+		failOpen bool
+		ok, err  bool
+	}{
+		{"allow", "", false, true, false},
+		{"opa", "", false, true, false},
+		{"opa-deny", "", false, false, false},
+		{"empty", "", false, true, false},
+		{"forbidden", "", false, false, false},
+		{"forbidden", "", true, false, false},
+		{"undecided", "", false, false, true},
+		{"error", "", false, false, true},
+		{"error", "", true, true, false},
+		{"slow", "50ms", false, false, true},
+		{"slow", "50ms", true, true, false},
+	} {
+		r := MatchRule{Type: MatchHTTPAuth, URL: ts.URL + "/v1/data/webhook/allow", Timeout: tt.timeout, FailOpen: tt.failOpen}
+		req := &Request{
+			Payload:    map[string]interface{}{"answer": tt.answer},
+			RawRequest: &http.Request{Method: "POST", URL: &url.URL{Path: "/hooks/deploy"}, RemoteAddr: "10.0.0.1:1234"},
+		}
+
+		ok, err := r.Evaluate(req)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s (fail-open %v): expected ok: %v, err: %v, got ok: %v, err: %v", tt.answer, tt.failOpen, tt.ok, tt.err, ok, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		rule MatchRule
+		ok   bool
+	}{
+		{MatchRule{Type: MatchHTTPAuth, URL: "https://opa.internal:8181/v1/data/webhook/allow"}, true},
+		{MatchRule{Type: MatchHTTPAuth, URL: "http://authz/check", Timeout: "2s", FailOpen: true}, true},
+		{MatchRule{Type: MatchHTTPAuth}, false},
+		{MatchRule{Type: MatchHTTPAuth, URL: "opa.internal/check"}, false},
+		{MatchRule{Type: MatchHTTPAuth, URL: "http://authz/check", Timeout: "soon"}, false},
+	} {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%#v: unexpected validation result %v", tt.rule, err)
+		}
+	}
+}
+
+func TestMatchRuleIPRules(t *testing.T) {
+	r := MatchRule{Type: IPWhitelist, IPRange: "10.0.0.0/8", IPRules: []string{"deny 10.1.2.0/24"}}
+
+	for addr, want := range map[string]bool{"10.0.0.5:80": true, "10.1.2.3:80": false, "192.168.0.1:80": false} {
+		ok, err := r.Evaluate(&Request{RawRequest: &http.Request{RemoteAddr: addr}})
+		if ok != want || err != nil {
+			t.Errorf("%s: expected %v, got %v, %v", addr, want, ok, err)
+		}
+	}
+
+	r = MatchRule{Type: IPBlacklist, IPRules: []string{"allow 10.1.2.3", "deny 10.0.0.0/8"}}
+
+	for addr, want := range map[string]bool{"10.1.2.3:80": true, "10.0.0.5:80": false, "192.168.0.1:80": true} {
+		ok, err := r.Evaluate(&Request{RawRequest: &http.Request{RemoteAddr: addr}})
+		if ok != want || err != nil {
+			t.Errorf("%s: expected %v, got %v, %v", addr, want, ok, err)
+		}
+	}
+
+	if err := r.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := (&MatchRule{Type: IPBlacklist}).Validate(); err == nil {
+		t.Error("expected an error for a missing ip-range")
+	}
+
+	if err := (&MatchRule{Type: IPWhitelist, IPRules: []string{"permit 10.0.0.0/8"}}).Validate(); err == nil {
+		t.Error("expected an error for an invalid ip-rules entry")
+	}
+}
+
+var matchRuleRequestTests = []struct {
+	typ, regex, value string
+	method, path      string
+	ok                bool
+	err               bool
+}{
+	{"http-method", "", "POST", "POST", "/hooks/a", true, false},
+	{"http-method", "", "get, post", "POST", "/hooks/a", true, false},
+	{"url-path-regex", "/a/v[0-9]+$", "", "GET", "/hooks/a/v1", true, false},
+	// failures
+	{"http-method", "", "GET", "POST", "/hooks/a", false, false},
+	{"url-path-regex", "/b$", "", "GET", "/hooks/a", false, false},
+	// errors
+	{"url-path-regex", "*", "", "GET", "/hooks/a", false, true}, // invalid regex
+}
+
+func TestMatchRuleRequest(t *testing.T) {
+	for i, tt := range matchRuleRequestTests {
+		r := MatchRule{Type: tt.typ, Regex: tt.regex, Value: tt.value}
+		req := &Request{
+			RawRequest: &http.Request{
+				Method: tt.method,
+				URL:    &url.URL{Path: tt.path},
+			},
+		}
+		ok, err := r.Evaluate(req)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%d failed to match %#v:\nexpected ok: %#v, err: %v\ngot ok: %#v, err: %v", i, r, tt.ok, tt.err, ok, err)
+		}
+	}
+
+	if _, err := (MatchRule{Type: "http-method", Value: "POST"}).Evaluate(&Request{}); err == nil {
+		t.Error("expected error for nil request")
+	}
+}
+
+var andRuleTests = []struct {
+	desc                    string // description of the test case
+	rule                    AndRule
+	headers, query, payload map[string]interface{}
+	body                    []byte
+	ok                      bool
+	err                     bool
+}{
+	{
+		"(a=z, b=y): a=z && b=y",
+		AndRule{
+			{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}},
+			{Match: &MatchRule{Type: "value", Value: "y", Parameter: Argument{"header", "b", "", false}}},
+		},
+		map[string]interface{}{"A": "z", "B": "y"}, nil, nil,
+		[]byte{},
+		true, false,
+	},
+	{
+		"(a=z, b=Y): a=z && b=y",
+		AndRule{
+			{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}},
+			{Match: &MatchRule{Type: "value", Value: "y", Parameter: Argument{"header", "b", "", false}}},
+		},
+		map[string]interface{}{"A": "z", "B": "Y"}, nil, nil,
+		[]byte{},
+		false, false,
+	},
+	// Complex test to cover Rules.Evaluate
+	{
+		"(a=z, b=y, c=x, d=w=, e=X, f=X): a=z && (b=y && c=x) && (d=w || e=v) && !f=u",
+		AndRule{
+			{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}},
+			{
+				And: &AndRule{
+					{Match: &MatchRule{Type: "value", Value: "y", Parameter: Argument{"header", "b", "", false}}},
+					{Match: &MatchRule{Type: "value", Value: "x", Parameter: Argument{"header", "c", "", false}}},
+				},
+			},
+			{
+				Or: &OrRule{
+					{Match: &MatchRule{Type: "value", Value: "w", Parameter: Argument{"header", "d", "", false}}},
+					{Match: &MatchRule{Type: "value", Value: "v", Parameter: Argument{"header", "e", "", false}}},
+				},
+			},
+			{
+				Not: &NotRule{
+					Match: &MatchRule{Type: "value", Value: "u", Parameter: Argument{"header", "f", "", false}},
+				},
+			},
+		},
+		map[string]interface{}{"A": "z", "B": "y", "C": "x", "D": "w", "E": "X", "F": "X"}, nil, nil,
+		[]byte{},
+		true, false,
+	},
+	{"empty rule", AndRule{{}}, nil, nil, nil, nil, false, false},
+	// failures
+	{
+		"invalid rule",
+		AndRule{{Match: &MatchRule{Type: "value", Value: "X", Parameter: Argument{"header", "a", "", false}}}},
+		map[string]interface{}{"Y": "z"}, nil, nil, nil,
+		false, true,
+	},
+}
+
+func TestAndRule(t *testing.T) {
+	for _, tt := range andRuleTests {
+		r := &Request{
+			Headers: tt.headers,
+			Query:   tt.query,
+			Payload: tt.payload,
+			Body:    tt.body,
+		}
+		ok, err := tt.rule.Evaluate(r)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("failed to match %#v:\nexpected ok: %#v, err: %v\ngot ok: %#v, err: %v", tt.desc, tt.ok, tt.err, ok, err)
+		}
+	}
+}
+
+var orRuleTests = []struct {
+	desc                    string // description of the test case
+	rule                    OrRule
+	headers, query, payload map[string]interface{}
+	body                    []byte
+	ok                      bool
+	err                     bool
+}{
+	{
+		"(a=z, b=X): a=z || b=y",
+		OrRule{
+			{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}},
+			{Match: &MatchRule{Type: "value", Value: "y", Parameter: Argument{"header", "b", "", false}}},
+		},
+		map[string]interface{}{"A": "z", "B": "X"}, nil, nil,
+		[]byte{},
+		true, false,
+	},
+	{
+		"(a=X, b=y): a=z || b=y",
+		OrRule{
+			{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}},
+			{Match: &MatchRule{Type: "value", Value: "y", Parameter: Argument{"header", "b", "", false}}},
+		},
+		map[string]interface{}{"A": "X", "B": "y"}, nil, nil,
+		[]byte{},
+		true, false,
+	},
+	{
+		"(a=Z, b=Y): a=z || b=y",
+		OrRule{
+			{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}},
+			{Match: &MatchRule{Type: "value", Value: "y", Parameter: Argument{"header", "b", "", false}}},
+		},
+		map[string]interface{}{"A": "Z", "B": "Y"}, nil, nil,
+		[]byte{},
+		false, false,
+	},
+	// failures
+	{
+		"missing parameter node",
+		OrRule{
+			{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}},
+		},
+		map[string]interface{}{"Y": "Z"}, nil, nil,
+		[]byte{},
+		false, false,
+	},
+}
+
+func TestOrRule(t *testing.T) {
+	for _, tt := range orRuleTests {
+		r := &Request{
+			Headers: tt.headers,
+			Query:   tt.query,
+			Payload: tt.payload,
+			Body:    tt.body,
+		}
+		ok, err := tt.rule.Evaluate(r)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%#v:\nexpected ok: %#v, err: %v\ngot ok: %#v err: %v", tt.desc, tt.ok, tt.err, ok, err)
+		}
+	}
+}
+
+var notRuleTests = []struct {
+	desc                    string // description of the test case
+	rule                    NotRule
+	headers, query, payload map[string]interface{}
+	body                    []byte
+	ok                      bool
+	err                     bool
+}{
+	{"(a=z): !a=X", NotRule{Match: &MatchRule{Type: "value", Value: "X", Parameter: Argument{"header", "a", "", false}}}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, true, false},
+	{"(a=z): !a=z", NotRule{Match: &MatchRule{Type: "value", Value: "z", Parameter: Argument{"header", "a", "", false}}}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, false, false},
+}
+
+func TestNotRule(t *testing.T) {
+	for _, tt := range notRuleTests {
+		r := &Request{
+			Headers: tt.headers,
+			Query:   tt.query,
+			Payload: tt.payload,
+			Body:    tt.body,
+		}
+		ok, err := tt.rule.Evaluate(r)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("failed to match %#v:\nexpected ok: %#v, err: %v\ngot ok: %#v, err: %v", tt.rule, tt.ok, tt.err, ok, err)
+		}
+	}
+}
+
+var elementRuleTests = []struct {
+	desc    string // description of the test case
+	rule    string // rule in JSON format
+	payload string
+	ok      bool
+	err     bool
+}{
+	{
+		"any commit touches docs",
+		`{"any": {"parameter": {"source": "payload", "name": "commits"}, "rule": {"any": {"parameter": {"source": "element", "name": "modified"}, "rule": {"match": {"type": "regex", "regex": "^docs/", "parameter": {"source": "element"}}}}}}}`,
+		`{"commits": [{"modified": ["README.md"]}, {"modified": ["src/a.go", "docs/b.md"]}]}`,
+		true, false,
+	},
+	{
+		"no commit touches docs",
+		`{"any": {"parameter": {"source": "payload", "name": "commits"}, "rule": {"any": {"parameter": {"source": "element", "name": "modified"}, "rule": {"match": {"type": "regex", "regex": "^docs/", "parameter": {"source": "element"}}}}}}}`,
+		`{"commits": [{"modified": ["README.md"]}, {"modified": ["src/a.go"]}]}`,
+		false, false,
+	},
+	{
+		"any element missing the key",
+		`{"any": {"parameter": {"source": "payload", "name": "commits"}, "rule": {"match": {"type": "value", "value": "x", "parameter": {"source": "element", "name": "id"}}}}}`,
+		`{"commits": [{"msg": "a"}, {"id": "x"}]}`,
+		true, false,
+	},
+	{
+		"all commits verified",
+		`{"all": {"parameter": {"source": "payload", "name": "commits"}, "rule": {"match": {"type": "value", "value": "true", "parameter": {"source": "element", "name": "verified"}}}}}`,
+		`{"commits": [{"verified": true}, {"verified": true}]}`,
+		true, false,
+	},
+	{
+		"not all commits verified",
+		`{"all": {"parameter": {"source": "payload", "name": "commits"}, "rule": {"match": {"type": "value", "value": "true", "parameter": {"source": "element", "name": "verified"}}}}}`,
+		`{"commits": [{"verified": true}, {"verified": false}]}`,
+		false, false,
+	},
+	{
+		"all of empty array",
+		`{"all": {"parameter": {"source": "payload", "name": "commits"}, "rule": {"match": {"type": "value", "value": "true", "parameter": {"source": "element", "name": "verified"}}}}}`,
+		`{"commits": []}`,
+		true, false,
+	},
+	{
+		"array in JSON string parameter",
+		`{"any": {"parameter": {"source": "raw-request-body"}, "rule": {"match": {"type": "gt", "value": "2", "parameter": {"source": "element"}}}}}`,
+		`[1, 2, 3]`,
+		true, false,
+	},
+	{
+		"not an array",
+		`{"any": {"parameter": {"source": "payload", "name": "ref"}, "rule": {"match": {"type": "value", "value": "x", "parameter": {"source": "element"}}}}}`,
+		`{"ref": "x"}`,
+		false, true,
+	},
+	{
+		"missing array",
+		`{"any": {"parameter": {"source": "payload", "name": "commits"}, "rule": {"match": {"type": "value", "value": "x", "parameter": {"source": "element"}}}}}`,
+		`{}`,
+		false, true,
+	},
+}
+
+func TestElementRules(t *testing.T) {
+	for _, tt := range elementRuleTests {
+		var rule Rules
+		if err := json.Unmarshal([]byte(tt.rule), &rule); err != nil {
+			t.Fatalf("%s: invalid rule: %s", tt.desc, err)
+		}
+
+		r := &Request{Body: []byte(tt.payload), ContentType: "application/json"}
+		if err := r.ParseJSONPayload(); err != nil {
+			t.Fatalf("%s: invalid payload: %s", tt.desc, err)
+		}
+
+		ok, err := rule.Evaluate(r)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: expected ok: %#v, err: %v\ngot ok: %#v, err: %v", tt.desc, tt.ok, tt.err, ok, err)
+		}
+
+		if got := len(rule.MatchRules()); got == 0 {
+			t.Errorf("%s: MatchRules returned no rules", tt.desc)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		ok   bool
+	}{
+		{"abcd", "abcd", true},
+		{"zyxw", "abcd", false},
+	} {
+		if ok := compare(tt.a, tt.b); ok != tt.ok {
+			t.Errorf("compare failed for %q and %q: got %v\n", tt.a, tt.b, ok)
+		}
+	}
+}
+
+func TestHookCommandOutput(t *testing.T) {
+	h := &Hook{
+		ID:                  "deploy",
+		CommandOutputFormat: "json",
+		ResponseHeadersFromOutput: []OutputHeader{
+			{Name: "X-Version", Key: "version"},
+			{Name: "X-Host", Key: "target.host"},
+			{Name: "X-Missing", Key: "missing"},
+		},
+		ResponseTemplate: "deployed {{.version}} to {{.target.host}}",
+		NotificationFieldsFromOutput: []OutputHeader{
+			{Name: "host", Key: "target.host"},
+		},
+	}
+
+	output, err := h.ParseCommandOutput(`{"version": "1.2.3", "target": {"host": "web1"}}`)
+	if err != nil {
+		t.Fatalf("unexpected error parsing output: %s", err)
+	}
+
+	headers, errs := h.OutputHeaders(output)
+	expectHeaders := ResponseHeaders{{"X-Version", "1.2.3"}, {"X-Host", "web1"}}
+	if !reflect.DeepEqual(headers, expectHeaders) {
+		t.Errorf("OutputHeaders: expected %#v, got %#v", expectHeaders, headers)
+	}
+	if len(errs) != 1 {
+		t.Errorf("OutputHeaders: expected 1 error for missing key, got %v", errs)
+	}
+
+	fields, errs := h.OutputNotificationFields(output)
+	if !reflect.DeepEqual(fields, map[string]string{"host": "web1"}) || len(errs) != 0 {
+		t.Errorf("OutputNotificationFields: got %v, %v", fields, errs)
+	}
+
+	body, err := h.ExecuteResponseTemplate(output)
+	if err != nil || body != "deployed 1.2.3 to web1" {
+		t.Errorf("ExecuteResponseTemplate: got %q, %v", body, err)
+	}
+
+	if _, err := h.ParseCommandOutput("arg: not json"); err == nil {
+		t.Error("expected error parsing invalid JSON output")
+	}
+
+	if output, err := (&Hook{}).ParseCommandOutput("anything"); output != nil || err != nil {
+		t.Errorf("expected nil output without format, got %v, %v", output, err)
+	}
+}
+
+func TestExpandIPRange(t *testing.T) {
+	f, err := ioutil.TempFile("", "webhook-ip-range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString("# GitHub hooks\n192.30.252.0/22 185.199.108.0/22\n\n140.82.112.0/20 # added later\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	got, err := ExpandIPRange("10.0.0.1 file:" + f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expect := "10.0.0.1 192.30.252.0/22 185.199.108.0/22 140.82.112.0/20"
+	if got != expect {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte("127.0.0.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = ExpandIPRange("file:" + f.Name())
+	if err != nil || got != "127.0.0.1" {
+		t.Errorf("expected changed file to be re-read, got %q, %v", got, err)
+	}
+
+	ok, err := MatchRule{Type: IPWhitelist, IPRange: "file:" + f.Name()}.Evaluate(&Request{RawRequest: &http.Request{RemoteAddr: "127.0.0.1:9000"}})
+	if !ok || err != nil {
+		t.Errorf("expected ip-whitelist to match file range, got %v, %v", ok, err)
+	}
+
+	if _, err := ExpandIPRange("file:" + f.Name() + ".missing"); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestGitHubWhitelist(t *testing.T) {
+	var fetches int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if fetches > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"hooks": ["192.30.252.0/22", "2a0a:a440::/29"], "web": ["10.0.0.0/8"]}`))
+	}))
+	defer ts.Close()
+
+	defer func(u string, c *ipRangeCache) { GitHubMetaURL, githubMeta = u, c }(GitHubMetaURL, githubMeta)
+	GitHubMetaURL = ts.URL
+	githubMeta = &ipRangeCache{name: "GitHub", client: ts.Client(), fetch: fetchGitHubHooks}
+
+	for _, tt := range []struct {
+		remoteAddr string
+		refresh    string
+		ok         bool
+		err        bool
+	}{
+		{"192.30.252.10:9000", "", true, false},
+		{"[2a0a:a440::1]:9000", "", true, false},
+		{"10.0.0.1:9000", "", false, false},
+		{"192.30.252.10:9000", "1ns", true, false}, // refresh fails, stale ranges are used
+		{"192.30.252.10:9000", "x", false, true},
+	} {
+		r := MatchRule{Type: GitHubWhitelist, RefreshInterval: tt.refresh}
+		ok, err := r.Evaluate(&Request{RawRequest: &http.Request{RemoteAddr: tt.remoteAddr}})
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s (refresh %q): expected ok: %v, err: %v, got ok: %v, err: %v", tt.remoteAddr, tt.refresh, tt.ok, tt.err, ok, err)
+		}
+	}
+
+	if fetches != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetches)
+	}
+
+	githubMeta = &ipRangeCache{name: "GitHub", client: ts.Client(), fetch: fetchGitHubHooks}
+	if _, err := (MatchRule{Type: GitHubWhitelist}).Evaluate(&Request{RawRequest: &http.Request{RemoteAddr: "192.30.252.10:9000"}}); err == nil {
+		t.Error("expected error when ranges can't be fetched")
+	}
+}
+
+func TestIPRangeCacheConcurrentFetch(t *testing.T) {
+	var fetches int32
+
+	started, release := make(chan struct{}), make(chan struct{})
+
+	c := &ipRangeCache{name: "test", fetch: func(*http.Client) (string, error) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "10.0.0.0/8", nil
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ranges, err := c.Ranges(time.Hour); ranges != "10.0.0.0/8" || err != nil {
+				t.Errorf("got %q, %v", ranges, err)
+			}
+		}()
+	}
+
+	<-started
+
+	// The lock isn't held while fetching.
+	c.mu.Lock()
+	c.mu.Unlock()
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected concurrent requests to share a fetch, got %d fetches", n)
+	}
+}
+
+func TestHookNormalize(t *testing.T) {
+	h := &Hook{
+		Normalize: PayloadMapping{
+			"branch":        "ref",
+			"commit.sha":    "head_commit.id",
+			"commit.author": "head_commit.author.name",
+			"missing":       "does.not.exist",
+		},
+		PassArgumentsToCommand: []Argument{
+			{Source: "normalized", Name: "commit.sha"},
+		},
+		TriggerRule: &Rules{Match: &MatchRule{Type: "value", Value: "refs/heads/main", Parameter: Argument{Source: "normalized", Name: "branch"}}},
+	}
+
+	r := &Request{
+		Payload: map[string]interface{}{
+			"ref": "refs/heads/main",
+			"head_commit": map[string]interface{}{
+				"id":     "abc123",
+				"author": map[string]interface{}{"name": "jane"},
+			},
+		},
+	}
+
+	if errs := h.NormalizePayload(r); len(errs) != 1 {
+		t.Errorf("expected one error for the missing path, got %v", errs)
+	}
+
+	expect := map[string]interface{}{
+		"branch": "refs/heads/main",
+		"commit": map[string]interface{}{"sha": "abc123", "author": "jane"},
+	}
+	if !reflect.DeepEqual(r.Normalized, expect) {
+		t.Errorf("expected normalized payload %#v, got %#v", expect, r.Normalized)
+	}
+
+	if ok, err := h.TriggerRule.Evaluate(r); !ok || err != nil {
+		t.Errorf("expected rule on normalized payload to match, got %v, %v", ok, err)
+	}
+
+	args, errs := h.ExtractCommandArguments(r)
+	if len(errs) != 0 || !reflect.DeepEqual(args, []string{"", "abc123"}) {
+		t.Errorf("unexpected arguments %q, errors %v", args, errs)
+	}
+}
+
+func TestHookValidate(t *testing.T) {
+	param := Argument{"payload", "a", "", false}
+
+	for _, tt := range []struct {
+		desc string
+		hook Hook
+		errs int
+	}{
+		{"valid", Hook{ExecuteCommand: "/bin/true", TriggerRule: &Rules{And: &AndRule{
+			{Match: &MatchRule{Type: MatchRegex, Regex: "^a", Parameter: param}},
+			{Not: &NotRule{Match: &MatchRule{Type: MatchBetween, Value: "1,2", Parameter: param}}},
+		}}}, 0},
+		{"missing command", Hook{}, 1},
+		{"proxy without url", Hook{Kind: KindProxy}, 1},
+		{"unknown kind", Hook{Kind: "batch"}, 1},
+		{"invalid read timeout", Hook{ExecuteCommand: "/bin/true", ReadTimeout: "soon"}, 1},
+		{"invalid success regex", Hook{ExecuteCommand: "/bin/true", SuccessCriteria: &SuccessRule{OutputRegex: "("}}, 1},
+		{"several rule properties", Hook{ExecuteCommand: "/bin/true", TriggerRule: &Rules{
+			And:   &AndRule{},
+			Match: &MatchRule{Type: MatchValue, Parameter: param},
+		}}, 1},
+		{"empty rules", Hook{ExecuteCommand: "/bin/true", TriggerRule: &Rules{Or: &OrRule{{}}}}, 1},
+		{"empty and", Hook{ExecuteCommand: "/bin/true", TriggerRule: &Rules{And: &AndRule{}}}, 1},
+		{"invalid matches", Hook{ExecuteCommand: "/bin/true", TriggerRule: &Rules{Or: &OrRule{
+			{Match: &MatchRule{Type: MatchRegex, Regex: "(", Parameter: param}},
+			{Match: &MatchRule{Type: "equals", Parameter: param}},
+			{Match: &MatchRule{Type: MatchHMACSHA256, Parameter: param}},
+			{Match: &MatchRule{Type: MatchGT, Value: "many", Parameter: param}},
+			{Match: &MatchRule{Type: MatchGlob, Value: "[", Parameter: param}},
+			{Match: &MatchRule{Type: MatchValue}},
+			{Match: &MatchRule{Type: IPWhitelist}},
+			{Any: &AnyRule{Rule: Rules{Match: &MatchRule{Type: MatchValue, Parameter: param}}}},
+		}}}, 8},
+	} {
+		if errs := tt.hook.Validate(); len(errs) != tt.errs {
+			t.Errorf("%s: expected %d errors, got %v", tt.desc, tt.errs, errs)
+		}
+	}
+}
+
+func TestLoadFromFileStrict(t *testing.T) {
+	f, err := ioutil.TempFile("", "hooks-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("- id: typo\n  execute-comand: /bin/true\n")
+	f.Close()
+
+	var hooks Hooks
+	if err := hooks.LoadFromFile(f.Name(), false); err != nil {
+		t.Fatalf("unexpected error without strict option: %s", err)
+	}
+
+	hooks = nil
+	if err := hooks.LoadFromFile(f.Name(), false, StrictOption()); err == nil || !strings.Contains(err.Error(), "execute-comand") {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestLoadInvalidReadTimeout(t *testing.T) {
+	var hooks Hooks
+	err := hooks.Load("hooks.yaml", []byte("- id: slow\n  execute-command: /bin/true\n  read-timeout: 10\n"), false)
+	if err == nil || !strings.Contains(err.Error(), "hook slow: invalid read-timeout") {
+		t.Errorf("expected invalid read-timeout error, got %v", err)
+	}
+
+	hooks = nil
+	if err := hooks.Load("hooks.yaml", []byte("- id: slow\n  execute-command: /bin/true\n  read-timeout: 10s\n"), false); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	h := http.Header{}
+	if id := NewIdentity(h, "X-Forwarded-User", "X-Forwarded-Groups"); id != nil {
+		t.Errorf("expected no identity without user header, got %#v", id)
+	}
+
+	h.Set("X-Forwarded-User", "jane")
+	h.Add("X-Forwarded-Groups", "dev, ops")
+	h.Add("X-Forwarded-Groups", "admin")
+
+	id := NewIdentity(h, "X-Forwarded-User", "X-Forwarded-Groups")
+	expect := &Identity{User: "jane", Groups: []string{"dev", "ops", "admin"}}
+	if !reflect.DeepEqual(id, expect) {
+		t.Fatalf("expected identity %#v, got %#v", expect, id)
+	}
+
+	r := &Request{Identity: id}
+
+	for _, tt := range []struct {
+		name, expect string
+	}{
+		{"user", "jane"},
+		{"groups", "dev,ops,admin"},
+	} {
+		a := Argument{SourceIdentity, tt.name, "", false}
+		if v, err := a.Get(r); err != nil || v != tt.expect {
+			t.Errorf("identity %s: expected %q, got %q, %v", tt.name, tt.expect, v, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		groups string
+		ok     bool
+	}{
+		{"ops", true},
+		{"qa, admin", true},
+		{"qa", false},
+		{"", false},
+	} {
+		rule := MatchRule{Type: MatchSSOGroup, Value: tt.groups}
+		if ok, err := rule.Evaluate(r); ok != tt.ok || err != nil {
+			t.Errorf("sso-group %q: expected %v, got %v, %v", tt.groups, tt.ok, ok, err)
+		}
+	}
+
+	rule := MatchRule{Type: MatchSSOGroup, Value: "ops"}
+	if ok, _ := rule.Evaluate(&Request{}); ok {
+		t.Error("sso-group matched a request without identity")
+	}
+}
+
+func TestDetectPayloadType(t *testing.T) {
+	for _, tt := range []struct {
+		body, expect string
+	}{
+		{`{"a": 1}`, "application/json"},
+		{" [1, 2]\n", "application/json"},
+		{`{"a": `, ""},
+		{`<push><ref>main</ref></push>`, "application/xml"},
+		{`<not xml`, ""},
+		{"ref=main&sha=abc%20def\n", "application/x-www-form-urlencoded"},
+		{"ref=", "application/x-www-form-urlencoded"},
+		{"just some text", ""},
+		{"ref=main&orphan", ""},
+		{"=main", ""},
+		{"", ""},
+	} {
+		r := &Request{Body: []byte(tt.body)}
+		if got := r.DetectPayloadType(); got != tt.expect {
+			t.Errorf("DetectPayloadType(%q): expected %q, got %q", tt.body, tt.expect, got)
+		}
+	}
+}
+
+func TestSecretsRotation(t *testing.T) {
+	var r MatchRule
+
+	if err := json.Unmarshal([]byte(`{"type": "payload-hmac-sha256", "secret": ["old", "new"], "parameter": {"source": "header", "name": "X-Signature"}}`), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.Secret, Secrets{"old", "new"}) {
+		t.Fatalf("expected secrets [old new], got %v", r.Secret)
+	}
+
+	body := []byte(`{"a":"z"}`)
+
+	for _, tt := range []struct {
+		secret string
+		ok     bool
+	}{
+		{"old", true},
+		{"new", true},
+		{"other", false},
+	} {
+		mac := hmac.New(sha256.New, []byte(tt.secret))
+		mac.Write(body)
+
+		req := &Request{
+			Body:    body,
+			Headers: map[string]interface{}{"X-Signature": "sha256=" + hex.EncodeToString(mac.Sum(nil))},
+		}
+
+		ok, err := r.Evaluate(req)
+		if ok != tt.ok || (err == nil) != tt.ok {
+			t.Errorf("signed with %s: expected %t, got %t (error: %v)", tt.secret, tt.ok, ok, err)
+		}
+	}
+
+	var one MatchRule
+	if err := json.Unmarshal([]byte(`{"secret": "only"}`), &one); err != nil || !reflect.DeepEqual(one.Secret, Secrets{"only"}) {
+		t.Errorf("expected secrets [only], got %v (error: %v)", one.Secret, err)
+	}
+
+	if b, _ := json.Marshal(one.Secret); string(b) != `"only"` {
+		t.Errorf("expected a single secret to be encoded as a string, got %s", b)
+	}
+
+	if err := json.Unmarshal([]byte(`{"secret": 1}`), &one); err == nil {
+		t.Error("expected an error for a numeric secret")
+	}
+
+	if err := (&MatchRule{Type: MatchHMACSHA256, Secret: Secrets{"a", ""}}).Validate(); err == nil {
+		t.Error("expected an error validating an empty secret")
+	}
+}
+
+func TestPayloadHMAC(t *testing.T) {
+	body := []byte(`{"a": "z"}`)
+
+	for _, tt := range []struct {
+		algorithm, signature string
+		ok                   bool
+	}{
+		{"sha1", "sha1=b17e04cbb22afa8ffbff8796fc1894ed27badd9e", true},
+		{"sha256", "f417af3a21bd70379b5796d5f013915e7029f62c580fb0f500f59a35a6f04c89", true},
+		{"sha3-256", "sha3-256=4ce5d0c3ae3379dde5652b016b56effb6ce51c7dc0ef8f98e9df0ea60178e7bd", true},
+		{"blake2b", "7cda0ba21c8b0d04167a1afcd7c01437ca284c79ddb56612c64dc4a5f33f092def71491da9f54160be19ed1e77a912015c6c274647dfb44f2d4f52c39c58e91a", true},
+		{"sha3-256", "f417af3a21bd70379b5796d5f013915e7029f62c580fb0f500f59a35a6f04c89", false},
+		{"md4", "b17e04cbb22afa8ffbff8796fc1894ed27badd9e", false},
+	} {
+		r := MatchRule{Type: MatchHMAC, Algorithm: tt.algorithm, Secret: Secrets{"secret"}, Parameter: Argument{"header", "a", "", false}}
+		req := &Request{Body: body, Headers: map[string]interface{}{"A": tt.signature}}
+
+		ok, err := r.Evaluate(req)
+		if ok != tt.ok || (err == nil) != tt.ok {
+			t.Errorf("%s %s: expected %t, got %t (error: %v)", tt.algorithm, tt.signature, tt.ok, ok, err)
+		}
+
+		if _, known := HashAlgorithm(tt.algorithm); (r.Validate() == nil) != known {
+			t.Errorf("%s: unexpected validation result %v", tt.algorithm, r.Validate())
+		}
+	}
+
+	RegisterHashAlgorithm("test-sha1", sha1.New)
+	defer func() {
+		hashAlgorithms.Lock()
+		delete(hashAlgorithms.m, "test-sha1")
+		hashAlgorithms.Unlock()
+	}()
+
+	if _, err := CheckPayloadHMAC(body, "test-sha1", "secret", "test-sha1=b17e04cbb22afa8ffbff8796fc1894ed27badd9e"); err != nil {
+		t.Errorf("registered algorithm: unexpected error: %s", err)
+	}
+}
+
+func TestCheckPayloadHMACOptions(t *testing.T) {
+	body := []byte(`{"amount": 42}`)
+
+	sum := func(fn func() hash.Hash) []byte {
+		mac := hmac.New(fn, []byte("secret"))
+		mac.Write(body)
+		return mac.Sum(nil)
+	}
+
+	md5Hex := hex.EncodeToString(sum(md5.New))
+	sha256Base64 := base64.StdEncoding.EncodeToString(sum(sha256.New))
+
+	for _, tt := range []struct {
+		desc      string
+		signature string
+		options   HMACOptions
+		ok        bool
+	}{
+		{"md5 hex", md5Hex, HMACOptions{Algorithm: "md5"}, true},
+		{"md5 upper case hex", strings.ToUpper(md5Hex), HMACOptions{Algorithm: "md5"}, true},
+		{"md5 prefixed", "md5=" + md5Hex, HMACOptions{Algorithm: "md5"}, true},
+		{"base64", sha256Base64, HMACOptions{Algorithm: "sha256", Encoding: EncodingBase64}, true},
+		{"base64 custom prefix", "v1=" + sha256Base64, HMACOptions{Algorithm: "sha256", Encoding: EncodingBase64, Prefix: "v1="}, true},
+		{"base64 url-safe unpadded", base64.RawURLEncoding.EncodeToString(sum(sha256.New)), HMACOptions{Algorithm: "sha256", Encoding: EncodingBase64}, true},
+		{"multiple signatures", "v1=bad,v1=" + sha256Base64, HMACOptions{Algorithm: "sha256", Encoding: EncodingBase64, Prefix: "v1="}, true},
+		{"wrong encoding", sha256Base64, HMACOptions{Algorithm: "sha256"}, false},
+		{"wrong signature", strings.Repeat("0", 32), HMACOptions{Algorithm: "md5"}, false},
+		{"unknown encoding", md5Hex, HMACOptions{Algorithm: "md5", Encoding: "base32"}, false},
+	} {
+		expected, err := CheckPayloadHMACOptions(body, "secret", tt.signature, tt.options)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error %v", tt.desc, err)
+		}
+
+		if tt.ok && expected != md5Hex && expected != sha256Base64 {
+			t.Errorf("%s: unexpected expected signature %q", tt.desc, expected)
+		}
+	}
+
+	r := MatchRule{Type: MatchHMAC, Algorithm: "md5", Encoding: "base32", Secret: Secrets{"secret"}}
+	if err := r.Validate(); err == nil {
+		t.Errorf("expected an error validating an unknown encoding")
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(path, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := MatchRule{Type: MatchHMACSHA1, SecretFile: Secrets{path}, Parameter: Argument{"header", "a", "", false}}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %s", err)
+	}
+
+	req := &Request{
+		Body:    []byte(`{"a": "z"}`),
+		Headers: map[string]interface{}{"A": "sha1=b17e04cbb22afa8ffbff8796fc1894ed27badd9e"},
+	}
+
+	if ok, err := r.Evaluate(req); !ok || err != nil {
+		t.Errorf("expected the secret from the file to verify the signature, got %t (error: %v)", ok, err)
+	}
+
+	// Replace the secret; the cache notices the new modification time.
+	if err := ioutil.WriteFile(path, []byte("SECRET\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(path, future, future)
+
+	if ok, _ := r.Evaluate(req); ok {
+		t.Error("expected the replaced secret to be rejected")
+	}
+
+	// Inline secrets are accepted too.
+	r.Secret = Secrets{"secret"}
+	if ok, err := r.Evaluate(req); !ok || err != nil {
+		t.Errorf("expected the inline secret to verify the signature, got %t (error: %v)", ok, err)
+	}
+
+	r.SecretFile = Secrets{filepath.Join(dir, "missing")}
+	if _, err := r.Evaluate(req); err == nil {
+		t.Error("expected an error for a missing secret file")
+	}
+
+	if err := (&MatchRule{Type: MatchHMACSHA1, SecretFile: Secrets{""}}).Validate(); err == nil {
+		t.Error("expected an error validating an empty secret-file")
+	}
+}
+
+func TestExecuteShell(t *testing.T) {
+	h := &Hook{
+		ID:                      "deploy",
+		ExecuteShell:            `git pull && make "$1"`,
+		Shell:                   "bash",
+		PassArgumentsToCommand:  []Argument{{Source: "payload", Name: "target"}},
+		CommandWorkingDirectory: "/srv",
+	}
+
+	req := &Request{Payload: map[string]interface{}{"target": "$(reboot)"}}
+
+	args, errs := h.ExtractCommandArguments(req)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expect := []string{"bash", "-c", `git pull && make "$1"`, "deploy", "$(reboot)"}
+	if !reflect.DeepEqual(args, expect) {
+		t.Errorf("expected arguments %q, got %q", expect, args)
+	}
+
+	// The interpreter is found in the PATH, not the working directory.
+	if h.CommandPath() != "bash" {
+		t.Errorf("expected command path bash, got %s", h.CommandPath())
+	}
+
+	h = &Hook{ID: "ps", ExecuteShell: "Get-Date", Shell: "powershell"}
+
+	args, _ = h.ExtractCommandArguments(req)
+	expect = []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", "Get-Date"}
+	if !reflect.DeepEqual(args, expect) {
+		t.Errorf("expected arguments %q, got %q", expect, args)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		h    Hook
+		errs int
+	}{
+		{"sh", Hook{ExecuteShell: "true", PassArgumentsToCommand: []Argument{{Source: "string", Name: "a"}}}, 0},
+		{"both commands", Hook{ExecuteCommand: "/bin/true", ExecuteShell: "true"}, 1},
+		{"unknown shell", Hook{ExecuteShell: "true", Shell: "fish"}, 1},
+		{"cmd arguments", Hook{ExecuteShell: "dir", Shell: "cmd", PassArgumentsToCommand: []Argument{{Source: "string", Name: "a"}}}, 1},
+		{"shell without script", Hook{ExecuteCommand: "/bin/true", Shell: "sh"}, 1},
+	} {
+		if errs := tt.h.Validate(); len(errs) != tt.errs {
+			t.Errorf("%s: expected %d errors, got %v", tt.desc, tt.errs, errs)
+		}
+	}
+}
+
+func TestVault(t *testing.T) {
+	var reads int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		reads++
+
+		switch r.URL.Path {
+		case "/v1/secret/data/webhooks":
+			w.Write([]byte(`{"data": {"data": {"github": "secret"}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/webhooks":
+			w.Write([]byte(`{"lease_duration": 1, "data": {"token": "t0ken"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(c *vaultClient) { vault = c }(vault)
+
+	vault = &vaultClient{client: ts.Client()}
+	ConfigureVault(ts.URL, "token", "", false)
+
+	// vault: secrets are used as given unless enabled.
+	if v, err := resolveSecret("vault:secret/data/webhooks#github"); err != nil || v != "vault:secret/data/webhooks#github" || reads != 0 {
+		t.Errorf("expected the secret to be used as given, got %q (error: %v)", v, err)
+	}
+
+	ConfigureVault(ts.URL, "token", "", true)
+
+	r := MatchRule{Type: MatchHMACSHA1, Secret: Secrets{"vault:secret/data/webhooks#github"}, Parameter: Argument{"header", "a", "", false}}
+	req := &Request{
+		Body:    []byte(`{"a": "z"}`),
+		Headers: map[string]interface{}{"A": "sha1=b17e04cbb22afa8ffbff8796fc1894ed27badd9e"},
+	}
+
+	if ok, err := r.Evaluate(req); !ok || err != nil {
+		t.Errorf("expected the secret from Vault to verify the signature, got %t (error: %v)", ok, err)
+	}
+
+	// The KV version 2 secret has no lease, so it is cached.
+	r.Evaluate(req)
+	if reads != 1 {
+		t.Errorf("expected 1 read, got %d", reads)
+	}
+
+	a := Argument{Source: SourceVault, Name: "kv/webhooks#token"}
+	if v, err := a.Get(req); err != nil || v != "t0ken" {
+		t.Errorf("expected t0ken, got %q (error: %v)", v, err)
+	}
+
+	// Secrets are read again once their lease expires, and the previous
+	// secret is used if that fails.
+	vault.cache["kv/webhooks"] = vaultSecret{data: map[string]interface{}{"token": "old"}, expires: time.Now().Add(-time.Second)}
+	vault.token = "expired"
+
+	if v, err := a.Get(req); err != nil || v != "old" {
+		t.Errorf("expected the previous secret, got %q (error: %v)", v, err)
+	}
+
+	vault.token = "token"
+
+	for _, ref := range []string{"kv/webhooks#missing", "kv/missing#token", "kv/webhooks"} {
+		if _, err := vault.Secret(ref); err == nil {
+			t.Errorf("expected an error for %s", ref)
+		}
+	}
+
+	hooks := Hooks{{ID: "a", PassEnvironmentToCommand: []Argument{{Source: SourceVault, Name: "kv/missing#token"}}}}
+	if err := hooks.resolveVaultSecrets(); err == nil {
+		t.Error("expected an error loading hooks with a missing Vault secret")
+	}
+}
+
+func TestVaultConcurrentReads(t *testing.T) {
+	var reads int32
+
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reads, 1)
+
+		if r.URL.Path == "/v1/kv/slow" {
+			<-release
+		}
+
+		w.Write([]byte(`{"data": {"token": "t0ken"}}`))
+	}))
+	defer ts.Close()
+
+	defer func(c *vaultClient) { vault = c }(vault)
+
+	vault = &vaultClient{client: ts.Client()}
+	ConfigureVault(ts.URL, "token", "", true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := vault.Secret("kv/slow#token"); err != nil || v != "t0ken" {
+				t.Errorf("expected t0ken, got %q (error: %v)", v, err)
+			}
+		}()
+	}
+
+	// Other secrets can be read while the slow one is being read.
+	for atomic.LoadInt32(&reads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if v, err := vault.Secret("kv/fast#token"); err != nil || v != "t0ken" {
+		t.Errorf("expected t0ken, got %q (error: %v)", v, err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&reads); n != 2 {
+		t.Errorf("expected the concurrent requests to share a read, got %d reads", n)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	f, err := ioutil.TempFile("", "hooks-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`- id: plain
+  execute-command: /bin/true
+- group: deploy
+  url-prefix: deploy
+  allowed-callers: [ci]
+  pass-environment-to-command:
+  - source: string
+    envname: STAGE
+    name: prod
+  trigger-rule:
+    match:
+      type: value
+      value: 42
+      parameter:
+        source: header
+        name: X-Token
+  hooks:
+  - id: api
+    execute-command: /bin/true
+    trigger-rule:
+      match:
+        type: value
+        value: main
+        parameter:
+          source: payload
+          name: ref
+  - id: web
+    execute-command: /bin/true
+    allowed-callers: [admin]
+  - group: nested
+    url-prefix: db
+    hooks:
+    - id: migrate
+      execute-command: /bin/true
+- group: off
+  disabled: true
+  hooks:
+  - id: ignored
+    execute-command: /bin/true
+`)
+	f.Close()
+
+	var hooks Hooks
+	if err := hooks.LoadFromFile(f.Name(), false, StrictOption()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var ids []string
+	for _, h := range hooks {
+		ids = append(ids, h.ID)
+	}
+
+	if expect := []string{"plain", "deploy/api", "deploy/web", "deploy/db/migrate"}; !reflect.DeepEqual(ids, expect) {
+		t.Fatalf("expected hooks %q, got %q", expect, ids)
+	}
+
+	api, web, migrate := hooks.Match("deploy/api"), hooks.Match("deploy/web"), hooks.Match("deploy/db/migrate")
+
+	if api.Group != "deploy" || migrate.Group != "nested" || hooks.Match("plain").Group != "" {
+		t.Errorf("unexpected groups %q, %q and %q", api.Group, migrate.Group, hooks.Match("plain").Group)
+	}
+
+	req := &Request{
+		Headers: map[string]interface{}{"X-Token": "42"},
+		Payload: map[string]interface{}{"ref": "main"},
+	}
+
+	for _, h := range []*Hook{api, web, migrate} {
+		if ok, err := h.TriggerRule.Evaluate(req); !ok || err != nil {
+			t.Errorf("%s: expected the trigger rule to be satisfied, got %t (error: %v)", h.ID, ok, err)
+		}
+	}
+
+	req.Headers["X-Token"] = "43"
+	if ok, _ := web.TriggerRule.Evaluate(req); ok {
+		t.Error("expected the group trigger rule to apply to members")
+	}
+
+	req.Headers["X-Token"], req.Payload["ref"] = "42", "dev"
+	if ok, _ := api.TriggerRule.Evaluate(req); ok {
+		t.Error("expected the trigger rule of the member to apply too")
+	}
+
+	if len(api.PassEnvironmentToCommand) != 1 || api.PassEnvironmentToCommand[0].EnvName != "STAGE" {
+		t.Errorf("expected the group environment, got %+v", api.PassEnvironmentToCommand)
+	}
+
+	if !reflect.DeepEqual(api.AllowedCallers, []string{"ci"}) || !reflect.DeepEqual(web.AllowedCallers, []string{"admin"}) {
+		t.Errorf("unexpected allowed callers %q and %q", api.AllowedCallers, web.AllowedCallers)
+	}
+
+	// Misspelled properties of groups and their members are reported.
+	f, err = os.Create(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.WriteString("- group: g\n  url-prefx: g\n  hooks:\n  - id: a\n    execute-comand: /bin/true\n")
+	f.Close()
+
+	err = hooks.LoadFromFile(f.Name(), false, StrictOption())
+	if err == nil || !strings.Contains(err.Error(), "[0].url-prefx") || !strings.Contains(err.Error(), "[0].hooks[0].execute-comand") {
+		t.Errorf("expected unknown field errors, got %v", err)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	c := &CORS{AllowedOrigins: []string{"https://dashboard.example.com", "https://*.internal.example.com"}}
+
+	for origin, expect := range map[string]bool{
+		"https://dashboard.example.com":      true,
+		"https://DASHBOARD.example.com":      true,
+		"https://ci.internal.example.com":    true,
+		"http://dashboard.example.com":       false,
+		"https://dashboard.example.com.evil": false,
+	} {
+		if c.AllowsOrigin(origin) != expect {
+			t.Errorf("AllowsOrigin(%q): expected %v", origin, expect)
+		}
+	}
+
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	for _, c := range []CORS{
+		{},
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"https://[.example.com"}},
+		{AllowedOrigins: []string{"*"}, MaxAge: -1},
+	} {
+		if errs := c.Validate(); len(errs) != 1 {
+			t.Errorf("expected 1 error for %+v, got %v", c, errs)
+		}
+	}
+}
+
+func TestSanitizeOutput(t *testing.T) {
+	out := "\x1b[1;32mok\x1b[0m \x1b]0;title\x07\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\ \x1b7done\xff\n"
+
+	for _, tt := range []struct {
+		options []string
+		expect  string
+	}{
+		{nil, out},
+		{[]string{SanitizeANSI}, "ok link done\xff\n"},
+		{[]string{SanitizeInvalidUTF8}, strings.Replace(out, "\xff", "�", 1)},
+		{[]string{SanitizeANSI, SanitizeInvalidUTF8}, "ok link done�\n"},
+		{[]string{SanitizeNone}, out},
+	} {
+		if got := SanitizeOutput(out, tt.options); got != tt.expect {
+			t.Errorf("%q: expected %q, got %q", tt.options, tt.expect, got)
+		}
+	}
+
+	for _, options := range [][]string{{"color"}, {SanitizeNone, SanitizeANSI}} {
+		if err := ValidateSanitizeOptions(options); err == nil {
+			t.Errorf("%q: expected an error", options)
+		}
+	}
+}
+
+func TestLoadLargeFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "hooks-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	const n = 500
+
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(f, "- id: hook-%d\n  execute-command: /bin/true\n  http-methods: [POST]\n", i)
+	}
+	f.Close()
+
+	var hooks Hooks
+	if err := hooks.LoadFromFile(f.Name(), false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(hooks) != n {
+		t.Fatalf("expected %d hooks, got %d", n, len(hooks))
+	}
+
+	for i := range hooks {
+		if want := fmt.Sprintf("hook-%d", i); hooks[i].ID != want {
+			t.Fatalf("expected hook %d to be %s, got %s", i, want, hooks[i].ID)
+		}
+	}
+
+	// Errors of any entry fail the whole file.
+	data, _ := ioutil.ReadFile(f.Name())
+	ioutil.WriteFile(f.Name(), append(data, "- id: [broken]\n"...), 0o644)
+
+	hooks = nil
+	if err := hooks.LoadFromFile(f.Name(), false); err == nil {
+		t.Errorf("expected an error loading an invalid hook")
+	}
+}
+
+func TestCompileRegex(t *testing.T) {
+	a, err := compileRegex("^refs/heads/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if b, _ := compileRegex("^refs/heads/"); a != b {
+		t.Errorf("expected the compiled regex to be cached")
+	}
+
+	if _, err := compileRegex("("); err == nil {
+		t.Errorf("expected an error compiling an invalid regex")
+	}
+}
+
+// encryptJWE returns plaintext encrypted to key as a compact JWE, compressed
+// with the optional compression algorithm.
+func encryptJWE(t *testing.T, alg, enc string, key interface{}, cty string, plaintext []byte, zip ...jose.CompressionAlgorithm) string {
+	t.Helper()
+
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		key = &k.PublicKey
+	}
+
+	opts := &jose.EncrypterOptions{}
+	if len(zip) != 0 {
+		opts.Compression = zip[0]
+	}
+	if cty != "" {
+		opts = opts.WithContentType(jose.ContentType(cty))
+	}
+
+	e, err := jose.NewEncrypter(jose.ContentEncryption(enc), jose.Recipient{Algorithm: jose.KeyAlgorithm(alg), Key: key}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := e.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestDecryptJWE(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-jwe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(rsaFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), 0o600)
+
+	aesKey := bytes.Repeat([]byte{7}, 16)
+	dirKey := bytes.Repeat([]byte{9}, 32)
+	plaintext := []byte(`{"ref": "refs/heads/main"}`)
+
+	for _, tt := range []struct {
+		alg, enc string
+		key      interface{}
+		d        PayloadDecryption
+	}{
+		{"dir", "A256GCM", dirKey, PayloadDecryption{Format: DecryptJWE, Key: base64.StdEncoding.EncodeToString(dirKey)}},
+		{"A128KW", "A128GCM", aesKey, PayloadDecryption{Format: DecryptJWE, Key: base64.RawURLEncoding.EncodeToString(aesKey)}},
+		{"A128KW", "A256CBC-HS512", aesKey, PayloadDecryption{Format: DecryptJWE, Key: base64.StdEncoding.EncodeToString(aesKey)}},
+		{"RSA-OAEP-256", "A128CBC-HS256", rsaKey, PayloadDecryption{Format: DecryptJWE, KeyFile: rsaFile}},
+	} {
+		token := encryptJWE(t, tt.alg, tt.enc, tt.key, "json", plaintext)
+
+		tt.d.Algorithms, tt.d.Encryptions = []string{tt.alg}, []string{tt.enc}
+
+		h := &Hook{DecryptPayload: &tt.d}
+		req := &Request{Body: []byte(token), ContentType: "application/jose"}
+		if err := h.DecryptRequest(req); err != nil {
+			t.Errorf("%s/%s: unexpected error: %s", tt.alg, tt.enc, err)
+			continue
+		}
+
+		if string(req.Body) != string(plaintext) || req.ContentType != "application/json" {
+			t.Errorf("%s/%s: got %q with content type %q", tt.alg, tt.enc, req.Body, req.ContentType)
+		}
+
+		// Tampering with any byte of the ciphertext fails decryption.
+		parts := strings.Split(token, ".")
+		ct, _ := base64.RawURLEncoding.DecodeString(parts[3])
+		ct[0] ^= 1
+		parts[3] = base64.RawURLEncoding.EncodeToString(ct)
+
+		if _, _, err := tt.d.Decrypt([]byte(strings.Join(parts, "."))); err == nil {
+			t.Errorf("%s/%s: expected tampered ciphertext to fail decryption", tt.alg, tt.enc)
+		}
+	}
+
+	wrong := PayloadDecryption{Format: DecryptJWE, Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), Algorithms: []string{"dir"}, Encryptions: []string{"A256GCM"}}
+	if _, _, err := wrong.Decrypt([]byte(encryptJWE(t, "dir", "A256GCM", dirKey, "", plaintext))); err == nil {
+		t.Errorf("expected decryption with the wrong key to fail")
+	}
+
+	// The sender can't choose algorithms that aren't accepted, ie. dir with
+	// the key meant for A128KW.
+	kw := PayloadDecryption{Format: DecryptJWE, Key: base64.StdEncoding.EncodeToString(aesKey), Algorithms: []string{"A128KW"}, Encryptions: []string{"A128GCM"}}
+	if _, _, err := kw.Decrypt([]byte(encryptJWE(t, "dir", "A128GCM", aesKey, "", plaintext))); err == nil || !strings.Contains(err.Error(), "not accepted") {
+		t.Errorf("expected an algorithm that isn't accepted to fail, got %v", err)
+	}
+	if _, _, err := kw.Decrypt([]byte(encryptJWE(t, "A128KW", "A256CBC-HS512", aesKey, "", plaintext))); err == nil || !strings.Contains(err.Error(), "not accepted") {
+		t.Errorf("expected an encryption that isn't accepted to fail, got %v", err)
+	}
+	// Compressed payloads aren't accepted.
+	zipped := encryptJWE(t, "A128KW", "A128GCM", aesKey, "", plaintext, jose.DEFLATE)
+	if _, _, err := kw.Decrypt([]byte(zipped)); err == nil || !strings.Contains(err.Error(), "compression") {
+		t.Errorf("expected a compressed payload to fail, got %v", err)
+	}
+
+	if errs := kw.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	if errs := (&PayloadDecryption{Format: "pgp"}).Validate(); len(errs) != 1 || !strings.Contains(errs[0].Error(), "not supported") {
+		t.Errorf("expected an unsupported format error, got %v", errs)
+	}
+
+	if errs := (&PayloadDecryption{Format: DecryptJWE}).Validate(); len(errs) != 3 {
+		t.Errorf("expected missing key, algorithms and encryptions errors, got %v", errs)
+	}
+
+	if errs := (&PayloadDecryption{Format: DecryptJWE, Key: "a2V5", Algorithms: []string{"none"}, Encryptions: []string{"A256GCM"}}).Validate(); len(errs) != 1 {
+		t.Errorf("expected an unsupported algorithm error, got %v", errs)
+	}
+}
+
+func TestCheckShopifySignature(t *testing.T) {
+	body := []byte(`{"id":820982911946154508}`)
+
+	for _, tt := range []struct {
+		desc    string
+		headers map[string]interface{}
+		ok      bool
+		err     bool
+	}{
+		{"valid", map[string]interface{}{"X-Shopify-Hmac-Sha256": "qBvrsZF7RfB1iS6BX6IHqJTp5L911P7intnPeTPsH0I="}, true, false},
+		{"hex encoded", map[string]interface{}{"X-Shopify-Hmac-Sha256": "a81bebb1917b45f075892e815fa207a894e9e4bf75d4fee29ed9cf7933ec1f42"}, false, true},
+		{"wrong signature", map[string]interface{}{"X-Shopify-Hmac-Sha256": "c2lnbmF0dXJl"}, false, true},
+		{"missing header", map[string]interface{}{}, false, false},
+	} {
+		r := MatchRule{Type: ShopifySignature, Secret: Secrets{"hush"}}
+
+		ok, err := r.Evaluate(&Request{Body: body, Headers: tt.headers})
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: got %t, %v", tt.desc, ok, err)
+		}
+	}
+
+	if err := (&MatchRule{Type: ShopifySignature}).Validate(); err == nil {
+		t.Errorf("expected an error for a shopify-signature rule without secret")
+	}
+}
+
+func TestGiteaRules(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	sig := "2415c216b44144d7d382aa2b9b0096074f2190e1e0c74a1f6f22c8115dc3b153"
+
+	for _, tt := range []struct {
+		desc    string
+		rule    MatchRule
+		headers map[string]interface{}
+		ok      bool
+		err     bool
+	}{
+		{"gitea signature", MatchRule{Type: GiteaSignature, Secret: Secrets{"hush"}}, map[string]interface{}{"X-Gitea-Signature": sig}, true, false},
+		{"gogs signature", MatchRule{Type: GiteaSignature, Secret: Secrets{"hush"}}, map[string]interface{}{"X-Gogs-Signature": sig}, true, false},
+		{"wrong signature", MatchRule{Type: GiteaSignature, Secret: Secrets{"other"}}, map[string]interface{}{"X-Gitea-Signature": sig}, false, true},
+		{"missing signature", MatchRule{Type: GiteaSignature, Secret: Secrets{"hush"}}, map[string]interface{}{}, false, false},
+		{"event", MatchRule{Type: GiteaEvent, Value: "push, create"}, map[string]interface{}{"X-Gitea-Event": "create"}, true, false},
+		{"gogs event", MatchRule{Type: GiteaEvent, Value: "push"}, map[string]interface{}{"X-Gogs-Event": "push"}, true, false},
+		{"other event", MatchRule{Type: GiteaEvent, Value: "push"}, map[string]interface{}{"X-Gitea-Event": "pull_request"}, false, false},
+	} {
+		ok, err := tt.rule.Evaluate(&Request{Body: body, Headers: tt.headers})
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: got %t, %v", tt.desc, ok, err)
+		}
+	}
+
+	for _, r := range []MatchRule{{Type: GiteaSignature}, {Type: GiteaEvent}} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected an error for an incomplete %s rule", r.Type)
+		}
+	}
+}
+
+func TestBitbucket(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items": [
+			{"cidr": "104.192.136.0/21", "product": ["bitbucket"], "direction": ["egress"]},
+			{"cidr": "185.166.140.0/22", "product": ["bitbucket"], "direction": ["ingress"]},
+			{"cidr": "13.52.5.0/25", "product": ["jira"], "direction": ["egress"]},
+			{"cidr": "18.184.99.128/25"}
+		]}`))
+	}))
+	defer ts.Close()
+
+	defer func(u string, c *ipRangeCache) { AtlassianIPRangesURL, atlassianIPRanges = u, c }(AtlassianIPRangesURL, atlassianIPRanges)
+	AtlassianIPRangesURL = ts.URL
+	atlassianIPRanges = &ipRangeCache{name: "Atlassian", client: ts.Client(), fetch: fetchBitbucketRanges}
+
+	const uuid = "{a2e7c3b4-0f43-4bd8-9a6b-1d5c2e8f7a10}"
+
+	for _, tt := range []struct {
+		remoteAddr string
+		value      string
+		header     string
+		ok         bool
+	}{
+		{"104.192.136.7:443", "", "", true},
+		{"18.184.99.130:443", "", "", true},
+		{"185.166.140.1:443", "", "", false},
+		{"13.52.5.1:443", "", "", false},
+		{"104.192.136.7:443", "A2E7C3B4-0F43-4BD8-9A6B-1D5C2E8F7A10", uuid, true},
+		{"104.192.136.7:443", "other," + uuid, uuid, true},
+		{"104.192.136.7:443", "other", uuid, false},
+		{"104.192.136.7:443", uuid, "", false},
+		{"10.0.0.1:443", uuid, uuid, false},
+	} {
+		headers := map[string]interface{}{}
+		if tt.header != "" {
+			headers["X-Hook-Uuid"] = tt.header
+		}
+
+		r := MatchRule{Type: MatchBitbucket, Value: tt.value}
+		ok, err := r.Evaluate(&Request{Headers: headers, RawRequest: &http.Request{RemoteAddr: tt.remoteAddr}})
+		if ok != tt.ok || err != nil {
+			t.Errorf("%s with %q (header %q): expected %t, got %t, %v", tt.remoteAddr, tt.value, tt.header, tt.ok, ok, err)
+		}
+	}
+
+	if err := (&MatchRule{Type: MatchBitbucket, RefreshInterval: "x"}).Validate(); err == nil {
+		t.Errorf("expected an error for an invalid refresh-interval")
+	}
+}
+
+func TestCheckStandardWebhooksSignature(t *testing.T) {
+	defer func(now func() time.Time) { Now = now }(Now)
+	Now = func() time.Time { return time.Unix(1614265330, 0).Add(time.Minute) }
+
+	const (
+		secret = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+		valid  = "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE="
+	)
+
+	body := []byte(`{"test": 2432232314}`)
+
+	headers := func(prefix, timestamp, signature string) map[string]interface{} {
+		return map[string]interface{}{
+			prefix + "-Id":        "msg_p5jXN8AQM9LWM0D4loKWxJek",
+			prefix + "-Timestamp": timestamp,
+			prefix + "-Signature": signature,
+		}
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		secret  string
+		headers map[string]interface{}
+		ok      bool
+		err     bool
+	}{
+		{"valid", secret, headers("Webhook", "1614265330", valid), true, false},
+		{"svix headers", secret, headers("Svix", "1614265330", valid), true, false},
+		{"secret without prefix", "MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw", headers("Webhook", "1614265330", valid), true, false},
+		{"multiple signatures", secret, headers("Webhook", "1614265330", "v1,c2lnbmF0dXJl v1a,abc "+valid), true, false},
+		{"wrong version", secret, headers("Webhook", "1614265330", "v2,"+valid[3:]), false, true},
+		{"wrong secret", "whsec_c2VjcmV0", headers("Webhook", "1614265330", valid), false, true},
+		{"outdated", secret, headers("Webhook", "1614264000", valid), false, true},
+		{"invalid timestamp", secret, headers("Webhook", "yesterday", valid), false, true},
+		{"missing headers", secret, map[string]interface{}{}, false, false},
+	} {
+		r := MatchRule{Type: StandardWebhooks, Secret: Secrets{tt.secret}}
+
+		ok, err := r.Evaluate(&Request{Body: body, Headers: tt.headers})
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: got %t, %v", tt.desc, ok, err)
+		}
+	}
+}
+
+func TestCheckMailgunSignature(t *testing.T) {
+	defer func(now func() time.Time, tokens *nonceCache) { Now, mailgunTokens = now, tokens }(Now, mailgunTokens)
+	Now = func() time.Time { return time.Unix(1529006854, 0) }
+	mailgunTokens = newNonceCache()
+
+	const (
+		key       = "key-3ax6xnjp29jd6fds4gc373sgvjxteol0"
+		token     = "a8ce0edb2dd8301dee6c2405235584e45aa91d1e9f979f3de0"
+		signature = "b63c0701c4f4b614f272106a1b367c5c3369bcdca664ae73ebd52787e45eef07"
+	)
+
+	jsonPayload := func(timestamp, token, signature interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"signature":  map[string]interface{}{"timestamp": timestamp, "token": token, "signature": signature},
+			"event-data": map[string]interface{}{"event": "delivered"},
+		}
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		payload map[string]interface{}
+		ok      bool
+		err     bool
+	}{
+		{"json", jsonPayload("1529006854", token, signature), true, false},
+		{"replayed token", jsonPayload("1529006854", token, signature), false, true},
+		{"wrong signature", jsonPayload("1529006854", "other", signature), false, true},
+		{"missing fields", map[string]interface{}{"event-data": map[string]interface{}{}}, false, false},
+	} {
+		r := MatchRule{Type: MailgunSignature, Secret: Secrets{key}}
+
+		req := &Request{Payload: tt.payload}
+		ok, err := r.Evaluate(req)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: got %t, %v", tt.desc, ok, err)
+		}
+
+		if ok {
+			req.RecordNonces()
+		}
+	}
+
+	// Legacy form payloads hold the fields at the top level.  Checking the
+	// signature doesn't use the token up, only accepting the request does.
+	mailgunTokens = newNonceCache()
+	form := map[string]interface{}{"timestamp": "1529006854", "token": token, "signature": signature}
+	req := &Request{Payload: form}
+	for i := 0; i < 2; i++ {
+		if ok, err := CheckMailgunSignature(req, key); !ok || err != nil {
+			t.Errorf("form: got %t, %v", ok, err)
+		}
+	}
+
+	if !req.RecordNonces() {
+		t.Fatalf("expected the token to be recorded")
+	}
+
+	if ok, err := CheckMailgunSignature(&Request{Payload: form}, key); ok || err == nil {
+		t.Errorf("replayed form: got %t, %v", ok, err)
+	}
+
+	if (&Request{nonces: req.nonces}).RecordNonces() {
+		t.Errorf("expected recording the token twice to be a replay")
+	}
+
+	// Failed requests can be retried.
+	req.ForgetNonces()
+	if ok, err := CheckMailgunSignature(&Request{Payload: form}, key); !ok || err != nil {
+		t.Errorf("retried form: got %t, %v", ok, err)
+	}
+
+	req.RecordNonces()
+
+	// Tokens are forgotten once the timestamp is no longer accepted anyway.
+	Now = func() time.Time { return time.Unix(1529006854, 0).Add(2*MailgunTolerance + time.Second) }
+	if mailgunTokens.Seen(token, time.Minute) {
+		t.Errorf("expected the token to expire")
+	}
+
+	Now = func() time.Time { return time.Unix(1529006854, 0).Add(MailgunTolerance + time.Second) }
+	mailgunTokens = newNonceCache()
+	if ok, err := CheckMailgunSignature(&Request{Payload: form}, key); ok || err == nil {
+		t.Errorf("outdated: got %t, %v", ok, err)
+	}
+}
+
+func TestCheckPayPalSignature(t *testing.T) {
+	caKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	issue := func(name string) (*rsa.PrivateKey, []byte) {
+		key, _ := rsa.GenerateKey(rand.Reader, 2048)
+		der, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+		}, ca, &key.PublicKey, caKey)
+
+		return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	signingKey, signingCert := issue("messageverificationcerts.paypal.com")
+	_, otherCert := issue("www.example.com")
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/other" {
+			w.Write(otherCert)
+			return
 		}
-		ok, err := tt.rule.Evaluate(r)
+		w.Write(signingCert)
+	}))
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	defer func(hosts []string, c *payPalCertCache) { PayPalCertHosts, payPalCerts = hosts, c }(PayPalCertHosts, payPalCerts)
+	PayPalCertHosts = []string{"127.0.0.1"}
+	payPalCerts = &payPalCertCache{certs: make(map[string]*x509.Certificate), client: ts.Client(), roots: roots}
+
+	body := []byte(`{"id":"WH-2WR32451HC0233532-67976317FL4543714","event_type":"PAYMENT.SALE.COMPLETED"}`)
+
+	sign := func(webhookID string) string {
+		msg := fmt.Sprintf("b2384410-f8d2-11e8-9b3a-4bc8e3e4f5a8|2018-12-05T23:01:10Z|%s|%d", webhookID, crc32.ChecksumIEEE(body))
+		sum := sha256.Sum256([]byte(msg))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, signingKey, crypto.SHA256, sum[:])
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	headers := func(sig, certURL string) map[string]interface{} {
+		return map[string]interface{}{
+			"Paypal-Transmission-Id":   "b2384410-f8d2-11e8-9b3a-4bc8e3e4f5a8",
+			"Paypal-Transmission-Time": "2018-12-05T23:01:10Z",
+			"Paypal-Transmission-Sig":  sig,
+			"Paypal-Cert-Url":          certURL,
+			"Paypal-Auth-Algo":         "SHA256withRSA",
+		}
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		headers map[string]interface{}
+		ok      bool
+		err     bool
+	}{
+		{"valid", headers(sign("1JE4291016473214C"), ts.URL+"/cert.pem"), true, false},
+		{"other webhook", headers(sign("9NB01537VN0453254"), ts.URL+"/cert.pem"), false, true},
+		{"not a signing certificate", headers(sign("1JE4291016473214C"), ts.URL+"/other"), false, true},
+		{"untrusted host", headers(sign("1JE4291016473214C"), "https://example.com/cert.pem"), false, true},
+		{"plain http", headers(sign("1JE4291016473214C"), strings.Replace(ts.URL, "https", "http", 1)+"/cert.pem"), false, true},
+		{"missing headers", map[string]interface{}{}, false, false},
+	} {
+		r := MatchRule{Type: PayPalSignature, Value: "1JE4291016473214C"}
+
+		ok, err := r.Evaluate(&Request{Body: body, Headers: tt.headers})
 		if ok != tt.ok || (err != nil) != tt.err {
-			t.Errorf("failed to match %#v:\nexpected ok: %#v, err: %v\ngot ok: %#v, err: %v", tt.desc, tt.ok, tt.err, ok, err)
+			t.Errorf("%s: got %t, %v", tt.desc, ok, err)
 		}
 	}
+
+	// Concurrent requests for a certificate share a fetch, made without
+	// holding the lock.
+	var fetches int32
+
+	started, release := make(chan struct{}), make(chan struct{})
+
+	slow := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			close(started)
+		}
+		<-release
+		w.Write(signingCert)
+	}))
+	defer slow.Close()
+
+	payPalCerts = &payPalCertCache{certs: make(map[string]*x509.Certificate), client: slow.Client(), roots: roots}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := payPalCerts.Get(slow.URL + "/cert.pem"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	<-started
+
+	payPalCerts.mu.Lock()
+	payPalCerts.mu.Unlock()
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected concurrent requests to share a fetch, got %d fetches", n)
+	}
+
+	if err := (&MatchRule{Type: PayPalSignature}).Validate(); err == nil {
+		t.Errorf("expected an error for a paypal-signature rule without webhook ID")
+	}
 }
 
-var orRuleTests = []struct {
-	desc                    string // description of the test case
-	rule                    OrRule
-	headers, query, payload map[string]interface{}
-	body                    []byte
-	ok                      bool
-	err                     bool
-}{
-	{
-		"(a=z, b=X): a=z || b=y",
-		OrRule{
-			{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}},
-			{Match: &MatchRule{"value", "", "", "y", Argument{"header", "b", "", false}, ""}},
-		},
-		map[string]interface{}{"A": "z", "B": "X"}, nil, nil,
-		[]byte{},
-		true, false,
-	},
-	{
-		"(a=X, b=y): a=z || b=y",
-		OrRule{
-			{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}},
-			{Match: &MatchRule{"value", "", "", "y", Argument{"header", "b", "", false}, ""}},
-		},
-		map[string]interface{}{"A": "X", "B": "y"}, nil, nil,
-		[]byte{},
-		true, false,
-	},
-	{
-		"(a=Z, b=Y): a=z || b=y",
-		OrRule{
-			{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}},
-			{Match: &MatchRule{"value", "", "", "y", Argument{"header", "b", "", false}, ""}},
-		},
-		map[string]interface{}{"A": "Z", "B": "Y"}, nil, nil,
-		[]byte{},
-		false, false,
-	},
-	// failures
-	{
-		"missing parameter node",
-		OrRule{
-			{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}},
+func TestCheckDocuSignSignature(t *testing.T) {
+	body := []byte(`{"event":"envelope-completed"}`)
+
+	sign := func(secret string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		secret  Secrets
+		headers map[string]interface{}
+		ok      bool
+		err     bool
+	}{
+		{"valid", Secrets{"old"}, map[string]interface{}{"X-Docusign-Signature-1": sign("old")}, true, false},
+		{"second key", Secrets{"new"}, map[string]interface{}{"X-Docusign-Signature-1": sign("old"), "X-Docusign-Signature-2": sign("new")}, true, false},
+		{"any configured key", Secrets{"other", "new"}, map[string]interface{}{"X-Docusign-Signature-1": sign("new")}, true, false},
+		{"wrong signature", Secrets{"new"}, map[string]interface{}{"X-Docusign-Signature-1": sign("old")}, false, true},
+		{"missing header", Secrets{"new"}, map[string]interface{}{}, false, false},
+	} {
+		r := MatchRule{Type: DocuSignHMAC, Secret: tt.secret}
+
+		ok, err := r.Evaluate(&Request{Body: body, Headers: tt.headers})
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: got %t, %v", tt.desc, ok, err)
+		}
+	}
+
+	if err := (&MatchRule{Type: DocuSignHMAC}).Validate(); err == nil {
+		t.Errorf("expected an error for a docusign-signature rule without secret")
+	}
+}
+
+func TestReplayProtection(t *testing.T) {
+	defer func(now func() time.Time) { Now = now }(Now)
+
+	start := time.Now()
+	Now = func() time.Time { return start }
+
+	h := &Hook{
+		ID: "deploy",
+		ReplayProtection: &ReplayProtection{
+			Keys:   []Argument{{Source: SourceHeader, Name: "X-Github-Delivery"}},
+			Window: "10m",
 		},
-		map[string]interface{}{"Y": "Z"}, nil, nil,
-		[]byte{},
-		false, false,
-	},
+	}
+
+	delivery := func(id string) *Request {
+		return &Request{Headers: map[string]interface{}{"X-Github-Delivery": id}}
+	}
+
+	for _, tt := range []struct {
+		desc   string
+		id     string
+		after  time.Duration
+		replay bool
+	}{
+		{"first delivery", "72d3162e", 0, false},
+		{"replayed", "72d3162e", time.Minute, true},
+		{"other delivery", "a1b2c3d4", time.Minute, false},
+		{"after the window", "72d3162e", 11 * time.Minute, false},
+	} {
+		Now = func() time.Time { return start.Add(tt.after) }
+
+		req := delivery(tt.id)
+
+		replay, err := h.IsReplay(req)
+		if err != nil || replay != tt.replay {
+			t.Errorf("%s: got %t, %v, want %t", tt.desc, replay, err, tt.replay)
+		}
+
+		if !replay && !req.RecordNonces() {
+			t.Errorf("%s: expected the delivery key to be recorded", tt.desc)
+		}
+	}
+
+	// Keys are only recorded once the request is accepted, and forgotten if
+	// its command fails.
+	req := delivery("e5f6a7b8")
+	if replay, _ := h.IsReplay(req); replay {
+		t.Fatalf("expected a new delivery not to be a replay")
+	}
+
+	if replay, _ := h.IsReplay(delivery("e5f6a7b8")); replay {
+		t.Errorf("expected a delivery whose key isn't recorded yet not to be a replay")
+	}
+
+	req.RecordNonces()
+	req.ForgetNonces()
+
+	if replay, _ := h.IsReplay(delivery("e5f6a7b8")); replay {
+		t.Errorf("expected a retried delivery not to be a replay after a failure")
+	}
+
+	// Keys are remembered per hook.
+	if replay, _ := (&Hook{ID: "other", ReplayProtection: h.ReplayProtection}).IsReplay(delivery("a1b2c3d4")); replay {
+		t.Errorf("expected a delivery to another hook not to be a replay")
+	}
+
+	if _, err := h.IsReplay(&Request{Headers: map[string]interface{}{}}); err == nil {
+		t.Errorf("expected an error for a missing delivery ID")
+	}
+
+	if errs := (&ReplayProtection{Window: "soon"}).Validate(); len(errs) != 2 {
+		t.Errorf("expected missing keys and invalid window errors, got %v", errs)
+	}
 }
 
-func TestOrRule(t *testing.T) {
-	for _, tt := range orRuleTests {
-		r := &Request{
-			Headers: tt.headers,
-			Query:   tt.query,
-			Payload: tt.payload,
-			Body:    tt.body,
+func TestNonceCacheEviction(t *testing.T) {
+	defer func(now func() time.Time) { Now = now }(Now)
+
+	start := time.Now()
+	Now = func() time.Time { return start }
+
+	c := newNonceCache()
+
+	c.Seen("short", time.Minute)
+	for i := 1; i < maxNonces; i++ {
+		c.Seen(strconv.Itoa(i), time.Hour)
+	}
+
+	// Expired values are removed first.
+	Now = func() time.Time { return start.Add(2 * time.Minute) }
+
+	c.Seen("a", time.Hour)
+	if c.Contains("short") || !c.Contains("1") || len(c.m) != maxNonces {
+		t.Fatalf("expected the expired value to be removed, got %d values", len(c.m))
+	}
+
+	// Then the oldest recorded ones, rather than all of them.
+	c.Seen("b", time.Hour)
+	if c.Contains("1") || !c.Contains("2") || !c.Contains("a") || !c.Contains("b") || len(c.m) != maxNonces {
+		t.Errorf("expected only the oldest value to be removed, got %d values", len(c.m))
+	}
+
+	c.Forget("2")
+	if c.Contains("2") || len(c.m) != c.order.Len() {
+		t.Errorf("expected the forgotten value to be removed")
+	}
+}
+
+func TestCheckTimestampFresh(t *testing.T) {
+	defer func(now func() time.Time) { Now = now }(Now)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	Now = func() time.Time { return now }
+
+	for _, tt := range []struct {
+		desc   string
+		value  string
+		layout string
+		maxAge string
+		ok     bool
+		err    bool
+	}{
+		{"unix", "1709294280", "", "", true, false},
+		{"unix too old", "1709294000", "", "", false, false},
+		{"unix in the future", "1709296000", "unix", "", false, false},
+		{"unix-ms", "1709294399500", "unix-ms", "", true, false},
+		{"http date", "Fri, 01 Mar 2024 11:58:00 GMT", "http", "", true, false},
+		{"go layout", "2024-03-01T11:50:00Z", time.RFC3339, "15m", true, false},
+		{"go layout too old", "2024-03-01T11:50:00Z", time.RFC3339, "5m", false, false},
+		{"invalid", "yesterday", "", "", false, true},
+	} {
+		r := MatchRule{Type: MatchTimestamp, Layout: tt.layout, MaxAge: tt.maxAge, Parameter: Argument{Source: SourceHeader, Name: "X-Timestamp"}}
+
+		ok, err := r.Evaluate(&Request{Headers: map[string]interface{}{"X-Timestamp": tt.value}})
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("%s: got %t, %v", tt.desc, ok, err)
 		}
-		ok, err := tt.rule.Evaluate(r)
+	}
+
+	for _, r := range []MatchRule{
+		{Type: MatchTimestamp},
+		{Type: MatchTimestamp, MaxAge: "-1m", Parameter: Argument{Source: SourceHeader, Name: "X-Timestamp"}},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", r)
+		}
+	}
+}
+
+func TestCheckSemver(t *testing.T) {
+	for _, tt := range []struct {
+		version    string
+		constraint string
+		ok         bool
+		err        bool
+	}{
+		{"1.2.0", ">=1.2.0 <2.0.0", true, false},
+		{"v1.9.12", ">=1.2.0 <2.0.0", true, false},
+		{"2.0.0", ">=1.2.0 <2.0.0", false, false},
+		{"1.1.9", ">=1.2.0, <2.0.0", false, false},
+		{"1.5.0", ">= 1.2 < 2", true, false},
+		{"1.5.0+build.7", "1.5.0", true, false},
+		{"1.5.1", "=1.5.0", false, false},
+		{"1.5.1", "!=1.5.0", true, false},
+		{"1.3.4", "~1.3.2", true, false},
+		{"1.4.0", "~1.3.2", false, false},
+		{"1.9.0", "^1.3", true, false},
+		{"2.0.0", "^1.3", false, false},
+		{"0.3.9", "^0.3.1", true, false},
+		{"0.4.0", "^0.3.1", false, false},
+		{"0.0.4", "^0.0.3", false, false},
+		{"3.1.0", "^1.0.0 || ^3.0.0", true, false},
+		{"2.1.0", "^1.0.0 || ^3.0.0", false, false},
+		// prereleases
+		{"1.5.0-rc.1", ">=1.2.0 <2.0.0", false, false},
+		{"2.0.0-rc.1", ">=2.0.0-beta.2", true, false},
+		{"2.0.0-rc.1", ">=2.0.0-rc.2", false, false},
+		{"2.0.0-rc.10", ">2.0.0-rc.9", true, false},
+		{"2.0.0-rc.1", ">=2.0.0-1", true, false},
+		{"2.0.1-rc.1", ">=2.0.0-rc.1", true, false},
+		{"2.0.1-rc.1", ">=2.0.0", false, false},
+		{"2.0.0", ">2.0.0-rc.1", true, false},
+		// not versions
+		{"latest", ">=1.0.0", false, false},
+		{"1.2", ">=1.0.0", false, false},
+		{"01.2.3", ">=1.0.0", false, false},
+		// invalid constraints
+		{"1.2.3", "", false, true},
+		{"1.2.3", ">=", false, true},
+		{"1.2.3", "=<>1.0.0", false, true},
+		{"1.2.3", ">=1.0.0 ||", false, true},
+		{"1.2.3", ">=1.0.0.0", false, true},
+		// wildcards and ranges
+		{"1.7.2", "1.x", true, false},
+		{"2.0.0", "1.x", false, false},
+		{"1.4.0", "1.2 - 1.4", true, false},
+		{"1.5.0", "1.2 - 1.4", false, false},
+	} {
+		r := MatchRule{Type: MatchSemver, Value: tt.constraint, Parameter: Argument{Source: SourceHeader, Name: "X-Version"}}
+
+		ok, err := r.Evaluate(&Request{Headers: map[string]interface{}{"X-Version": tt.version}})
 		if ok != tt.ok || (err != nil) != tt.err {
-			t.Errorf("%#v:\nexpected ok: %#v, err: %v\ngot ok: %#v err: %v", tt.desc, tt.ok, tt.err, ok, err)
+			t.Errorf("%s %s: expected ok: %v, err: %v, got ok: %v, err: %v", tt.version, tt.constraint, tt.ok, tt.err, ok, err)
+		}
+
+		if verr := r.Validate(); (verr != nil) != tt.err {
+			t.Errorf("%s: unexpected validation result %v", tt.constraint, verr)
 		}
 	}
 }
 
-var notRuleTests = []struct {
-	desc                    string // description of the test case
-	rule                    NotRule
-	headers, query, payload map[string]interface{}
-	body                    []byte
-	ok                      bool
-	err                     bool
-}{
-	{"(a=z): !a=X", NotRule{Match: &MatchRule{"value", "", "", "X", Argument{"header", "a", "", false}, ""}}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, true, false},
-	{"(a=z): !a=z", NotRule{Match: &MatchRule{"value", "", "", "z", Argument{"header", "a", "", false}, ""}}, map[string]interface{}{"A": "z"}, nil, nil, []byte{}, false, false},
+func TestScalrSignatureDateOptions(t *testing.T) {
+	defer func(now func() time.Time) { Now = now }(Now)
+
+	signed := time.Date(2017, 9, 7, 6, 30, 4, 0, time.UTC)
+	req := &Request{
+		Headers: map[string]interface{}{"Date": "Thu 07 Sep 2017 06:30:04 UTC", "X-Signature": "48e395e38ac48988929167df531eb2da00063a7d"},
+		Body:    []byte(`{"a": "b"}`),
+	}
+
+	no := false
+
+	for _, tt := range []struct {
+		desc      string
+		after     time.Duration
+		maxAge    string
+		checkDate *bool
+		ok        bool
+	}{
+		{"fresh", 4 * time.Minute, "", nil, true},
+		{"outdated", 6 * time.Minute, "", nil, false},
+		{"within max-age", 6 * time.Minute, "10m", nil, true},
+		{"outside max-age", 30 * time.Second, "10s", nil, false},
+		{"date not checked", 24 * time.Hour, "", &no, true},
+	} {
+		Now = func() time.Time { return signed.Add(tt.after) }
+
+		r := MatchRule{Type: ScalrSignature, Secret: Secrets{"bilFGi4ZVZUdG+C6r0NIM9tuRq6PaG33R3eBUVhLwMAErGBaazvXe4Gq2DcJs5q+"}, MaxAge: tt.maxAge, CheckDate: tt.checkDate}
+
+		if ok, _ := r.Evaluate(req); ok != tt.ok {
+			t.Errorf("%s: got %t, want %t", tt.desc, ok, tt.ok)
+		}
+	}
+
+	if err := (&MatchRule{Type: ScalrSignature, Secret: Secrets{"key"}, MaxAge: "5"}).Validate(); err == nil {
+		t.Errorf("expected an error for an invalid max-age")
+	}
 }
 
-func TestNotRule(t *testing.T) {
-	for _, tt := range notRuleTests {
-		r := &Request{
-			Headers: tt.headers,
-			Query:   tt.query,
-			Payload: tt.payload,
-			Body:    tt.body,
+func TestHMACSignaturePrefix(t *testing.T) {
+	body := []byte(`{"a": "b"}`)
+	sig := "dcfe7ee825e95e01612de9bb5c6804e7d64db2f540e034ae3304d1b58bf61533"
+
+	for _, tt := range []struct {
+		desc      string
+		prefix    string
+		signature string
+		ok        bool
+	}{
+		{"default prefix", "", "sha256=" + sig, true},
+		{"default prefix, bare digest", "", sig, true},
+		{"custom prefix", "hmac-sha256=", "hmac-sha256=" + sig, true},
+		{"custom prefix, multiple signatures", "hmac-sha256=", "hmac-sha256=0123,hmac-sha256=" + sig, true},
+		{"none", "none", sig, true},
+		{"none, multiple signatures", "none", "0123," + sig, true},
+		{"none, prefixed signature", "none", "sha256=" + sig, false},
+	} {
+		r := MatchRule{
+			Type:            MatchHMACSHA256,
+			Secret:          Secrets{"secret"},
+			SignaturePrefix: tt.prefix,
+			Parameter:       Argument{Source: SourceHeader, Name: "X-Signature"},
 		}
-		ok, err := tt.rule.Evaluate(r)
-		if ok != tt.ok || (err != nil) != tt.err {
-			t.Errorf("failed to match %#v:\nexpected ok: %#v, err: %v\ngot ok: %#v, err: %v", tt.rule, tt.ok, tt.err, ok, err)
+
+		ok, _ := r.Evaluate(&Request{Body: body, Headers: map[string]interface{}{"X-Signature": tt.signature}})
+		if ok != tt.ok {
+			t.Errorf("%s: got %t, want %t", tt.desc, ok, tt.ok)
 		}
 	}
 }
 
-func TestCompare(t *testing.T) {
+func TestExtractSignatures(t *testing.T) {
 	for _, tt := range []struct {
-		a, b string
-		ok   bool
+		source string
+		want   []string
 	}{
-		{"abcd", "abcd", true},
-		{"zyxw", "abcd", false},
+		{"sha256=a", []string{"a"}},
+		{"a", []string{"a"}},
+		{"sha256=a,sha256=b", []string{"a", "b"}},
+		{"sha256=a, sha256=b", []string{"a", "b"}},
+		{"a,b", []string{"a", "b"}},
+		{"sha256=a,,", []string{"a"}},
+		{"", []string{}},
 	} {
-		if ok := compare(tt.a, tt.b); ok != tt.ok {
-			t.Errorf("compare failed for %q and %q: got %v\n", tt.a, tt.b, ok)
+		if got := ExtractSignatures(tt.source, "sha256="); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExtractSignatures(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+
+	// Any of the signatures sent during a key rotation verifies the request.
+	r := MatchRule{Type: MatchHMACSHA256, Secret: Secrets{"secret"}, Parameter: Argument{Source: SourceHeader, Name: "X-Signature"}}
+	header := "sha256=0123456789abcdef, sha256=dcfe7ee825e95e01612de9bb5c6804e7d64db2f540e034ae3304d1b58bf61533"
+
+	if ok, err := r.Evaluate(&Request{Body: []byte(`{"a": "b"}`), Headers: map[string]interface{}{"X-Signature": header}}); !ok || err != nil {
+		t.Errorf("got %t, %v, want the second signature to match", ok, err)
+	}
+}
+
+// buildPlugin builds the test plugin in testdata/plugin as a WebAssembly
+// module in dir and returns its path.
+func buildPlugin(t *testing.T, dir string) string {
+	if testing.Short() {
+		t.Skip("skipping building a WebAssembly module in short mode")
+	}
+
+	path := filepath.Join(dir, "plugin.wasm")
+
+	cmd := exec.Command("go", "build", "-o", path, "./testdata/plugin")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building the plugin: %v\n%s", err, out)
+	}
+
+	return path
+}
+
+func TestHookRunPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugins-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := buildPlugin(t, dir)
+
+	h := &Hook{
+		ID:      "deploy",
+		Plugins: []Plugin{{Path: path}, {Path: path}},
+		TriggerRule: &Rules{Match: &MatchRule{
+			Type:      "value",
+			Value:     "2",
+			Parameter: Argument{Source: "plugin", Name: "runs"},
+		}},
+	}
+
+	newRequest := func(action string) *Request {
+		return &Request{
+			RawRequest: httptest.NewRequest("POST", "/hooks/deploy", nil),
+			Body:       []byte(`{"action": "` + action + `"}`),
+			Payload:    map[string]interface{}{"action": action},
+		}
+	}
+
+	r := newRequest("rewrite")
+	if err := h.RunPlugins(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := map[string]interface{}{"action": "rewrite", "rewritten": true}; !reflect.DeepEqual(r.Payload, want) {
+		t.Errorf("payload = %#v, want %#v", r.Payload, want)
+	}
+
+	for name, want := range map[string]string{"hook": "deploy", "method": "POST", "length": "21", "runs": "2"} {
+		if got, err := (&Argument{Source: "plugin", Name: name}).Get(r); err != nil || got != want {
+			t.Errorf("plugin parameter %s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	if ok, err := h.TriggerRule.Evaluate(r); !ok || err != nil {
+		t.Errorf("expected rule on plugin parameter to match, got %v, %v", ok, err)
+	}
+
+	r = newRequest("none")
+	if err := h.RunPlugins(r); err != nil || r.Plugin != nil || len(r.Payload) != 1 {
+		t.Errorf("expected the request unchanged, got %#v, %#v, %v", r.Payload, r.Plugin, err)
+	}
+
+	err = h.RunPlugins(newRequest("veto"))
+	if !IsVetoError(err) || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("expected a veto, got %v", err)
+	}
+
+	err = h.RunPlugins(newRequest("fail"))
+	if err == nil || IsVetoError(err) || !strings.Contains(err.Error(), "failing as asked") {
+		t.Errorf("expected the plugin to fail with its standard error, got %v", err)
+	}
+
+	// Plugins have no access to the file system.
+	if err := h.RunPlugins(newRequest("files")); err == nil {
+		t.Error("expected reading the file system to fail")
+	}
+
+	h.Plugins = []Plugin{{Path: path, Timeout: "100ms"}}
+
+	if err := h.RunPlugins(newRequest("loop")); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	h.Plugins = []Plugin{{Path: filepath.Join(dir, "missing.wasm")}}
+
+	if err := h.RunPlugins(newRequest("rewrite")); err == nil {
+		t.Error("expected an error for a missing module")
+	}
+}
+
+func TestPluginValidate(t *testing.T) {
+	for _, tt := range []struct {
+		plugin Plugin
+		errs   int
+	}{
+		{Plugin{Path: "check.wasm"}, 0},
+		{Plugin{Path: "check.wasm", Timeout: "1s"}, 0},
+		{Plugin{}, 1},
+		{Plugin{Path: "check.wasm", Timeout: "soon"}, 1},
+		{Plugin{Path: "check.wasm", Timeout: "-1s"}, 1},
+	} {
+		if errs := tt.plugin.Validate(); len(errs) != tt.errs {
+			t.Errorf("%+v: expected %d errors, got %v", tt.plugin, tt.errs, errs)
 		}
 	}
 }
@@ -0,0 +1,147 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DefaultHTTPAuthTimeout is how long the authorization service of http-auth
+// rules has to answer by default.
+const DefaultHTTPAuthTimeout = 5 * time.Second
+
+// maxHTTPAuthResponse is the size limit of the answers of authorization
+// services.
+const maxHTTPAuthResponse = 1 << 20
+
+var httpAuthClient = &http.Client{}
+
+// httpAuthInput is the summary of a request sent to the authorization
+// service of http-auth rules.
+type httpAuthInput struct {
+	RequestID  string                 `json:"request_id,omitempty"`
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	RemoteAddr string                 `json:"remote_addr"`
+	Headers    map[string]interface{} `json:"headers"`
+	Query      map[string]interface{} `json:"query"`
+	Payload    map[string]interface{} `json:"payload"`
+	Identity   *Identity              `json:"identity,omitempty"`
+}
+
+// checkHTTPAuth asks the authorization service of an http-auth rule whether
+// the request is allowed.  If the service fails, the rule fails with an
+// error, or matches with fail-open.
+func (r *MatchRule) checkHTTPAuth(req *Request) (bool, error) {
+	ok, err := CheckHTTPAuth(req, r.URL, r.Timeout)
+	if err != nil && r.FailOpen {
+		log.Printf("error checking authorization, allowing the request as fail-open is set: %s", err)
+		return true, nil
+	}
+
+	return ok, err
+}
+
+// CheckHTTPAuth posts a summary of the request to the authorization service
+// at url, as {"input": {...}} like Open Policy Agent expects it, and reports
+// whether it allows the request: with a 2xx response holding true in
+// "allow", "result" or "result.allow", or without a body.  401 and 403
+// responses deny the request; other responses are errors.  The service has
+// timeout to answer, DefaultHTTPAuthTimeout if empty.
+func CheckHTTPAuth(req *Request, url, timeout string) (bool, error) {
+	d := DefaultHTTPAuthTimeout
+	if timeout != "" {
+		var err error
+		if d, err = time.ParseDuration(timeout); err != nil {
+			return false, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+
+	input := httpAuthInput{
+		RequestID: req.ID,
+		Headers:   req.Headers,
+		Query:     req.Query,
+		Payload:   req.Payload,
+		Identity:  req.Identity,
+	}
+
+	if req.RawRequest != nil {
+		input.Method = req.RawRequest.Method
+		input.RemoteAddr = req.RawRequest.RemoteAddr
+		if req.RawRequest.URL != nil {
+			input.Path = req.RawRequest.URL.Path
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	hr, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	hr.Header.Set("Content-Type", "application/json")
+	hr.Header.Set("Accept", "application/json")
+
+	res, err := httpAuthClient.Do(hr)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return false, nil
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return false, fmt.Errorf("authorization service %s answered %s", url, res.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxHTTPAuthResponse))
+	if err != nil {
+		return false, err
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return true, nil
+	}
+
+	var decision struct {
+		Allow  *bool           `json:"allow"`
+		Result json.RawMessage `json:"result"`
+	}
+
+	if err := json.Unmarshal(data, &decision); err != nil {
+		return false, fmt.Errorf("error decoding the answer of %s: %w", url, err)
+	}
+
+	if decision.Allow != nil {
+		return *decision.Allow, nil
+	}
+
+	var result struct {
+		Allow *bool `json:"allow"`
+	}
+
+	var allow bool
+
+	switch {
+	case json.Unmarshal(decision.Result, &allow) == nil:
+		return allow, nil
+	case json.Unmarshal(decision.Result, &result) == nil && result.Allow != nil:
+		return *result.Allow, nil
+	}
+
+	return false, fmt.Errorf("no allow decision in the answer of %s", url)
+}
@@ -0,0 +1,197 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// includeKey is the property of hooks file objects listing the files they
+// include.
+const includeKey = "include"
+
+// resolveIncludes replaces the include directives in the JSON hooks file
+// contents in file, loaded from path, with the contents of the included
+// files, and returns the resulting JSON.
+//
+// An object with an include property, a path or a list of paths relative to
+// the including file, is merged over the objects of the included YAML, JSON,
+// TOML or HCL files, so its own properties override the included ones.  An
+// object that only has an include property may also include lists, such as
+// response headers; in a list, the included items are spliced into it.
+// Included files may include other files.
+func resolveIncludes(path string, file []byte) ([]byte, error) {
+	if !bytes.Contains(file, []byte(includeKey)) {
+		return file, nil
+	}
+
+	v, err := decodeJSONValue(file)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err = resolveIncludeValue(v, path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Included HCL files may have single blocks for lists.
+	return json.Marshal(fitHooksFile(v))
+}
+
+// decodeIncludeFile decodes the contents in file of the included file path
+// into a JSON value.
+func decodeIncludeFile(path string, file []byte) (interface{}, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return parseTOML(file)
+	case ".hcl":
+		return parseHCL(file)
+	}
+
+	return decodeJSONValue(file)
+}
+
+// decodeJSONValue decodes the JSON or YAML data into a JSON value, keeping
+// numbers as they are.
+func decodeJSONValue(data []byte) (interface{}, error) {
+	data, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// resolveIncludeValue resolves the include directives in v, found in the
+// file path, which is included depth levels deep.
+func resolveIncludeValue(v interface{}, path string, depth int) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+
+		for _, e := range v {
+			_, include := e.(map[string]interface{})
+
+			e, err := resolveIncludeValue(e, path, depth)
+			if err != nil {
+				return nil, err
+			}
+
+			if list, ok := e.([]interface{}); ok && include {
+				res = append(res, list...)
+			} else {
+				res = append(res, e)
+			}
+		}
+
+		return res, nil
+
+	case map[string]interface{}:
+		for k, e := range v {
+			e, err := resolveIncludeValue(e, path, depth)
+			if err != nil {
+				return nil, err
+			}
+
+			v[k] = e
+		}
+
+		include, ok := v[includeKey]
+		if !ok {
+			return v, nil
+		}
+
+		delete(v, includeKey)
+
+		return includeFiles(v, include, path, depth)
+	}
+
+	return v, nil
+}
+
+// includeFiles returns the object v merged over the contents of the files
+// listed in include, relative to the including file path.
+func includeFiles(v map[string]interface{}, include interface{}, path string, depth int) (interface{}, error) {
+	var names []string
+
+	switch include := include.(type) {
+	case string:
+		names = []string{include}
+	case []interface{}:
+		for _, name := range include {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: include must be a path or a list of paths", path)
+			}
+
+			names = append(names, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s: include must be a path or a list of paths", path)
+	}
+
+	if depth >= maxIncludeDepth {
+		return nil, fmt.Errorf("error including %s: maximum include depth of %d exceeded", strings.Join(names, ", "), maxIncludeDepth)
+	}
+
+	merged := make(map[string]interface{})
+	list := make([]interface{}, 0)
+	lists := false
+
+	for _, name := range names {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("error including %s: %w", name, err)
+		}
+
+		e, err := decodeIncludeFile(name, b)
+		if err != nil {
+			return nil, fmt.Errorf("error including %s: %w", name, err)
+		}
+
+		e, err = resolveIncludeValue(e, name, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e := e.(type) {
+		case map[string]interface{}:
+			mergeValues(merged, e)
+		case []interface{}:
+			list = append(list, e...)
+			lists = true
+		default:
+			return nil, fmt.Errorf("error including %s: not an object or a list", name)
+		}
+	}
+
+	if lists {
+		if len(merged) != 0 || len(v) != 0 {
+			return nil, fmt.Errorf("%s: included lists can't be merged with objects", path)
+		}
+
+		return list, nil
+	}
+
+	mergeValues(merged, v)
+
+	return merged, nil
+}
@@ -0,0 +1,93 @@
+package hook
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ipRangeCache caches the IP ranges a provider sends webhook deliveries from,
+// as published by the provider.
+type ipRangeCache struct {
+	mu      sync.Mutex
+	ranges  string
+	fetched time.Time
+	client  *http.Client
+
+	// name is the provider name used in log messages.
+	name string
+
+	// fetch returns the space-separated IP ranges of the provider.
+	fetch func(client *http.Client) (string, error)
+
+	// inflight is the fetch in progress, if any, which concurrent requests
+	// wait for.
+	inflight *ipRangeFetch
+}
+
+// ipRangeFetch is a fetch of the IP ranges in progress.
+type ipRangeFetch struct {
+	done   chan struct{}
+	ranges string
+	err    error
+}
+
+// Ranges returns the space-separated IP ranges, fetching them if they are
+// older than refresh.  If fetching fails, previously fetched ranges are used.
+// The ranges are fetched without holding the lock, and concurrent requests
+// share a fetch.
+func (c *ipRangeCache) Ranges(refresh time.Duration) (string, error) {
+	c.mu.Lock()
+
+	if c.ranges != "" && time.Since(c.fetched) < refresh {
+		ranges := c.ranges
+		c.mu.Unlock()
+		return ranges, nil
+	}
+
+	if f := c.inflight; f != nil {
+		c.mu.Unlock()
+		<-f.done
+		return f.ranges, f.err
+	}
+
+	f := &ipRangeFetch{done: make(chan struct{})}
+	c.inflight = f
+	c.mu.Unlock()
+
+	ranges, err := c.fetch(c.client)
+
+	c.mu.Lock()
+	c.inflight = nil
+
+	switch {
+	case err == nil:
+		c.ranges = ranges
+		c.fetched = time.Now()
+
+	case c.ranges != "":
+		log.Printf("error refreshing %s IP ranges, using ranges fetched at %s: %s", c.name, c.fetched.Format(time.RFC3339), err)
+
+		// Retry on the next refresh rather than on every request.
+		c.fetched = time.Now()
+		err = nil
+	}
+
+	f.ranges, f.err = c.ranges, err
+	c.mu.Unlock()
+
+	close(f.done)
+
+	return f.ranges, f.err
+}
+
+// parseRefreshInterval returns the refresh-interval s as a duration, or def if
+// s is empty.
+func parseRefreshInterval(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,103 @@
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// jweAlgorithms are the supported key management algorithms.
+var jweAlgorithms = map[string]bool{
+	"dir": true, "A128KW": true, "A192KW": true, "A256KW": true, "RSA-OAEP": true, "RSA-OAEP-256": true,
+}
+
+// jweEncryptions are the supported content encryption algorithms.
+var jweEncryptions = map[string]bool{
+	"A128GCM": true, "A192GCM": true, "A256GCM": true, "A128CBC-HS256": true, "A192CBC-HS384": true, "A256CBC-HS512": true,
+}
+
+// decryptJWE decrypts a JWE in compact serialization (RFC 7516), whose alg
+// and enc headers must be among the algorithms and encryptions of d.
+func decryptJWE(d *PayloadDecryption, body []byte) ([]byte, string, error) {
+	algs := make([]jose.KeyAlgorithm, 0, len(jweAlgorithms))
+	for alg := range jweAlgorithms {
+		algs = append(algs, jose.KeyAlgorithm(alg))
+	}
+
+	encs := make([]jose.ContentEncryption, 0, len(jweEncryptions))
+	for enc := range jweEncryptions {
+		encs = append(encs, jose.ContentEncryption(enc))
+	}
+
+	obj, err := jose.ParseEncryptedCompact(strings.TrimSpace(string(body)), algs, encs)
+	if err != nil {
+		return nil, "", fmt.Errorf("payload is not a valid JWE in compact serialization: %w", err)
+	}
+
+	alg := obj.Header.Algorithm
+	enc, _ := obj.Header.ExtraHeaders[jose.HeaderKey("enc")].(string)
+
+	if !containsString(d.Algorithms, alg) {
+		return nil, "", fmt.Errorf("JWE algorithm %q is not accepted", alg)
+	}
+
+	if !containsString(d.Encryptions, enc) {
+		return nil, "", fmt.Errorf("JWE encryption %q is not accepted", enc)
+	}
+
+	if zip, ok := obj.Header.ExtraHeaders[jose.HeaderKey("zip")]; ok {
+		return nil, "", fmt.Errorf("unsupported JWE compression %v", zip)
+	}
+
+	key, err := d.ReadKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := checkJWEKey(alg, enc, key); err != nil {
+		return nil, "", err
+	}
+
+	plaintext, err := obj.Decrypt(key)
+	if err != nil {
+		return nil, "", errors.New("JWE decryption failed")
+	}
+
+	cty, _ := obj.Header.ExtraHeaders[jose.HeaderContentType].(string)
+
+	return plaintext, cty, nil
+}
+
+// checkJWEKey returns an error if key can't be used with the key management
+// algorithm alg and the content encryption algorithm enc, which would
+// otherwise only be reported as a failed decryption.
+func checkJWEKey(alg, enc string, key interface{}) error {
+	switch alg {
+	case "dir", "A128KW", "A192KW", "A256KW":
+		k, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("JWE algorithm %s requires a symmetric key", alg)
+		}
+
+		want := map[string]int{"A128KW": 16, "A192KW": 24, "A256KW": 32}[alg]
+		if alg == "dir" {
+			want = map[string]int{
+				"A128GCM": 16, "A192GCM": 24, "A256GCM": 32,
+				"A128CBC-HS256": 32, "A192CBC-HS384": 48, "A256CBC-HS512": 64,
+			}[enc]
+		}
+
+		if len(k) != want {
+			return fmt.Errorf("JWE algorithm %s with encryption %s requires a %d byte key", alg, enc, want)
+		}
+
+	default:
+		if _, ok := key.([]byte); ok {
+			return fmt.Errorf("JWE algorithm %s requires an RSA private key", alg)
+		}
+	}
+
+	return nil
+}
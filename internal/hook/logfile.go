@@ -0,0 +1,89 @@
+package hook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultLogMaxSize is the size in megabytes at which hook log files are
+// rotated if the log-file settings don't set a max-size.
+const DefaultLogMaxSize = 100
+
+// LogFile defines the file the requests to a hook and the output of its
+// command are logged to, and how it is rotated.
+type LogFile struct {
+	// Path is the path of the log file.  Rotated files are kept next to it,
+	// with the time of the rotation appended to the name.
+	Path string `json:"path"`
+
+	// MaxSize is the size in megabytes at which the file is rotated.
+	// Defaults to DefaultLogMaxSize.
+	MaxSize int `json:"max-size,omitempty"`
+
+	// RotateEvery rotates the file when a period of the given duration
+	// passed since its last write, counted from midnight UTC, ie. "24h"
+	// rotates on the first write of every day.
+	RotateEvery string `json:"rotate-every,omitempty"`
+
+	// MaxBackups is the number of rotated files kept, or 0 to keep all.
+	MaxBackups int `json:"max-backups,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting a path as a shorthand
+// for a log file with the default rotation.
+func (l *LogFile) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*l = LogFile{Path: path}
+		return nil
+	}
+
+	type logFile LogFile
+
+	return json.Unmarshal(data, (*logFile)(l))
+}
+
+// MaxBytes returns the size in bytes at which the file is rotated.
+func (l *LogFile) MaxBytes() int64 {
+	if l.MaxSize <= 0 {
+		return DefaultLogMaxSize << 20
+	}
+
+	return int64(l.MaxSize) << 20
+}
+
+// Interval returns the rotation period, or 0 if the file is only rotated by
+// size.
+func (l *LogFile) Interval() time.Duration {
+	d, _ := time.ParseDuration(l.RotateEvery)
+	return d
+}
+
+// Validate returns the problems found in the log file settings.
+func (l *LogFile) Validate() []error {
+	var errs []error
+
+	if l.Path == "" {
+		errs = append(errs, errors.New("log-file requires a path"))
+	}
+
+	if l.MaxSize < 0 {
+		errs = append(errs, fmt.Errorf("log-file max-size %d is negative", l.MaxSize))
+	}
+
+	if l.MaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("log-file max-backups %d is negative", l.MaxBackups))
+	}
+
+	if l.RotateEvery != "" {
+		if d, err := time.ParseDuration(l.RotateEvery); err != nil {
+			errs = append(errs, fmt.Errorf("invalid log-file rotate-every: %w", err))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("log-file rotate-every %s is not positive", l.RotateEvery))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,84 @@
+package hook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// MailgunTolerance is how far the timestamp of Mailgun requests may be from
+// the current time.  Tokens of accepted requests are remembered for twice as
+// long, so a request can't be replayed while its timestamp is accepted.
+const MailgunTolerance = 5 * time.Minute
+
+var mailgunTokens = newNonceCache()
+
+// mailgunField returns the value of the Mailgun signature field name from m as
+// a string.
+func mailgunField(m map[string]interface{}, name string) string {
+	switch v := m[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return ""
+}
+
+// CheckMailgunSignature checks the signature of a Mailgun webhook request,
+// the hex encoded HMAC-SHA256 of the timestamp and token computed with the
+// webhook signing key.  The fields are read from the "signature" object of
+// JSON payloads, or from the top level of form payloads sent by legacy
+// webhooks and routes.  Requests with a timestamp more than MailgunTolerance
+// away from the current time, or with the token of an accepted request, are
+// rejected.  The token is recorded by Request.RecordNonces once the request
+// is accepted.
+func CheckMailgunSignature(r *Request, signingKey string) (bool, error) {
+	fields := r.Payload
+	if sig, ok := r.Payload["signature"].(map[string]interface{}); ok {
+		fields = sig
+	}
+
+	timestamp := mailgunField(fields, "timestamp")
+	token := mailgunField(fields, "token")
+	signature := mailgunField(fields, "signature")
+
+	if timestamp == "" || token == "" || signature == "" {
+		return false, nil
+	}
+
+	if signingKey == "" {
+		return false, errors.New("signature validation signing key can not be empty")
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(timestamp + token))
+
+	if b, err := hex.DecodeString(signature); err != nil || !hmac.Equal(b, mac.Sum(nil)) {
+		return false, &SignatureError{Signature: signature}
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, &SignatureError{Signature: "invalid timestamp " + timestamp}
+	}
+
+	if d := Now().Sub(time.Unix(seconds, 0)); d > MailgunTolerance || d < -MailgunTolerance {
+		return false, &SignatureError{Signature: "outdated"}
+	}
+
+	if mailgunTokens.Contains(token) {
+		return false, &SignatureError{Signature: "replayed token " + token}
+	}
+
+	r.addNonce(mailgunTokens, token, 2*MailgunTolerance)
+
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package hook
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Matcher evaluates match rules of a custom type, such as a proprietary
+// signature scheme or a business rule, registered with RegisterMatcher.
+type Matcher interface {
+	// Match reports whether the request req satisfies the match rule r.
+	Match(r *MatchRule, req *Request) (bool, error)
+
+	// Validate returns an error if the match rule r is invalid, ie. lacks
+	// its secret or options.
+	Validate(r *MatchRule) error
+}
+
+// builtinMatchTypes are the match rule types implemented by this package.
+var builtinMatchTypes = map[string]bool{
+	MatchValue: true, MatchRegex: true, MatchHMACSHA1: true, MatchHMACSHA256: true,
+	MatchHMACSHA512: true, MatchHMAC: true, MatchHashSHA1: true, MatchHashSHA256: true,
+	MatchHashSHA512: true, IPWhitelist: true, IPBlacklist: true, GeoIPCountry: true,
+	DNSWhitelist: true, ScalrSignature: true, MatchHTTPMethod: true, MatchURLPath: true,
+	MatchGT: true, MatchLT: true, MatchGTE: true, MatchLTE: true, MatchBetween: true,
+	MatchGlob: true, MatchCompare: true, MatchSemver: true, GitHubWhitelist: true,
+	MatchSSOGroup: true, ShopifySignature: true, GiteaSignature: true, GiteaEvent: true,
+	MatchBitbucket: true, StandardWebhooks: true, MailgunSignature: true,
+	PayPalSignature: true, DocuSignHMAC: true, MatchTimestamp: true, MatchCommand: true,
+	MatchHTTPAuth: true,
+}
+
+var matchers = struct {
+	sync.RWMutex
+	m map[string]Matcher
+}{m: make(map[string]Matcher)}
+
+// RegisterMatcher makes the Matcher m evaluate match rules of type typ.  It is
+// meant to be called from init functions, and panics if typ is empty, a
+// built-in type or already registered.
+func RegisterMatcher(typ string, m Matcher) {
+	matchers.Lock()
+	defer matchers.Unlock()
+
+	switch {
+	case typ == "":
+		panic("hook: RegisterMatcher with an empty type")
+	case m == nil:
+		panic("hook: RegisterMatcher with a nil Matcher for type " + typ)
+	case builtinMatchTypes[typ]:
+		panic("hook: RegisterMatcher with built-in type " + typ)
+	}
+
+	if _, dup := matchers.m[typ]; dup {
+		panic("hook: RegisterMatcher called twice for type " + typ)
+	}
+
+	matchers.m[typ] = m
+}
+
+// Matchers returns the registered match rule types, sorted.
+func Matchers() []string {
+	matchers.RLock()
+	defer matchers.RUnlock()
+
+	types := make([]string, 0, len(matchers.m))
+	for typ := range matchers.m {
+		types = append(types, typ)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
+
+// lookupMatcher returns the Matcher registered for typ, or nil.
+func lookupMatcher(typ string) Matcher {
+	matchers.RLock()
+	defer matchers.RUnlock()
+
+	return matchers.m[typ]
+}
+
+// validateCustom validates the match rule r of a registered type.
+func (r *MatchRule) validateCustom() error {
+	m := lookupMatcher(r.Type)
+	if m == nil {
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+
+	return m.Validate(r)
+}
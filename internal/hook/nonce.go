@@ -0,0 +1,142 @@
+package hook
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// maxNonces limits the number of values remembered by a nonceCache.  When the
+// cache is full, expired values are removed, and if none are expired, the
+// oldest recorded ones, which weakens replay protection only for them under a
+// flood of distinct valid requests.
+const maxNonces = 100000
+
+// nonceCache remembers values such as tokens or delivery IDs for a time, to
+// reject requests replaying them.
+type nonceCache struct {
+	mu sync.Mutex
+	m  map[string]*list.Element
+
+	// order holds the *nonceEntry of each value, oldest recorded first.
+	order *list.List
+}
+
+// nonceEntry is a value remembered by a nonceCache until it expires.
+type nonceEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{m: make(map[string]*list.Element), order: list.New()}
+}
+
+// Seen records key for the duration ttl and reports whether it was already
+// recorded and hasn't expired.
+func (c *nonceCache) Seen(key string, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := Now()
+
+	if e, ok := c.m[key]; ok {
+		if now.Before(e.Value.(*nonceEntry).expires) {
+			return true
+		}
+
+		c.remove(e)
+	}
+
+	if len(c.m) >= maxNonces {
+		for e := c.order.Front(); e != nil; {
+			next := e.Next()
+			if !now.Before(e.Value.(*nonceEntry).expires) {
+				c.remove(e)
+			}
+			e = next
+		}
+
+		for len(c.m) >= maxNonces {
+			c.remove(c.order.Front())
+		}
+	}
+
+	c.m[key] = c.order.PushBack(&nonceEntry{key, now.Add(ttl)})
+
+	return false
+}
+
+// remove removes the value of e.  The caller must hold c.mu.
+func (c *nonceCache) remove(e *list.Element) {
+	delete(c.m, e.Value.(*nonceEntry).key)
+	c.order.Remove(e)
+}
+
+// Contains reports whether key is recorded and hasn't expired, without
+// recording it.
+func (c *nonceCache) Contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.m[key]
+
+	return ok && Now().Before(e.Value.(*nonceEntry).expires)
+}
+
+// Forget removes key, so that it is accepted again.
+func (c *nonceCache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.m[key]; ok {
+		c.remove(e)
+	}
+}
+
+// nonce is a single-use value checked by a rule, to be recorded in cache for
+// ttl once the request is accepted.
+type nonce struct {
+	cache *nonceCache
+	key   string
+	ttl   time.Duration
+}
+
+// addNonce adds the single-use value key of cache to the values recorded by
+// RecordNonces, unless a rule already added it.
+func (r *Request) addNonce(cache *nonceCache, key string, ttl time.Duration) {
+	for _, n := range r.nonces {
+		if n.cache == cache && n.key == key {
+			return
+		}
+	}
+
+	r.nonces = append(r.nonces, nonce{cache, key, ttl})
+}
+
+// RecordNonces records the single-use values, such as Mailgun tokens, that
+// rules checked while evaluating the trigger rule of r.  Rules only check
+// them, so that dry runs and requests failing the trigger rule don't use them
+// up; it is called once the trigger rule is satisfied.  It reports false if
+// one of them was recorded by a concurrent request in the meantime, which
+// makes r a replay.
+func (r *Request) RecordNonces() bool {
+	for i, n := range r.nonces {
+		if n.cache.Seen(n.key, n.ttl) {
+			for _, m := range r.nonces[:i] {
+				m.cache.Forget(m.key)
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+// ForgetNonces removes the single-use values recorded by RecordNonces, so
+// that the sender can retry a request whose command failed.
+func (r *Request) ForgetNonces() {
+	for _, n := range r.nonces {
+		n.cache.Forget(n.key)
+	}
+}
@@ -0,0 +1,107 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Constants for the Hook command output format
+const (
+	OutputFormatJSON string = "json"
+)
+
+// OutputHeader maps a key of the parsed command output to a response header,
+// or to a field of failure notifications.
+type OutputHeader struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// ParseCommandOutput decodes the command output according to the hook's
+// command-output-format.  It returns nil if no format is set.
+func (h *Hook) ParseCommandOutput(out string) (map[string]interface{}, error) {
+	switch h.CommandOutputFormat {
+	case "":
+		return nil, nil
+
+	case OutputFormatJSON:
+		var res map[string]interface{}
+
+		decoder := json.NewDecoder(strings.NewReader(out))
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&res); err != nil {
+			return nil, &ParseError{fmt.Errorf("error parsing command output as JSON: %w", err)}
+		}
+
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unsupported command output format %q", h.CommandOutputFormat)
+}
+
+// OutputHeaders returns the response headers mapped from the parsed command
+// output.  Keys use the same dotted notation as payload parameters.
+func (h *Hook) OutputHeaders(output map[string]interface{}) (ResponseHeaders, []error) {
+	var (
+		res    ResponseHeaders
+		errors []error
+	)
+
+	for _, oh := range h.ResponseHeadersFromOutput {
+		v, err := ExtractParameterAsString(oh.Key, output)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+
+		res = append(res, Header{Name: oh.Name, Value: v})
+	}
+
+	return res, errors
+}
+
+// OutputNotificationFields returns the failure notification fields mapped
+// from the parsed command output.
+func (h *Hook) OutputNotificationFields(output map[string]interface{}) (map[string]string, []error) {
+	var (
+		res    map[string]string
+		errors []error
+	)
+
+	for _, f := range h.NotificationFieldsFromOutput {
+		v, err := ExtractParameterAsString(f.Key, output)
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+
+		if res == nil {
+			res = make(map[string]string)
+		}
+
+		res[f.Name] = v
+	}
+
+	return res, errors
+}
+
+// ExecuteResponseTemplate renders the hook's response-template with the
+// parsed command output as data.
+func (h *Hook) ExecuteResponseTemplate(output map[string]interface{}) (string, error) {
+	tmpl, err := template.New(h.ID).Parse(h.ResponseTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, output); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
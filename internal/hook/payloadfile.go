@@ -0,0 +1,81 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Constants for the format of a PayloadFile.
+const (
+	PayloadFileJSON       = "json"
+	PayloadFileRaw        = "raw"
+	PayloadFileNormalized = "normalized"
+)
+
+// PayloadFile defines the temporary file the whole payload of a request is
+// written to for the command, which is passed its path in an environment
+// variable.  Unlike passing entire-payload as an argument, it works for
+// payloads of any size.
+type PayloadFile struct {
+	// Format is the content of the file: the parsed payload as JSON, the
+	// raw request body, or the normalized payload as JSON.  Defaults to
+	// PayloadFileJSON.
+	Format string `json:"format,omitempty"`
+
+	// EnvName is the environment variable holding the path of the file.
+	// Defaults to HOOK_PAYLOAD_FILE.
+	EnvName string `json:"envname,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting a format as a shorthand
+// for a payload file with the default environment variable.
+func (p *PayloadFile) UnmarshalJSON(data []byte) error {
+	var format string
+	if err := json.Unmarshal(data, &format); err == nil {
+		*p = PayloadFile{Format: format}
+		return nil
+	}
+
+	type payloadFile PayloadFile
+
+	return json.Unmarshal(data, (*payloadFile)(p))
+}
+
+// envName returns the environment variable holding the path of the file.
+func (p *PayloadFile) envName() string {
+	if p.EnvName == "" {
+		return EnvNamespace + "PAYLOAD_FILE"
+	}
+
+	return p.EnvName
+}
+
+// FileParameter returns the file parameter for the payload of r.
+func (p *PayloadFile) FileParameter(r *Request) (FileParameter, error) {
+	fp := FileParameter{EnvName: p.envName()}
+
+	var err error
+
+	switch p.Format {
+	case "", PayloadFileJSON:
+		fp.Data, err = json.Marshal(r.Payload)
+	case PayloadFileRaw:
+		fp.Data = r.Body
+	case PayloadFileNormalized:
+		fp.Data, err = json.Marshal(r.Normalized)
+	default:
+		err = fmt.Errorf("unknown payload-file format %q", p.Format)
+	}
+
+	return fp, err
+}
+
+// Validate returns the problems found in the payload file settings.
+func (p *PayloadFile) Validate() []error {
+	switch p.Format {
+	case "", PayloadFileJSON, PayloadFileRaw, PayloadFileNormalized:
+		return nil
+	}
+
+	return []error{fmt.Errorf("unknown payload-file format %q", p.Format)}
+}
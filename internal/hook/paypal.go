@@ -0,0 +1,215 @@
+package hook
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PayPalCertHosts lists the hosts PayPal signing certificates are fetched
+// from.  Certificates referenced by the PAYPAL-CERT-URL header of requests
+// are only fetched from these hosts, over HTTPS.
+var PayPalCertHosts = []string{"api.paypal.com", "api-m.paypal.com", "api.sandbox.paypal.com", "api-m.sandbox.paypal.com"}
+
+// payPalCertNames are the names PayPal signing certificates are issued to.
+var payPalCertNames = []string{"messageverificationcerts.paypal.com", "messageverificationcerts.sandbox.paypal.com"}
+
+// payPalCertCache caches the verified signing certificates of PayPal by URL
+// until they expire.
+type payPalCertCache struct {
+	mu     sync.Mutex
+	certs  map[string]*x509.Certificate
+	client *http.Client
+
+	// inflight holds the fetches in progress, so concurrent requests for a
+	// certificate wait for the same fetch.
+	inflight map[string]*payPalCertFetch
+
+	// roots holds the root certificates the signing certificates must chain
+	// to, or is nil to use the system roots.
+	roots *x509.CertPool
+}
+
+// payPalCertFetch is a fetch of a signing certificate in progress.
+type payPalCertFetch struct {
+	done chan struct{}
+	cert *x509.Certificate
+	err  error
+}
+
+var payPalCerts = &payPalCertCache{
+	certs:  make(map[string]*x509.Certificate),
+	client: &http.Client{Timeout: 10 * time.Second},
+}
+
+// Get returns the signing certificate at rawurl, fetching and verifying it if
+// it isn't cached.  Certificates are fetched without holding the lock, and
+// concurrent requests for a certificate share a fetch.
+func (c *payPalCertCache) Get(rawurl string) (*x509.Certificate, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "https" || !containsString(PayPalCertHosts, u.Hostname()) {
+		return nil, fmt.Errorf("untrusted PayPal certificate URL %q", rawurl)
+	}
+
+	c.mu.Lock()
+
+	if cert, ok := c.certs[rawurl]; ok && Now().Before(cert.NotAfter) {
+		c.mu.Unlock()
+		return cert, nil
+	}
+
+	if f, fetching := c.inflight[rawurl]; fetching {
+		c.mu.Unlock()
+		<-f.done
+		return f.cert, f.err
+	}
+
+	f := &payPalCertFetch{done: make(chan struct{})}
+	if c.inflight == nil {
+		c.inflight = make(map[string]*payPalCertFetch)
+	}
+	c.inflight[rawurl] = f
+	c.mu.Unlock()
+
+	f.cert, f.err = c.fetch(rawurl)
+
+	c.mu.Lock()
+	delete(c.inflight, rawurl)
+	if f.err == nil {
+		c.certs[rawurl] = f.cert
+	}
+	c.mu.Unlock()
+
+	close(f.done)
+
+	return f.cert, f.err
+}
+
+// fetch fetches the signing certificate at rawurl and verifies it.
+func (c *payPalCertCache) fetch(rawurl string) (*x509.Certificate, error) {
+	res, err := c.client.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %s: %s", rawurl, res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first certificate is the signing certificate, followed by the
+	// intermediates.
+	var chain []*x509.Certificate
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", rawurl, err)
+		}
+
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", rawurl)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	cert := chain[0]
+
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: c.roots, Intermediates: intermediates, CurrentTime: Now()}); err != nil {
+		return nil, fmt.Errorf("error verifying %s: %w", rawurl, err)
+	}
+
+	var named bool
+	for _, name := range payPalCertNames {
+		if cert.VerifyHostname(name) == nil {
+			named = true
+		}
+	}
+
+	if !named {
+		return nil, fmt.Errorf("certificate %s is not a PayPal signing certificate", rawurl)
+	}
+
+	return cert, nil
+}
+
+// CheckPayPalSignature checks the PAYPAL-TRANSMISSION-SIG signature of a
+// PayPal webhook request for the webhook with the ID webhookID.  The signature
+// is the base64 encoded SHA256withRSA signature of the transmission ID,
+// transmission time, webhook ID and CRC32 of the body, joined by "|", made
+// with the certificate at PAYPAL-CERT-URL.
+func CheckPayPalSignature(r *Request, webhookID string) (bool, error) {
+	header := func(name string) string {
+		v, _ := r.Headers[name].(string)
+		return v
+	}
+
+	id := header("Paypal-Transmission-Id")
+	timestamp := header("Paypal-Transmission-Time")
+	signature := header("Paypal-Transmission-Sig")
+	certURL := header("Paypal-Cert-Url")
+
+	if id == "" || timestamp == "" || signature == "" || certURL == "" {
+		return false, nil
+	}
+
+	if webhookID == "" {
+		return false, errors.New("missing PayPal webhook ID")
+	}
+
+	if algo := header("Paypal-Auth-Algo"); algo != "" && algo != "SHA256withRSA" {
+		return false, fmt.Errorf("unsupported PayPal signature algorithm %q", algo)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, &SignatureError{Signature: signature}
+	}
+
+	cert, err := payPalCerts.Get(certURL)
+	if err != nil {
+		return false, err
+	}
+
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return false, fmt.Errorf("certificate %s has no RSA key", certURL)
+	}
+
+	message := strings.Join([]string{id, timestamp, webhookID, strconv.FormatUint(uint64(crc32.ChecksumIEEE(r.Body)), 10)}, "|")
+	sum := sha256.Sum256([]byte(message))
+
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return false, &SignatureError{Signature: signature}
+	}
+
+	return true, nil
+}
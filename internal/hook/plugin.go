@@ -0,0 +1,316 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	// defaultPluginTimeout is how long plugins without a timeout may run.
+	defaultPluginTimeout = 5 * time.Second
+
+	// pluginMemoryLimitPages limits the memory of plugins to 256 MiB.
+	pluginMemoryLimitPages = 4096
+
+	// maxPluginOutput is the maximum size of the output of a plugin.
+	maxPluginOutput = 16 << 20
+)
+
+// Plugin is a WebAssembly module run for each request to a hook before its
+// trigger rule is evaluated.  The module is a WASI command reading the
+// request as JSON from its standard input and writing its result as JSON to
+// its standard output.  It has no access to the file system, the network or
+// the environment.
+type Plugin struct {
+	// Path is the path of the module.
+	Path string `json:"path,omitempty"`
+
+	// Timeout is how long the module may run, 5s by default.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Validate returns the problems found in the plugin definition.
+func (p *Plugin) Validate() []error {
+	var errs []error
+
+	if p.Path == "" {
+		errs = append(errs, errors.New("missing path"))
+	}
+
+	if p.Timeout != "" {
+		if d, err := time.ParseDuration(p.Timeout); err != nil {
+			errs = append(errs, fmt.Errorf("invalid timeout: %w", err))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("timeout %s is not positive", p.Timeout))
+		}
+	}
+
+	return errs
+}
+
+// pluginInput is the request written to the standard input of plugins.
+type pluginInput struct {
+	Hook        string                 `json:"hook"`
+	Method      string                 `json:"method,omitempty"`
+	URL         string                 `json:"url,omitempty"`
+	RemoteAddr  string                 `json:"remote-addr,omitempty"`
+	ContentType string                 `json:"content-type,omitempty"`
+	Headers     map[string]interface{} `json:"headers"`
+	Query       map[string]interface{} `json:"query"`
+	Payload     map[string]interface{} `json:"payload"`
+	Body        []byte                 `json:"body"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// pluginOutput is the result plugins write to their standard output.
+type pluginOutput struct {
+	// Payload replaces the payload of the request, if set.
+	Payload map[string]interface{} `json:"payload"`
+
+	// Parameters are added to the plugin parameters of the request.
+	Parameters map[string]interface{} `json:"parameters"`
+
+	// Veto rejects the request for Reason.
+	Veto   bool   `json:"veto"`
+	Reason string `json:"reason"`
+}
+
+// VetoError is returned by RunPlugins when a plugin vetoed the request.
+type VetoError struct {
+	Plugin string
+	Reason string
+}
+
+func (e *VetoError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("vetoed by plugin %s", e.Plugin)
+	}
+
+	return fmt.Sprintf("vetoed by plugin %s: %s", e.Plugin, e.Reason)
+}
+
+// IsVetoError returns whether err is of type VetoError.
+func IsVetoError(err error) bool {
+	_, ok := err.(*VetoError)
+	return ok
+}
+
+// RunPlugins runs the plugins of the hook for the request r, in order.  Each
+// plugin can replace r.Payload and add parameters to r.Plugin, which are seen
+// by the following plugins.  A *VetoError is returned if a plugin vetoed the
+// request.
+func (h *Hook) RunPlugins(r *Request) error {
+	if len(h.Plugins) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	if r.RawRequest != nil {
+		ctx = r.RawRequest.Context()
+	}
+
+	for i := range h.Plugins {
+		p := &h.Plugins[i]
+
+		in := pluginInput{
+			Hook:        h.ID,
+			ContentType: r.ContentType,
+			Headers:     r.Headers,
+			Query:       r.Query,
+			Payload:     r.Payload,
+			Body:        r.Body,
+			Parameters:  r.Plugin,
+		}
+
+		if r.RawRequest != nil {
+			in.Method = r.RawRequest.Method
+			in.URL = requestURL(r.RawRequest)
+			in.RemoteAddr = r.RawRequest.RemoteAddr
+		}
+
+		b, err := json.Marshal(&in)
+		if err != nil {
+			return err
+		}
+
+		b, err = p.run(ctx, b)
+		if err != nil {
+			return fmt.Errorf("plugin %s: %w", p.Path, err)
+		}
+
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+
+		var out pluginOutput
+
+		if err := json.Unmarshal(b, &out); err != nil {
+			return fmt.Errorf("plugin %s: error parsing output: %w", p.Path, err)
+		}
+
+		if out.Veto {
+			return &VetoError{Plugin: p.Path, Reason: out.Reason}
+		}
+
+		if out.Payload != nil {
+			r.Payload = out.Payload
+		}
+
+		for k, v := range out.Parameters {
+			if r.Plugin == nil {
+				r.Plugin = make(map[string]interface{}, len(out.Parameters))
+			}
+
+			r.Plugin[k] = v
+		}
+	}
+
+	return nil
+}
+
+// run runs the plugin with the standard input in and returns its standard
+// output.
+func (p *Plugin) run(ctx context.Context, in []byte) ([]byte, error) {
+	d := defaultPluginTimeout
+	if p.Timeout != "" {
+		var err error
+
+		if d, err = time.ParseDuration(p.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	rt, compiled, err := compilePlugin(ctx, p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr limitedBuffer
+
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(filepath.Base(p.Path)).
+		WithStdin(bytes.NewReader(in)).
+		WithStdout(&stdout).
+		WithStderr(&stderr)
+
+	m, err := rt.InstantiateModule(ctx, compiled, config)
+	if m != nil {
+		m.Close(ctx)
+	}
+
+	if err != nil {
+		if exit, ok := err.(*sys.ExitError); ok && exit.ExitCode() == 0 {
+			err = nil
+		} else if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", d)
+		}
+	}
+
+	if err == nil && stdout.overflow {
+		err = fmt.Errorf("output exceeds %d bytes", maxPluginOutput)
+	}
+
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) != 0 {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// plugins holds the WebAssembly runtime and the modules compiled for it.
+var plugins = struct {
+	sync.Mutex
+	runtime wazero.Runtime
+	modules map[string]*pluginModule
+}{modules: make(map[string]*pluginModule)}
+
+// pluginModule is a compiled plugin and the version of the file it was
+// compiled from.
+type pluginModule struct {
+	modTime  time.Time
+	size     int64
+	compiled wazero.CompiledModule
+}
+
+// compilePlugin returns the runtime and the module compiled from the file
+// path, compiling it again if the file changed since it was last compiled.
+func compilePlugin(ctx context.Context, path string) (wazero.Runtime, wazero.CompiledModule, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plugins.Lock()
+
+	if plugins.runtime == nil {
+		// Plugins are interrupted when the context of the request is done.
+		config := wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(pluginMemoryLimitPages)
+
+		plugins.runtime = wazero.NewRuntimeWithConfig(context.Background(), config)
+		wasi_snapshot_preview1.MustInstantiate(context.Background(), plugins.runtime)
+	}
+
+	rt := plugins.runtime
+
+	if m := plugins.modules[path]; m != nil && m.modTime.Equal(fi.ModTime()) && m.size == fi.Size() {
+		plugins.Unlock()
+		return rt, m.compiled, nil
+	}
+
+	plugins.Unlock()
+
+	// Compile without holding the lock, so requests to other plugins aren't
+	// held up.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compiled, err := rt.CompileModule(ctx, b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error compiling module: %w", err)
+	}
+
+	plugins.Lock()
+	plugins.modules[path] = &pluginModule{modTime: fi.ModTime(), size: fi.Size(), compiled: compiled}
+	plugins.Unlock()
+
+	return rt, compiled, nil
+}
+
+// limitedBuffer is a bytes.Buffer discarding writes beyond maxPluginOutput.
+type limitedBuffer struct {
+	bytes.Buffer
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxPluginOutput {
+		b.overflow = true
+		return 0, errors.New("output too large")
+	}
+
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,402 @@
+package hook
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// position is the line and column of a value of a hooks file, counted from 1.
+type position struct {
+	line, column int
+}
+
+// nodePositions returns the positions of the values of the JSON or YAML
+// document in src by field path, ie. "[0].trigger-rule", located at their key
+// for object properties.  It only scans the structure of the document, which
+// is expected to be valid, so it is best effort: values it doesn't make sense
+// of are left out.
+func nodePositions(src []byte) map[string]position {
+	s := &positionScanner{src: src, positions: make(map[string]position)}
+
+	for i, b := range src {
+		if b == '\n' {
+			s.lines = append(s.lines, i+1)
+		}
+	}
+
+	s.skipSpace()
+
+	// Directives and the document start marker.
+	for s.at("%") || (s.at("---") && s.column(s.off) == 1 && s.blankAt(s.off+3)) {
+		if s.at("%") {
+			s.skipLine()
+		} else {
+			s.off += 3
+		}
+		s.skipSpace()
+	}
+
+	s.node("", -1)
+
+	return s.positions
+}
+
+// positionScanner scans the structure of a JSON or YAML document.
+type positionScanner struct {
+	src       []byte
+	off       int
+	lines     []int // offsets of the starts of the lines but the first
+	positions map[string]position
+}
+
+// position returns the position of the offset off.
+func (s *positionScanner) position(off int) position {
+	line := sort.SearchInts(s.lines, off+1)
+
+	start := 0
+	if line > 0 {
+		start = s.lines[line-1]
+	}
+
+	return position{line: line + 1, column: utf8.RuneCount(s.src[start:off]) + 1}
+}
+
+// column returns the column of the offset off.
+func (s *positionScanner) column(off int) int {
+	return s.position(off).column
+}
+
+func (s *positionScanner) eof() bool {
+	return s.off >= len(s.src)
+}
+
+func (s *positionScanner) at(prefix string) bool {
+	return strings.HasPrefix(string(s.src[s.off:]), prefix)
+}
+
+// blankAt reports whether the byte at off is a space, a line break or the
+// end of the document.
+func (s *positionScanner) blankAt(off int) bool {
+	return off >= len(s.src) || s.src[off] == ' ' || s.src[off] == '\t' || s.src[off] == '\r' || s.src[off] == '\n'
+}
+
+// skipSpace skips spaces, line breaks and comments.
+func (s *positionScanner) skipSpace() {
+	for !s.eof() {
+		switch s.src[s.off] {
+		case ' ', '\t', '\r', '\n':
+			s.off++
+		case '#':
+			s.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+// skipInlineSpace skips spaces and a comment up to the end of the line.
+func (s *positionScanner) skipInlineSpace() {
+	for !s.eof() {
+		switch s.src[s.off] {
+		case ' ', '\t':
+			s.off++
+		case '#':
+			s.skipLine()
+			return
+		default:
+			return
+		}
+	}
+}
+
+// skipLine skips to the line break ending the current line.
+func (s *positionScanner) skipLine() {
+	for !s.eof() && s.src[s.off] != '\n' {
+		s.off++
+	}
+}
+
+// atLineEnd reports whether only spaces and a comment remain on the line.
+func (s *positionScanner) atLineEnd() bool {
+	off := s.off
+	s.skipInlineSpace()
+	end := s.eof() || s.src[s.off] == '\r' || s.src[s.off] == '\n'
+	s.off = off
+
+	return end
+}
+
+// node scans the value at the current offset, inside a block indented by
+// indent spaces.
+func (s *positionScanner) node(path string, indent int) {
+	s.skipProperties()
+
+	if s.eof() {
+		return
+	}
+
+	switch c := s.src[s.off]; {
+	case c == '[' || c == '{':
+		s.flow(path)
+	case c == '|' || c == '>':
+		s.skipLine()
+		s.skipIndented(indent)
+	case c == '-' && s.blankAt(s.off+1):
+		s.sequence(path, s.column(s.off))
+	case s.keyEnd() >= 0:
+		s.mapping(path, s.column(s.off))
+	default:
+		s.scalar()
+		s.skipIndented(indent)
+	}
+}
+
+// skipProperties skips the anchor and tag of a value.
+func (s *positionScanner) skipProperties() {
+	for !s.eof() && (s.src[s.off] == '&' || s.src[s.off] == '!') {
+		for !s.blankAt(s.off) {
+			s.off++
+		}
+		s.skipSpace()
+	}
+}
+
+// skipIndented skips the lines indented more than indent spaces, continuing
+// a multi-line scalar.
+func (s *positionScanner) skipIndented(indent int) {
+	for {
+		s.skipSpace()
+		if s.eof() || s.column(s.off)-1 <= indent {
+			return
+		}
+		s.skipLine()
+	}
+}
+
+// sequence scans the block sequence at the column col.
+func (s *positionScanner) sequence(path string, col int) {
+	for i := 0; ; i++ {
+		item := fmt.Sprintf("%s[%d]", path, i)
+		dash := s.off
+
+		s.off++
+		if s.atLineEnd() {
+			s.positions[item] = s.position(dash)
+			s.skipSpace()
+			if !s.eof() && s.column(s.off) > col {
+				s.node(item, col-1)
+			}
+		} else {
+			s.skipInlineSpace()
+			s.positions[item] = s.position(s.off)
+			s.node(item, col-1)
+		}
+
+		s.skipSpace()
+		if s.eof() || s.column(s.off) != col || s.src[s.off] != '-' || !s.blankAt(s.off+1) {
+			return
+		}
+	}
+}
+
+// mapping scans the block mapping at the column col.
+func (s *positionScanner) mapping(path string, col int) {
+	for {
+		end := s.keyEnd()
+		if end < 0 {
+			return
+		}
+
+		p := joinFieldPath(path, s.key(s.off, end))
+		s.positions[p] = s.position(s.off)
+
+		s.off = end + 1
+
+		if s.atLineEnd() {
+			s.skipSpace()
+			if !s.eof() && (s.column(s.off) > col || (s.column(s.off) == col && s.src[s.off] == '-' && s.blankAt(s.off+1))) {
+				s.node(p, col-1)
+			}
+		} else {
+			s.skipInlineSpace()
+			s.node(p, col-1)
+		}
+
+		s.skipSpace()
+		if s.eof() || s.column(s.off) != col {
+			return
+		}
+	}
+}
+
+// keyEnd returns the offset of the colon ending the mapping key at the
+// current offset, or -1 if there is none on the line.
+func (s *positionScanner) keyEnd() int {
+	off := s.off
+
+	if off < len(s.src) && (s.src[off] == '"' || s.src[off] == '\'') {
+		end := s.quotedEnd(off)
+		if end < 0 {
+			return -1
+		}
+
+		for off = end; off < len(s.src) && (s.src[off] == ' ' || s.src[off] == '\t'); off++ {
+		}
+
+		if off < len(s.src) && s.src[off] == ':' && s.blankAt(off+1) {
+			return off
+		}
+
+		return -1
+	}
+
+	for ; off < len(s.src) && s.src[off] != '\n'; off++ {
+		switch {
+		case s.src[off] == ':' && s.blankAt(off+1):
+			return off
+		case s.src[off] == '#' && off > s.off && (s.src[off-1] == ' ' || s.src[off-1] == '\t'):
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// key returns the mapping key between the offsets start and end.
+func (s *positionScanner) key(start, end int) string {
+	raw := strings.TrimSpace(string(s.src[start:end]))
+
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		if k, err := strconv.Unquote(raw); err == nil {
+			return k
+		}
+	case strings.HasPrefix(raw, "'"):
+		return strings.Replace(raw[1:len(raw)-1], "''", "'", -1)
+	}
+
+	return raw
+}
+
+// quotedEnd returns the offset following the quoted scalar at off, or -1 if
+// it isn't terminated.
+func (s *positionScanner) quotedEnd(off int) int {
+	q := s.src[off]
+
+	for off++; off < len(s.src); off++ {
+		switch {
+		case q == '"' && s.src[off] == '\\':
+			off++
+		case s.src[off] == q && q == '\'' && off+1 < len(s.src) && s.src[off+1] == '\'':
+			off++
+		case s.src[off] == q:
+			return off + 1
+		}
+	}
+
+	return -1
+}
+
+// scalar skips the scalar at the current offset, to the end of its line for
+// plain scalars.
+func (s *positionScanner) scalar() {
+	if c := s.src[s.off]; c == '"' || c == '\'' {
+		if end := s.quotedEnd(s.off); end >= 0 {
+			s.off = end
+			return
+		}
+	}
+
+	for !s.eof() && s.src[s.off] != '\n' {
+		if s.src[s.off] == '#' && (s.src[s.off-1] == ' ' || s.src[s.off-1] == '\t') {
+			break
+		}
+		s.off++
+	}
+}
+
+// flow scans the flow sequence or mapping at the current offset, such as
+// JSON arrays and objects.
+func (s *positionScanner) flow(path string) {
+	closing := byte(']')
+	if s.src[s.off] == '{' {
+		closing = '}'
+	}
+
+	s.off++
+
+	for i := 0; ; i++ {
+		s.skipSpace()
+		if s.eof() {
+			return
+		}
+
+		if s.src[s.off] == closing {
+			s.off++
+			return
+		}
+
+		if closing == ']' {
+			item := fmt.Sprintf("%s[%d]", path, i)
+			s.positions[item] = s.position(s.off)
+			s.flowNode(item)
+		} else {
+			start := s.off
+			s.flowScalar(true)
+
+			p := joinFieldPath(path, s.key(start, s.off))
+			s.positions[p] = s.position(start)
+
+			s.skipSpace()
+			if !s.eof() && s.src[s.off] == ':' {
+				s.off++
+				s.skipSpace()
+				s.flowNode(p)
+			}
+		}
+
+		s.skipSpace()
+		if !s.eof() && s.src[s.off] == ',' {
+			s.off++
+		}
+	}
+}
+
+// flowNode scans the value at the current offset inside a flow collection.
+func (s *positionScanner) flowNode(path string) {
+	s.skipProperties()
+
+	if !s.eof() && (s.src[s.off] == '[' || s.src[s.off] == '{') {
+		s.flow(path)
+		return
+	}
+
+	s.flowScalar(false)
+}
+
+// flowScalar skips the scalar at the current offset inside a flow
+// collection, up to the colon following it if it is a key.
+func (s *positionScanner) flowScalar(key bool) {
+	if s.eof() {
+		return
+	}
+
+	if c := s.src[s.off]; c == '"' || c == '\'' {
+		if end := s.quotedEnd(s.off); end >= 0 {
+			s.off = end
+			return
+		}
+	}
+
+	for ; !s.eof(); s.off++ {
+		switch c := s.src[s.off]; {
+		case c == ',' || c == ']' || c == '}' || c == '\n':
+			return
+		case key && c == ':' && (s.blankAt(s.off+1) || strings.IndexByte(",[]{}", s.src[s.off+1]) >= 0):
+			return
+		}
+	}
+}
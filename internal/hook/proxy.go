@@ -0,0 +1,44 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+)
+
+// ProxyBody returns the body to forward for the proxy hook h.  If the hook
+// defines a proxy-body-template, it is executed with the request's Payload,
+// Headers, Query and Normalized values as data; the json function renders a
+// value as JSON.  Otherwise the raw request body is returned.
+func (h *Hook) ProxyBody(r *Request) ([]byte, error) {
+	if h.ProxyBodyTemplate == "" {
+		return r.Body, nil
+	}
+
+	funcMap := template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+
+	tmpl, err := template.New(h.ID).Funcs(funcMap).Option("missingkey=zero").Parse(h.ProxyBodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"Payload":    r.Payload,
+		"Headers":    r.Headers,
+		"Query":      r.Query,
+		"Normalized": r.Normalized,
+	}
+
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
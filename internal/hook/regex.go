@@ -0,0 +1,55 @@
+package hook
+
+import (
+	"regexp"
+	"sync"
+)
+
+// maxCachedRegexes limits the number of compiled regular expressions kept by
+// compileRegex.  The cache is emptied when it is full, which only happens if
+// reloads keep changing the expressions.
+const maxCachedRegexes = 10000
+
+var regexCache = struct {
+	sync.RWMutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compileRegex returns the compiled regular expression expr.  Expressions are
+// compiled when they are first evaluated rather than when the hooks file is
+// loaded, which keeps loading large hooks files fast, and are compiled only
+// once rather than on every request.
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	regexCache.RLock()
+	re, ok := regexCache.m[expr]
+	regexCache.RUnlock()
+
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	regexCache.Lock()
+	if len(regexCache.m) >= maxCachedRegexes {
+		regexCache.m = make(map[string]*regexp.Regexp)
+	}
+	regexCache.m[expr] = re
+	regexCache.Unlock()
+
+	return re, nil
+}
+
+// matchRegex reports whether s contains any match of the regular expression
+// expr.
+func matchRegex(expr, s string) (bool, error) {
+	re, err := compileRegex(expr)
+	if err != nil {
+		return false, err
+	}
+
+	return re.MatchString(s), nil
+}
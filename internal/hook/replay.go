@@ -0,0 +1,88 @@
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultReplayWindow is how long delivery keys are remembered if the replay
+// protection of a hook doesn't set a window.
+const DefaultReplayWindow = time.Hour
+
+// ReplayProtection rejects repeated deliveries to a hook, identified by the
+// values of one or more request parameters, such as the X-GitHub-Delivery
+// header, or a signature and timestamp.
+type ReplayProtection struct {
+	// Keys are the parameters whose values identify a delivery.
+	Keys []Argument `json:"keys"`
+
+	// Window is how long the keys of a delivery are remembered.  Defaults to
+	// DefaultReplayWindow.
+	Window string `json:"window,omitempty"`
+}
+
+// deliveries remembers the keys of the deliveries to hooks with replay
+// protection.
+var deliveries = newNonceCache()
+
+// window returns the duration the keys of a delivery are remembered.
+func (p *ReplayProtection) window() time.Duration {
+	if p.Window == "" {
+		return DefaultReplayWindow
+	}
+
+	d, _ := time.ParseDuration(p.Window)
+
+	return d
+}
+
+// Validate returns the problems found in the replay protection settings.
+func (p *ReplayProtection) Validate() []error {
+	var errs []error
+
+	if len(p.Keys) == 0 {
+		errs = append(errs, errors.New("replay-protection requires at least one key"))
+	}
+
+	if p.Window != "" {
+		if d, err := time.ParseDuration(p.Window); err != nil {
+			errs = append(errs, fmt.Errorf("invalid replay-protection window: %w", err))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("replay-protection window %s is not positive", p.Window))
+		}
+	}
+
+	return errs
+}
+
+// IsReplay reports whether a request with the same delivery key as req was
+// delivered to h within the replay protection window.  It returns an error if
+// a key parameter is missing, as the delivery can't be identified then.  The
+// key is only recorded by Request.RecordNonces once the trigger rule is
+// satisfied, and forgotten by Request.ForgetNonces if the command fails, so
+// that the sender can retry the delivery.  Keys are remembered in memory, so
+// they are forgotten on restart.
+func (h *Hook) IsReplay(req *Request) (bool, error) {
+	values := make([]string, 0, len(h.ReplayProtection.Keys)+1)
+	values = append(values, h.ID)
+
+	for _, key := range h.ReplayProtection.Keys {
+		v, err := key.Get(req)
+		if err != nil {
+			return false, fmt.Errorf("replay-protection key: %w", err)
+		}
+
+		values = append(values, v)
+	}
+
+	key := strings.Join(values, "\x00")
+	if deliveries.Contains(key) {
+		return true, nil
+	}
+
+	req.addNonce(deliveries, key, h.ReplayProtection.window())
+
+	return false, nil
+}
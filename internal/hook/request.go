@@ -2,10 +2,13 @@ package hook
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 	"unicode"
 
 	"github.com/clbanning/mxj"
@@ -31,11 +34,95 @@ type Request struct {
 	// Payload is a map of the parsed payload.
 	Payload map[string]interface{}
 
+	// Normalized is the payload view built from the hook's normalize mapping.
+	Normalized map[string]interface{}
+
+	// Plugin holds the parameters added by the hook's plugins.
+	Plugin map[string]interface{}
+
 	// The underlying HTTP request.
 	RawRequest *http.Request
 
+	// Received is the time the request was received.
+	Received time.Time
+
 	// Treat signature errors as simple validate failures.
 	AllowSignatureErrors bool
+
+	// Element is the array element being evaluated by an any or all rule.
+	Element interface{}
+
+	// Identity is the authenticated caller, if known.
+	Identity *Identity
+
+	// nonces are the single-use values checked by the trigger rule, recorded
+	// by RecordNonces.
+	nonces []nonce
+}
+
+// requestURL returns the full URL r was sent to, as requested by the client.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	return scheme + "://" + r.Host + uri
+}
+
+// tlsVersionName returns the name of the TLS version v, ie. "TLS 1.3".
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// Identity describes an authenticated caller.
+type Identity struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// NewIdentity returns the identity set by an authenticating proxy in the
+// userHeader and groupsHeader request headers, or nil if userHeader is empty
+// or not set.  Groups are separated by commas.
+func NewIdentity(h http.Header, userHeader, groupsHeader string) *Identity {
+	if userHeader == "" {
+		return nil
+	}
+
+	user := strings.TrimSpace(h.Get(userHeader))
+	if user == "" {
+		return nil
+	}
+
+	id := &Identity{User: user}
+
+	if groupsHeader != "" {
+		for _, v := range h.Values(groupsHeader) {
+			for _, g := range strings.Split(v, ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					id.Groups = append(id.Groups, g)
+				}
+			}
+		}
+	}
+
+	return id
 }
 
 func (r *Request) ParseJSONPayload() error {
@@ -117,3 +204,50 @@ func (r *Request) ParseXMLPayload() error {
 
 	return nil
 }
+
+// DetectPayloadType returns the content type of the payload in r.Body, one of
+// "application/json", "application/x-www-form-urlencoded" and
+// "application/xml", or an empty string if it isn't any of them.  It is used
+// to parse payloads sent with a wrong Content-Type.
+func (r *Request) DetectPayloadType() string {
+	body := bytes.TrimSpace(r.Body)
+	if len(body) == 0 {
+		return ""
+	}
+
+	switch body[0] {
+	case '{', '[':
+		if json.Valid(body) {
+			return "application/json"
+		}
+
+		return ""
+
+	case '<':
+		if _, err := mxj.NewMapXml(body); err == nil {
+			return "application/xml"
+		}
+
+		return ""
+	}
+
+	// Form data has no whitespace or control characters, and every field has a
+	// name and a value.
+	for _, c := range body {
+		if c <= ' ' || c >= 0x7f {
+			return ""
+		}
+	}
+
+	for _, field := range strings.Split(string(body), "&") {
+		if i := strings.IndexByte(field, '='); i < 1 {
+			return ""
+		}
+	}
+
+	if _, err := url.ParseQuery(string(body)); err != nil {
+		return ""
+	}
+
+	return "application/x-www-form-urlencoded"
+}
@@ -0,0 +1,55 @@
+package hook
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultRetryDelay is the delay before the first retry of a failed execution
+// if the retry rule doesn't set one.
+const DefaultRetryDelay = 10 * time.Second
+
+// RetryRule defines how failed executions of commands running in the
+// background are retried.
+type RetryRule struct {
+	// Attempts is the maximum number of executions, including the first.
+	Attempts int `json:"attempts,omitempty"`
+
+	// Delay is the delay before the first retry, doubled for each following
+	// one.  Defaults to DefaultRetryDelay.
+	Delay string `json:"delay,omitempty"`
+}
+
+// Backoff returns the delay before the retry following the failed execution
+// attempt, counted from 1.
+func (r *RetryRule) Backoff(attempt int) time.Duration {
+	d := DefaultRetryDelay
+	if r.Delay != "" {
+		d, _ = time.ParseDuration(r.Delay)
+	}
+
+	for i := 1; i < attempt; i++ {
+		d *= 2
+	}
+
+	return d
+}
+
+// Validate returns the problems found in the retry rule.
+func (r *RetryRule) Validate() []error {
+	var errs []error
+
+	if r.Attempts < 1 {
+		errs = append(errs, fmt.Errorf("retry attempts must be at least 1"))
+	}
+
+	if r.Delay != "" {
+		if d, err := time.ParseDuration(r.Delay); err != nil {
+			errs = append(errs, fmt.Errorf("invalid retry delay: %w", err))
+		} else if d < 0 {
+			errs = append(errs, fmt.Errorf("retry delay %s is negative", r.Delay))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,52 @@
+package hook
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Constants for the sanitize-output options.
+const (
+	SanitizeANSI        = "ansi"
+	SanitizeInvalidUTF8 = "invalid-utf8"
+	SanitizeNone        = "none"
+)
+
+// ansiEscapes matches ANSI escape sequences: CSI sequences such as colors and
+// cursor movement, OSC sequences such as terminal titles and hyperlinks, and
+// other two-byte escapes such as saving the cursor position.
+var ansiEscapes = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[0-~]`)
+
+// SanitizeOutput applies the sanitize-output options to the command output
+// out.  Colored output of scripts is unreadable for API consumers, and invalid
+// UTF-8 is replaced when encoding JSON anyway.
+func SanitizeOutput(out string, options []string) string {
+	for _, o := range options {
+		switch o {
+		case SanitizeANSI:
+			out = ansiEscapes.ReplaceAllString(out, "")
+		case SanitizeInvalidUTF8:
+			out = strings.ToValidUTF8(out, "�")
+		}
+	}
+
+	return out
+}
+
+// ValidateSanitizeOptions checks the sanitize-output options.
+func ValidateSanitizeOptions(options []string) error {
+	for _, o := range options {
+		switch o {
+		case SanitizeANSI, SanitizeInvalidUTF8:
+		case SanitizeNone:
+			if len(options) != 1 {
+				return fmt.Errorf("sanitize-output %q can't be combined with other options", o)
+			}
+		default:
+			return fmt.Errorf("unknown sanitize-output option %q", o)
+		}
+	}
+
+	return nil
+}
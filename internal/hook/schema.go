@@ -0,0 +1,483 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// SchemaError describes a hooks file not matching the JSON Schema of hooks
+// files.
+type SchemaError struct {
+	Problems []SchemaProblem
+}
+
+func (e *SchemaError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		problems[i] = p.String()
+	}
+
+	return "invalid hooks file: " + strings.Join(problems, "; ")
+}
+
+// SchemaProblem describes a value of a hooks file not matching the JSON
+// Schema.
+type SchemaProblem struct {
+	// Line and Column locate the value in the hooks file, counted from 1,
+	// or are 0 if unknown, ie. for hooks files parsed as templates.
+	Line   int
+	Column int
+
+	// Path is the field path of the value, ie. "[0].trigger-rule.match".
+	Path    string
+	Message string
+}
+
+func (p SchemaProblem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
+	}
+
+	return fmt.Sprintf("line %d, column %d: %s: %s", p.Line, p.Column, p.Path, p.Message)
+}
+
+var (
+	schemaOnce sync.Once
+	schemaRoot map[string]interface{}
+	schemaJSON []byte
+)
+
+// hooksSchema returns the JSON Schema of hooks files, generated from the
+// types they are decoded into.
+func hooksSchema() map[string]interface{} {
+	schemaOnce.Do(func() {
+		g := &schemaGenerator{defs: make(map[string]interface{})}
+
+		schemaRoot = map[string]interface{}{
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"title":   "webhook hooks file",
+			"if":      map[string]interface{}{"type": "array"},
+			"then":    g.schemaOf(hooksType),
+			"else":    g.schemaOf(hooksFileType),
+		}
+		schemaRoot["definitions"] = g.defs
+
+		schemaJSON, _ = json.MarshalIndent(schemaRoot, "", "  ")
+		schemaJSON = append(schemaJSON, '\n')
+	})
+
+	return schemaRoot
+}
+
+// Schema returns the JSON Schema of hooks files, as JSON.
+func Schema() []byte {
+	hooksSchema()
+
+	return schemaJSON
+}
+
+var (
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// schemaGenerator generates JSON Schemas from the types JSON values are
+// decoded into, following the rules of encoding/json.  Named struct types
+// are put in defs, and referenced.
+type schemaGenerator struct {
+	defs map[string]interface{}
+}
+
+// schemaOf returns the schema of the JSON values decoded into t.
+func (g *schemaGenerator) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == rawMessageType || t.Kind() == reflect.Interface:
+		return map[string]interface{}{}
+
+	case t == hooksType:
+		return g.define(t, func() map[string]interface{} {
+			return map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"if":   map[string]interface{}{"required": []interface{}{"group"}},
+					"then": g.schemaOf(groupType),
+					"else": g.schemaOf(reflect.TypeOf(Hook{})),
+				},
+			}
+		})
+
+	// Types decoding themselves accept a string as a shorthand.
+	case reflect.PtrTo(t).Implements(unmarshalerType):
+		switch t.Kind() {
+		case reflect.Struct:
+			return map[string]interface{}{
+				"anyOf": []interface{}{
+					map[string]interface{}{"type": "string"},
+					g.define(t, func() map[string]interface{} { return g.structSchema(t) }),
+				},
+			}
+		case reflect.Slice:
+			return map[string]interface{}{
+				"type":  []interface{}{"string", "array"},
+				"items": g.schemaOf(t.Elem()),
+			}
+		}
+
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		return g.define(t, func() map[string]interface{} { return g.structSchema(t) })
+
+	case reflect.Slice, reflect.Array:
+		// Byte slices are encoded in base64.
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+
+		return map[string]interface{}{"type": "array", "items": g.schemaOf(t.Elem())}
+
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaOf(t.Elem())}
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+
+	return map[string]interface{}{}
+}
+
+// define adds the schema returned by f to the definitions, under the name of
+// t, unless already there, and returns a reference to it.
+func (g *schemaGenerator) define(t reflect.Type, f func() map[string]interface{}) map[string]interface{} {
+	ref := map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+
+	if _, ok := g.defs[t.Name()]; !ok {
+		// The placeholder ends the recursion of recursive types.
+		g.defs[t.Name()] = nil
+		g.defs[t.Name()] = f()
+	}
+
+	return ref
+}
+
+// structSchema returns the schema of the JSON objects decoded into the struct
+// type t, which only have the properties of its fields with a JSON name.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		// The entries of groups are hooks and groups.
+		if t == groupType && name == "hooks" {
+			properties[name] = g.schemaOf(hooksType)
+			continue
+		}
+
+		properties[name] = g.schemaOf(f.Type)
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// validateHooksFile validates the JSON or YAML hooks file contents in file
+// against the JSON Schema of hooks files.  Unknown fields are only reported
+// if strict is set.  The problems are located in src, the contents of the
+// hooks file before they were rendered or converted, if file is unchanged.
+func validateHooksFile(file, src []byte, strict bool) error {
+	v, err := decodeJSONValue(file)
+	if err != nil {
+		return err
+	}
+
+	schema, err := hooksValidator(strict)
+	if err != nil {
+		return err
+	}
+
+	verr, ok := schema.Validate(v).(*jsonschema.ValidationError)
+	if !ok {
+		return nil
+	}
+
+	problems := schemaProblems(v, verr)
+
+	if string(file) == string(src) {
+		positions := nodePositions(src)
+
+		for i := range problems {
+			if p, ok := positions[problems[i].Path]; ok {
+				problems[i].Line, problems[i].Column = p.line, p.column
+			}
+		}
+
+		sort.SliceStable(problems, func(i, j int) bool {
+			if problems[i].Line != problems[j].Line {
+				return problems[i].Line < problems[j].Line
+			}
+			return problems[i].Column < problems[j].Column
+		})
+	}
+
+	return &SchemaError{Problems: problems}
+}
+
+var (
+	validatorsOnce sync.Once
+	validators     [2]*jsonschema.Schema
+	validatorsErr  error
+)
+
+// hooksValidator returns the compiled JSON Schema hooks files are validated
+// against when they are loaded, with unknown fields rejected if strict is set.
+func hooksValidator(strict bool) (*jsonschema.Schema, error) {
+	validatorsOnce.Do(func() {
+		for i, strict := range []bool{false, true} {
+			c := jsonschema.NewCompiler()
+			c.DefaultDraft(jsonschema.Draft7)
+
+			if err := c.AddResource("hooks.schema.json", relaxSchema(hooksSchema(), strict)); err != nil {
+				validatorsErr = err
+				return
+			}
+
+			validators[i], validatorsErr = c.Compile("hooks.schema.json")
+			if validatorsErr != nil {
+				return
+			}
+		}
+	})
+
+	if validatorsErr != nil {
+		return nil, fmt.Errorf("error compiling the schema of hooks files: %w", validatorsErr)
+	}
+
+	if strict {
+		return validators[1], nil
+	}
+
+	return validators[0], nil
+}
+
+// relaxSchema returns a copy of the JSON Schema s relaxed like the decoding of
+// hooks files: null leaves any value unchanged, and, like the YAML decoder,
+// which converts scalars decoded into strings, strings accept numbers and
+// booleans.  Unknown fields are only rejected if strict is set.
+func relaxSchema(s interface{}, strict bool) interface{} {
+	switch s := s.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(s))
+
+		for k, v := range s {
+			switch k {
+			case "type":
+				m[k] = relaxType(v)
+			case "additionalProperties":
+				if v == false && !strict {
+					continue
+				}
+				m[k] = relaxSchema(v, strict)
+			case "properties", "definitions":
+				// Maps of names to schemas, whose names aren't keywords.
+				props := make(map[string]interface{})
+				for name, p := range v.(map[string]interface{}) {
+					props[name] = relaxSchema(p, strict)
+				}
+				m[k] = props
+			default:
+				m[k] = relaxSchema(v, strict)
+			}
+		}
+
+		return m
+
+	case []interface{}:
+		l := make([]interface{}, len(s))
+		for i, v := range s {
+			l[i] = relaxSchema(v, strict)
+		}
+
+		return l
+	}
+
+	return s
+}
+
+// relaxType returns the JSON Schema type t, or list of types, extended with
+// the types relaxSchema accepts in their place.
+func relaxType(t interface{}) interface{} {
+	var types []interface{}
+
+	switch t := t.(type) {
+	case string:
+		types = []interface{}{t}
+	case []interface{}:
+		types = append(types, t...)
+	default:
+		return t
+	}
+
+	for _, e := range types {
+		if e == "string" {
+			types = append(types, "number", "boolean")
+			break
+		}
+	}
+
+	return append(types, "null")
+}
+
+// schemaProblems returns the problems of the validation error err of the
+// decoded hooks file v.
+func schemaProblems(v interface{}, err *jsonschema.ValidationError) []SchemaProblem {
+	path := fieldPath(v, err.InstanceLocation)
+
+	switch k := err.ErrorKind.(type) {
+	case *kind.Type:
+		return []SchemaProblem{{Path: path, Message: fmt.Sprintf("expected %s, got %s", typeNames(k.Want), k.Got)}}
+
+	case *kind.AdditionalProperties:
+		problems := make([]SchemaProblem, len(k.Properties))
+		for i, name := range k.Properties {
+			problems[i] = SchemaProblem{Path: joinFieldPath(path, name), Message: "unknown field"}
+		}
+
+		return problems
+
+	case *kind.Required:
+		problems := make([]SchemaProblem, len(k.Missing))
+		for i, name := range k.Missing {
+			problems[i] = SchemaProblem{Path: path, Message: "missing " + name}
+		}
+
+		return problems
+
+	case *kind.AnyOf:
+		return anyOfProblems(v, err)
+	}
+
+	if len(err.Causes) == 0 {
+		return []SchemaProblem{{Path: path, Message: err.ErrorKind.LocalizedString(message.NewPrinter(language.English))}}
+	}
+
+	var problems []SchemaProblem
+	for _, cause := range err.Causes {
+		problems = append(problems, schemaProblems(v, cause)...)
+	}
+
+	return problems
+}
+
+// anyOfProblems returns the problems of the value matching none of the
+// schemas of an anyOf keyword, like a string shorthand or an object: those of
+// the first schema of the type of the value, if any.
+func anyOfProblems(v interface{}, err *jsonschema.ValidationError) []SchemaProblem {
+	var want []string
+	var got string
+
+	for _, cause := range err.Causes {
+		problems := schemaProblems(v, cause)
+
+		leaf := cause
+		for len(leaf.Causes) == 1 {
+			leaf = leaf.Causes[0]
+		}
+
+		k, ok := leaf.ErrorKind.(*kind.Type)
+		if !ok || len(leaf.InstanceLocation) != len(err.InstanceLocation) {
+			return problems
+		}
+
+		want = append(want, k.Want...)
+		got = k.Got
+	}
+
+	return []SchemaProblem{{Path: fieldPath(v, err.InstanceLocation), Message: fmt.Sprintf("expected %s, got %s", typeNames(want), got)}}
+}
+
+// typeNames returns the names of the JSON Schema types, without those added
+// by relaxType, for error messages, ie. "string or array".
+func typeNames(types []string) string {
+	relaxed := map[string]bool{"null": true}
+
+	for _, t := range types {
+		if t == "string" {
+			relaxed["number"], relaxed["boolean"] = true, true
+		}
+	}
+
+	var names []string
+	for _, t := range types {
+		if !relaxed[t] {
+			names = append(names, t)
+		}
+	}
+
+	return strings.Join(names, " or ")
+}
+
+// fieldPath returns the field path, ie. "[0].trigger-rule", of the value at
+// the JSON pointer tokens loc in the decoded hooks file v.
+func fieldPath(v interface{}, loc []string) string {
+	var path string
+
+	for _, token := range loc {
+		switch e := v.(type) {
+		case []interface{}:
+			path += "[" + token + "]"
+
+			if i, err := strconv.Atoi(token); err == nil && i < len(e) {
+				v = e[i]
+			}
+
+		case map[string]interface{}:
+			path = joinFieldPath(path, token)
+			v = e[token]
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,40 @@
+package hook
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// parseSemverConstraint parses the version constraint s, ie. ">=1.2.0 <2.0.0"
+// or "^1.4 || ^2", with the syntax of the semver package.
+func parseSemverConstraint(s string) (*semver.Constraints, error) {
+	c, err := semver.NewConstraint(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q: %w", s, err)
+	}
+
+	return c, nil
+}
+
+// CheckSemver reports whether version, a semantic version optionally prefixed
+// with "v", satisfies the constraint.  Prerelease versions only satisfy
+// comparators with a prerelease version, so that ">=1.2.0" doesn't match
+// 2.0.0-rc.1 while ">=2.0.0-rc.1" does.  Values that aren't semantic versions
+// satisfy no constraint.
+func CheckSemver(version, constraint string) (bool, error) {
+	c, err := parseSemverConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+
+	version = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(version), "v"), "V")
+
+	v, err := semver.StrictNewVersion(version)
+	if err != nil {
+		return false, nil
+	}
+
+	return c.Check(v), nil
+}
@@ -0,0 +1,115 @@
+package hook
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+// shell describes a shell running execute-shell scripts.
+type shell struct {
+	// command is the shell interpreter.
+	command string
+
+	// args precede the script.
+	args []string
+
+	// positional is set if pass-arguments-to-command are available to the
+	// script as positional parameters, ie. $1.  Other shells parse their
+	// command line themselves, so arguments can't be passed safely.
+	positional bool
+}
+
+// shells are the shells supported by execute-shell.
+var shells = map[string]shell{
+	"sh":         {"/bin/sh", []string{"-c"}, true},
+	"bash":       {"bash", []string{"-c"}, true},
+	"cmd":        {"cmd", []string{"/C"}, false},
+	"powershell": {"powershell", []string{"-NoProfile", "-NonInteractive", "-Command"}, false},
+	"pwsh":       {"pwsh", []string{"-NoProfile", "-NonInteractive", "-Command"}, false},
+}
+
+// ShellName returns the name of the shell running the execute-shell script of
+// the hook: the shell property, defaulting to cmd on Windows and sh elsewhere.
+func (h *Hook) ShellName() string {
+	if h.Shell != "" {
+		return h.Shell
+	}
+
+	if runtime.GOOS == "windows" {
+		return "cmd"
+	}
+
+	return "sh"
+}
+
+// Command returns the command run by the hook: the execute-command, or the
+// shell interpreter of the execute-shell script.
+func (h *Hook) Command() string {
+	if h.ExecuteShell == "" {
+		return h.ExecuteCommand
+	}
+
+	sh := shells[h.ShellName()]
+	if sh.command == "/bin/sh" && runtime.GOOS == "windows" {
+		return "sh"
+	}
+
+	return sh.command
+}
+
+// CommandPath returns the path of the command to look up with exec.LookPath:
+// the execute-command, relative to the command-working-directory, or the
+// shell interpreter, found in the PATH.
+func (h *Hook) CommandPath() string {
+	cmd := h.Command()
+
+	if h.ExecuteShell == "" && !filepath.IsAbs(cmd) && h.CommandWorkingDirectory != "" {
+		return filepath.Join(h.CommandWorkingDirectory, cmd)
+	}
+
+	return cmd
+}
+
+// shellArgs returns the arguments of the shell running the execute-shell
+// script, followed by the passed arguments args.
+func (h *Hook) shellArgs(args []string) []string {
+	sh := shells[h.ShellName()]
+
+	res := append(append([]string{h.Command()}, sh.args...), h.ExecuteShell)
+
+	if sh.positional {
+		// The hook ID becomes $0, so arguments start at $1.
+		res = append(append(res, h.ID), args...)
+	}
+
+	return res
+}
+
+// validateShell returns the problems found in the execute-shell properties.
+func (h *Hook) validateShell() []error {
+	if h.ExecuteShell == "" {
+		if h.Shell != "" {
+			return []error{fmt.Errorf("shell is set without execute-shell")}
+		}
+
+		return nil
+	}
+
+	var errs []error
+
+	if h.ExecuteCommand != "" {
+		errs = append(errs, fmt.Errorf("execute-command and execute-shell are mutually exclusive"))
+	}
+
+	sh, ok := shells[h.ShellName()]
+	if !ok {
+		return append(errs, fmt.Errorf("unknown shell %q", h.Shell))
+	}
+
+	if !sh.positional && len(h.PassArgumentsToCommand) > 0 {
+		errs = append(errs, fmt.Errorf("shell %s can't be passed arguments safely; use pass-environment-to-command", h.ShellName()))
+	}
+
+	return errs
+}
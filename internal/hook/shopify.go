@@ -0,0 +1,21 @@
+package hook
+
+// ShopifySignatureHeader is the header holding the signature of Shopify
+// webhooks.
+const ShopifySignatureHeader = "X-Shopify-Hmac-Sha256"
+
+// CheckShopifySignature checks the base64 encoded HMAC-SHA256 signature of
+// the request body in the X-Shopify-Hmac-Sha256 header, computed with the
+// app's client secret.
+func CheckShopifySignature(r *Request, secret string) (bool, error) {
+	signature, ok := r.Headers[ShopifySignatureHeader].(string)
+	if !ok {
+		return false, nil
+	}
+
+	if _, err := CheckPayloadHMACOptions(r.Body, secret, signature, HMACOptions{Algorithm: "sha256", Encoding: EncodingBase64}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
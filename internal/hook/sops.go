@@ -0,0 +1,86 @@
+package hook
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// SOPSCommandOption sets the sops command used to decrypt SOPS encrypted
+// hooks files, "sops" by default.
+func SOPSCommandOption(command string) LoadOption {
+	return func(o *loadOptions) {
+		if command != "" {
+			o.sopsCommand = command
+		}
+	}
+}
+
+// isSOPSEncrypted reports whether the hooks file contents in file are a
+// JSON or YAML document encrypted with SOPS, which holds its metadata in the
+// top-level sops object.
+func isSOPSEncrypted(file []byte) bool {
+	if !bytes.Contains(file, []byte("sops")) {
+		return false
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(file, &doc); err != nil {
+		return false
+	}
+
+	meta, ok := doc["sops"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	_, ok = meta["mac"]
+
+	return ok
+}
+
+// decryptSOPS decrypts the SOPS encrypted hooks file contents in file, loaded
+// from path, with the sops command, and returns the JSON plaintext.  The keys
+// are found by sops as usual, ie. through $SOPS_AGE_KEY_FILE, the AWS
+// credentials or the GnuPG agent.
+func decryptSOPS(path string, file []byte, command string) ([]byte, error) {
+	inputType := "yaml"
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		inputType = "json"
+	}
+
+	// The contents are written to a temporary file, as they may not come
+	// from a file, ie. for remote hooks files.  They are still encrypted.
+	f, err := ioutil.TempFile("", "webhook-sops-*."+inputType)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(file)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(command, "--decrypt", "--input-type", inputType, "--output-type", "json", f.Name())
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s with %s: %w: %s", path, command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package hook
+
+import (
+	"sort"
+	"sync"
+)
+
+// SourceResolver resolves argument values of a custom source, such as a value
+// stored in Redis, a database or an external API, registered with
+// RegisterSource.
+type SourceResolver interface {
+	// Resolve returns the value named name for the request req.
+	Resolve(name string, req *Request) (string, error)
+}
+
+// SourceFunc is a function implementing SourceResolver.
+type SourceFunc func(name string, req *Request) (string, error)
+
+// Resolve implements SourceResolver.
+func (f SourceFunc) Resolve(name string, req *Request) (string, error) {
+	return f(name, req)
+}
+
+// builtinSources are the argument sources implemented by this package.
+var builtinSources = map[string]bool{
+	SourceHeader: true, SourceQuery: true, SourceQueryAlias: true, SourcePayload: true,
+	SourceRawRequestBody: true, SourceRequest: true, SourceString: true,
+	SourceEntirePayload: true, SourceEntireQuery: true, SourceEntireHeaders: true,
+	SourceElement: true, SourceNormalized: true, SourceIdentity: true, SourceVault: true,
+	SourcePlugin: true,
+}
+
+var sources = struct {
+	sync.RWMutex
+	m map[string]SourceResolver
+}{m: make(map[string]SourceResolver)}
+
+// RegisterSource makes the SourceResolver s resolve arguments with the source
+// name.  It is meant to be called from init functions, and panics if name is
+// empty, a built-in source or already registered.
+func RegisterSource(name string, s SourceResolver) {
+	sources.Lock()
+	defer sources.Unlock()
+
+	switch {
+	case name == "":
+		panic("hook: RegisterSource with an empty name")
+	case s == nil:
+		panic("hook: RegisterSource with a nil SourceResolver for source " + name)
+	case builtinSources[name]:
+		panic("hook: RegisterSource with built-in source " + name)
+	}
+
+	if _, dup := sources.m[name]; dup {
+		panic("hook: RegisterSource called twice for source " + name)
+	}
+
+	sources.m[name] = s
+}
+
+// Sources returns the names of the registered argument sources, sorted.
+func Sources() []string {
+	sources.RLock()
+	defer sources.RUnlock()
+
+	names := make([]string, 0, len(sources.m))
+	for name := range sources.m {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// lookupSource returns the SourceResolver registered for name, or nil.
+func lookupSource(name string) SourceResolver {
+	sources.RLock()
+	defer sources.RUnlock()
+
+	return sources.m[name]
+}
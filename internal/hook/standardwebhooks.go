@@ -0,0 +1,81 @@
+package hook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StandardWebhooksTolerance is how far the webhook-timestamp of Standard
+// Webhooks requests may be from the current time.
+const StandardWebhooksTolerance = 5 * time.Minute
+
+// standardWebhooksHeaders holds the id, timestamp and signature headers of
+// the Standard Webhooks specification, and those of Svix, which it was
+// derived from.
+var standardWebhooksHeaders = [][3]string{
+	{"Webhook-Id", "Webhook-Timestamp", "Webhook-Signature"},
+	{"Svix-Id", "Svix-Timestamp", "Svix-Signature"},
+}
+
+// CheckStandardWebhooksSignature checks the signatures of a request signed
+// according to the Standard Webhooks specification, as sent by Svix based
+// senders.  The webhook-signature header holds space-separated signatures,
+// each a version and the base64 encoded HMAC-SHA256 of the webhook-id,
+// webhook-timestamp and body joined by dots, ie. "v1,K5oZ...".  The secret is
+// base64 encoded, optionally prefixed with "whsec_".
+func CheckStandardWebhooksSignature(r *Request, secret string) (bool, error) {
+	var id, timestamp, signature string
+
+	for _, h := range standardWebhooksHeaders {
+		id, _ = r.Headers[h[0]].(string)
+		timestamp, _ = r.Headers[h[1]].(string)
+		signature, _ = r.Headers[h[2]].(string)
+
+		if id != "" && timestamp != "" && signature != "" {
+			break
+		}
+	}
+
+	if id == "" || timestamp == "" || signature == "" {
+		return false, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil || len(key) == 0 {
+		return false, errors.New("standard-webhooks secret is not base64 encoded")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, &SignatureError{Signature: "invalid timestamp " + timestamp}
+	}
+
+	if d := Now().Sub(time.Unix(seconds, 0)); d > StandardWebhooksTolerance || d < -StandardWebhooksTolerance {
+		return false, &SignatureError{Signature: "outdated"}
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(r.Body)
+	sum := mac.Sum(nil)
+
+	signatures := strings.Fields(signature)
+
+	for _, s := range signatures {
+		i := strings.Index(s, ",")
+		if i == -1 || s[:i] != "v1" {
+			continue
+		}
+
+		if b, err := base64.StdEncoding.DecodeString(s[i+1:]); err == nil && hmac.Equal(b, sum) {
+			return true, nil
+		}
+	}
+
+	return false, &SignatureError{Signatures: signatures}
+}
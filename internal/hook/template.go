@@ -0,0 +1,286 @@
+package hook
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/ghodss/yaml"
+)
+
+// LoadOption configures how hooks files are loaded.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	// templateMissingKey is the text/template "missingkey" option.
+	templateMissingKey string
+
+	// templateMaxSize is the maximum size in bytes of a hooks file parsed as
+	// a template.  Zero means no limit.
+	templateMaxSize int64
+
+	// templateFuncs, if not nil, is the set of functions a template may use.
+	templateFuncs map[string]bool
+
+	// templateValuesFiles are the YAML or JSON files whose merged contents
+	// are the data templates are executed with.
+	templateValuesFiles []string
+
+	// templateValues is the data read from templateValuesFiles.
+	templateValues map[string]interface{}
+
+	// strict rejects hooks files with unknown fields.
+	strict bool
+
+	// sopsCommand decrypts SOPS encrypted hooks files.
+	sopsCommand string
+}
+
+func newLoadOptions(options ...LoadOption) *loadOptions {
+	o := &loadOptions{templateMissingKey: "default", sopsCommand: "sops"}
+	for _, opt := range options {
+		opt(o)
+	}
+	return o
+}
+
+// TemplateMissingKeyOption sets the behavior of templates referencing a
+// missing key: "default", "zero" or "error".  When set to "error", getenv also
+// fails for unset environment variables.
+func TemplateMissingKeyOption(v string) LoadOption {
+	return func(o *loadOptions) {
+		if v != "" {
+			o.templateMissingKey = v
+		}
+	}
+}
+
+// TemplateMaxSizeOption limits the size in bytes of hooks files parsed as
+// templates.  Zero means no limit.
+func TemplateMaxSizeOption(n int64) LoadOption {
+	return func(o *loadOptions) {
+		o.templateMaxSize = n
+	}
+}
+
+// TemplateFuncsOption restricts templates to the given built-in and webhook
+// provided functions.  An empty list means no restriction.
+func TemplateFuncsOption(funcs []string) LoadOption {
+	return func(o *loadOptions) {
+		if len(funcs) == 0 {
+			o.templateFuncs = nil
+			return
+		}
+
+		o.templateFuncs = make(map[string]bool, len(funcs))
+		for _, f := range funcs {
+			if f = strings.TrimSpace(f); f != "" {
+				o.templateFuncs[f] = true
+			}
+		}
+	}
+}
+
+// TemplateValuesOption makes the contents of the YAML or JSON values files
+// paths the data templates are executed with, so they can be referenced as
+// {{ .key }}.  Values of later files override those of earlier ones, merging
+// nested objects.  The files are read every time a hooks file is loaded.
+func TemplateValuesOption(paths ...string) LoadOption {
+	return func(o *loadOptions) {
+		o.templateValuesFiles = append(o.templateValuesFiles, paths...)
+	}
+}
+
+// readTemplateValues reads and merges the values files paths.
+func readTemplateValues(paths []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading template values: %w", err)
+		}
+
+		var v map[string]interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("error parsing template values %s: %w", path, err)
+		}
+
+		mergeValues(values, v)
+	}
+
+	return values, nil
+}
+
+// mergeValues merges src into dst, recursively merging objects
+// present in both.
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sv, ok := v.(map[string]interface{}); ok {
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeValues(dv, sv)
+				continue
+			}
+		}
+
+		dst[k] = v
+	}
+}
+
+// StrictOption rejects hooks files containing fields that don't correspond to
+// any hook property, such as misspelled ones, instead of ignoring them.
+func StrictOption() LoadOption {
+	return func(o *loadOptions) {
+		o.strict = true
+	}
+}
+
+// maxIncludeDepth limits nesting of included templates, which also guards
+// against include cycles.
+const maxIncludeDepth = 10
+
+// executeTemplate parses and executes the hooks file contents in file as a Go
+// text/template.  The path of the file is used to resolve relative includes.
+func executeTemplate(file []byte, path string, o *loadOptions, depth int) ([]byte, error) {
+	if o.templateMaxSize > 0 && int64(len(file)) > o.templateMaxSize {
+		return nil, fmt.Errorf("hooks template %s is %d bytes, exceeding the maximum of %d bytes", path, len(file), o.templateMaxSize)
+	}
+
+	strict := o.templateMissingKey == "error"
+
+	// Templates can use the functions of the Sprig library, next to the
+	// webhook ones.
+	funcMap := sprig.TxtFuncMap()
+
+	for name, fn := range map[string]interface{}{
+		"getenv": func(s string, def ...string) (string, error) {
+			v, ok := os.LookupEnv(s)
+			if ok {
+				return v, nil
+			}
+			if len(def) > 0 {
+				return def[0], nil
+			}
+			if strict {
+				return "", fmt.Errorf("environment variable %q is not set", s)
+			}
+			return "", nil
+		},
+		"include": func(name string) (string, error) {
+			if depth >= maxIncludeDepth {
+				return "", fmt.Errorf("error including %s: maximum include depth of %d exceeded", name, maxIncludeDepth)
+			}
+
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(filepath.Dir(path), name)
+			}
+
+			b, err := ioutil.ReadFile(name)
+			if err != nil {
+				return "", err
+			}
+
+			b, err = executeTemplate(b, name, o, depth+1)
+			if err != nil {
+				return "", err
+			}
+
+			return string(b), nil
+		},
+	} {
+		funcMap[name] = fn
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(funcMap).Option("missingkey=" + o.templateMissingKey).Parse(string(file))
+	if err != nil {
+		return nil, err
+	}
+
+	if o.templateFuncs != nil {
+		for _, t := range tmpl.Templates() {
+			if t.Tree == nil {
+				continue
+			}
+
+			if err := checkTemplateFuncs(t.Tree.Root, o.templateFuncs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	data := o.templateValues
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// checkTemplateFuncs walks a template parse tree and returns an error if a
+// function not present in allowed is used.
+func checkTemplateFuncs(node parse.Node, allowed map[string]bool) error {
+	if node == nil {
+		return nil
+	}
+
+	var children []parse.Node
+
+	switch n := node.(type) {
+	case *parse.IdentifierNode:
+		if !allowed[n.Ident] {
+			names := make([]string, 0, len(allowed))
+			for k := range allowed {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+
+			return fmt.Errorf("template function %q is not allowed; allowed functions: %s", n.Ident, strings.Join(names, ", "))
+		}
+	case *parse.ListNode:
+		if n != nil {
+			children = append(children, n.Nodes...)
+		}
+	case *parse.ActionNode:
+		children = append(children, n.Pipe)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, c := range n.Cmds {
+				children = append(children, c)
+			}
+		}
+	case *parse.CommandNode:
+		children = append(children, n.Args...)
+	case *parse.ChainNode:
+		children = append(children, n.Node)
+	case *parse.IfNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.RangeNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.WithNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.TemplateNode:
+		children = append(children, n.Pipe)
+	}
+
+	for _, c := range children {
+		if err := checkTemplateFuncs(c, allowed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
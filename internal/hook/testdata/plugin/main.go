@@ -0,0 +1,61 @@
+// Plugin is a WebAssembly plugin used to test plugins, built with
+// GOOS=wasip1 GOARCH=wasm.  The action payload value selects what it does.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+func main() {
+	var in struct {
+		Hook       string                 `json:"hook"`
+		Method     string                 `json:"method"`
+		Payload    map[string]interface{} `json:"payload"`
+		Body       []byte                 `json:"body"`
+		Parameters map[string]interface{} `json:"parameters"`
+	}
+
+	if err := json.NewDecoder(os.Stdin).Decode(&in); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	out := map[string]interface{}{}
+
+	switch in.Payload["action"] {
+	case "veto":
+		out["veto"] = true
+		out["reason"] = "not allowed"
+	case "fail":
+		fmt.Fprintln(os.Stderr, "failing as asked")
+		os.Exit(3)
+	case "loop":
+		for {
+		}
+	case "files":
+		if _, err := os.ReadDir("/"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "none":
+	default:
+		in.Payload["rewritten"] = true
+		out["payload"] = in.Payload
+		out["parameters"] = map[string]interface{}{
+			"hook":   in.Hook,
+			"method": in.Method,
+			"length": len(in.Body),
+			"runs":   runs(in.Parameters) + 1,
+		}
+	}
+
+	json.NewEncoder(os.Stdout).Encode(out)
+}
+
+// runs returns how many times the plugin ran for the request before.
+func runs(params map[string]interface{}) int {
+	n, _ := params["runs"].(float64)
+	return int(n)
+}
@@ -0,0 +1,71 @@
+package hook
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxAge is how far the timestamp checked by timestamp-fresh rules may
+// be from the current time if the rule doesn't set a max-age.
+const DefaultMaxAge = 5 * time.Minute
+
+// Layouts of timestamp-fresh rules besides Go time layouts.
+const (
+	TimestampUnix   = "unix"
+	TimestampUnixMs = "unix-ms"
+	TimestampHTTP   = "http"
+)
+
+// ParseTimestamp parses value according to layout: "unix" (the default) for
+// seconds since the epoch, "unix-ms" for milliseconds since the epoch, "http"
+// for the date formats of HTTP headers, or a Go time layout, ie.
+// "2006-01-02T15:04:05Z07:00".
+func ParseTimestamp(value, layout string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	switch layout {
+	case "", TimestampUnix, TimestampUnixMs:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a unix timestamp", value)
+		}
+
+		if layout == TimestampUnixMs {
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		}
+
+		return time.Unix(n, 0), nil
+
+	case TimestampHTTP:
+		return http.ParseTime(value)
+	}
+
+	return time.Parse(layout, value)
+}
+
+// CheckTimestampFresh reports whether the timestamp value, parsed according
+// to layout, is at most maxAge before or after the current time.
+func CheckTimestampFresh(value, layout string, maxAge time.Duration) (bool, error) {
+	t, err := ParseTimestamp(value, layout)
+	if err != nil {
+		return false, err
+	}
+
+	d := Now().Sub(t)
+
+	return d <= maxAge && d >= -maxAge, nil
+}
+
+// maxAge returns the max-age of the rule, or def if it isn't set.
+func (r MatchRule) maxAge(def time.Duration) time.Duration {
+	if r.MaxAge == "" {
+		return def
+	}
+
+	d, _ := time.ParseDuration(r.MaxAge)
+
+	return d
+}
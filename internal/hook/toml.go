@@ -0,0 +1,15 @@
+package hook
+
+import "github.com/BurntSushi/toml"
+
+// parseTOML decodes the TOML document data into a map.  Dates and times are
+// decoded as time.Time, and so converted to RFC 3339 strings in JSON.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+
+	if _, err := toml.Decode(string(data), &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
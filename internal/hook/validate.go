@@ -0,0 +1,433 @@
+package hook
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Validate returns the problems found in the hook definition that would only
+// otherwise be discovered when the hook is requested.  It doesn't check that
+// the command exists.
+func (h *Hook) Validate() []error {
+	var errs []error
+
+	switch h.Kind {
+	case "", KindCommand, KindService:
+		if h.ExecuteCommand == "" && h.ExecuteShell == "" {
+			errs = append(errs, fmt.Errorf("missing execute-command"))
+		}
+
+		errs = append(errs, h.validateShell()...)
+	case KindProxy:
+		if h.ProxyURL == "" {
+			errs = append(errs, fmt.Errorf("missing proxy-url"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown kind %q", h.Kind))
+	}
+
+	switch h.CommandOutputFormat {
+	case "", OutputFormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported command-output-format %q", h.CommandOutputFormat))
+	}
+
+	if h.CaptureRequestsMax < 0 {
+		errs = append(errs, fmt.Errorf("invalid capture-requests-max %d", h.CaptureRequestsMax))
+	}
+
+	if h.ReadTimeout != "" {
+		if _, err := time.ParseDuration(h.ReadTimeout); err != nil {
+			errs = append(errs, fmt.Errorf("invalid read-timeout: %w", err))
+		}
+	}
+
+	if h.ResponseDeadline != "" {
+		if d, err := time.ParseDuration(h.ResponseDeadline); err != nil {
+			errs = append(errs, fmt.Errorf("invalid response-deadline: %w", err))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("response-deadline %s is not positive", h.ResponseDeadline))
+		}
+	}
+
+	if h.SuccessCriteria != nil && h.SuccessCriteria.OutputRegex != "" {
+		if _, err := regexp.Compile(h.SuccessCriteria.OutputRegex); err != nil {
+			errs = append(errs, fmt.Errorf("invalid success-criteria output-regex: %w", err))
+		}
+	}
+
+	if h.IncomingPath != "" && !strings.HasPrefix(h.IncomingPath, "/") {
+		errs = append(errs, fmt.Errorf("incoming-path %q doesn't start with /", h.IncomingPath))
+	}
+
+	if h.HideID && h.IncomingPath == "" {
+		errs = append(errs, fmt.Errorf("hide-id requires an incoming-path"))
+	}
+
+	if err := ValidateSanitizeOptions(h.SanitizeOutput); err != nil {
+		errs = append(errs, err)
+	}
+
+	if h.AckFirst && (h.Kind == KindProxy || h.Kind == KindService) {
+		errs = append(errs, fmt.Errorf("ack-first is not supported for %s hooks", h.Kind))
+	}
+
+	if h.Retry != nil {
+		errs = append(errs, h.Retry.Validate()...)
+	}
+
+	if h.DecryptPayload != nil {
+		errs = append(errs, h.DecryptPayload.Validate()...)
+	}
+
+	if h.ReplayProtection != nil {
+		errs = append(errs, h.ReplayProtection.Validate()...)
+	}
+
+	if h.LogFile != nil {
+		errs = append(errs, h.LogFile.Validate()...)
+	}
+
+	if h.PayloadFile != nil {
+		errs = append(errs, h.PayloadFile.Validate()...)
+	}
+
+	switch h.FileRetention {
+	case "", FileRetentionDelete, FileRetentionKeep:
+	default:
+		if d, err := time.ParseDuration(h.FileRetention); err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("file-retention must be %q, %q or a duration", FileRetentionDelete, FileRetentionKeep))
+		}
+	}
+
+	for _, rawurl := range h.OnFailure {
+		// The error of url.Parse would disclose passwords in the URL.
+		if u, err := url.Parse(rawurl); err != nil {
+			errs = append(errs, fmt.Errorf("invalid on-failure URL"))
+		} else if u.Scheme != "slack" && u.Scheme != "smtp" && u.Scheme != "http" && u.Scheme != "https" {
+			errs = append(errs, fmt.Errorf("unsupported on-failure scheme %q", u.Scheme))
+		}
+	}
+
+	if h.CallbackURL != "" {
+		if u, err := url.Parse(h.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("callback-url must be an http or https URL"))
+		}
+	}
+
+	switch h.CallbackOn {
+	case "", CallbackOnCompletion, CallbackOnSuccess:
+	default:
+		errs = append(errs, fmt.Errorf("unknown callback-on %q", h.CallbackOn))
+	}
+
+	if h.CORS != nil {
+		errs = append(errs, h.CORS.Validate()...)
+	}
+
+	for i := range h.Plugins {
+		for _, err := range h.Plugins[i].Validate() {
+			errs = append(errs, fmt.Errorf("plugins[%d]: %w", i, err))
+		}
+	}
+
+	for _, err := range h.TriggerRule.Validate() {
+		errs = append(errs, fmt.Errorf("trigger-rule: %w", err))
+	}
+
+	return errs
+}
+
+// checkLoaded returns an error for the first hook whose read-timeout can't be
+// parsed, so that the hooks file fails to load rather than the hook once it
+// is requested.
+func (h Hooks) checkLoaded() error {
+	for i := range h {
+		if h[i].ReadTimeout == "" {
+			continue
+		}
+
+		if _, err := time.ParseDuration(h[i].ReadTimeout); err != nil {
+			return fmt.Errorf("hook %s: invalid read-timeout: %w", h[i].ID, err)
+		}
+	}
+
+	return nil
+}
+
+// ruleProperties are the names of the Rules properties, in the order they are
+// evaluated.
+var ruleProperties = []string{"and", "or", "not", "any", "all", "match"}
+
+// Validate returns the problems found in the rules, such as rules setting
+// more than one property, empty and/or rules, unknown match types and invalid
+// regular expressions.  A nil Rules is valid.
+func (r *Rules) Validate() []error {
+	if r == nil {
+		return nil
+	}
+
+	var set []string
+
+	for i, ok := range []bool{r.And != nil, r.Or != nil, r.Not != nil, r.Any != nil, r.All != nil, r.Match != nil} {
+		if ok {
+			set = append(set, ruleProperties[i])
+		}
+	}
+
+	switch len(set) {
+	case 0:
+		return []error{fmt.Errorf("empty rule; set one of %s", strings.Join(ruleProperties, ", "))}
+	case 1:
+	default:
+		// Only the first property is evaluated; the others are ignored.
+		return []error{fmt.Errorf("rule sets %s; only one of them may be set", strings.Join(set, ", "))}
+	}
+
+	var errs []error
+
+	add := func(prefix string, children []error) {
+		for _, err := range children {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+
+	switch {
+	case r.And != nil:
+		if len(*r.And) == 0 {
+			errs = append(errs, fmt.Errorf("empty and rule"))
+		}
+
+		for i := range *r.And {
+			add(fmt.Sprintf("and[%d]", i), (*r.And)[i].Validate())
+		}
+	case r.Or != nil:
+		if len(*r.Or) == 0 {
+			errs = append(errs, fmt.Errorf("empty or rule"))
+		}
+
+		for i := range *r.Or {
+			add(fmt.Sprintf("or[%d]", i), (*r.Or)[i].Validate())
+		}
+	case r.Not != nil:
+		add("not", (*Rules)(r.Not).Validate())
+	case r.Any != nil:
+		add("any", (*ElementRule)(r.Any).validate())
+	case r.All != nil:
+		add("all", (*ElementRule)(r.All).validate())
+	case r.Match != nil:
+		if err := r.Match.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("match: %w", err))
+		}
+	}
+
+	return errs
+}
+
+// validate returns the problems found in the element rule.
+func (r *ElementRule) validate() []error {
+	var errs []error
+
+	if r.Parameter.Source == "" {
+		errs = append(errs, fmt.Errorf("missing parameter source"))
+	}
+
+	for _, err := range r.Rule.Validate() {
+		errs = append(errs, fmt.Errorf("rule: %w", err))
+	}
+
+	return errs
+}
+
+// Validate returns the first problem found in the match rule.
+func (r *MatchRule) Validate() error {
+	if r.CompareToEnv != "" && r.Type != MatchValue && r.Type != MatchRegex {
+		return fmt.Errorf("compare-to-env is only supported by value and regex rules")
+	}
+
+	switch r.Type {
+	case "":
+		return fmt.Errorf("missing type")
+
+	case IPWhitelist, IPBlacklist:
+		if r.IPRange == "" && len(r.IPRules) == 0 {
+			return fmt.Errorf("missing ip-range")
+		}
+
+		for _, rule := range r.IPRules {
+			if _, _, err := parseIPRule(rule); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case GitHubWhitelist, MatchBitbucket:
+		if r.RefreshInterval != "" {
+			if _, err := time.ParseDuration(r.RefreshInterval); err != nil {
+				return fmt.Errorf("invalid refresh-interval: %w", err)
+			}
+		}
+		return nil
+
+	case ScalrSignature, ShopifySignature, GiteaSignature, StandardWebhooks,
+		MailgunSignature, DocuSignHMAC:
+		if r.secretMissing() {
+			return fmt.Errorf("missing secret")
+		}
+		if r.Type == ScalrSignature {
+			return r.validateMaxAge()
+		}
+		return nil
+
+	case MatchHTTPMethod, MatchSSOGroup, GiteaEvent, PayPalSignature:
+		if r.Value == "" {
+			return fmt.Errorf("missing value")
+		}
+		return nil
+
+	case MatchURLPath:
+		return validateRegex(r.Regex)
+
+	case GeoIPCountry:
+		return validateCountries(r.Value)
+
+	case DNSWhitelist:
+		return validateHostnames(r.Value)
+
+	case MatchCommand, MatchHTTPAuth:
+		if r.Type == MatchCommand && r.Command == "" {
+			return fmt.Errorf("missing command")
+		}
+		if r.Type == MatchHTTPAuth {
+			if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid url %q: expected an http:// or https:// URL", r.URL)
+			}
+		}
+		if r.Timeout != "" {
+			if d, err := time.ParseDuration(r.Timeout); err != nil || d <= 0 {
+				return fmt.Errorf("invalid timeout %q: expected a positive duration", r.Timeout)
+			}
+		}
+		return nil
+	}
+
+	var err error
+
+	switch r.Type {
+	case MatchValue:
+		if r.CompareToEnv != "" && r.Value != "" {
+			err = fmt.Errorf("value can't be set with compare-to-env")
+		}
+	case MatchRegex:
+		if r.CompareToEnv == "" {
+			err = validateRegex(r.Regex)
+		} else if r.Regex != "" {
+			err = fmt.Errorf("regex can't be set with compare-to-env")
+		}
+	case MatchGlob:
+		if _, e := path.Match(r.Value, ""); e != nil {
+			err = fmt.Errorf("invalid glob %q: %w", r.Value, e)
+		}
+	case MatchSemver:
+		_, err = parseSemverConstraint(r.Value)
+	case MatchCompare:
+		switch {
+		case r.CompareTo.Source == "":
+			err = fmt.Errorf("missing compare-to source")
+		case r.Operator != "" && r.Operator != CompareEquals && r.Operator != CompareNotEquals && r.Operator != CompareContains:
+			err = fmt.Errorf("unknown operator %q", r.Operator)
+		}
+	case MatchHMACSHA1, MatchHMACSHA256, MatchHMACSHA512, MatchHashSHA1, MatchHashSHA256, MatchHashSHA512:
+		if r.Encoding != "" && r.Encoding != EncodingHex && r.Encoding != EncodingBase64 {
+			err = fmt.Errorf("unknown encoding %q", r.Encoding)
+		} else if r.secretMissing() {
+			err = fmt.Errorf("missing secret")
+		}
+	case MatchHMAC:
+		if _, ok := HashAlgorithm(r.Algorithm); !ok {
+			err = fmt.Errorf("unknown algorithm %q", r.Algorithm)
+		} else if r.Encoding != "" && r.Encoding != EncodingHex && r.Encoding != EncodingBase64 {
+			err = fmt.Errorf("unknown encoding %q", r.Encoding)
+		} else if r.secretMissing() {
+			err = fmt.Errorf("missing secret")
+		}
+	case MatchGT, MatchLT, MatchGTE, MatchLTE, MatchBetween:
+		_, err = CheckNumber("0", r.Type, r.Value)
+	case MatchTimestamp:
+		err = r.validateMaxAge()
+	default:
+		return r.validateCustom()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if r.Parameter.Source == "" {
+		return fmt.Errorf("missing parameter source")
+	}
+
+	return nil
+}
+
+// validateMaxAge returns an error if the max-age of r is set and is not a
+// positive duration.
+func (r *MatchRule) validateMaxAge() error {
+	if r.MaxAge == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(r.MaxAge)
+	if err != nil {
+		return fmt.Errorf("invalid max-age: %w", err)
+	}
+
+	if d <= 0 {
+		return fmt.Errorf("max-age %s is not positive", r.MaxAge)
+	}
+
+	return nil
+}
+
+// validateRegex returns an error if expr is empty or not a valid regular
+// expression.
+func validateRegex(expr string) error {
+	if expr == "" {
+		return fmt.Errorf("missing regex")
+	}
+
+	if _, err := regexp.Compile(expr); err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+
+	return nil
+}
+
+// secretMissing reports whether r has no secret or secret file, or an empty
+// one.
+func (r *MatchRule) secretMissing() bool {
+	if len(r.SecretFile) == 0 {
+		return r.Secret.missing()
+	}
+
+	return (len(r.Secret) > 0 && r.Secret.missing()) || r.SecretFile.missing()
+}
+
+// missing reports whether s has no secrets, or an empty one.
+func (s Secrets) missing() bool {
+	if len(s) == 0 {
+		return true
+	}
+
+	for _, secret := range s {
+		if secret == "" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,273 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultPrefix marks secrets read from HashiCorp Vault, ie.
+// "vault:secret/data/webhooks#github".
+const vaultPrefix = "vault:"
+
+// DefaultVaultRefresh is the interval after which Vault secrets without a
+// lease, such as those of the KV version 2 engine, are read again.
+const DefaultVaultRefresh = 5 * time.Minute
+
+// vaultClient reads and caches secrets from a Vault server.
+type vaultClient struct {
+	mu        sync.Mutex
+	addr      string
+	token     string
+	namespace string
+	cache     map[string]vaultSecret
+	client    *http.Client
+
+	// prefixed is whether secrets starting with vaultPrefix are read from
+	// Vault.
+	prefixed bool
+
+	// inflight holds the reads in progress, so concurrent requests for a
+	// secret wait for the same read.
+	inflight map[string]*vaultRead
+
+	// gen counts the calls of ConfigureVault, which reset the cache.
+	gen int
+}
+
+// vaultSecret is a cached Vault secret.
+type vaultSecret struct {
+	data    map[string]interface{}
+	read    time.Time
+	expires time.Time
+}
+
+// vaultRead is a read of a Vault secret in progress.
+type vaultRead struct {
+	done   chan struct{}
+	secret vaultSecret
+	err    error
+}
+
+var vault = &vaultClient{client: &http.Client{Timeout: 10 * time.Second}}
+
+// ConfigureVault sets the address, token and namespace of the Vault server
+// secrets are read from.  Empty values default to the VAULT_ADDR,
+// VAULT_TOKEN and VAULT_NAMESPACE environment variables.  Secrets starting
+// with "vault:" are only read from Vault if prefixed is set, and are used as
+// given otherwise.
+func ConfigureVault(addr, token, namespace string, prefixed bool) {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+
+	vault.addr, vault.token, vault.namespace = addr, token, namespace
+	vault.prefixed = prefixed
+	vault.cache = nil
+	vault.gen++
+}
+
+// resolveSecret returns the secret s, reading it from Vault if it is a vault:
+// reference and those are enabled.
+func resolveSecret(s string) (string, error) {
+	if !strings.HasPrefix(s, vaultPrefix) {
+		return s, nil
+	}
+
+	vault.mu.Lock()
+	prefixed := vault.prefixed
+	vault.mu.Unlock()
+
+	if !prefixed {
+		return s, nil
+	}
+
+	return vault.Secret(strings.TrimPrefix(s, vaultPrefix))
+}
+
+// Secret returns the value of the key of the secret at path, given as
+// "path#key", reading the secret again once its lease expires.  If reading
+// fails, the previously read secret is used.  Secrets are read without
+// holding the lock, and concurrent requests for the same path share a read.
+func (c *vaultClient) Secret(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i == -1 || i == len(ref)-1 {
+		return "", fmt.Errorf("vault reference %q has no #key", ref)
+	}
+
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	s, err := c.secret(path)
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := s.data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in Vault secret %s", key, path)
+	}
+
+	return valueAsString(v)
+}
+
+// secret returns the secret at path, from the cache unless it expired.
+func (c *vaultClient) secret(path string) (vaultSecret, error) {
+	c.mu.Lock()
+
+	s, ok := c.cache[path]
+	if ok && !time.Now().After(s.expires) {
+		c.mu.Unlock()
+		return s, nil
+	}
+
+	if r, reading := c.inflight[path]; reading {
+		c.mu.Unlock()
+		<-r.done
+		return r.secret, r.err
+	}
+
+	r := &vaultRead{done: make(chan struct{})}
+	if c.inflight == nil {
+		c.inflight = make(map[string]*vaultRead)
+	}
+	c.inflight[path] = r
+
+	addr, token, namespace, gen := c.addr, c.token, c.namespace, c.gen
+	c.mu.Unlock()
+
+	fresh, err := c.read(path, addr, token, namespace)
+
+	c.mu.Lock()
+	delete(c.inflight, path)
+
+	// Secrets read with the settings replaced by ConfigureVault in the
+	// meantime aren't cached.
+	current := gen == c.gen
+
+	switch {
+	case err == nil:
+		s = fresh
+		if current {
+			if c.cache == nil {
+				c.cache = make(map[string]vaultSecret)
+			}
+			c.cache[path] = s
+		}
+
+	case !ok:
+		// There is no previously read secret to fall back to.
+
+	default:
+		log.Printf("error refreshing Vault secret %s, using the secret read at %s: %s", path, s.read.Format(time.RFC3339), err)
+
+		// Retry after the default refresh rather than on every request.
+		s.expires = time.Now().Add(DefaultVaultRefresh)
+		if current {
+			c.cache[path] = s
+		}
+		err = nil
+	}
+	c.mu.Unlock()
+
+	r.secret, r.err = s, err
+	close(r.done)
+
+	return s, err
+}
+
+// read reads the secret at path from the Vault server at addr.
+func (c *vaultClient) read(path, addr, token, namespace string) (vaultSecret, error) {
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	if addr == "" {
+		return vaultSecret{}, fmt.Errorf("no Vault address configured for secret %s", path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+
+	req.Header.Set("X-Vault-Token", token)
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return vaultSecret{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return vaultSecret{}, fmt.Errorf("error reading Vault secret %s: %s", path, res.Status)
+	}
+
+	var body struct {
+		LeaseDuration int                    `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return vaultSecret{}, fmt.Errorf("error decoding Vault secret %s: %w", path, err)
+	}
+
+	data := body.Data
+
+	// The KV version 2 engine nests the secret with its metadata.
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"].(map[string]interface{}); ok {
+			data = inner
+		}
+	}
+
+	refresh := DefaultVaultRefresh
+	if body.LeaseDuration > 0 {
+		refresh = time.Duration(body.LeaseDuration) * time.Second
+	}
+
+	now := time.Now()
+
+	return vaultSecret{data: data, read: now, expires: now.Add(refresh)}, nil
+}
+
+// resolveVaultSecrets reads the Vault secrets referenced by the hooks, so
+// missing secrets are reported when the hooks are loaded rather than when
+// they are requested.
+func (h *Hooks) resolveVaultSecrets() error {
+	for _, hook := range *h {
+		for _, m := range hook.TriggerRule.MatchRules() {
+			for _, s := range m.Secret {
+				if _, err := resolveSecret(s); err != nil {
+					return fmt.Errorf("hook %s: %w", hook.ID, err)
+				}
+			}
+		}
+
+		for _, args := range [][]Argument{hook.PassArgumentsToCommand, hook.PassEnvironmentToCommand, hook.PassFileToCommand} {
+			for _, a := range args {
+				if a.Source != SourceVault {
+					continue
+				}
+
+				if _, err := vault.Secret(a.Name); err != nil {
+					return fmt.Errorf("hook %s: %w", hook.ID, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BasePath is a middleware that serves requests under the path prefix base,
+// ie. "/api/webhooks", removing it from the request path so the handler sees
+// the paths it would be served at without a prefix.  Requests outside of base
+// are answered with 404 Not Found.
+func BasePath(base string) func(http.Handler) http.Handler {
+	base = "/" + strings.Trim(base, "/")
+
+	return func(next http.Handler) http.Handler {
+		if base == "/" {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := strings.TrimPrefix(r.URL.Path, base)
+			if len(path) == len(r.URL.Path) || (path != "" && path[0] != '/') {
+				http.NotFound(w, r)
+				return
+			}
+
+			if path == "" {
+				path = "/"
+			}
+
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = path
+			r2.URL.RawPath = ""
+
+			if raw := strings.TrimPrefix(r.URL.RawPath, base); len(raw) < len(r.URL.RawPath) {
+				r2.URL.RawPath = raw
+			}
+
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and CIDR
+// ranges.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", p)
+			}
+
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, cidr, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q: %s", p, err)
+		}
+
+		res = append(res, cidr)
+	}
+
+	return res, nil
+}
+
+// RealIP is a middleware that replaces the request's RemoteAddr with the client
+// address from the X-Forwarded-For or X-Real-IP header.  The headers are only
+// used if the direct peer is one of the trusted proxies, since anyone can set
+// them.
+func RealIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	isTrusted := trustedFunc(trusted)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if trustedPeer(r, isTrusted) {
+				if ip := forwardedFor(r, isTrusted); ip != nil {
+					r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TrustedHeaders is a middleware that removes the named headers from requests
+// whose direct peer is not one of the trusted proxies, so that only headers
+// injected by the proxies reach the handler.  It must run before RealIP.
+func TrustedHeaders(trusted []*net.IPNet, names ...string) func(http.Handler) http.Handler {
+	isTrusted := trustedFunc(trusted)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !trustedPeer(r, isTrusted) {
+				for _, name := range names {
+					r.Header.Del(name)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedFunc returns a function reporting whether an address is one of the
+// trusted proxies.
+func trustedFunc(trusted []*net.IPNet) func(net.IP) bool {
+	return func(ip net.IP) bool {
+		for _, n := range trusted {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// trustedPeer reports whether the direct peer of r is trusted.
+func trustedPeer(r *http.Request, isTrusted func(net.IP) bool) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+
+	return peer != nil && isTrusted(peer)
+}
+
+// forwardedFor returns the client address of a request received from a
+// trusted proxy.  X-Forwarded-For is read from right to left, skipping trusted
+// proxies, so addresses prepended by the client are ignored.  X-Real-IP is used
+// if X-Forwarded-For is not set.
+func forwardedFor(r *http.Request, isTrusted func(net.IP) bool) net.IP {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	if len(hops) == 0 {
+		return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	}
+
+	var ip net.IP
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return nil
+		}
+
+		if !isTrusted(ip) {
+			return ip
+		}
+	}
+
+	// All hops are trusted proxies; use the first one.
+	return ip
+}
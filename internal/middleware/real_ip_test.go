@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		input  string
+		expect []string
+		err    bool
+	}{
+		{"empty", "", nil, false},
+		{"address", "10.0.0.1", []string{"10.0.0.1/32"}, false},
+		{"range", "10.0.0.0/8", []string{"10.0.0.0/8"}, false},
+		{"range with host bits", "192.168.1.7/24", []string{"192.168.1.0/24"}, false},
+		{"ipv6 address", "::1", []string{"::1/128"}, false},
+		{"ipv6 range", "fd00::/8", []string{"fd00::/8"}, false},
+		{"list", " 10.0.0.1, ,fd00::/8 ", []string{"10.0.0.1/32", "fd00::/8"}, false},
+		{"invalid address", "10.0.0.256", nil, true},
+		{"hostname", "proxy.example.com", nil, true},
+		{"invalid range", "10.0.0.0/33", nil, true},
+		{"invalid entry in list", "10.0.0.1,nope", nil, true},
+	} {
+		nets, err := ParseTrustedProxies(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: unexpected error %v", tt.desc, err)
+			continue
+		}
+
+		var got []string
+		for _, n := range nets {
+			got = append(got, n.String())
+		}
+
+		if !reflect.DeepEqual(got, tt.expect) {
+			t.Errorf("%s: expected %q, got %q", tt.desc, tt.expect, got)
+		}
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc       string
+		remoteAddr string
+		xff        []string
+		xRealIP    string
+		expect     string
+	}{
+		{"untrusted peer", "203.0.113.5:1234", []string{"198.51.100.1"}, "", "203.0.113.5:1234"},
+		{"untrusted peer with X-Real-IP", "203.0.113.5:1234", nil, "198.51.100.1", "203.0.113.5:1234"},
+		{"trusted peer", "10.0.0.2:1234", []string{"198.51.100.1"}, "", "198.51.100.1:0"},
+		{"trusted peer without headers", "10.0.0.2:1234", nil, "", "10.0.0.2:1234"},
+		{"trusted peer with X-Real-IP", "10.0.0.2:1234", nil, "198.51.100.1", "198.51.100.1:0"},
+		{"X-Forwarded-For wins over X-Real-IP", "10.0.0.2:1234", []string{"198.51.100.1"}, "198.51.100.2", "198.51.100.1:0"},
+		{"spoofed hop prepended by the client", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1:0"},
+		{"chain of trusted proxies", "10.0.0.2:1234", []string{"198.51.100.1, 10.0.0.3", "10.0.0.4"}, "", "198.51.100.1:0"},
+		{"all hops trusted", "10.0.0.2:1234", []string{"10.0.0.9"}, "", "10.0.0.9:0"},
+		{"ipv6 trusted peer", "[fd00::1]:1234", []string{"2001:db8::1"}, "", "[2001:db8::1]:0"},
+		{"ipv6 untrusted peer", "[2001:db8::2]:1234", []string{"2001:db8::1"}, "", "[2001:db8::2]:1234"},
+		{"malformed hop", "10.0.0.2:1234", []string{"198.51.100.1, garbage"}, "", "10.0.0.2:1234"},
+		{"malformed X-Real-IP", "10.0.0.2:1234", nil, "garbage", "10.0.0.2:1234"},
+		{"malformed remote address", "garbage", []string{"198.51.100.1"}, "", "garbage"},
+		{"remote address without port", "10.0.0.2", []string{"198.51.100.1"}, "", "198.51.100.1:0"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if tt.xRealIP != "" {
+			req.Header.Set("X-Real-IP", tt.xRealIP)
+		}
+
+		var got string
+		RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.RemoteAddr
+		})).ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != tt.expect {
+			t.Errorf("%s: expected remote address %q, got %q", tt.desc, tt.expect, got)
+		}
+	}
+}
+
+func TestTrustedHeaders(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc       string
+		remoteAddr string
+		keep       bool
+	}{
+		{"untrusted peer", "203.0.113.5:1234", false},
+		{"trusted proxy", "10.0.0.2:1234", true},
+		{"ipv6 untrusted peer", "[2001:db8::2]:1234", false},
+		{"ipv6 trusted proxy", "[fd00::1]:1234", true},
+		{"malformed remote address", "garbage", false},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Forwarded-User", "jane")
+		req.Header.Add("X-Forwarded-Groups", "admin")
+		req.Header.Set("X-Other", "kept")
+
+		// The proxy forwards for an untrusted client, which must not matter.
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		var got http.Header
+		h := TrustedHeaders(trusted, "X-Forwarded-User", "X-Forwarded-Groups")(RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header
+		})))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		user, groups := got.Get("X-Forwarded-User"), got.Get("X-Forwarded-Groups")
+		if tt.keep && (user != "jane" || groups != "admin") {
+			t.Errorf("%s: expected identity headers to be kept, got %q, %q", tt.desc, user, groups)
+		}
+		if !tt.keep && (user != "" || groups != "") {
+			t.Errorf("%s: expected identity headers to be stripped, got %q, %q", tt.desc, user, groups)
+		}
+		if got.Get("X-Other") != "kept" {
+			t.Errorf("%s: expected other headers to be kept", tt.desc)
+		}
+	}
+}
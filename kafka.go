@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+// kafkaPublishTimeout is how long publishing an event may take, including
+// reconnecting to the brokers.
+var kafkaPublishTimeout = 10 * time.Second
+
+// kafkaPublisher publishes events to a partition of a Kafka topic.  Its client
+// keeps its connections to the brokers and their metadata between events,
+// following the partition to its new leader when it moves.  Events are
+// published with acks=1, without a key.
+type kafkaPublisher struct {
+	client    *kgo.Client
+	topic     string
+	partition int32
+}
+
+// newKafkaPublisher returns a publisher to the Kafka topic given by the
+// kafka:// or kafkas:// URL u, connecting over TLS with tlsConfig if set and
+// authenticating with SASL/PLAIN if u has a user.  Several bootstrap brokers
+// can be given separated by commas, ie. kafka://host1,host2:9093/topic.
+func newKafkaPublisher(u *url.URL, tlsConfig *tls.Config) (*kafkaPublisher, error) {
+	p := &kafkaPublisher{topic: strings.TrimPrefix(u.Path, "/")}
+
+	if p.topic == "" {
+		p.topic = "webhook.events"
+	}
+
+	if v := u.Query().Get("partition"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid partition %q", v)
+		}
+
+		p.partition = int32(n)
+	}
+
+	var brokers []string
+	for _, addr := range strings.Split(u.Host, ",") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), "9092")
+		}
+
+		brokers = append(brokers, addr)
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(brokers...),
+		kgo.ClientID("webhook"),
+		kgo.DefaultProduceTopic(p.topic),
+		kgo.RecordPartitioner(kgo.ManualPartitioner()),
+		kgo.RequiredAcks(kgo.LeaderAck()),
+		kgo.DisableIdempotentWrite(),
+		kgo.RecordDeliveryTimeout(kafkaPublishTimeout),
+		kgo.DialTimeout(5 * time.Second),
+	}
+
+	if tlsConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	if u.User != nil {
+		password, _ := u.User.Password()
+		opts = append(opts, kgo.SASL(plain.Auth{User: u.User.Username(), Pass: password}.AsMechanism()))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	p.client = client
+
+	return p, nil
+}
+
+// Publish implements eventPublisher.
+func (p *kafkaPublisher) Publish(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kafkaPublishTimeout)
+	defer cancel()
+
+	return p.client.ProduceSync(ctx, &kgo.Record{Partition: p.partition, Value: data}).FirstErr()
+}
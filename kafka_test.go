@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+// fakeKafkaCluster starts an in-memory Kafka cluster with the topic events of
+// two partitions, with the options opts.
+func fakeKafkaCluster(t *testing.T, opts ...kfake.Opt) *kfake.Cluster {
+	t.Helper()
+
+	c, err := kfake.NewCluster(append([]kfake.Opt{kfake.NumBrokers(2), kfake.SeedTopics(2, "events")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	return c
+}
+
+// consumeKafka returns the value of the first record of the partition of the
+// topic events, consumed with the client options opts.
+func consumeKafka(t *testing.T, c *kfake.Cluster, partition int32, opts ...kgo.Opt) string {
+	t.Helper()
+
+	opts = append(opts,
+		kgo.SeedBrokers(c.ListenAddrs()...),
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{"events": {partition: kgo.NewOffset().AtStart()}}),
+	)
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fetches := client.PollRecords(ctx, 1)
+	if err := fetches.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(fetches.Records()[0].Value)
+}
+
+// newTestKafkaPublisher returns the publisher of rawurl, closed when the test
+// ends.
+func newTestKafkaPublisher(t *testing.T, rawurl string, cfg *tls.Config) *kafkaPublisher {
+	t.Helper()
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newKafkaPublisher(u, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.client.Close)
+
+	return p
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	c := fakeKafkaCluster(t)
+
+	p, err := newEventPublisher("kafka://" + strings.Join(c.ListenAddrs(), ",") + "/events?partition=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(*kafkaPublisher).client.Close()
+
+	// The connections and metadata are kept between events.
+	for _, data := range []string{`{"type":"delivery"}`, `{"type":"execution"}`} {
+		if err := p.Publish([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := consumeKafka(t, c, 1), `{"type":"delivery"}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The partition is followed to its new leader.
+	leader := c.ListenAddrs()[0]
+	if err := c.MoveTopicPartition("events", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Publish([]byte("{}")); err != nil {
+		t.Errorf("unexpected error after the partition moved from %s: %v", leader, err)
+	}
+
+	if err := newTestKafkaPublisher(t, "kafka://"+c.ListenAddrs()[0]+"/events?partition=2", nil).Publish([]byte("{}")); err == nil {
+		t.Errorf("expected an error for a partition that doesn't exist")
+	}
+
+	if _, err := newEventPublisher("kafka://" + c.ListenAddrs()[0] + "/events?partition=x"); err == nil {
+		t.Errorf("expected an error for an invalid partition")
+	}
+}
+
+func TestKafkaPublisherTLSAndSASL(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	// Failing connections are retried until the timeout.
+	defer func(d time.Duration) { kafkaPublishTimeout = d }(kafkaPublishTimeout)
+	kafkaPublishTimeout = 2 * time.Second
+
+	c := fakeKafkaCluster(t, kfake.TLS(srv.TLS), kfake.EnableSASL(), kfake.Superuser("PLAIN", "user", "secret"))
+	addr := c.ListenAddrs()[0]
+
+	// The brokers are verified against the system roots.
+	p, err := newEventPublisher("kafkas://user:secret@" + addr + "/events?partition=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(*kafkaPublisher).client.Close()
+
+	if err := p.Publish([]byte("{}")); err == nil {
+		t.Errorf("expected an error for an untrusted certificate")
+	}
+
+	cfg := &tls.Config{RootCAs: srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+
+	if err := newTestKafkaPublisher(t, "kafkas://user:secret@"+addr+"/events?partition=1", cfg).Publish([]byte(`{"type":"delivery"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := consumeKafka(t, c, 1, kgo.DialTLSConfig(cfg), kgo.SASL(plain.Auth{User: "user", Pass: "secret"}.AsMechanism()))
+	if want := `{"type":"delivery"}`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := newTestKafkaPublisher(t, "kafkas://user:wrong@"+addr+"/events?partition=1", cfg).Publish([]byte("{}")); err == nil {
+		t.Errorf("expected an error for a wrong password")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials of the pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// configMapPrefix prefixes the hooks file paths of ConfigMap keys, which are
+// configmap://namespace/name/key.
+const configMapPrefix = "configmap://"
+
+// configMapSource loads hooks files from the keys of the ConfigMaps matching a
+// label selector, using the Kubernetes API with the pod's service account.
+type configMapSource struct {
+	client    *http.Client
+	server    string
+	tokenFile string
+	namespace string
+	selector  string
+
+	sourceFiles
+}
+
+// newConfigMapSource returns a source of the ConfigMaps matching selector in
+// namespace, or the pod's namespace if empty, using the in-cluster
+// configuration.
+func newConfigMapSource(namespace, selector string) (*configMapSource, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", filepath.Join(serviceAccountDir, "ca.crt"))
+	}
+
+	if namespace == "" {
+		b, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, err
+		}
+
+		namespace = strings.TrimSpace(string(b))
+	}
+
+	return &configMapSource{
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+		server:    "https://" + net.JoinHostPort(host, port),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		namespace: namespace,
+		selector:  selector,
+	}, nil
+}
+
+// Owns implements hooksSource.
+func (s *configMapSource) Owns(path string) bool {
+	return strings.HasPrefix(path, configMapPrefix)
+}
+
+// configMapList is the part of a ConfigMap list used.
+type configMapList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Data map[string]string `json:"data"`
+	} `json:"items"`
+}
+
+// get sends a GET request for the ConfigMaps with the query q.
+func (s *configMapSource) get(q url.Values, timeout time.Duration) (*http.Response, error) {
+	q.Set("labelSelector", s.selector)
+
+	u := s.server + "/api/v1/namespaces/" + url.PathEscape(s.namespace) + "/configmaps?" + q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Service account tokens are rotated, so they are read every time.
+	if s.tokenFile != "" {
+		token, err := ioutil.ReadFile(s.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	client := *s.client
+	client.Timeout = timeout
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error fetching configmaps: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// List implements hooksSource, listing the keys of the ConfigMaps with a
+// .json, .yaml, .yml, .toml or .hcl extension at a resource version.
+func (s *configMapSource) List() ([]string, map[string]bool, string, error) {
+	resp, err := s.get(url.Values{}, 30*time.Second)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var list configMapList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, nil, "", fmt.Errorf("error decoding configmaps: %w", err)
+	}
+
+	files := make(map[string][]byte)
+	var paths []string
+
+	for _, item := range list.Items {
+		for key, data := range item.Data {
+			if !isHooksFileName(key) {
+				continue
+			}
+
+			p := configMapPrefix + item.Metadata.Namespace + "/" + item.Metadata.Name + "/" + key
+			files[p] = []byte(data)
+			paths = append(paths, p)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths, s.update(files), list.Metadata.ResourceVersion, nil
+}
+
+// Watch implements hooksSource, watching the ConfigMaps from the resource
+// version rv until one of them changes or the API server ends the watch.
+func (s *configMapSource) Watch(rv string) error {
+	q := url.Values{}
+	q.Set("watch", "true")
+	q.Set("resourceVersion", rv)
+	q.Set("timeoutSeconds", "300")
+
+	resp, err := s.get(q, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Any event ends the watch, so it is restarted from a new list, which
+	// also handles ERROR events for expired resource versions.
+	var event struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&event); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
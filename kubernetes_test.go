@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestConfigMapSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-kubernetes-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var version int32 = 1
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/ci/configmaps" || r.URL.Query().Get("labelSelector") != "app=webhook" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Query().Get("watch") == "true" {
+			w.Write([]byte(`{"type": "MODIFIED", "object": {}}` + "\n"))
+			return
+		}
+
+		value := "[]"
+		if atomic.LoadInt32(&version) == 2 {
+			value = `[{"id": "a"}]`
+		}
+
+		w.Write([]byte(`{"metadata": {"resourceVersion": "7"}, "items": [
+			{"metadata": {"name": "hooks", "namespace": "ci"}, "data": {"deploy.yaml": ` + strconv.Quote(value) + `, "README": "not hooks", "ops.json": "[]"}}
+		]}`))
+	}))
+	defer ts.Close()
+
+	s := &configMapSource{client: ts.Client(), server: ts.URL, tokenFile: tokenFile, namespace: "ci", selector: "app=webhook"}
+
+	paths, changed, rv, err := s.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"configmap://ci/hooks/deploy.yaml", "configmap://ci/hooks/ops.json"}
+	if !reflect.DeepEqual(paths, want) || len(changed) != 2 || rv != "7" {
+		t.Errorf("expected paths %v, all changed, at version 7, got %v, %v, %q", want, paths, changed, rv)
+	}
+
+	if err := s.Watch(rv); err != nil {
+		t.Fatalf("unexpected error watching: %v", err)
+	}
+
+	atomic.StoreInt32(&version, 2)
+
+	_, changed, _, err = s.List()
+	if err != nil || !reflect.DeepEqual(changed, map[string]bool{"configmap://ci/hooks/deploy.yaml": true}) {
+		t.Errorf("expected only deploy.yaml to change, got %v (err: %v)", changed, err)
+	}
+
+	if b, ok := s.File(want[0]); !ok || string(b) != `[{"id": "a"}]` {
+		t.Errorf("unexpected contents of %s: %q", want[0], b)
+	}
+
+	s.selector = "app=other"
+	if _, _, _, err := s.List(); err == nil {
+		t.Error("expected an error for a forbidden list")
+	}
+}
+
+func TestConfigMapSyncWhileServing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-kubernetes-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var version int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// deploy.yaml comes and goes with every list.
+		data := `"ops.json": "[{\"id\": \"ops\", \"execute-command\": \"/bin/true\"}]"`
+		if v := atomic.AddInt32(&version, 1); v%2 == 0 {
+			data += `, "deploy.yaml": "- {id: deploy, execute-command: /bin/true}"`
+		}
+
+		w.Write([]byte(`{"metadata": {"resourceVersion": "7"}, "items": [
+			{"metadata": {"name": "hooks", "namespace": "ci"}, "data": {` + data + `}}
+		]}`))
+	}))
+	defer ts.Close()
+
+	defer func(h map[string]hook.Hooks, files hook.HooksFiles, sources []hooksSource) {
+		loadedHooksFromFiles, hooksFiles, hooksSources = h, files, sources
+	}(loadedHooksFromFiles, hooksFiles, hooksSources)
+
+	s := &configMapSource{client: ts.Client(), server: ts.URL, tokenFile: tokenFile, namespace: "ci", selector: "app=webhook"}
+
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+	hooksFiles = nil
+	hooksSources = []hooksSource{s}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 20; i++ {
+			paths, changed, _, err := s.List()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			syncSourceHooks(s, paths, changed)
+		}
+	}()
+
+	// Run with -race to check that requests don't race with the watcher.
+	for {
+		select {
+		case <-done:
+			if matchLoadedHook("ops") == nil || matchLoadedHook("deploy") == nil {
+				t.Errorf("expected the ops and deploy hooks to be loaded, got %+v", listHooks())
+			}
+			return
+		default:
+			matchLoadedHook("deploy")
+			listHooks()
+			currentHooksFiles()
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kvWatchTimeout bounds the blocking queries and watches of key-value stores.
+const kvWatchTimeout = 5 * time.Minute
+
+// newKVSource returns the hooks source of the keys under a prefix of the
+// key-value store at rawurl:
+//
+//	consul://host:port/prefix?token=t  Consul, port 8500 by default; the token
+//	                                   defaults to $CONSUL_HTTP_TOKEN
+//	etcd://host:port/prefix            etcd v3, port 2379 by default
+//
+// With ?tls=true, the store is accessed over HTTPS.
+func newKVSource(rawurl string) (hooksSource, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http"
+	if tls, _ := strconv.ParseBool(u.Query().Get("tls")); tls {
+		scheme = "https"
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	client := &http.Client{Timeout: kvWatchTimeout + time.Minute}
+
+	switch u.Scheme {
+	case "consul":
+		s := &consulSource{client: client, base: scheme + "://" + u.Host, prefix: prefix, token: u.Query().Get("token")}
+		if u.Port() == "" {
+			s.base = scheme + "://" + net.JoinHostPort(u.Hostname(), "8500")
+		}
+
+		if s.token == "" {
+			s.token = os.Getenv("CONSUL_HTTP_TOKEN")
+		}
+
+		s.name = "consul://" + strings.TrimPrefix(s.base, scheme+"://") + "/"
+
+		return s, nil
+
+	case "etcd":
+		s := &etcdSource{client: client, base: scheme + "://" + u.Host, prefix: prefix}
+		if u.Port() == "" {
+			s.base = scheme + "://" + net.JoinHostPort(u.Hostname(), "2379")
+		}
+
+		s.name = "etcd://" + strings.TrimPrefix(s.base, scheme+"://") + "/"
+
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key-value store %s", redactURL(rawurl))
+}
+
+// consulSource loads hooks files from the keys under a prefix of the Consul
+// KV store, watching them with blocking queries.  Its hooks file paths are
+// consul://host:port/key.
+type consulSource struct {
+	client *http.Client
+	base   string
+	prefix string
+	token  string
+	name   string
+
+	sourceFiles
+}
+
+// Owns implements hooksSource.
+func (s *consulSource) Owns(path string) bool {
+	return strings.HasPrefix(path, s.name)
+}
+
+// get fetches the keys under the prefix, blocking until their index changes
+// from index if it isn't empty.
+func (s *consulSource) get(ctx context.Context, index string) (*http.Response, error) {
+	q := url.Values{}
+	q.Set("recurse", "true")
+
+	if index != "" {
+		q.Set("index", index)
+		q.Set("wait", strconv.Itoa(int(kvWatchTimeout/time.Second))+"s")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.base+"/v1/kv/"+s.prefix+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// Consul responds 404 Not Found if no key has the prefix.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error fetching consul keys: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// List implements hooksSource, listing the keys with a .json, .yaml, .yml,
+// .toml or .hcl extension at a Consul index.
+func (s *consulSource) List() ([]string, map[string]bool, string, error) {
+	resp, err := s.get(context.Background(), "")
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var pairs []struct {
+		Key   string
+		Value []byte
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+			return nil, nil, "", fmt.Errorf("error decoding consul keys: %w", err)
+		}
+	}
+
+	files := make(map[string][]byte)
+	var paths []string
+
+	for _, pair := range pairs {
+		if !isHooksFileName(pair.Key) {
+			continue
+		}
+
+		p := s.name + pair.Key
+		files[p] = pair.Value
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	return paths, s.update(files), resp.Header.Get("X-Consul-Index"), nil
+}
+
+// Watch implements hooksSource, blocking until the Consul index changes.
+func (s *consulSource) Watch(index string) error {
+	resp, err := s.get(context.Background(), index)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// etcdSource loads hooks files from the keys under a prefix of etcd, using
+// its v3 JSON API.  Its hooks file paths are etcd://host:port/key.
+type etcdSource struct {
+	client *http.Client
+	base   string
+	prefix string
+	name   string
+
+	sourceFiles
+}
+
+// Owns implements hooksSource.
+func (s *etcdSource) Owns(path string) bool {
+	return strings.HasPrefix(path, s.name)
+}
+
+// keyRange returns the base64 encoded key and range end of the keys under
+// the prefix.
+func (s *etcdSource) keyRange() (string, string) {
+	end := []byte(s.prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			end = end[:i+1]
+
+			return base64.StdEncoding.EncodeToString([]byte(s.prefix)), base64.StdEncoding.EncodeToString(end)
+		}
+	}
+
+	// All keys.
+	return base64.StdEncoding.EncodeToString([]byte(s.prefix)), base64.StdEncoding.EncodeToString([]byte{0})
+}
+
+// post sends the JSON request body to the API endpoint.
+func (s *etcdSource) post(ctx context.Context, endpoint string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.base+endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("error fetching etcd keys: %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// List implements hooksSource, listing the keys with a .json, .yaml, .yml,
+// .toml or .hcl extension at an etcd revision.
+func (s *etcdSource) List() ([]string, map[string]bool, string, error) {
+	key, end := s.keyRange()
+
+	resp, err := s.post(context.Background(), "/v3/kv/range", map[string]string{"key": key, "range_end": end})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		Kvs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, nil, "", fmt.Errorf("error decoding etcd keys: %w", err)
+	}
+
+	files := make(map[string][]byte)
+	var paths []string
+
+	for _, kv := range res.Kvs {
+		if !isHooksFileName(string(kv.Key)) {
+			continue
+		}
+
+		p := s.name + string(kv.Key)
+		files[p] = kv.Value
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	return paths, s.update(files), res.Header.Revision, nil
+}
+
+// Watch implements hooksSource, watching the keys after the etcd revision
+// until one of them changes or a timeout.
+func (s *etcdSource) Watch(revision string) error {
+	rev, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid etcd revision %q", revision)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kvWatchTimeout)
+	defer cancel()
+
+	key, end := s.keyRange()
+
+	resp, err := s.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            key,
+			"range_end":      end,
+			"start_revision": strconv.FormatInt(rev+1, 10),
+		},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	d := json.NewDecoder(resp.Body)
+
+	for {
+		var msg struct {
+			Result struct {
+				Events []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		if err := d.Decode(&msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if msg.Error != nil {
+			return fmt.Errorf("error watching etcd keys: %s", msg.Error.Message)
+		}
+
+		// The first message confirms the watch was created.
+		if len(msg.Result.Events) != 0 {
+			return nil
+		}
+	}
+}
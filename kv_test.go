@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestConsulSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/webhook/" || r.URL.Query().Get("recurse") != "true" || r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Query().Get("index") == "" {
+			w.Header().Set("X-Consul-Index", "42")
+		} else if r.URL.Query().Get("index") != "42" || r.URL.Query().Get("wait") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`[
+			{"Key": "webhook/", "Value": null},
+			{"Key": "webhook/deploy.yaml", "Value": "` + base64.StdEncoding.EncodeToString([]byte("- id: deploy")) + `"},
+			{"Key": "webhook/notes.txt", "Value": "eA=="}
+		]`))
+	}))
+	defer ts.Close()
+
+	src, err := newKVSource("consul://" + strings.TrimPrefix(ts.URL, "http://") + "/webhook/?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, changed, index, err := src.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := "consul://" + strings.TrimPrefix(ts.URL, "http://") + "/webhook/deploy.yaml"
+	if !reflect.DeepEqual(paths, []string{path}) || !changed[path] || index != "42" || !src.Owns(path) {
+		t.Errorf("unexpected list: %v, %v, %q", paths, changed, index)
+	}
+
+	if b, ok := src.File(path); !ok || string(b) != "- id: deploy" {
+		t.Errorf("unexpected contents of %s: %q", path, b)
+	}
+
+	if err := src.Watch(index); err != nil {
+		t.Errorf("unexpected error watching: %v", err)
+	}
+}
+
+func TestEtcdSource(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch r.URL.Path {
+		case "/v3/kv/range":
+			if req["key"] != b64("webhook/") || req["range_end"] != b64("webhook0") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.Write([]byte(`{"header": {"revision": "7"}, "kvs": [
+				{"key": "` + b64("webhook/deploy.json") + `", "value": "` + b64(`[{"id": "deploy"}]`) + `"}
+			]}`))
+
+		case "/v3/watch":
+			if create, _ := req["create_request"].(map[string]interface{}); create["start_revision"] != "8" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.Write([]byte(`{"result": {"created": true}}` + "\n" + `{"result": {"events": [{"type": "PUT"}]}}` + "\n"))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	src, err := newKVSource("etcd://" + strings.TrimPrefix(ts.URL, "http://") + "/webhook/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, _, rev, err := src.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := "etcd://" + strings.TrimPrefix(ts.URL, "http://") + "/webhook/deploy.json"
+	if !reflect.DeepEqual(paths, []string{path}) || rev != "7" {
+		t.Errorf("unexpected list: %v, %q", paths, rev)
+	}
+
+	if err := src.Watch(rev); err != nil {
+		t.Errorf("unexpected error watching: %v", err)
+	}
+
+	if _, err := newKVSource("zookeeper://localhost/webhook"); err == nil {
+		t.Error("expected an error for an unsupported store")
+	}
+}
+
+func TestKVSyncWhileServing(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	// The b.json key of both stores comes and goes with every list.
+	var consulLists, etcdLists int32
+
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "42")
+
+		keys := `{"Key": "webhook/a.json", "Value": "` + b64(`[{"id": "consul-a", "execute-command": "/bin/true"}]`) + `"}`
+		if atomic.AddInt32(&consulLists, 1)%2 == 0 {
+			keys += `, {"Key": "webhook/b.json", "Value": "` + b64(`[{"id": "consul-b", "execute-command": "/bin/true"}]`) + `"}`
+		}
+
+		w.Write([]byte("[" + keys + "]"))
+	}))
+	defer consul.Close()
+
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kvs := `{"key": "` + b64("webhook/a.json") + `", "value": "` + b64(`[{"id": "etcd-a", "execute-command": "/bin/true"}]`) + `"}`
+		if atomic.AddInt32(&etcdLists, 1)%2 == 0 {
+			kvs += `, {"key": "` + b64("webhook/b.json") + `", "value": "` + b64(`[{"id": "etcd-b", "execute-command": "/bin/true"}]`) + `"}`
+		}
+
+		w.Write([]byte(`{"header": {"revision": "7"}, "kvs": [` + kvs + `]}`))
+	}))
+	defer etcd.Close()
+
+	defer func(h map[string]hook.Hooks, files hook.HooksFiles, sources []hooksSource) {
+		loadedHooksFromFiles, hooksFiles, hooksSources = h, files, sources
+	}(loadedHooksFromFiles, hooksFiles, hooksSources)
+
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+	hooksFiles = nil
+	hooksSources = nil
+
+	for _, rawurl := range []string{
+		"consul://" + strings.TrimPrefix(consul.URL, "http://") + "/webhook/",
+		"etcd://" + strings.TrimPrefix(etcd.URL, "http://") + "/webhook/",
+	} {
+		src, err := newKVSource(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hooksSources = append(hooksSources, src)
+	}
+
+	var wg sync.WaitGroup
+
+	for _, src := range hooksSources {
+		wg.Add(1)
+		go func(src hooksSource) {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				paths, changed, _, err := src.List()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				syncSourceHooks(src, paths, changed)
+			}
+		}(src)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Run with -race to check that requests don't race with the watchers.
+	for {
+		select {
+		case <-done:
+			if lenLoadedHooks() != 4 {
+				t.Errorf("expected the hooks of both stores to be loaded, got %+v", listHooks())
+			}
+			return
+		default:
+			matchLoadedHook("consul-b")
+			matchLoadedHook("etcd-b")
+			currentHooksFiles()
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// lintFinding describes a problem found in a hook definition.
+type lintFinding struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Hook    string `json:"hook,omitempty"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// lintOptions controls which checks are run by lintHooks.
+type lintOptions struct {
+	security        bool
+	minSecretLength int
+}
+
+// lintCommand implements the "lint" subcommand.
+func lintCommand(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+
+	var files hook.HooksFiles
+	fs.Var(&files, "hooks", "path to the json file containing defined hooks, use multiple times to check multiple files")
+
+	security := fs.Bool("security", false, "report hooks with security anti-patterns")
+	minSecretLength := fs.Int("min-secret-length", 16, "minimum length of signature secrets when checking security")
+	tmpl := fs.Bool("template", false, "parse hooks file as a Go template")
+	format := fs.String("format", "text", `output format ("text" or "json")`)
+
+	fs.Parse(args)
+
+	if len(files) == 0 {
+		files = append(files, "hooks.json")
+	}
+
+	o := lintOptions{security: *security, minSecretLength: *minSecretLength}
+
+	var findings []lintFinding
+
+	for _, file := range files {
+		hooks := hook.Hooks{}
+
+		if err := hooks.LoadFromFile(file, *tmpl); err != nil {
+			findings = append(findings, loadFindings(file, err)...)
+			continue
+		}
+
+		findings = append(findings, lintHooks(file, hooks, o)...)
+	}
+
+	if err := writeLintFindings(os.Stdout, findings, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if len(findings) != 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// writeLintFindings writes findings to w in the given format.
+func writeLintFindings(w io.Writer, findings []lintFinding, format string) error {
+	switch format {
+	case "json":
+		if findings == nil {
+			findings = []lintFinding{}
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(findings)
+
+	case "text":
+		for _, f := range findings {
+			file := f.File
+			if f.Line != 0 {
+				file = fmt.Sprintf("%s:%d:%d", f.File, f.Line, f.Column)
+			}
+
+			if f.Hook != "" {
+				fmt.Fprintf(w, "%s: hook %q: %s: %s\n", file, f.Hook, f.Check, f.Message)
+			} else {
+				fmt.Fprintf(w, "%s: %s: %s\n", file, f.Check, f.Message)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("error: unknown output format %q", format)
+}
+
+// loadFindings returns the findings of the error loading file: one per
+// problem for hooks files not matching the JSON Schema of hooks files.
+func loadFindings(file string, err error) []lintFinding {
+	serr, ok := err.(*hook.SchemaError)
+	if !ok {
+		return []lintFinding{{File: file, Check: "load", Message: err.Error()}}
+	}
+
+	findings := make([]lintFinding, len(serr.Problems))
+	for i, p := range serr.Problems {
+		findings[i] = lintFinding{File: file, Line: p.Line, Column: p.Column, Check: "schema", Message: p.Path + ": " + p.Message}
+	}
+
+	return findings
+}
+
+// lintHooks checks the hooks loaded from file.
+func lintHooks(file string, hooks hook.Hooks, o lintOptions) []lintFinding {
+	if !o.security {
+		return nil
+	}
+
+	var findings []lintFinding
+
+	add := func(h *hook.Hook, check, format string, a ...interface{}) {
+		findings = append(findings, lintFinding{File: file, Hook: h.ID, Check: check, Message: fmt.Sprintf(format, a...)})
+	}
+
+	for i := range hooks {
+		h := &hooks[i]
+
+		if h.TriggerRule == nil {
+			add(h, "no-trigger-rule", "hook has no trigger rule; anyone who can reach it can execute its command")
+		}
+
+		for _, m := range h.TriggerRule.MatchRules() {
+			switch m.Type {
+			case hook.MatchHMACSHA1, hook.MatchHashSHA1, hook.ScalrSignature, hook.MatchHMAC,
+				hook.MatchHMACSHA256, hook.MatchHashSHA256, hook.MatchHMACSHA512, hook.MatchHashSHA512,
+				hook.ShopifySignature, hook.GiteaSignature, hook.StandardWebhooks,
+				hook.MailgunSignature, hook.DocuSignHMAC:
+			default:
+				continue
+			}
+
+			for _, secret := range m.Secret {
+				if len(secret) < o.minSecretLength {
+					add(h, "short-secret", "%s secret is %d characters long; use at least %d", m.Type, len(secret), o.minSecretLength)
+				}
+			}
+		}
+
+		if h.ReliesOnSHA1() {
+			add(h, "sha1-only", "signature verification relies on SHA-1 only; use payload-hmac-sha256 or payload-hmac-sha512")
+		}
+
+		if h.Command() == "" {
+			continue
+		}
+
+		cmdPath := lookupCommand(h)
+
+		if fi, err := os.Stat(cmdPath); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm()&0o002 != 0 {
+			add(h, "world-writable-command", "command %s is world-writable", cmdPath)
+		}
+
+		if isTempPath(cmdPath) {
+			add(h, "temp-dir-command", "command %s is located in a temporary directory", cmdPath)
+		}
+	}
+
+	return findings
+}
+
+// lookupCommand returns the path to the command executed by h, as resolved by
+// handleHook.  The unresolved path is returned if the command can't be found.
+func lookupCommand(h *hook.Hook) string {
+	lookpath := h.CommandPath()
+
+	if p, err := exec.LookPath(lookpath); err == nil {
+		lookpath = p
+	}
+
+	if p, err := filepath.Abs(lookpath); err == nil {
+		lookpath = p
+	}
+
+	return lookpath
+}
+
+// isTempPath reports whether path is located in a temporary directory.
+func isTempPath(path string) bool {
+	dirs := []string{os.TempDir()}
+	if runtime.GOOS != "windows" {
+		dirs = append(dirs, "/tmp", "/var/tmp", "/dev/shm")
+	}
+
+	path = filepath.Clean(path)
+
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestLintHooksSecurity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writable := filepath.Join(dir, "writable.sh")
+	if err := ioutil.WriteFile(writable, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(writable, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := "0123456789abcdef"
+
+	hooks := hook.Hooks{
+		{ID: "ok", ExecuteCommand: "/bin/true", TriggerRule: &hook.Rules{
+			Match: &hook.MatchRule{Type: hook.MatchHMACSHA256, Secret: hook.Secrets{secret}},
+		}},
+		{ID: "no-rule", ExecuteCommand: "/bin/true"},
+		{ID: "sha1", ExecuteCommand: "/bin/true", TriggerRule: &hook.Rules{
+			Or: &hook.OrRule{
+				{Match: &hook.MatchRule{Type: hook.MatchHMACSHA1, Secret: hook.Secrets{secret}}},
+				{Not: &hook.NotRule{Match: &hook.MatchRule{Type: hook.MatchValue, Value: "x"}}},
+			},
+		}},
+		{ID: "sha1-and-sha256", ExecuteCommand: "/bin/true", TriggerRule: &hook.Rules{
+			And: &hook.AndRule{
+				{Match: &hook.MatchRule{Type: hook.MatchHMACSHA1, Secret: hook.Secrets{secret}}},
+				{Match: &hook.MatchRule{Type: hook.MatchHMACSHA512, Secret: hook.Secrets{"short"}}},
+			},
+		}},
+		{ID: "writable", ExecuteCommand: writable, TriggerRule: &hook.Rules{
+			Match: &hook.MatchRule{Type: hook.MatchHMACSHA256, Secret: hook.Secrets{secret}},
+		}},
+		{ID: "tmp", ExecuteCommand: "/tmp/deploy.sh", TriggerRule: &hook.Rules{
+			Match: &hook.MatchRule{Type: hook.MatchHMACSHA256, Secret: hook.Secrets{secret}},
+		}},
+	}
+
+	var got []string
+	for _, f := range lintHooks("hooks.json", hooks, lintOptions{security: true, minSecretLength: 16}) {
+		got = append(got, f.Hook+":"+f.Check)
+	}
+
+	expect := []string{
+		"no-rule:no-trigger-rule",
+		"sha1:sha1-only",
+		"sha1-and-sha256:short-secret",
+		"writable:world-writable-command",
+		"writable:temp-dir-command",
+		"tmp:temp-dir-command",
+	}
+
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("lintHooks findings:\nexpected %q\ngot      %q", expect, got)
+	}
+
+	if f := lintHooks("hooks.json", hooks, lintOptions{}); f != nil {
+		t.Errorf("expected no findings without security checks, got %v", f)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const systemdListenFDsStart = 3
+
+// listen returns the listener hooks are served on and a description of its
+// address for logging.  A socket passed by systemd socket activation takes
+// precedence over the Unix domain socket set with -socket, which takes
+// precedence over the TCP address addr.
+func listen(addr string) (net.Listener, string, error) {
+	ln, err := systemdListener()
+	if ln != nil || err != nil {
+		return ln, "systemd socket", err
+	}
+
+	if *socketPath != "" {
+		ln, err := listenUnix(*socketPath, *socketMode)
+		return ln, "unix:" + *socketPath, err
+	}
+
+	ln, err = net.Listen("tcp", addr)
+
+	return ln, addr, err
+}
+
+// systemdListener returns the first socket passed by systemd socket
+// activation, or nil if webhook wasn't socket activated.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// Don't pass the sockets on to hook commands.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(systemdListenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("error using systemd socket: %w", err)
+	}
+
+	return ln, nil
+}
+
+// listenUnix listens on the Unix domain socket at path, created with the
+// given octal permissions.
+func listenUnix(path, mode string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, errors.New("invalid socket-mode " + strconv.Quote(mode))
+	}
+
+	// Remove a stale socket left behind by a previous instance.
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// listenSpecs is the list of additional listeners set with -listen.
+type listenSpecs []string
+
+func (l *listenSpecs) String() string {
+	return strings.Join(*l, ", ")
+}
+
+// Set implements the flag.Value interface.
+func (l *listenSpecs) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// extraListener is an additional listener set with -listen.
+type extraListener struct {
+	ln     net.Listener
+	addr   string
+	secure bool
+
+	// hooks holds the IDs of the hooks served on the listener, or is nil if
+	// all hooks are served.
+	hooks map[string]bool
+}
+
+// unrestrictedOnly returns a middleware answering requests under the path
+// prefixes with 404 Not Found on listeners restricted to some hooks, since
+// the endpoints there, such as the admin endpoints, act on all hooks.
+func unrestrictedOnly(prefixes ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, restricted := r.Context().Value(allowedHooksContextKey).(map[string]bool); restricted {
+				for _, p := range prefixes {
+					if r.URL.Path == p || strings.HasPrefix(r.URL.Path, p+"/") {
+						http.NotFound(w, r)
+						return
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// listenExtra opens the additional listener described by spec, in the form
+// http://ip:port or https://ip:port, optionally followed by ?hooks=id1,id2.
+func listenExtra(spec string) (*extraListener, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &extraListener{addr: u.Host}
+
+	switch u.Scheme {
+	case "http":
+	case "https":
+		l.secure = true
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, errors.New("missing address")
+	}
+
+	if ids := u.Query().Get("hooks"); ids != "" {
+		l.hooks = make(map[string]bool)
+
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				l.hooks[id] = true
+			}
+		}
+	}
+
+	l.ln, err = net.Listen("tcp", l.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
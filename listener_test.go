@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires Unix domain sockets")
+	}
+
+	dir, err := ioutil.TempDir("", "webhook-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "webhook.sock")
+
+	for i := 0; i < 2; i++ {
+		// The second iteration replaces the socket left behind by the first.
+		ln, err := listenUnix(path, "0600")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Mode().Perm() != 0o600 {
+			t.Errorf("expected socket mode 0600, got %o", fi.Mode().Perm())
+		}
+
+		// Keep the socket file around, as a crashed instance would.
+		if l, ok := ln.(interface{ SetUnlinkOnClose(bool) }); ok {
+			l.SetUnlinkOnClose(false)
+		}
+		ln.Close()
+	}
+
+	if _, err := listenUnix(path, "rw"); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+func TestSystemdListenerNotActivated(t *testing.T) {
+	os.Setenv("LISTEN_PID", "1")
+	defer os.Unsetenv("LISTEN_PID")
+
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		t.Errorf("expected no listener for another process, got %v, %v", ln, err)
+	}
+}
+
+func TestListenExtra(t *testing.T) {
+	l, err := listenExtra("http://127.0.0.1:0?hooks=deploy,%20status")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer l.ln.Close()
+
+	if l.secure {
+		t.Error("expected plain HTTP listener")
+	}
+
+	if len(l.hooks) != 2 || !l.hooks["deploy"] || !l.hooks["status"] {
+		t.Errorf("unexpected hooks: %v", l.hooks)
+	}
+
+	ctx := context.WithValue(context.Background(), allowedHooksContextKey, l.hooks)
+	if !hookAllowed(ctx, "deploy") || hookAllowed(ctx, "other") {
+		t.Error("listener hooks not enforced")
+	}
+
+	if !hookAllowed(context.Background(), "other") {
+		t.Error("unrestricted listener should serve all hooks")
+	}
+
+	for _, spec := range []string{"ftp://127.0.0.1:0", "https://", "http://%zz"} {
+		if _, err := listenExtra(spec); err == nil {
+			t.Errorf("listenExtra(%q): expected error", spec)
+		}
+	}
+}
+
+func TestUnrestrictedOnly(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(unrestrictedOnly(adminPrefix, debugPrefix, testModePrefix))
+	registerAdminRoutes(r, "s3cret")
+	registerDebugRoutes(r)
+	r.HandleFunc("/hooks/{id}", func(w http.ResponseWriter, req *http.Request) {})
+
+	restricted := context.WithValue(context.Background(), allowedHooksContextKey, map[string]bool{"deploy": true})
+
+	for _, tt := range []struct {
+		method     string
+		path       string
+		restricted bool
+		status     int
+	}{
+		{"GET", "/_admin/hooks", false, http.StatusOK},
+		{"GET", "/_admin/hooks", true, http.StatusNotFound},
+		{"POST", "/_admin/hooks/other/trigger", true, http.StatusNotFound},
+		{"GET", "/debug/hooks", false, http.StatusOK},
+		{"GET", "/debug/hooks", true, http.StatusNotFound},
+		{"GET", "/hooks/deploy", true, http.StatusOK},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		if tt.restricted {
+			req = req.WithContext(restricted)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s %s (restricted %t): expected status %d, got %d", tt.method, tt.path, tt.restricted, tt.status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errLockHeld is returned when releasing or refreshing a lock that is held by
+// another owner, or not held at all.
+var errLockHeld = errors.New("lock is not held by this owner")
+
+// locker is a lock backend shared by hook commands.
+type locker interface {
+	// Acquire takes the lock name for the owner token, expiring after ttl,
+	// waiting up to timeout.
+	Acquire(name, token string, ttl, timeout time.Duration) error
+
+	// Refresh extends the lock name held by token to expire after ttl.
+	Refresh(name, token string, ttl time.Duration) error
+
+	// Release releases the lock name held by token.
+	Release(name, token string) error
+}
+
+// newLocker returns the lock backend for rawurl, either a directory given as
+// a path or file:// URL, or a Redis server given as a redis:// URL.
+func newLocker(rawurl string) (locker, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		dir := rawurl
+		if u.Scheme == "file" {
+			dir = u.Path
+		}
+
+		return &fileLocker{dir: dir}, nil
+
+	case "redis":
+		c, err := newRedisClient(u)
+		if err != nil {
+			return nil, err
+		}
+
+		return &redisLocker{c}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported lock URL scheme %q", u.Scheme)
+}
+
+// lockCommand implements the "lock" subcommand, which lets hook commands
+// coordinate through the lock backend set with -lock-url.
+func lockCommand(args []string) int {
+	fs := flag.NewFlagSet("lock", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage of lock: webhook lock [flags] run NAME COMMAND [ARGS...] | acquire NAME | release NAME TOKEN")
+		fs.PrintDefaults()
+	}
+
+	lockURL := fs.String("url", os.Getenv("HOOK_LOCK_URL"), "lock backend, a directory or a redis:// URL; defaults to $HOOK_LOCK_URL")
+	timeout := fs.Duration("timeout", 30*time.Second, "maximum duration to wait for the lock")
+	ttl := fs.Duration("ttl", 10*time.Minute, "duration after which a lock that isn't released expires")
+
+	fs.Parse(args)
+
+	if *lockURL == "" {
+		fmt.Fprintln(os.Stderr, "error: no lock backend; set -url or HOOK_LOCK_URL")
+		return 2
+	}
+
+	l, err := newLocker(*lockURL)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+
+	op, name := fs.Arg(0), fs.Arg(1)
+
+	switch {
+	case op == "run" && fs.NArg() >= 3:
+		return runLocked(l, name, *ttl, *timeout, fs.Args()[2:])
+
+	case op == "acquire" && fs.NArg() == 2:
+		token := newLockToken()
+		if err := l.Acquire(name, token, *ttl, *timeout); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+
+		fmt.Println(token)
+		return 0
+
+	case op == "release" && fs.NArg() == 3:
+		if err := l.Release(name, fs.Arg(2)); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+
+		return 0
+	}
+
+	fs.Usage()
+
+	return 2
+}
+
+// runLocked runs the command args while holding the lock name, refreshing it
+// until the command exits.  It returns the command's exit status.
+func runLocked(l locker, name string, ttl, timeout time.Duration, args []string) int {
+	token := newLockToken()
+
+	if err := l.Acquire(name, token, ttl, timeout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := l.Refresh(name, token, ttl); err != nil {
+					fmt.Fprintln(os.Stderr, "error refreshing lock:", err)
+				}
+			}
+		}
+	}()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	err := cmd.Run()
+
+	close(done)
+
+	if err := l.Release(name, token); err != nil {
+		fmt.Fprintln(os.Stderr, "error releasing lock:", err)
+	}
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+
+		fmt.Fprintln(os.Stderr, "error:", err)
+
+		return 1
+	}
+
+	return 0
+}
+
+// newLockToken returns a random lock owner token.
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// fileLocker implements locks as directories holding the owner token.  Locks
+// are prepared under a temporary name and renamed into place, and removed by
+// renaming them aside first, which is atomic on all platforms for
+// directories, so a lock always holds its owner token and only one of several
+// callers breaking or releasing a lock removes it.  This is only suitable for
+// commands on the same host, or sharing a file system with atomic rename.
+type fileLocker struct {
+	dir string
+}
+
+func (l *fileLocker) path(name string) string {
+	return filepath.Join(l.dir, url.PathEscape(name)+".lock")
+}
+
+// Acquire implements locker.
+func (l *fileLocker) Acquire(name, token string, ttl, timeout time.Duration) error {
+	if err := os.MkdirAll(l.dir, 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(l.dir, ".lock")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := ioutil.WriteFile(filepath.Join(tmp, "owner"), []byte(token+"\n"+strconv.FormatInt(int64(ttl), 10)), 0o600); err != nil {
+		return err
+	}
+
+	path := l.path(name)
+	deadline := time.Now().Add(timeout)
+
+	for {
+		// Renaming fails if the lock is held, as its directory isn't empty.
+		err := os.Rename(tmp, path)
+		if err == nil {
+			return nil
+		}
+
+		fi, serr := os.Stat(path)
+		switch {
+		case os.IsNotExist(serr):
+			// The lock was released meanwhile.
+		case serr != nil:
+			return err
+		case time.Since(fi.ModTime()) > l.ttl(path):
+			// Break locks whose owner didn't release or refresh them in time.
+			l.remove(path, l.owner(path))
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for lock %s", name)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Refresh implements locker.
+func (l *fileLocker) Refresh(name, token string, ttl time.Duration) error {
+	path := l.path(name)
+
+	if l.owner(path) != token {
+		return errLockHeld
+	}
+
+	now := time.Now()
+
+	return os.Chtimes(path, now, now)
+}
+
+// Release implements locker.
+func (l *fileLocker) Release(name, token string) error {
+	if !l.remove(l.path(name), token) {
+		return errLockHeld
+	}
+
+	return nil
+}
+
+// remove removes the lock at path if it is held by owner, and reports
+// whether it did.  The lock is renamed aside before its owner is checked, so
+// a lock broken and taken again meanwhile by another caller is not removed
+// but put back.
+func (l *fileLocker) remove(path, owner string) bool {
+	if l.owner(path) != owner {
+		return false
+	}
+
+	tomb := path + "." + newLockToken()
+	if os.Rename(path, tomb) != nil {
+		return false
+	}
+
+	if l.owner(tomb) != owner {
+		// The lock can't be put back if yet another caller took it since,
+		// which then holds it.
+		if os.Rename(tomb, path) != nil {
+			os.RemoveAll(tomb)
+		}
+
+		return false
+	}
+
+	os.RemoveAll(tomb)
+
+	return true
+}
+
+// owner returns the token of the owner of the lock at path.
+func (l *fileLocker) owner(path string) string {
+	b, _ := ioutil.ReadFile(filepath.Join(path, "owner"))
+	return strings.SplitN(string(b), "\n", 2)[0]
+}
+
+// ttl returns the expiry of the lock at path.  Locks without a readable owner
+// file, ie. left behind by older versions, expire after staleLockAge.
+func (l *fileLocker) ttl(path string) time.Duration {
+	b, _ := ioutil.ReadFile(filepath.Join(path, "owner"))
+
+	parts := strings.SplitN(string(b), "\n", 2)
+	if len(parts) == 2 {
+		if n, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			return time.Duration(n)
+		}
+	}
+
+	return staleLockAge
+}
+
+// redisLocker implements locks as Redis keys set with NX and a TTL, released
+// and refreshed by scripts checking the owner token.
+type redisLocker struct {
+	*redisClient
+}
+
+// redisClient runs commands on the Redis server given by a redis:// URL.
+type redisClient struct {
+	addr     string
+	password string
+	db       string
+}
+
+// newRedisClient returns a client for the Redis server of the redis:// URL u,
+// with an optional password and database number.
+func newRedisClient(u *url.URL) (*redisClient, error) {
+	c := &redisClient{addr: u.Host}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+		c.db = db
+	}
+
+	return c, nil
+}
+
+const (
+	redisKeyPrefix = "webhook:lock:"
+
+	redisReleaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+	redisRefreshScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+)
+
+// Acquire implements locker.
+func (l *redisLocker) Acquire(name, token string, ttl, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		res, err := l.do("SET", redisKeyPrefix+name, token, "NX", "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+		if err != nil {
+			return err
+		}
+
+		if res == "OK" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for lock %s", name)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Refresh implements locker.
+func (l *redisLocker) Refresh(name, token string, ttl time.Duration) error {
+	res, err := l.do("EVAL", redisRefreshScript, "1", redisKeyPrefix+name, token, strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	if err != nil {
+		return err
+	}
+
+	if res != "1" {
+		return errLockHeld
+	}
+
+	return nil
+}
+
+// Release implements locker.
+func (l *redisLocker) Release(name, token string) error {
+	res, err := l.do("EVAL", redisReleaseScript, "1", redisKeyPrefix+name, token)
+	if err != nil {
+		return err
+	}
+
+	if res != "1" {
+		return errLockHeld
+	}
+
+	return nil
+}
+
+// do runs the command args on a new connection, after authenticating and
+// selecting the database, and returns the reply of the last command.  Null
+// replies are returned as empty strings.
+func (c *redisClient) do(args ...string) (string, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	cmds := [][]string{args}
+	if c.db != "" {
+		cmds = append([][]string{{"SELECT", c.db}}, cmds...)
+	}
+	if c.password != "" {
+		cmds = append([][]string{{"AUTH", c.password}}, cmds...)
+	}
+
+	for _, cmd := range cmds {
+		if _, err := conn.Write(encodeRESP(cmd)); err != nil {
+			return "", err
+		}
+	}
+
+	r := bufio.NewReader(conn)
+
+	var res string
+
+	for range cmds {
+		if res, err = readRESP(r); err != nil {
+			return "", err
+		}
+	}
+
+	return res, nil
+}
+
+// encodeRESP encodes a Redis command as an array of bulk strings.
+func encodeRESP(args []string) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	return []byte(b.String())
+}
+
+// readRESP reads a simple string, error, integer or bulk string Redis reply.
+func readRESP(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+
+	case '-':
+		return "", fmt.Errorf("redis: %s", line[1:])
+
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid redis reply %q", line)
+		}
+
+		if n < 0 {
+			return "", nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+
+		return string(b[:n]), nil
+	}
+
+	return "", fmt.Errorf("unsupported redis reply %q", line)
+}
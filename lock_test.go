@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testLocker(t *testing.T, l locker) {
+	if err := l.Acquire("deploy", "a", time.Minute, time.Second); err != nil {
+		t.Fatalf("unexpected error acquiring free lock: %s", err)
+	}
+
+	if err := l.Acquire("deploy", "b", time.Minute, 200*time.Millisecond); err == nil {
+		t.Fatal("acquired a held lock")
+	}
+
+	if err := l.Refresh("deploy", "b", time.Minute); err != errLockHeld {
+		t.Errorf("expected errLockHeld refreshing another owner's lock, got %v", err)
+	}
+
+	if err := l.Release("deploy", "b"); err != errLockHeld {
+		t.Errorf("expected errLockHeld releasing another owner's lock, got %v", err)
+	}
+
+	if err := l.Refresh("deploy", "a", time.Minute); err != nil {
+		t.Errorf("unexpected error refreshing lock: %s", err)
+	}
+
+	if err := l.Release("deploy", "a"); err != nil {
+		t.Errorf("unexpected error releasing lock: %s", err)
+	}
+
+	if err := l.Acquire("deploy", "b", 100*time.Millisecond, time.Second); err != nil {
+		t.Fatalf("unexpected error acquiring released lock: %s", err)
+	}
+
+	// The lock expires since it isn't refreshed.
+	if err := l.Acquire("deploy", "c", time.Minute, 2*time.Second); err != nil {
+		t.Errorf("unexpected error acquiring expired lock: %s", err)
+	}
+}
+
+func TestFileLocker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := newLocker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testLocker(t, l)
+}
+
+func TestFileLockerBreakStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := &fileLocker{dir: dir}
+
+	if err := l.Acquire("deploy", "stale", time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// Of the callers breaking the stale lock at once, only one gets it.
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		owners []string
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+
+			if l.Acquire("deploy", token, time.Minute, 300*time.Millisecond) == nil {
+				mu.Lock()
+				owners = append(owners, token)
+				mu.Unlock()
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	if len(owners) != 1 {
+		t.Fatalf("expected a single owner, got %v", owners)
+	}
+
+	if got := l.owner(l.path("deploy")); got != owners[0] {
+		t.Errorf("expected the lock to be held by %s, got %q", owners[0], got)
+	}
+
+	if err := l.Release("deploy", "stale"); err != errLockHeld {
+		t.Errorf("expected errLockHeld releasing a broken lock, got %v", err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only the lock to be left, got %d files", len(files))
+	}
+}
+
+func TestRedisLocker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go serveFakeRedis(ln)
+
+	l, err := newLocker("redis://:pass@" + ln.Addr().String() + "/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testLocker(t, l)
+}
+
+// serveFakeRedis answers the commands used by redisLocker.
+func serveFakeRedis(ln net.Listener) {
+	var mu sync.Mutex
+
+	type entry struct {
+		value   string
+		expires time.Time
+	}
+
+	keys := make(map[string]entry)
+
+	get := func(k string) (string, bool) {
+		e, ok := keys[k]
+		if !ok || time.Now().After(e.expires) {
+			return "", false
+		}
+		return e.value, true
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			r := bufio.NewReader(conn)
+
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+				args := make([]string, n)
+				for i := range args {
+					r.ReadString('\n')
+					arg, _ := r.ReadString('\n')
+					args[i] = strings.TrimRight(arg, "\r\n")
+				}
+
+				mu.Lock()
+
+				var reply string
+
+				switch args[0] {
+				case "AUTH", "SELECT":
+					reply = "+OK\r\n"
+				case "SET":
+					ms, _ := strconv.Atoi(args[5])
+					if _, ok := get(args[1]); ok {
+						reply = "$-1\r\n"
+					} else {
+						keys[args[1]] = entry{args[2], time.Now().Add(time.Duration(ms) * time.Millisecond)}
+						reply = "+OK\r\n"
+					}
+				case "EVAL":
+					if v, ok := get(args[3]); !ok || v != args[4] {
+						reply = ":0\r\n"
+					} else if args[1] == redisReleaseScript {
+						delete(keys, args[3])
+						reply = ":1\r\n"
+					} else {
+						ms, _ := strconv.Atoi(args[5])
+						keys[args[3]] = entry{v, time.Now().Add(time.Duration(ms) * time.Millisecond)}
+						reply = ":1\r\n"
+					}
+				default:
+					reply = fmt.Sprintf("-ERR unknown command %s\r\n", args[0])
+				}
+
+				mu.Unlock()
+
+				conn.Write([]byte(reply))
+			}
+		}(conn)
+	}
+}
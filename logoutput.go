@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Log outputs selectable with -log-output.
+const (
+	logOutputStderr   = "stderr"
+	logOutputStdout   = "stdout"
+	logOutputFile     = "file"
+	logOutputSyslog   = "syslog"
+	logOutputJournald = "journald"
+)
+
+// Syslog priorities of log messages.
+const (
+	logPriorityErr     = 3
+	logPriorityWarning = 4
+	logPriorityInfo    = 6
+)
+
+// journaldSocket is the socket of the native journald protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// openLogOutput returns the writer of the log output, which defaults to the
+// logfile if path is set and to standard error otherwise, and whether the
+// output adds its own timestamps.
+func openLogOutput(output, path string) (io.Writer, bool, error) {
+	if output == "" {
+		output = logOutputStderr
+		if path != "" {
+			output = logOutputFile
+		}
+	}
+
+	if path != "" && output != logOutputFile {
+		return nil, false, fmt.Errorf("logfile can't be used with log-output %s", output)
+	}
+
+	switch output {
+	case logOutputStderr:
+		return os.Stderr, false, nil
+
+	case logOutputStdout:
+		return os.Stdout, false, nil
+
+	case logOutputFile:
+		if path == "" {
+			return nil, false, fmt.Errorf("log-output file requires a logfile")
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+		if err != nil {
+			return nil, false, fmt.Errorf("error opening log file %q: %v", path, err)
+		}
+
+		return file, false, nil
+
+	case logOutputSyslog:
+		w, err := newSyslogWriter()
+		if err != nil {
+			return nil, false, fmt.Errorf("error connecting to syslog: %v", err)
+		}
+
+		return w, true, nil
+
+	case logOutputJournald:
+		conn, err := net.Dial("unixgram", journaldSocket)
+		if err != nil {
+			return nil, false, fmt.Errorf("error connecting to journald: %v", err)
+		}
+
+		return &journaldWriter{conn: conn}, true, nil
+	}
+
+	return nil, false, fmt.Errorf("invalid log-output %q", output)
+}
+
+// logPriority returns the syslog priority of the log message msg: errors for
+// messages about errors and failures to load hooks, warnings for those
+// starting with "warn", and info otherwise.
+func logPriority(msg string) int {
+	msg = strings.ToLower(msg)
+
+	// Skip the request ID and other bracketed prefixes.
+	for strings.HasPrefix(msg, "[") {
+		i := strings.Index(msg, "] ")
+		if i == -1 {
+			break
+		}
+
+		msg = msg[i+2:]
+	}
+
+	switch {
+	case strings.HasPrefix(msg, "warn"):
+		return logPriorityWarning
+	case strings.Contains(msg, "error"), strings.HasPrefix(msg, "couldn't"):
+		return logPriorityErr
+	}
+
+	return logPriorityInfo
+}
+
+// journaldWriter sends log messages to journald with the native protocol,
+// with the priority given by logPriority.
+type journaldWriter struct {
+	conn net.Conn
+}
+
+// Write implements io.Writer.
+func (w *journaldWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+
+	var b bytes.Buffer
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(logPriority(msg)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", "webhook")
+	writeJournalField(&b, "MESSAGE", msg)
+
+	if _, err := w.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// writeJournalField appends the field name with value to b, using the binary
+// encoding for values spanning multiple lines, such as command output.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogPriority(t *testing.T) {
+	for _, tt := range []struct {
+		msg  string
+		want int
+	}{
+		{"version 2.8.0 starting", logPriorityInfo},
+		{"[6d2c81] deploy hook triggered successfully", logPriorityInfo},
+		{"[6d2c81] error occurred: exit status 1", logPriorityErr},
+		{"error: refusing hook(s) in hooks.json", logPriorityErr},
+		{"couldn't load hooks from file! open hooks.json: no such file or directory", logPriorityErr},
+		{"warn: use of deprecated option payload-hash-sha1; use payload-hmac-sha1 instead", logPriorityWarning},
+		{"[6d2c81] [deploy] warn: slow command", logPriorityWarning},
+	} {
+		if got := logPriority(tt.msg); got != tt.want {
+			t.Errorf("logPriority(%q) = %d, want %d", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestJournaldWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addr := &net.UnixAddr{Name: filepath.Join(dir, "socket"), Net: "unixgram"}
+
+	ln, err := net.ListenUnixgram("unixgram", addr)
+	if err != nil {
+		t.Skipf("unix datagram sockets are not supported: %s", err)
+	}
+	defer ln.Close()
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w := &journaldWriter{conn: conn}
+
+	for _, tt := range []struct {
+		msg  string
+		want string
+	}{
+		{"[6d2c81] error occurred: exit status 1\n", "PRIORITY=3\nSYSLOG_IDENTIFIER=webhook\nMESSAGE=[6d2c81] error occurred: exit status 1\n"},
+		{"[6d2c81] command output: a\nb\n", "PRIORITY=6\nSYSLOG_IDENTIFIER=webhook\nMESSAGE\n\x1c\x00\x00\x00\x00\x00\x00\x00[6d2c81] command output: a\nb\n"},
+	} {
+		if n, err := w.Write([]byte(tt.msg)); err != nil || n != len(tt.msg) {
+			t.Fatalf("got %d, %v", n, err)
+		}
+
+		buf := make([]byte, 1024)
+		n, err := ln.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := buf[:n]; !bytes.Equal(got, []byte(tt.want)) {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestOpenLogOutput(t *testing.T) {
+	if w, timestamped, err := openLogOutput("", ""); err != nil || w != os.Stderr || timestamped {
+		t.Errorf("expected standard error by default, got %v, %t, %v", w, timestamped, err)
+	}
+
+	for _, tt := range [][2]string{
+		{"file", ""},
+		{"syslog", "webhook.log"},
+		{"kafka", ""},
+	} {
+		if _, _, err := openLogOutput(tt[0], tt[1]); err == nil {
+			t.Errorf("expected an error for log-output %q with logfile %q", tt[0], tt[1])
+		}
+	}
+}
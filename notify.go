@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// Limits of the command output included in failure notifications.
+const (
+	notifyTailLines = 20
+	notifyTailBytes = 4096
+)
+
+// failureNotifiers are the notifiers set with -on-failure, notified of the
+// failed executions of all hooks.
+var failureNotifiers []failureNotifier
+
+// notifierURLs is the list of notifiers set with -on-failure.
+type notifierURLs []string
+
+func (n *notifierURLs) String() string {
+	return strings.Join(*n, ", ")
+}
+
+// Set implements the flag.Value interface.
+func (n *notifierURLs) Set(value string) error {
+	*n = append(*n, value)
+	return nil
+}
+
+// FailureNotification describes a failed execution.  It is the body POSTed to
+// HTTP notifiers.
+type FailureNotification struct {
+	RequestID string    `json:"request-id"`
+	HookID    string    `json:"hook-id"`
+	Group     string    `json:"group,omitempty"`
+	Time      time.Time `json:"time"`
+	ExitCode  int       `json:"exit-code"`
+	Error     string    `json:"error"`
+
+	// Output holds the last lines of the sanitized command output.
+	Output string `json:"output"`
+
+	// Fields holds the values mapped from the parsed command output with
+	// notification-fields-from-output.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// summary returns a one-line description of the failure.
+func (n *FailureNotification) summary() string {
+	return fmt.Sprintf("hook %s failed with exit code %d (request %s): %s", n.HookID, n.ExitCode, n.RequestID, n.Error)
+}
+
+// details returns the summary of the failure followed by its fields, one
+// per line.
+func (n *FailureNotification) details() string {
+	names := make([]string, 0, len(n.Fields))
+	for name := range n.Fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	s := n.summary()
+	for _, name := range names {
+		s += "\n" + name + ": " + n.Fields[name]
+	}
+
+	return s
+}
+
+// failureNotifier sends notifications of failed executions.
+type failureNotifier interface {
+	Notify(n *FailureNotification) error
+}
+
+// newFailureNotifier returns the notifier given by rawurl: a Slack incoming
+// webhook, an SMTP server or an HTTP endpoint.
+func newFailureNotifier(rawurl string) (failureNotifier, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "slack":
+		u.Scheme = "https"
+		return &slackNotifier{url: u.String()}, nil
+
+	case "smtp":
+		n := &smtpNotifier{addr: u.Host, from: u.Query().Get("from")}
+		if u.Port() == "" {
+			n.addr = net.JoinHostPort(u.Hostname(), "25")
+		}
+
+		for _, to := range strings.Split(u.Query().Get("to"), ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				n.to = append(n.to, to)
+			}
+		}
+
+		if n.from == "" || len(n.to) == 0 {
+			return nil, fmt.Errorf("smtp notifier requires from and to addresses")
+		}
+
+		if u.User != nil {
+			password, _ := u.User.Password()
+			n.auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+		}
+
+		return n, nil
+
+	case "http", "https":
+		return &httpNotifier{url: rawurl}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+}
+
+// notifyFailure notifies the notifiers set with -on-failure and the
+// on-failure notifiers of h of the failed execution ex, in the background.
+func notifyFailure(h *hook.Hook, ex Execution) {
+	notifiers := failureNotifiers
+
+	for _, rawurl := range h.OnFailure {
+		n, err := newFailureNotifier(rawurl)
+		if err != nil {
+			log.Printf("[%s] invalid on-failure notifier %s of hook %s: %s\n", ex.RequestID, redactURL(rawurl), h.ID, err)
+			continue
+		}
+
+		notifiers = append(notifiers, n)
+	}
+
+	if len(notifiers) == 0 {
+		return
+	}
+
+	n := &FailureNotification{
+		RequestID: ex.RequestID,
+		HookID:    h.ID,
+		Group:     h.Group,
+		Time:      time.Now(),
+		ExitCode:  ex.ExitCode,
+		Error:     ex.Error,
+		Output:    outputTail(ex.Output),
+	}
+
+	if ex.Result != nil {
+		var errors []error
+		n.Fields, errors = h.OutputNotificationFields(ex.Result)
+		for _, err := range errors {
+			log.Printf("[%s] error mapping command output to notification field: %s\n", ex.RequestID, err)
+		}
+	}
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+
+		for _, notifier := range notifiers {
+			if err := notifier.Notify(n); err != nil {
+				log.Printf("[%s] error sending failure notification of %s: %s\n", n.RequestID, n.HookID, err)
+			}
+		}
+	}()
+}
+
+// outputTail returns the last notifyTailLines lines of out, limited to
+// notifyTailBytes.
+func outputTail(out string) string {
+	out = strings.TrimRight(out, "\n")
+
+	lines := strings.Split(out, "\n")
+	if len(lines) > notifyTailLines {
+		out = strings.Join(lines[len(lines)-notifyTailLines:], "\n")
+	}
+
+	if len(out) > notifyTailBytes {
+		out = out[len(out)-notifyTailBytes:]
+	}
+
+	return out
+}
+
+var notifyHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON POSTs v as JSON to rawurl.
+func postJSON(rawurl string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyHTTPClient.Post(rawurl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// httpNotifier POSTs the FailureNotification as JSON to an HTTP endpoint.
+type httpNotifier struct {
+	url string
+}
+
+// Notify implements failureNotifier.
+func (n *httpNotifier) Notify(f *FailureNotification) error {
+	return postJSON(n.url, f)
+}
+
+// slackNotifier posts a message to a Slack incoming webhook.
+type slackNotifier struct {
+	url string
+}
+
+// Notify implements failureNotifier.
+func (n *slackNotifier) Notify(f *FailureNotification) error {
+	text := ":x: " + f.details()
+	if f.Output != "" {
+		text += "\n```\n" + f.Output + "\n```"
+	}
+
+	return postJSON(n.url, map[string]string{"text": text})
+}
+
+// smtpNotifier sends an email through an SMTP server, using STARTTLS if the
+// server supports it.
+type smtpNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// Notify implements failureNotifier.
+func (n *smtpNotifier) Notify(f *FailureNotification) error {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", n.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&b, "Subject: webhook: %s failed with exit code %d\r\n", f.HookID, f.ExitCode)
+	fmt.Fprintf(&b, "Date: %s\r\n", f.Time.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(f.details()+"\n\n"+f.Output+"\n", "\n", "\r\n"))
+
+	return smtp.SendMail(n.addr, n.auth, n.from, n.to, b.Bytes())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestFailureNotifiers(t *testing.T) {
+	received := make(chan string, 10)
+
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r.URL.Path + " " + string(body)
+	}))
+	defer h.Close()
+
+	smtpAddr := fakeEventServer(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+
+		var data []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "DATA":
+				conn.Write([]byte("354 go ahead\r\n"))
+				for {
+					line, _ := r.ReadString('\n')
+					if line == ".\r\n" {
+						break
+					}
+					data = append(data, strings.TrimRight(line, "\r\n"))
+				}
+				conn.Write([]byte("250 queued\r\n"))
+			case "QUIT":
+				received <- strings.Join(data, "\n")
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	})
+
+	n := &FailureNotification{RequestID: "abc", HookID: "deploy", ExitCode: 2, Error: "exit status 2", Output: "fatal: bad ref", Fields: map[string]string{"stage": "fetch"}}
+
+	for _, tt := range []struct {
+		notifier failureNotifier
+		want     []string
+	}{
+		{&httpNotifier{url: h.URL + "/alerts"}, []string{`/alerts {"request-id":"abc","hook-id":"deploy"`, `"exit-code":2,"error":"exit status 2","output":"fatal: bad ref","fields":{"stage":"fetch"}}`}},
+		{&slackNotifier{url: h.URL + "/services/T0/B0/X0"}, []string{"/services/T0/B0/X0 ", ":x: hook deploy failed with exit code 2 (request abc): exit status 2", "fatal: bad ref"}},
+	} {
+		if err := tt.notifier.Notify(n); err != nil {
+			t.Fatalf("%T: unexpected error: %s", tt.notifier, err)
+		}
+
+		got := <-received
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%T: expected %q in %s", tt.notifier, want, got)
+			}
+		}
+	}
+
+	smtpNotifier, err := newFailureNotifier("smtp://" + smtpAddr + "?from=webhook@example.com&to=ops@example.com,dev@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := smtpNotifier.Notify(n); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got := <-received
+	for _, want := range []string{"To: ops@example.com, dev@example.com", "Subject: webhook: deploy failed with exit code 2", "stage: fetch", "fatal: bad ref"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in the email:\n%s", want, got)
+		}
+	}
+
+	for _, rawurl := range []string{"smtp://localhost?to=ops@example.com", "amqp://localhost"} {
+		if _, err := newFailureNotifier(rawurl); err == nil {
+			t.Errorf("expected an error for %s", rawurl)
+		}
+	}
+}
+
+func TestNotifyFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	bodies := make(chan []byte, 10)
+
+	h := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer h.Close()
+
+	hk := &hook.Hook{
+		ID:                           "retried",
+		ExecuteCommand:               "sh",
+		PassArgumentsToCommand:       []hook.Argument{{Source: "string", Name: "-c"}, {Source: "string", Name: `echo '{"stage": "fetch"}'; echo 'failing' >&2; exit 1`}},
+		Retry:                        &hook.RetryRule{Attempts: 2, Delay: "1ms"},
+		OnFailure:                    []string{h.URL},
+		CommandOutputFormat:          hook.OutputFormatJSON,
+		NotificationFieldsFromOutput: []hook.OutputHeader{{Name: "stage", Key: "stage"}},
+	}
+
+	// Only the last of the failed attempts is notified.
+	handleHookInBackground(hk, &hook.Request{ID: "test"})
+	running.Wait()
+
+	var got FailureNotification
+
+	select {
+	case body := <-bodies:
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the notification")
+	}
+
+	if got.HookID != "retried" || got.ExitCode != 1 || got.Error != "exit status 1" || got.Fields["stage"] != "fetch" {
+		t.Errorf("unexpected notification %+v", got)
+	}
+
+	if len(bodies) != 0 {
+		t.Errorf("expected a single notification, got %d more", len(bodies))
+	}
+}
+
+func TestOutputTail(t *testing.T) {
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, strings.Repeat("x", i))
+	}
+
+	if got := outputTail(strings.Join(lines, "\n") + "\n"); got != strings.Join(lines[10:], "\n") {
+		t.Errorf("expected the last 20 lines, got %q", got)
+	}
+
+	if got := outputTail(strings.Repeat("y", 5000)); len(got) != notifyTailBytes {
+		t.Errorf("expected the output to be limited to %d bytes, got %d", notifyTailBytes, len(got))
+	}
+}
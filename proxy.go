@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// proxyClient is used to forward requests of proxy hooks.
+var proxyClient = &http.Client{Timeout: 30 * time.Second}
+
+// proxyHook forwards the request r for the proxy hook h to its proxy-url and
+// copies the upstream response to w.
+func proxyHook(w http.ResponseWriter, h *hook.Hook, r *hook.Request) {
+	body, err := h.ProxyBody(r)
+	if err != nil {
+		log.Printf("[%s] error transforming body for %s: %s\n", r.ID, h.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error occurred while transforming the request body. Please check your logs for more details.")
+		return
+	}
+
+	method := http.MethodPost
+	if r.RawRequest != nil {
+		method = r.RawRequest.Method
+	}
+
+	req, err := http.NewRequest(method, h.ProxyURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[%s] error creating upstream request for %s: %s\n", r.ID, h.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Error occurred while forwarding the request. Please check your logs for more details.")
+		return
+	}
+
+	switch {
+	case h.ProxyBodyTemplate != "":
+		req.Header.Set("Content-Type", "application/json")
+	case r.ContentType != "":
+		req.Header.Set("Content-Type", r.ContentType)
+	}
+
+	req.Header.Set("X-Request-Id", r.ID)
+
+	for _, header := range h.ProxyHeaders {
+		req.Header.Set(header.Name, header.Value)
+	}
+
+	log.Printf("[%s] forwarding %s to %s\n", r.ID, h.ID, h.ProxyURL)
+
+	res, err := proxyClient.Do(req)
+	if err != nil {
+		log.Printf("[%s] error forwarding %s: %s\n", r.ID, h.ID, err)
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "Error occurred while forwarding the request. Please check your logs for more details.")
+		return
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+
+	w.WriteHeader(res.StatusCode)
+
+	if _, err := io.Copy(w, res.Body); err != nil {
+		log.Printf("[%s] error copying upstream response for %s: %s\n", r.ID, h.ID, err)
+	}
+}
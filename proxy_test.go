@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestProxyHook(t *testing.T) {
+	var gotBody, gotType, gotToken string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		gotBody, gotType, gotToken = string(b), r.Header.Get("Content-Type"), r.Header.Get("X-Token")
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("queued"))
+	}))
+	defer upstream.Close()
+
+	h := &hook.Hook{
+		ID:                "chat",
+		Kind:              hook.KindProxy,
+		ProxyURL:          upstream.URL,
+		ProxyHeaders:      hook.ResponseHeaders{{Name: "X-Token", Value: "t0k"}},
+		ProxyBodyTemplate: `{"text": {{ json .Payload.head_commit.message }}, "event": {{ json .Headers.X_Event }}, "original": {{ json .Payload }}}`,
+	}
+
+	r := &hook.Request{
+		ID:      "abc",
+		Headers: map[string]interface{}{"X_Event": "push"},
+		Payload: map[string]interface{}{"head_commit": map[string]interface{}{"message": "fix \"bug\""}},
+	}
+
+	rec := httptest.NewRecorder()
+	proxyHook(rec, h, r)
+
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "queued" {
+		t.Errorf("unexpected response %d %q", rec.Code, rec.Body.String())
+	}
+
+	expect := `{"text": "fix \"bug\"", "event": "push", "original": {"head_commit":{"message":"fix \"bug\""}}}`
+	if gotBody != expect {
+		t.Errorf("unexpected upstream body:\nexpected %s\ngot      %s", expect, gotBody)
+	}
+
+	if gotType != "application/json" || gotToken != "t0k" {
+		t.Errorf("unexpected upstream headers: Content-Type %q, X-Token %q", gotType, gotToken)
+	}
+
+	h.ProxyURL = "http://127.0.0.1:0"
+	rec = httptest.NewRecorder()
+	proxyHook(rec, h, r)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("expected %d for unreachable upstream, got %d", http.StatusBadGateway, rec.Code)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// remoteHooksMaxSize limits the size of remote hooks files.
+const remoteHooksMaxSize = 16 << 20
+
+// isRemoteHooks reports whether the hooks file path is a URL.
+func isRemoteHooks(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// remoteHooksFile is a cached remote hooks file.
+type remoteHooksFile struct {
+	body         []byte
+	etag         string
+	lastModified string
+}
+
+// remoteHooksCache fetches remote hooks files, revalidating the cached
+// contents with their ETag or modification time.
+type remoteHooksCache struct {
+	client  *http.Client
+	headers hook.ResponseHeaders
+
+	mu    sync.Mutex
+	files map[string]*remoteHooksFile
+}
+
+// Fetch returns the contents of the hooks file at url, and whether they
+// changed since they were last fetched.
+func (c *remoteHooksCache) Fetch(url string) ([]byte, bool, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, false, fmt.Errorf("remote hooks file %s must be fetched over HTTPS", redactURL(url))
+	}
+
+	c.mu.Lock()
+	cached := c.files[url]
+	c.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, h := range c.headers {
+		req.Header.Set(h.Name, h.Value)
+	}
+
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached.body, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("error fetching %s: %s", redactURL(url), resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, remoteHooksMaxSize))
+	if err != nil {
+		return nil, false, fmt.Errorf("error fetching %s: %w", redactURL(url), err)
+	}
+
+	c.mu.Lock()
+	if c.files == nil {
+		c.files = make(map[string]*remoteHooksFile)
+	}
+	c.files[url] = &remoteHooksFile{
+		body:         body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	c.mu.Unlock()
+
+	changed := cached == nil || string(cached.body) != string(body)
+
+	return body, changed, nil
+}
+
+// loadRemoteHooks loads the hooks of the remote hooks file url into h.
+func loadRemoteHooks(h *hook.Hooks, url string, options []hook.LoadOption) error {
+	body, _, err := remoteHooks.Fetch(url)
+	if err != nil {
+		return err
+	}
+
+	return h.Load(url, body, *asTemplate, options...)
+}
+
+// refreshRemoteHooks fetches the remote hooks files every interval, reloading
+// them if they changed.
+func refreshRemoteHooks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		refreshRemoteHooksFiles()
+	}
+}
+
+// refreshRemoteHooksFiles fetches the remote hooks files, reloading those
+// that changed.
+func refreshRemoteHooksFiles() {
+	for _, path := range currentHooksFiles() {
+		if !isRemoteHooks(path) {
+			continue
+		}
+
+		body, changed, err := remoteHooks.Fetch(path)
+		if err != nil {
+			log.Printf("couldn't refresh hooks from %s: %s\n", redactURL(path), err)
+			continue
+		}
+
+		if !changed {
+			continue
+		}
+
+		log.Printf("hooks file %s modified\n", redactURL(path))
+
+		var load hooksFileLoad
+
+		started := time.Now()
+		load.err = load.hooks.Load(path, body, *asTemplate, hookLoadOptions()...)
+		load.duration = time.Since(started)
+
+		if load.err == nil {
+			load.hooks = applySHA1Policy(path, load.hooks)
+		}
+
+		swapHooks(path, load)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestRemoteHooksCache(t *testing.T) {
+	body := `[{"id": "remote", "execute-command": "/bin/true"}]`
+
+	var notModified int32
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	c := &remoteHooksCache{client: ts.Client()}
+
+	if _, _, err := c.Fetch(ts.URL + "/hooks.json"); err == nil {
+		t.Error("expected an error without the authorization header")
+	}
+
+	c.headers = hook.ResponseHeaders{{Name: "Authorization", Value: "Bearer secret"}}
+
+	b, changed, err := c.Fetch(ts.URL + "/hooks.json")
+	if err != nil || !changed || string(b) != body {
+		t.Fatalf("expected changed hooks file, got %q, %v (err: %v)", b, changed, err)
+	}
+
+	b, changed, err = c.Fetch(ts.URL + "/hooks.json")
+	if err != nil || changed || string(b) != body {
+		t.Fatalf("expected unchanged cached hooks file, got %q, %v (err: %v)", b, changed, err)
+	}
+
+	if notModified != 1 {
+		t.Errorf("expected the cached hooks file to be revalidated with its ETag")
+	}
+
+	var hooks hook.Hooks
+	if err := hooks.Load(ts.URL+"/hooks.json", b, false); err != nil || hooks.Match("remote") == nil {
+		t.Errorf("expected the remote hook to load, got %+v (err: %v)", hooks, err)
+	}
+
+	if _, _, err := c.Fetch("http://example.com/hooks.json"); err == nil {
+		t.Error("expected an error for a plain HTTP URL")
+	}
+}
+
+func TestRefreshRemoteHooksWhileServing(t *testing.T) {
+	var version int32
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"id": "remote", "execute-command": "/bin/true", "response-message": "v%d"}]`, atomic.AddInt32(&version, 1))
+	}))
+	defer ts.Close()
+
+	defer func(h map[string]hook.Hooks, files hook.HooksFiles, c *remoteHooksCache) {
+		loadedHooksFromFiles, hooksFiles, remoteHooks = h, files, c
+	}(loadedHooksFromFiles, hooksFiles, remoteHooks)
+
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+	hooksFiles = hook.HooksFiles{ts.URL + "/hooks.json"}
+	remoteHooks = &remoteHooksCache{client: ts.Client()}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 20; i++ {
+			refreshRemoteHooksFiles()
+		}
+	}()
+
+	// Run with -race to check that requests don't race with refreshes.
+	for {
+		select {
+		case <-done:
+			if h := matchLoadedHook("remote"); h == nil || h.ResponseMessage != "v20" {
+				t.Errorf("expected the last version of the remote hook, got %+v", h)
+			}
+			return
+		default:
+			matchLoadedHook("remote")
+		}
+	}
+}
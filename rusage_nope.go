@@ -0,0 +1,11 @@
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import (
+	"os"
+)
+
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,25 @@
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// maxRSS returns the maximum resident set size in bytes of the exited process
+// ps.
+func maxRSS(ps *os.ProcessState) int64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+
+	// Darwin reports bytes, the others kilobytes.
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+
+	return int64(ru.Maxrss) * 1024
+}
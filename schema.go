@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// schemaCommand implements the "schema" subcommand, which prints the JSON
+// Schema of hooks files, published as docs/hooks.schema.json.
+func schemaCommand(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	os.Stdout.Write(hook.Schema())
+
+	return 0
+}
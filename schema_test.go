@@ -0,0 +1,19 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestPublishedSchema(t *testing.T) {
+	b, err := ioutil.ReadFile("docs/hooks.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != string(hook.Schema()) {
+		t.Error("docs/hooks.schema.json is out of date; run make schema")
+	}
+}
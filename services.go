@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+const (
+	// serviceQueueSize is the number of deliveries buffered for a service
+	// reading from stdin.
+	serviceQueueSize = 100
+
+	// serviceMinBackoff and serviceMaxBackoff bound the delay before a
+	// service that exited is restarted.
+	serviceMinBackoff = time.Second
+	serviceMaxBackoff = time.Minute
+
+	// serviceDialTimeout limits connecting to a service socket.
+	serviceDialTimeout = 5 * time.Second
+)
+
+// serviceDelivery is the message sent to a service for each triggered request.
+type serviceDelivery struct {
+	ID      string                 `json:"id"`
+	Hook    string                 `json:"hook"`
+	Method  string                 `json:"method,omitempty"`
+	Headers map[string]interface{} `json:"headers,omitempty"`
+	Query   map[string]interface{} `json:"query,omitempty"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	Body    string                 `json:"body,omitempty"`
+}
+
+// serviceSupervisor keeps the commands of service hooks running.
+type serviceSupervisor struct {
+	mu       sync.Mutex
+	services map[string]*service
+
+	// stopped holds the IDs of services stopped through Stop.  They are not
+	// started again until restarted.
+	stopped map[string]bool
+}
+
+// ServiceInfo describes the state of a service.
+type ServiceInfo struct {
+	ID       string    `json:"id"`
+	Command  string    `json:"command"`
+	Running  bool      `json:"running"`
+	Stopped  bool      `json:"stopped"`
+	PID      int       `json:"pid,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	Restarts int       `json:"restarts"`
+	Queued   int       `json:"queued"`
+}
+
+// service is a supervised, long-running hook command.
+type service struct {
+	id     string
+	config string
+
+	path   string
+	args   []string
+	env    []string
+	dir    string
+	socket string
+
+	queue chan []byte
+	stop  chan struct{}
+
+	mu       sync.Mutex
+	process  *os.Process
+	started  time.Time
+	restarts int
+}
+
+// Deliver sends the request r for the service hook h to its command, starting
+// the command first if it isn't running or its definition changed.
+func (s *serviceSupervisor) Deliver(h *hook.Hook, r *hook.Request) error {
+	svc, err := s.get(h)
+	if err != nil {
+		return err
+	}
+
+	d := serviceDelivery{
+		ID:      r.ID,
+		Hook:    h.ID,
+		Headers: r.Headers,
+		Query:   r.Query,
+		Payload: r.Payload,
+		Body:    string(r.Body),
+	}
+
+	if r.RawRequest != nil {
+		d.Method = r.RawRequest.Method
+	}
+
+	msg, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return svc.deliver(append(msg, '\n'))
+}
+
+// Prune stops the services whose hooks, as returned by lookup, no longer
+// exist or are no longer services.
+func (s *serviceSupervisor) Prune(lookup func(id string) *hook.Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, svc := range s.services {
+		if h := lookup(id); h == nil || h.Kind != hook.KindService {
+			log.Printf("service %s removed, stopping", id)
+			close(svc.stop)
+			delete(s.services, id)
+		}
+	}
+
+	for id := range s.stopped {
+		if h := lookup(id); h == nil || h.Kind != hook.KindService {
+			delete(s.stopped, id)
+		}
+	}
+}
+
+// List returns the state of all services, ordered by ID.
+func (s *serviceSupervisor) List() []ServiceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]ServiceInfo, 0, len(s.services)+len(s.stopped))
+
+	for _, svc := range s.services {
+		res = append(res, svc.info())
+	}
+
+	for id := range s.stopped {
+		res = append(res, ServiceInfo{ID: id, Stopped: true})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+
+	return res
+}
+
+// Signal sends sig to the process of the service id.
+func (s *serviceSupervisor) Signal(id string, sig os.Signal) error {
+	s.mu.Lock()
+	svc, ok := s.services[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("service %s is not running", id)
+	}
+
+	return svc.signal(sig)
+}
+
+// Stop stops the service id.  It is not started again by deliveries until it
+// is restarted.
+func (s *serviceSupervisor) Stop(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc, ok := s.services[id]
+	if !ok {
+		return fmt.Errorf("service %s is not running", id)
+	}
+
+	log.Printf("stopping service %s", id)
+	close(svc.stop)
+	delete(s.services, id)
+
+	if s.stopped == nil {
+		s.stopped = make(map[string]bool)
+	}
+
+	s.stopped[id] = true
+
+	return nil
+}
+
+// Restart stops the service for h, if it is running, and starts it again.
+func (s *serviceSupervisor) Restart(h *hook.Hook) error {
+	s.mu.Lock()
+
+	if svc, ok := s.services[h.ID]; ok {
+		log.Printf("restarting service %s", h.ID)
+		close(svc.stop)
+		delete(s.services, h.ID)
+	}
+
+	delete(s.stopped, h.ID)
+
+	s.mu.Unlock()
+
+	_, err := s.get(h)
+
+	return err
+}
+
+// get returns the running service for h.
+func (s *serviceSupervisor) get(h *hook.Hook) (*service, error) {
+	path, err := exec.LookPath(h.CommandPath())
+	if err != nil {
+		return nil, err
+	}
+
+	// Service commands are started independently of any request, so
+	// arguments can only use static sources.
+	static := &hook.Request{}
+
+	args, errs := h.ExtractCommandArguments(static)
+	for _, err := range errs {
+		log.Printf("error extracting service %s arguments: %s", h.ID, err)
+	}
+
+	env, errs := h.ExtractCommandArgumentsForEnv(static)
+	for _, err := range errs {
+		log.Printf("error extracting service %s environment: %s", h.ID, err)
+	}
+
+	config := strings.Join([]string{path, h.CommandWorkingDirectory, h.ServiceSocket, fmt.Sprintf("%q", args), fmt.Sprintf("%q", env)}, "\x00")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped[h.ID] {
+		return nil, fmt.Errorf("service %s is stopped", h.ID)
+	}
+
+	if svc, ok := s.services[h.ID]; ok {
+		if svc.config == config {
+			return svc, nil
+		}
+
+		log.Printf("service %s definition changed, restarting", h.ID)
+		close(svc.stop)
+	}
+
+	svc := &service{
+		id:     h.ID,
+		config: config,
+		path:   path,
+		args:   args,
+		env:    env,
+		dir:    h.CommandWorkingDirectory,
+		socket: h.ServiceSocket,
+		queue:  make(chan []byte, serviceQueueSize),
+		stop:   make(chan struct{}),
+	}
+
+	if s.services == nil {
+		s.services = make(map[string]*service)
+	}
+
+	s.services[h.ID] = svc
+
+	go svc.run()
+
+	return svc, nil
+}
+
+// info returns the state of the service.
+func (svc *service) info() ServiceInfo {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	info := ServiceInfo{
+		ID:       svc.id,
+		Command:  svc.path,
+		Running:  svc.process != nil,
+		Restarts: svc.restarts,
+		Queued:   len(svc.queue),
+	}
+
+	if svc.process != nil {
+		info.PID = svc.process.Pid
+		info.Started = svc.started
+	}
+
+	return info
+}
+
+// signal sends sig to the service process.
+func (svc *service) signal(sig os.Signal) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if svc.process == nil {
+		return fmt.Errorf("service %s is not running", svc.id)
+	}
+
+	log.Printf("sending %s to service %s (pid %d)", sig, svc.id, svc.process.Pid)
+
+	return svc.process.Signal(sig)
+}
+
+// deliver queues msg for the service's stdin, or writes it to the service
+// socket.
+func (svc *service) deliver(msg []byte) error {
+	if svc.socket == "" {
+		select {
+		case svc.queue <- msg:
+			return nil
+		default:
+			return fmt.Errorf("service %s queue is full", svc.id)
+		}
+	}
+
+	conn, err := net.DialTimeout("unix", svc.socket, serviceDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(serviceDialTimeout))
+
+	_, err = conn.Write(msg)
+
+	return err
+}
+
+// run runs the service command until the service is stopped, restarting it
+// with exponential backoff whenever it exits.
+func (svc *service) run() {
+	backoff := serviceMinBackoff
+
+	for i := 0; ; i++ {
+		started := time.Now()
+
+		log.Printf("starting service %s: %s %q", svc.id, svc.path, svc.args)
+
+		svc.mu.Lock()
+		svc.restarts = i
+		svc.mu.Unlock()
+
+		err := svc.runOnce()
+
+		select {
+		case <-svc.stop:
+			log.Printf("service %s stopped", svc.id)
+			return
+		default:
+		}
+
+		if time.Since(started) > serviceMaxBackoff {
+			backoff = serviceMinBackoff
+		}
+
+		log.Printf("service %s exited: %v; restarting in %s", svc.id, err, backoff)
+
+		select {
+		case <-svc.stop:
+			log.Printf("service %s stopped", svc.id)
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > serviceMaxBackoff {
+			backoff = serviceMaxBackoff
+		}
+	}
+}
+
+// runOnce starts the service command and feeds it deliveries until it exits
+// or the service is stopped.
+func (svc *service) runOnce() error {
+	cmd := exec.Command(svc.path)
+	cmd.Args = svc.args
+	cmd.Dir = svc.dir
+	cmd.Env = append(os.Environ(), svc.env...)
+	cmd.Stdout = log.Writer()
+	cmd.Stderr = log.Writer()
+
+	var (
+		stdin io.WriteCloser
+		queue chan []byte
+		err   error
+	)
+
+	if svc.socket == "" {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+
+		queue = svc.queue
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	svc.mu.Lock()
+	svc.process = cmd.Process
+	svc.started = time.Now()
+	svc.mu.Unlock()
+
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+
+		svc.mu.Lock()
+		svc.process = nil
+		svc.mu.Unlock()
+
+		exited <- err
+	}()
+
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("exit status 0")
+			}
+			return err
+
+		case <-svc.stop:
+			cmd.Process.Kill()
+			return <-exited
+
+		case msg := <-queue:
+			if _, err := stdin.Write(msg); err != nil {
+				log.Printf("error writing to service %s: %s", svc.id, err)
+			}
+		}
+	}
+}
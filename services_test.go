@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestServiceDeliver(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a POSIX shell")
+	}
+
+	dir, err := ioutil.TempDir("", "webhook-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "service.sh")
+	out := filepath.Join(dir, "out")
+
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexec cat >> \"$1\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &hook.Hook{
+		ID:             "svc",
+		Kind:           hook.KindService,
+		ExecuteCommand: script,
+		PassArgumentsToCommand: []hook.Argument{
+			{Source: "string", Name: out},
+		},
+	}
+
+	s := &serviceSupervisor{}
+	defer s.Prune(func(string) *hook.Hook { return nil })
+
+	for _, id := range []string{"r1", "r2"} {
+		if err := s.Deliver(h, &hook.Request{ID: id, Payload: map[string]interface{}{"n": id}}); err != nil {
+			t.Fatalf("unexpected error delivering %s: %s", id, err)
+		}
+	}
+
+	var lines []string
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		b, _ := ioutil.ReadFile(out)
+		if lines = strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) == 2 {
+			break
+		}
+	}
+
+	if len(lines) != 2 || !strings.Contains(lines[0], `"id":"r1"`) || !strings.Contains(lines[1], `"payload":{"n":"r2"}`) {
+		t.Errorf("unexpected service input: %q", lines)
+	}
+
+	if len(s.services) != 1 {
+		t.Errorf("expected a single running service, got %d", len(s.services))
+	}
+
+	if err := s.Stop("svc"); err != nil {
+		t.Fatalf("unexpected error stopping service: %s", err)
+	}
+
+	if err := s.Deliver(h, &hook.Request{ID: "r3"}); err == nil {
+		t.Error("expected delivery to a stopped service to fail")
+	}
+
+	if info := s.List(); len(info) != 1 || !info[0].Stopped {
+		t.Errorf("expected stopped service, got %+v", info)
+	}
+
+	if err := s.Restart(h); err != nil {
+		t.Fatalf("unexpected error restarting service: %s", err)
+	}
+
+	if err := s.Deliver(h, &hook.Request{ID: "r4"}); err != nil {
+		t.Errorf("unexpected error delivering to restarted service: %s", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// signHash returns the hash function of the -algo value of the sign
+// subcommand.  Scalr signatures use SHA1 over the body and date, and Shopify
+// signatures base64 encoded SHA256.
+func signHash(algo string) (func() hash.Hash, bool) {
+	switch algo {
+	case "scalr":
+		return sha1.New, true
+	case "shopify":
+		return sha256.New, true
+	}
+
+	return hook.HashAlgorithm(algo)
+}
+
+// signCommand implements the "sign" subcommand, which prints the signature a
+// payload-hmac, scalr-signature or shopify-signature rule expects for a
+// payload.
+func signCommand(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+
+	algo := fs.String("algo", "sha256", `signature algorithm: a payload-hmac algorithm, ie. "sha1", "sha256", "sha512", "sha3-256" or "blake2b", "scalr" or "shopify"`)
+	secret := fs.String("secret", os.Getenv("WEBHOOK_SECRET"), "secret of the rule; defaults to $WEBHOOK_SECRET, which keeps it out of the shell history")
+	file := fs.String("file", "-", `path to the payload; use "-" for stdin`)
+	header := fs.String("header", "", `print the signature as a header with this name, ie. "X-Hub-Signature-256", for use with curl -H`)
+	date := fs.String("date", "", `Date header of scalr signatures, in the "Mon 02 Jan 2006 15:04:05 MST" format; defaults to now`)
+
+	fs.Parse(args)
+
+	newHash, ok := signHash(*algo)
+	if !ok || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "error: no secret; set -secret or WEBHOOK_SECRET")
+		return 2
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+
+	if *file == "-" {
+		payload, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		payload, err = ioutil.ReadFile(*file)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	if *algo == "scalr" {
+		if *date == "" {
+			*date = time.Now().UTC().Format("Mon 02 Jan 2006 15:04:05 MST")
+		}
+
+		sig := sign(newHash, *secret, payload, []byte(*date))
+
+		if *header == "" {
+			*header = "X-Signature"
+		}
+
+		// The rule reads both headers, so always print them as headers.
+		writeSignature(os.Stdout, "Date", *date)
+		writeSignature(os.Stdout, *header, sig)
+
+		return 0
+	}
+
+	if *algo == "shopify" {
+		if *header == "" {
+			*header = hook.ShopifySignatureHeader
+		}
+
+		writeSignature(os.Stdout, *header, base64.StdEncoding.EncodeToString(signSum(newHash, *secret, payload)))
+
+		return 0
+	}
+
+	writeSignature(os.Stdout, *header, *algo+"="+sign(newHash, *secret, payload))
+
+	return 0
+}
+
+// sign returns the hex-encoded HMAC of the concatenated data using the hash
+// function newHash and secret.
+func sign(newHash func() hash.Hash, secret string, data ...[]byte) string {
+	return hex.EncodeToString(signSum(newHash, secret, data...))
+}
+
+// signSum returns the HMAC of the concatenated data using the hash function
+// newHash and secret.
+func signSum(newHash func() hash.Hash, secret string, data ...[]byte) []byte {
+	mac := hmac.New(newHash, []byte(secret))
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	return mac.Sum(nil)
+}
+
+// writeSignature writes sig to w, as the header name if name isn't empty.
+func writeSignature(w io.Writer, name, sig string) {
+	if name != "" {
+		fmt.Fprintf(w, "%s: %s\n", name, sig)
+		return
+	}
+
+	fmt.Fprintln(w, sig)
+}
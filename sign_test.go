@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"ref": "refs/heads/main"}`)
+
+	for _, tt := range []struct {
+		algo  string
+		check func([]byte, string, string) (string, error)
+	}{
+		{"sha1", hook.CheckPayloadSignature},
+		{"sha256", hook.CheckPayloadSignature256},
+		{"sha512", hook.CheckPayloadSignature512},
+		{"blake2b", func(payload []byte, secret, sig string) (string, error) {
+			return hook.CheckPayloadHMAC(payload, "blake2b", secret, sig)
+		}},
+	} {
+		newHash, _ := signHash(tt.algo)
+		sig := tt.algo + "=" + sign(newHash, "secret", payload)
+
+		if _, err := tt.check(payload, "secret", sig); err != nil {
+			t.Errorf("%s: signature %s rejected: %s", tt.algo, sig, err)
+		}
+	}
+
+	date := "Fri 08 Sep 2017 11:24:32 UTC"
+	r := &hook.Request{
+		Body: payload,
+		Headers: map[string]interface{}{
+			"Date":        date,
+			"X-Signature": sign(sha1.New, "secret", payload, []byte(date)),
+		},
+	}
+
+	if ok, err := hook.CheckScalrSignature(r, "secret", false); !ok || err != nil {
+		t.Errorf("scalr: signature rejected: %v", err)
+	}
+
+	newHash, _ := signHash("shopify")
+	r.Headers[hook.ShopifySignatureHeader] = base64.StdEncoding.EncodeToString(signSum(newHash, "secret", payload))
+
+	if ok, err := hook.CheckShopifySignature(r, "secret"); !ok || err != nil {
+		t.Errorf("shopify: signature rejected: %v", err)
+	}
+}
+
+func TestWriteSignature(t *testing.T) {
+	var b bytes.Buffer
+
+	writeSignature(&b, "", "sha1=abc")
+	writeSignature(&b, "X-Hub-Signature", "sha1=abc")
+
+	if expect := "sha1=abc\nX-Hub-Signature: sha1=abc\n"; b.String() != expect {
+		t.Errorf("expected %q, got %q", expect, b.String())
+	}
+}
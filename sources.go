@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// hooksSource is a source of hooks files other than the file system, such as
+// Kubernetes ConfigMaps or a key-value store, which is listed and watched for
+// changes.
+type hooksSource interface {
+	// Owns reports whether the hooks file path comes from the source.
+	Owns(path string) bool
+
+	// List fetches the hooks files, and returns their paths, sorted,
+	// whether each of them changed since the previous list, and the
+	// version of the list.
+	List() ([]string, map[string]bool, string, error)
+
+	// Watch blocks until the hooks files may have changed since the
+	// version, or a timeout.
+	Watch(version string) error
+
+	// File returns the contents of the hooks file path as of the last list.
+	File(path string) ([]byte, bool)
+}
+
+// hooksSources are the sources of hooks files set on the command line.
+var hooksSources []hooksSource
+
+// sourceOf returns the source of the hooks file path, or nil for files.
+func sourceOf(path string) hooksSource {
+	for _, s := range hooksSources {
+		if s.Owns(path) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// isHooksFileName reports whether the name of a ConfigMap or store key has the
+// extension of a hooks file.
+func isHooksFileName(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".toml", ".hcl":
+		return true
+	}
+
+	return false
+}
+
+// sourceFiles caches the hooks files listed by a source.
+type sourceFiles struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// update replaces the cached hooks files, and returns which of them changed.
+func (c *sourceFiles) update(files map[string][]byte) map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := make(map[string]bool, len(files))
+	for p, b := range files {
+		if old, ok := c.files[p]; !ok || string(old) != string(b) {
+			changed[p] = true
+		}
+	}
+
+	c.files = files
+
+	return changed
+}
+
+// File implements hooksSource.
+func (c *sourceFiles) File(path string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.files[path]
+
+	return b, ok
+}
+
+// loadSourceHooks loads the hooks of the hooks file path of src into h.
+func loadSourceHooks(h *hook.Hooks, src hooksSource, path string, options []hook.LoadOption) error {
+	b, ok := src.File(path)
+	if !ok {
+		return fmt.Errorf("hooks file %s not found", path)
+	}
+
+	return h.Load(path, b, *asTemplate, options...)
+}
+
+// watchHooksSource reloads the hooks files of src when they change, adding
+// and removing hooks files as they are, starting from the version of the
+// list done at startup.
+func watchHooksSource(src hooksSource, version string) {
+	for {
+		if err := src.Watch(version); err != nil {
+			log.Printf("error watching hooks files: %s\n", err)
+			time.Sleep(5 * time.Second)
+		}
+
+		paths, changed, newVersion, err := src.List()
+		if err != nil {
+			log.Printf("couldn't list hooks files: %s\n", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		version = newVersion
+
+		syncSourceHooks(src, paths, changed)
+	}
+}
+
+// syncSourceHooks reloads the changed hooks files of paths listed by src,
+// removing the hooks of those no longer listed.
+func syncSourceHooks(src hooksSource, paths []string, changed map[string]bool) {
+	listed := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		listed[p] = true
+	}
+
+	for _, p := range currentHooksFiles() {
+		if src.Owns(p) && !listed[p] {
+			log.Printf("hooks file %s removed, removing hooks that were loaded from it\n", p)
+			removeHooks(p)
+		}
+	}
+
+	for _, p := range paths {
+		hooksMu.Lock()
+		known := isHooksFile(p)
+		if !known {
+			hooksFiles = append(hooksFiles, p)
+		}
+		hooksMu.Unlock()
+
+		if changed[p] || !known {
+			log.Printf("hooks file %s modified\n", p)
+			reloadHooks(p)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// stateLockTimeout limits waiting for the lock of a state file.
+	stateLockTimeout = 10 * time.Second
+
+	// staleLockAge is the age after which a lock is assumed to be left behind
+	// by a crashed process.
+	staleLockAge = time.Minute
+)
+
+// stateFile returns the path of the state file of the hook id in the state
+// directory dir.
+func stateFile(dir, id string) string {
+	return filepath.Join(dir, url.PathEscape(id)+".json")
+}
+
+// stateCommand implements the "state" subcommand, which reads and updates a
+// hook state file from hook commands.
+func stateCommand(args []string) int {
+	fs := flag.NewFlagSet("state", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage of state: webhook state [-file path] get|set|delete|incr|list [key] [value]")
+		fs.PrintDefaults()
+	}
+
+	file := fs.String("file", os.Getenv("HOOK_STATE_FILE"), "path to the state file; defaults to $HOOK_STATE_FILE")
+
+	fs.Parse(args)
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "error: no state file; set -file or HOOK_STATE_FILE")
+		return 2
+	}
+
+	op, rest := fs.Arg(0), fs.Args()
+	if len(rest) > 0 {
+		rest = rest[1:]
+	}
+
+	// nargs holds the minimum and maximum number of arguments of each
+	// operation.
+	nargs := map[string][2]int{"get": {1, 1}, "set": {2, 2}, "delete": {1, 1}, "incr": {1, 2}, "list": {0, 0}}
+
+	n, ok := nargs[op]
+	if !ok || len(rest) < n[0] || len(rest) > n[1] {
+		fs.Usage()
+		return 2
+	}
+
+	if op == "get" || op == "list" {
+		state, err := readState(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			return 1
+		}
+
+		if op == "list" {
+			printState(state)
+			return 0
+		}
+
+		v, ok := state[rest[0]]
+		if !ok {
+			return 1
+		}
+
+		fmt.Println(v)
+
+		return 0
+	}
+
+	var out string
+
+	err := updateState(*file, func(state map[string]string) error {
+		switch op {
+		case "set":
+			state[rest[0]] = rest[1]
+		case "delete":
+			delete(state, rest[0])
+		case "incr":
+			by := int64(1)
+			if len(rest) == 2 {
+				var err error
+				if by, err = strconv.ParseInt(rest[1], 10, 64); err != nil {
+					return fmt.Errorf("invalid increment %q", rest[1])
+				}
+			}
+
+			var n int64
+			if v, ok := state[rest[0]]; ok {
+				var err error
+				if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return fmt.Errorf("value of %s is not an integer: %q", rest[0], v)
+				}
+			}
+
+			out = strconv.FormatInt(n+by, 10)
+			state[rest[0]] = out
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	if out != "" {
+		fmt.Println(out)
+	}
+
+	return 0
+}
+
+// printState writes state to stdout as key=value lines ordered by key.
+func printState(state map[string]string) {
+	keys := make([]string, 0, len(state))
+	for k := range state {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("%s=%s\n", k, state[k])
+	}
+}
+
+// readState returns the contents of the state file path.  A missing file is an
+// empty state.
+func readState(path string) (map[string]string, error) {
+	state := make(map[string]string)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return state, nil
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+
+	return state, nil
+}
+
+// updateState applies fn to the contents of the state file path while holding
+// its lock, and writes the result back atomically.
+func updateState(path string, fn func(state map[string]string) error) error {
+	unlock, err := lockPath(path, stateLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readState(path)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(state); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// lockPath acquires the lock for path, waiting up to timeout, and returns the
+// function releasing it.  The lock is a directory next to path, since creating
+// a directory is atomic on all platforms.
+func lockPath(path string, timeout time.Duration) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := os.Mkdir(lock, 0o700)
+		if err == nil {
+			return func() { os.Remove(lock) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(lock)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for lock %s", lock)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestUpdateState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := stateFile(dir, "sendgrid/dir")
+	if filepath.Dir(path) != dir {
+		t.Fatalf("state file %s escapes the state directory", path)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := updateState(path, func(state map[string]string) error {
+				n, _ := strconv.Atoi(state["count"])
+				state["count"] = strconv.Itoa(n + 1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	state, err := readState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state["count"] != "20" {
+		t.Errorf("expected count 20, got %q", state["count"])
+	}
+
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock not released: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+// +build !windows,!plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"strings"
+)
+
+// syslogWriter sends log messages to the system logger with the priority
+// given by logPriority.
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func newSyslogWriter() (io.Writer, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "webhook")
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogWriter{w: w}, nil
+}
+
+// Write implements io.Writer.
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+
+	var err error
+
+	switch logPriority(msg) {
+	case logPriorityErr:
+		err = w.w.Err(msg)
+	case logPriorityWarning:
+		err = w.w.Warning(msg)
+	default:
+		err = w.w.Info(msg)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
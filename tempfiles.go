@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// commandFilePrefix starts the names of the files written for commands, so
+// they can be found by sweepCommandFiles.
+const commandFilePrefix = "webhook-"
+
+// staleCommandFileAge is the age at which files of hooks deleting them once
+// the command exited are considered left behind, ie. by a crash.
+const staleCommandFileAge = 24 * time.Hour
+
+// createCommandFile creates the file passing the file parameter f of h to the
+// command, and writes its data.
+func createCommandFile(h *hook.Hook, f *hook.FileParameter) (*os.File, error) {
+	tmpfile, err := ioutil.TempFile(h.CommandWorkingDirectory, commandFilePrefix+f.EnvName+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tmpfile.Write(f.Data); err != nil {
+		tmpfile.Close()
+		return tmpfile, err
+	}
+
+	return tmpfile, tmpfile.Close()
+}
+
+// removeCommandFiles removes the files written for the command of h for the
+// request rid, as set by its file-retention.
+func removeCommandFiles(h *hook.Hook, rid string, files []hook.FileParameter) {
+	delay, remove := h.FileRetentionDelay()
+
+	for i := range files {
+		if files[i].File == nil {
+			continue
+		}
+
+		name := files[i].File.Name()
+
+		switch {
+		case !remove:
+			log.Printf("[%s] keeping file %s\n", rid, name)
+		case delay > 0:
+			log.Printf("[%s] removing file %s in %s\n", rid, name, delay)
+			time.AfterFunc(delay, func() { removeCommandFile(rid, name) })
+		default:
+			removeCommandFile(rid, name)
+		}
+	}
+}
+
+func removeCommandFile(rid, name string) {
+	log.Printf("[%s] removing file %s\n", rid, name)
+
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		log.Printf("[%s] error removing file %s [%s]", rid, name, err)
+	}
+}
+
+// sweepCommandFiles removes the files written for the commands of hooks that
+// outlived their file-retention, ie. because webhook was stopped before
+// removing them, or crashed.  Files of hooks deleting them once the command
+// exited are removed after staleCommandFileAge.
+func sweepCommandFiles(hooks []hook.Hook) {
+	now := time.Now()
+
+	for i := range hooks {
+		h := &hooks[i]
+
+		delay, remove := h.FileRetentionDelay()
+		if !remove {
+			continue
+		}
+
+		if delay == 0 {
+			delay = staleCommandFileAge
+		}
+
+		dir := h.CommandWorkingDirectory
+		if dir == "" {
+			dir = os.TempDir()
+		}
+
+		for _, env := range h.FileEnvNames() {
+			matches, _ := filepath.Glob(filepath.Join(dir, commandFilePrefix+env+"-*"))
+
+			for _, name := range matches {
+				fi, err := os.Lstat(name)
+				if err != nil || !fi.Mode().IsRegular() || now.Sub(fi.ModTime()) < delay {
+					continue
+				}
+
+				log.Printf("removing stale file %s of hook %s\n", name, h.ID)
+
+				if err := os.Remove(name); err != nil {
+					log.Printf("error removing stale file %s: %s", name, err)
+				}
+			}
+		}
+	}
+}
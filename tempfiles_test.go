@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestCommandFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		retention string
+		kept      bool
+	}{
+		{"", false},
+		{"keep", true},
+	} {
+		h := &hook.Hook{ID: "files", CommandWorkingDirectory: dir, FileRetention: tt.retention}
+
+		files := []hook.FileParameter{{EnvName: "HOOK_DATA", Data: []byte("data")}}
+
+		f, err := createCommandFile(h, &files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[0].File = f
+
+		if name := filepath.Base(f.Name()); name[:len("webhook-HOOK_DATA-")] != "webhook-HOOK_DATA-" {
+			t.Errorf("unexpected file name %s", name)
+		}
+
+		removeCommandFiles(h, "abc", files)
+
+		if _, err := os.Stat(f.Name()); (err == nil) != tt.kept {
+			t.Errorf("file-retention %q: expected kept %v, got error %v", tt.retention, tt.kept, err)
+		}
+	}
+}
+
+func TestSweepCommandFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-sweep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-2 * time.Hour)
+
+	create := func(name string, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	stale := create("webhook-HOOK_A-1", old)
+	fresh := create("webhook-HOOK_A-2", time.Now())
+	kept := create("webhook-HOOK_B-1", old)
+	other := create("HOOK_A-1", old)
+
+	sweepCommandFiles([]hook.Hook{
+		{ID: "a", CommandWorkingDirectory: dir, FileRetention: "1h", PassFileToCommand: []hook.Argument{{Source: "payload", Name: "a"}}},
+		{ID: "b", CommandWorkingDirectory: dir, FileRetention: "keep", PassFileToCommand: []hook.Argument{{Source: "payload", Name: "b"}}},
+	})
+
+	for _, tt := range []struct {
+		path   string
+		exists bool
+	}{
+		{stale, false},
+		{fresh, true},
+		{kept, true},
+		{other, true},
+	} {
+		if _, err := os.Stat(tt.path); (err == nil) != tt.exists {
+			t.Errorf("%s: expected exists %v, got error %v", filepath.Base(tt.path), tt.exists, err)
+		}
+	}
+}
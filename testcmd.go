@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/adnanh/webhook/internal/fixtures"
+	"github.com/adnanh/webhook/internal/hook"
+	"github.com/adnanh/webhook/internal/middleware"
+	"github.com/gorilla/mux"
+)
+
+// testCommand implements the "test" subcommand, which replays a saved request
+// through the regular request handling without starting the server.
+func testCommand(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+
+	var files hook.HooksFiles
+	fs.Var(&files, "hooks", "path to the json file containing defined hooks, use multiple times to load from different files")
+
+	id := fs.String("hook", "", "ID of the hook to send the request to; defaults to the id in the request file")
+	requestFile := fs.String("request", "", `path to the json file describing the request, with "method", "headers", "query" and "body" fields; use "-" for stdin`)
+	fixture := fs.String("fixture", "", "name of a sample provider request to send instead of -request, ie. github-push")
+	listFixtures := fs.Bool("list-fixtures", false, "list the sample provider requests and exit")
+	secret := fs.String("secret", "", "secret used to sign the -fixture request like the provider does")
+	tmpl := fs.Bool("template", false, "parse hooks file as a Go template")
+	dryRun := fs.Bool("dry-run", false, "report what would be executed instead of running the command")
+	verbose := fs.Bool("verbose", false, "show the webhook log on stderr")
+
+	fs.Parse(args)
+
+	if *listFixtures {
+		writeFixtures(os.Stdout)
+		return 0
+	}
+
+	if (*requestFile == "") == (*fixture == "") {
+		fmt.Fprintln(os.Stderr, "error: one of -request and -fixture is required")
+		return 2
+	}
+
+	if len(files) == 0 {
+		files = append(files, "hooks.json")
+	}
+
+	log.SetPrefix("[webhook] ")
+	log.SetOutput(ioutil.Discard)
+	if *verbose {
+		log.SetOutput(os.Stderr)
+	}
+
+	var tr TriggerArgs
+
+	if *fixture != "" {
+		f := fixtures.Get(*fixture)
+		if f == nil {
+			fmt.Fprintf(os.Stderr, "error: unknown fixture %q; see -list-fixtures\n", *fixture)
+			return 2
+		}
+
+		tr = fixtureRequest(f, *secret)
+	} else if err := readTestRequest(*requestFile, &tr); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading request %s: %s\n", *requestFile, err)
+		return 2
+	}
+
+	if *id != "" {
+		tr.ID = *id
+	}
+
+	for _, file := range files {
+		hooks := hook.Hooks{}
+
+		if err := hooks.LoadFromFile(file, *tmpl); err != nil {
+			fmt.Fprintf(os.Stderr, "error loading hooks from %s: %s\n", file, err)
+			return 2
+		}
+
+		loadedHooksFromFiles[file] = hooks
+	}
+
+	if *dryRun {
+		// Dry runs require the admin token, which only this process knows.
+		b := make([]byte, 16)
+		rand.Read(b)
+		*adminToken = hex.EncodeToString(b)
+
+		if tr.Headers == nil {
+			tr.Headers = make(map[string]string)
+		}
+		if tr.Query == nil {
+			tr.Query = make(map[string]string)
+		}
+
+		tr.Headers["Authorization"] = "Bearer " + *adminToken
+		tr.Query["dry-run"] = "true"
+	}
+
+	r := mux.NewRouter()
+	r.Use(middleware.RequestID())
+	r.HandleFunc(makeRoutePattern(hooksURLPrefix), hookHandler)
+
+	var reply TriggerReply
+
+	if err := triggerHook(r, &tr, &reply); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 2
+	}
+
+	// Wait for commands of hooks that don't capture their output.
+	running.Wait()
+
+	writeTestReply(os.Stdout, &reply)
+
+	if reply.Status >= 400 {
+		return 1
+	}
+
+	return 0
+}
+
+// readTestRequest decodes the request file path, or stdin if path is "-",
+// into tr.
+func readTestRequest(path string, tr *TriggerArgs) error {
+	var (
+		data []byte
+		err  error
+	)
+
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, tr)
+}
+
+// fixtureRequest returns the request of the fixture f, signed with secret if
+// it isn't empty.
+func fixtureRequest(f *fixtures.Fixture, secret string) TriggerArgs {
+	headers, body := f.Request(secret)
+
+	return TriggerArgs{Method: f.Method, Headers: headers, Body: body}
+}
+
+// writeFixtures writes the names and descriptions of the fixtures to w.
+func writeFixtures(w io.Writer) {
+	for _, f := range fixtures.List() {
+		signed := ""
+		if f.Signed() {
+			signed = " (signed with -secret)"
+		}
+
+		fmt.Fprintf(w, "%-22s %s%s\n", f.Name, f.Description, signed)
+	}
+}
+
+// writeTestReply writes the response status, headers and body to w.
+func writeTestReply(w io.Writer, reply *TriggerReply) {
+	fmt.Fprintf(w, "HTTP %d\n", reply.Status)
+
+	names := make([]string, 0, len(reply.Headers))
+	for k := range reply.Headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	for _, k := range names {
+		fmt.Fprintf(w, "%s: %s\n", k, reply.Headers[k])
+	}
+
+	fmt.Fprintf(w, "\n%s\n", reply.Body)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestTestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(h map[string]hook.Hooks) { loadedHooksFromFiles = h }(loadedHooksFromFiles)
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+
+	hooksFile := filepath.Join(dir, "hooks.json")
+	requestFile := filepath.Join(dir, "request.json")
+
+	if err := ioutil.WriteFile(hooksFile, []byte(`[{"id": "deploy", "execute-command": "/bin/true", "success-http-response-code": 201}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(requestFile, []byte(`{"id": "deploy", "method": "POST", "body": "{}"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := testCommand([]string{"-hooks", hooksFile, "-request", requestFile}); code != 0 {
+		t.Errorf("expected exit status 0, got %d", code)
+	}
+
+	if code := testCommand([]string{"-hooks", hooksFile, "-request", requestFile, "-hook", "missing"}); code != 1 {
+		t.Errorf("expected exit status 1 for a missing hook, got %d", code)
+	}
+}
+
+func TestTestCommandFixture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(h map[string]hook.Hooks) { loadedHooksFromFiles = h }(loadedHooksFromFiles)
+	loadedHooksFromFiles = make(map[string]hook.Hooks)
+
+	hooksFile := filepath.Join(dir, "hooks.json")
+
+	if err := ioutil.WriteFile(hooksFile, []byte(`[{
+		"id": "deploy",
+		"execute-command": "/bin/true",
+		"trigger-rule-mismatch-http-response-code": 403,
+		"trigger-rule": {"and": [
+			{"match": {"type": "payload-hmac-sha256", "secret": "s3cret", "parameter": {"source": "header", "name": "X-Hub-Signature-256"}}},
+			{"match": {"type": "value", "value": "refs/heads/main", "parameter": {"source": "payload", "name": "ref"}}}
+		]}
+	}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := testCommand([]string{"-hooks", hooksFile, "-hook", "deploy", "-fixture", "github-push", "-secret", "s3cret"}); code != 0 {
+		t.Errorf("expected exit status 0 for the signed fixture, got %d", code)
+	}
+
+	if code := testCommand([]string{"-hooks", hooksFile, "-hook", "deploy", "-fixture", "github-push"}); code != 1 {
+		t.Errorf("expected exit status 1 for the unsigned fixture, got %d", code)
+	}
+
+	if code := testCommand([]string{"-hooks", hooksFile, "-hook", "deploy", "-fixture", "missing"}); code != 2 {
+		t.Errorf("expected exit status 2 for an unknown fixture, got %d", code)
+	}
+}
+
+func TestWriteTestReply(t *testing.T) {
+	var buf bytes.Buffer
+
+	writeTestReply(&buf, &TriggerReply{
+		Status:  201,
+		Headers: map[string]string{"X-B": "2", "X-A": "1"},
+		Body:    "ok",
+	})
+
+	expect := "HTTP 201\nX-A: 1\nX-B: 2\n\nok\n"
+	if buf.String() != expect {
+		t.Errorf("expected %q, got %q", expect, buf.String())
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/adnanh/webhook/internal/fixtures"
+	"github.com/adnanh/webhook/internal/hook"
+	"github.com/gorilla/mux"
+)
+
+// testModePrefix is the URL path under which the test mode endpoints are
+// served.
+const testModePrefix = "/_test"
+
+// testRecorder records the executions requested while running in test mode.
+// Unlike executionHistory, it is unbounded until reset.
+type testRecorder struct {
+	mu    sync.Mutex
+	items []Execution
+}
+
+// Add records e.
+func (t *testRecorder) Add(e Execution) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.items = append(t.items, e)
+}
+
+// List returns the recorded executions in the order they were requested. If
+// hookID is not empty, only executions of that hook are returned.
+func (t *testRecorder) List(hookID string) []Execution {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]Execution, 0, len(t.items))
+	for _, e := range t.items {
+		if hookID == "" || e.HookID == hookID {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// Reset discards all recorded executions.
+func (t *testRecorder) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.items = nil
+}
+
+// recordTestExecution records the execution of h as requested by r instead of
+// running the command.
+func recordTestExecution(h *hook.Hook, r *hook.Request, cmdPath string, args, envs []string) string {
+	testExecutions.Add(Execution{
+		RequestID: r.ID,
+		HookID:    h.ID,
+		Command:   cmdPath,
+		Args:      args,
+		Env:       envs,
+		Started:   hook.Now(),
+	})
+
+	return ""
+}
+
+// registerTestModeRoutes adds the test mode endpoints to r:
+//
+//	GET    /_test/executions  recorded executions, optionally filtered by ?hook=
+//	DELETE /_test/executions  discard recorded executions
+//	GET    /_test/assert      check recorded executions, see testAssert
+//
+//	GET  /_test/fixtures            sample provider requests
+//	POST /_test/fixtures/{name}/{id}  send the sample request name to the hook
+//	                                  id, signed with ?secret= if given
+func registerTestModeRoutes(r *mux.Router) {
+	r.HandleFunc(testModePrefix+"/executions", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodDelete {
+			testExecutions.Reset()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, testExecutions.List(req.URL.Query().Get("hook")))
+	}).Methods(http.MethodGet, http.MethodDelete)
+
+	r.HandleFunc(testModePrefix+"/assert", func(w http.ResponseWriter, req *http.Request) {
+		if err := testAssert(req); err != nil {
+			w.WriteHeader(http.StatusExpectationFailed)
+			fmt.Fprintln(w, err)
+			return
+		}
+
+		fmt.Fprintln(w, "OK")
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc(testModePrefix+"/fixtures", func(w http.ResponseWriter, req *http.Request) {
+		type fixture struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Signed      bool   `json:"signed"`
+		}
+
+		var res []fixture
+		for _, f := range fixtures.List() {
+			res = append(res, fixture{f.Name, f.Description, f.Signed()})
+		}
+
+		writeJSON(w, res)
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc(testModePrefix+"/fixtures/{name}/{id:.*}", func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		f := fixtures.Get(vars["name"])
+		if f == nil {
+			http.Error(w, "Fixture not found.", http.StatusNotFound)
+			return
+		}
+
+		tr := fixtureRequest(f, req.URL.Query().Get("secret"))
+		tr.ID = vars["id"]
+
+		var reply TriggerReply
+		if err := triggerHook(r, &tr, &reply); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, reply)
+	}).Methods(http.MethodPost)
+}
+
+// testAssert checks the recorded executions against the query parameters of
+// req.  Executions are selected by "hook" and filtered by "arg" and "env",
+// which must each be present in the execution's arguments or environment.
+// The number of matching executions must equal "count" if given, or be at
+// least one otherwise.
+func testAssert(req *http.Request) error {
+	q := req.URL.Query()
+
+	var n int
+
+	for _, e := range testExecutions.List(q.Get("hook")) {
+		if containsAll(e.Args, q["arg"]) && containsAll(e.Env, q["env"]) {
+			n++
+		}
+	}
+
+	if s := q.Get("count"); s != "" {
+		count, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid count %q", s)
+		}
+
+		if n != count {
+			return fmt.Errorf("expected %d matching executions, got %d", count, n)
+		}
+
+		return nil
+	}
+
+	if n == 0 {
+		return fmt.Errorf("no matching executions")
+	}
+
+	return nil
+}
+
+// containsAll reports whether every element of want is present in have.
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		var found bool
+
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
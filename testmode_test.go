@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTestAssert(t *testing.T) {
+	defer testExecutions.Reset()
+
+	testExecutions.Add(Execution{HookID: "a", Args: []string{"cmd", "main"}, Env: []string{"REF=main"}})
+	testExecutions.Add(Execution{HookID: "a", Args: []string{"cmd", "dev"}})
+	testExecutions.Add(Execution{HookID: "b"})
+
+	for _, tt := range []struct {
+		query string
+		ok    bool
+	}{
+		{"", true},
+		{"count=3", true},
+		{"hook=a&count=2", true},
+		{"hook=a&arg=main&env=REF=main&count=1", true},
+		{"hook=a&arg=dev&env=REF=main", false},
+		{"hook=c", false},
+		{"hook=c&count=0", true},
+		{"count=x", false},
+	} {
+		err := testAssert(httptest.NewRequest("GET", "/_test/assert?"+tt.query, nil))
+		if (err == nil) != tt.ok {
+			t.Errorf("testAssert(%q): expected ok %v, got error %v", tt.query, tt.ok, err)
+		}
+	}
+}
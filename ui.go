@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// adminUIPath is the URL path of the dashboard served with -admin-ui.
+const adminUIPath = adminPrefix + "/ui"
+
+// registerAdminUI adds the dashboard to r.  The page itself holds no data and
+// is served without authentication; it asks for the admin token and calls the
+// admin endpoints with it, so it must be registered before them.
+func registerAdminUI(r *mux.Router) {
+	r.HandleFunc(adminUIPath, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Cache-Control", "no-store")
+
+		w.Write([]byte(adminUIPage))
+	}).Methods(http.MethodGet)
+}
+
+// adminUIPage is the single-page dashboard.  It stores the admin token in the
+// session storage of the browser tab only.
+const adminUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>webhook</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0 2em 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f4; }
+.failed { color: #b00; }
+.muted { color: #888; }
+pre { white-space: pre-wrap; margin: .3em 0; max-height: 20em; overflow: auto; background: #f8f8f8; padding: .5em; }
+#error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>webhook</h1>
+<p id="error"></p>
+<p><button id="refresh">Refresh</button> <button id="logout">Forget token</button></p>
+
+<h2>Hooks</h2>
+<table>
+<thead><tr><th>ID</th><th>Group</th><th>URL</th><th>Executions</th><th>Failure rate</th><th></th></tr></thead>
+<tbody id="hooks"></tbody>
+</table>
+
+<h2>Recent executions</h2>
+<table>
+<thead><tr><th>Started</th><th>Hook</th><th>Request</th><th>Duration</th><th>Exit code</th><th>Output</th></tr></thead>
+<tbody id="executions"></tbody>
+</table>
+
+<script>
+"use strict";
+
+const base = location.pathname.replace(/\/ui$/, "");
+
+function token() {
+	let t = sessionStorage.getItem("webhook-admin-token");
+	if (!t) {
+		t = prompt("Admin token");
+		if (t) {
+			sessionStorage.setItem("webhook-admin-token", t);
+		}
+	}
+	return t;
+}
+
+async function api(path, options) {
+	options = options || {};
+	options.headers = Object.assign({"Authorization": "Bearer " + token()}, options.headers);
+
+	const res = await fetch(base + path, options);
+	if (res.status === 401) {
+		sessionStorage.removeItem("webhook-admin-token");
+		throw new Error("Invalid admin token; refresh to enter it again.");
+	}
+	if (!res.ok) {
+		throw new Error(path + ": " + res.status + " " + (await res.text()));
+	}
+	return res.json();
+}
+
+function cell(row, text, className) {
+	const td = row.insertCell();
+	td.textContent = text === undefined || text === null ? "" : text;
+	if (className) {
+		td.className = className;
+	}
+	return td;
+}
+
+function seconds(ns) {
+	return (ns / 1e9).toFixed(2) + "s";
+}
+
+async function testFire(id) {
+	const body = prompt("Request body to send to " + id, "{}");
+	if (body === null) {
+		return;
+	}
+
+	try {
+		const reply = await api("/hooks/" + encodeURIComponent(id) + "/trigger", {
+			method: "POST",
+			headers: {"Content-Type": "application/json"},
+			body: JSON.stringify({headers: {"Content-Type": "application/json"}, body: body}),
+		});
+		alert(id + ": " + reply.status + "\n\n" + reply.body);
+	} catch (e) {
+		alert(e.message);
+	}
+	load();
+}
+
+async function load() {
+	const error = document.getElementById("error");
+	error.textContent = "";
+
+	try {
+		const [hooks, usage, executions] = await Promise.all([api("/hooks"), api("/usage"), api("/executions?limit=100")]);
+
+		const byHook = {};
+		for (const u of usage) {
+			byHook[u["hook-id"]] = u;
+		}
+
+		const tbody = document.getElementById("hooks");
+		tbody.textContent = "";
+		for (const h of hooks) {
+			const row = tbody.insertRow();
+			const u = byHook[h.id] || {executions: 0, failures: 0};
+			cell(row, h.id + (h.disabled ? " (disabled)" : ""), h.disabled ? "muted" : "");
+			cell(row, h.group);
+			cell(row, h.url);
+			cell(row, u.executions);
+			cell(row, u.executions ? (100 * u.failures / u.executions).toFixed(1) + "%" : "-", u.failures ? "failed" : "");
+
+			const button = document.createElement("button");
+			button.textContent = "Test fire";
+			button.onclick = () => testFire(h.id);
+			cell(row, "").appendChild(button);
+		}
+
+		const ebody = document.getElementById("executions");
+		ebody.textContent = "";
+		for (const e of executions) {
+			const row = ebody.insertRow();
+			const failed = !!e.error;
+			cell(row, new Date(e.started).toLocaleString());
+			cell(row, e["hook-id"]);
+			cell(row, e["request-id"]);
+			cell(row, seconds(e.duration));
+			cell(row, e["exit-code"] + (e.error ? " (" + e.error + ")" : ""), failed ? "failed" : "");
+
+			const details = document.createElement("details");
+			const summary = document.createElement("summary");
+			summary.textContent = (e.output || "").split("\n")[0].slice(0, 80) || "(no output)";
+			const pre = document.createElement("pre");
+			pre.textContent = e.output || "";
+			details.append(summary, pre);
+			cell(row, "").appendChild(details);
+		}
+	} catch (e) {
+		error.textContent = e.message;
+	}
+}
+
+document.getElementById("refresh").onclick = load;
+document.getElementById("logout").onclick = () => {
+	sessionStorage.removeItem("webhook-admin-token");
+	location.reload();
+};
+
+load();
+</script>
+</body>
+</html>
+`
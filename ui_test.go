@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAdminUI(t *testing.T) {
+	r := mux.NewRouter()
+	registerAdminUI(r)
+	registerAdminRoutes(r, "s3cret")
+
+	for _, tt := range []struct {
+		path   string
+		auth   string
+		status int
+	}{
+		{adminUIPath, "", http.StatusOK},
+		{adminPrefix + "/hooks", "", http.StatusUnauthorized},
+		{adminPrefix + "/hooks", "Bearer s3cret", http.StatusOK},
+		{adminPrefix + "/executions?limit=10", "Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rec.Code)
+		}
+
+		if tt.path == adminUIPath && !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: unexpected Content-Type %q", tt.path, rec.Header().Get("Content-Type"))
+		}
+	}
+}
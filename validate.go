@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+// validateCommand implements the "validate" subcommand.
+func validateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+
+	var files hook.HooksFiles
+	fs.Var(&files, "hooks", "path to the json file containing defined hooks, use multiple times to check multiple files")
+
+	tmpl := fs.Bool("template", false, "parse hooks file as a Go template")
+	format := fs.String("format", "text", `output format ("text" or "json")`)
+	config := fs.String("config", "", "path to a config file to check, along with the hooks files it lists")
+
+	fs.Parse(args)
+
+	var findings []lintFinding
+
+	// The config file sets the server flags, as it would at startup.
+	if *config != "" {
+		if err := applyConfigFile(flag.CommandLine, *config); err != nil {
+			findings = append(findings, lintFinding{File: *config, Check: "config", Message: err.Error()})
+		} else {
+			files = append(files, hooksFiles...)
+			*tmpl = *tmpl || *asTemplate
+		}
+	}
+
+	// Like at startup, hooks.json is the default without hooks sources.
+	if len(files) == 0 && len(findings) == 0 && *hooksKV == "" && *kubeConfigMaps == "" {
+		files = append(files, "hooks.json")
+	}
+
+	seen := make(map[string]string)
+	paths := make(map[string]string)
+
+	for _, file := range files {
+		hooks := hook.Hooks{}
+
+		if err := hooks.LoadFromFile(file, *tmpl, hook.StrictOption()); err != nil {
+			findings = append(findings, loadFindings(file, err)...)
+			continue
+		}
+
+		for i := range hooks {
+			id := hooks[i].ID
+			if id == "" {
+				continue
+			}
+
+			if other, ok := seen[id]; ok {
+				findings = append(findings, lintFinding{File: file, Hook: id, Check: "duplicate-id", Message: fmt.Sprintf("hook ID is already defined in %s", other)})
+				continue
+			}
+
+			seen[id] = file
+
+			if path := hooks[i].IncomingPath; path != "" {
+				if other, ok := paths[path]; ok {
+					findings = append(findings, lintFinding{File: file, Hook: id, Check: "duplicate-path", Message: fmt.Sprintf("incoming-path %s is already used by %s", path, other)})
+					continue
+				}
+
+				paths[path] = id
+			}
+		}
+
+		findings = append(findings, validateHooks(file, hooks)...)
+	}
+
+	if err := writeLintFindings(os.Stdout, findings, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if len(findings) != 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// validateHooks checks the definitions of the hooks loaded from file.
+func validateHooks(file string, hooks hook.Hooks) []lintFinding {
+	var findings []lintFinding
+
+	add := func(h *hook.Hook, check, format string, a ...interface{}) {
+		findings = append(findings, lintFinding{File: file, Hook: h.ID, Check: check, Message: fmt.Sprintf(format, a...)})
+	}
+
+	for i := range hooks {
+		h := &hooks[i]
+
+		if h.ID == "" {
+			add(h, "missing-id", "hook %d has no id", i)
+		}
+
+		for _, err := range h.Validate() {
+			add(h, "invalid", "%s", err)
+		}
+
+		if h.Command() == "" || h.Kind == hook.KindProxy {
+			continue
+		}
+
+		if _, err := exec.LookPath(h.CommandPath()); err != nil {
+			add(h, "missing-command", "%s", err)
+		}
+	}
+
+	return findings
+}
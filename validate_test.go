@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/adnanh/webhook/internal/hook"
+)
+
+func TestValidateHooks(t *testing.T) {
+	hooks := hook.Hooks{
+		{ID: "ok", ExecuteCommand: "/bin/true"},
+		{ExecuteCommand: "/bin/true"},
+		{ID: "missing", ExecuteCommand: "/nonexistent/deploy.sh"},
+		{ID: "proxy", Kind: hook.KindProxy, ProxyURL: "http://localhost/"},
+		{ID: "regex", ExecuteCommand: "/bin/true", TriggerRule: &hook.Rules{
+			Match: &hook.MatchRule{Type: hook.MatchRegex, Regex: "(", Parameter: hook.Argument{Source: "payload", Name: "ref"}},
+		}},
+		{ID: "hidden", ExecuteCommand: "/bin/true", HideID: true},
+		{ID: "path", ExecuteCommand: "/bin/true", IncomingPath: "integrations/github"},
+	}
+
+	var got []string
+	for _, f := range validateHooks("hooks.json", hooks) {
+		got = append(got, f.Hook+":"+f.Check)
+	}
+
+	expect := []string{":missing-id", "missing:missing-command", "regex:invalid", "hidden:invalid", "path:invalid"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected findings %q, got %q", expect, got)
+	}
+}
+
+func TestLoadFindings(t *testing.T) {
+	err := &hook.SchemaError{Problems: []hook.SchemaProblem{
+		{Line: 3, Column: 5, Path: "[0].http-methods", Message: "expected array, got string"},
+	}}
+
+	var b strings.Builder
+	if err := writeLintFindings(&b, loadFindings("hooks.yaml", err), "text"); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "hooks.yaml:3:5: schema: [0].http-methods: expected array, got string\n"; b.String() != expect {
+		t.Errorf("expected %q, got %q", expect, b.String())
+	}
+
+	if got := loadFindings("hooks.yaml", errors.New("no such file")); len(got) != 1 || got[0].Check != "load" {
+		t.Errorf("expected a load finding, got %+v", got)
+	}
+}
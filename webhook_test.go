@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -19,6 +22,8 @@ import (
 	"time"
 
 	"github.com/adnanh/webhook/internal/hook"
+	"github.com/adnanh/webhook/internal/middleware"
+	"github.com/gorilla/mux"
 )
 
 func TestStaticParams(t *testing.T) {
@@ -58,7 +63,7 @@ func TestStaticParams(t *testing.T) {
 		ID:      "test",
 		Headers: spHeaders,
 	}
-	_, err = handleHook(spHook, r)
+	_, _, err = handleHook(spHook, r)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v\n", err)
 	}
@@ -68,6 +73,132 @@ func TestStaticParams(t *testing.T) {
 	}
 }
 
+func TestHandleHookInBackgroundRetries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	b := &bytes.Buffer{}
+	log.SetOutput(b)
+	defer log.SetOutput(ioutil.Discard)
+
+	defer func(e *executionHistory) { executions = e }(executions)
+	executions = newExecutionHistory(10)
+
+	h := &hook.Hook{
+		ID:             "retried",
+		ExecuteCommand: "false",
+		Retry:          &hook.RetryRule{Attempts: 3, Delay: "1ms"},
+	}
+
+	handleHookInBackground(h, &hook.Request{ID: "test"})
+
+	if n := len(executions.List(h.ID, 0)); n != 3 {
+		t.Errorf("expected 3 executions, got %d", n)
+	}
+
+	for _, want := range []string{"attempt 1 of 3 to execute retried failed, retrying in 1ms", "attempt 2 of 3 to execute retried failed, retrying in 2ms", "giving up executing retried after 3 attempts"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected log to contain %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestResponseDeadline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	log.SetOutput(ioutil.Discard)
+
+	defer func(e *executionHistory) { executions = e }(executions)
+	executions = newExecutionHistory(10)
+
+	h := &hook.Hook{
+		ID:                   "slow",
+		ExecuteShell:         "sleep 0.3; echo done",
+		CaptureCommandOutput: true,
+		ResponseDeadline:     "50ms",
+	}
+
+	if _, _, finished, _ := handleHookWithDeadline(h, &hook.Request{ID: "job"}); finished {
+		t.Fatalf("expected the command not to finish within the deadline")
+	}
+
+	if _, ok := executions.Find("job"); ok {
+		t.Errorf("expected no execution before the command finished")
+	}
+
+	running.Wait()
+
+	if e, ok := executions.Find("job"); !ok || e.Output != "done\n" {
+		t.Errorf("expected the background execution to be recorded, got %+v", e)
+	}
+
+	h.ResponseDeadline = "5s"
+
+	if _, out, finished, err := handleHookWithDeadline(h, &hook.Request{ID: "fast"}); !finished || err != nil || out != "done\n" {
+		t.Errorf("expected the command to finish within the deadline, got %q, %v, %v", out, finished, err)
+	}
+}
+
+func TestSHA1Policy(t *testing.T) {
+	sha1Hook := func(id string, types ...string) hook.Hook {
+		and := make(hook.AndRule, len(types))
+		for i, typ := range types {
+			and[i] = hook.Rules{Match: &hook.MatchRule{Type: typ, Secret: hook.Secrets{"secret"}}}
+		}
+
+		return hook.Hook{ID: id, TriggerRule: &hook.Rules{And: &and}}
+	}
+
+	hooks := hook.Hooks{
+		sha1Hook("sha1", hook.MatchHMACSHA1),
+		sha1Hook("scalr", hook.ScalrSignature),
+		sha1Hook("both", hook.MatchHMACSHA1, hook.MatchHMACSHA256),
+		sha1Hook("sha256", hook.MatchHMACSHA256),
+		{ID: "plain"},
+	}
+
+	defer func(p string) { *sha1Policy = p }(*sha1Policy)
+
+	b := &bytes.Buffer{}
+	log.SetOutput(b)
+	defer log.SetOutput(ioutil.Discard)
+
+	for _, tt := range []struct {
+		policy string
+		expect []string
+		log    string
+	}{
+		{"allow", []string{"sha1", "scalr", "both", "sha256", "plain"}, ""},
+		{"warn", []string{"sha1", "scalr", "both", "sha256", "plain"}, "warn: hook(s) in hooks.json rely on SHA-1 only for signature verification, which is deprecated; use payload-hmac-sha256 or payload-hmac-sha512: sha1, scalr"},
+		{"refuse", []string{"both", "sha256", "plain"}, "error: refusing hook(s) in hooks.json whose signature verification relies on SHA-1 only: sha1, scalr"},
+	} {
+		b.Reset()
+		*sha1Policy = tt.policy
+
+		res := applySHA1Policy("hooks.json", hooks)
+
+		ids := make([]string, len(res))
+		for i := range res {
+			ids[i] = res[i].ID
+		}
+
+		if strings.Join(ids, " ") != strings.Join(tt.expect, " ") {
+			t.Errorf("%s: expected %v, got %v", tt.policy, tt.expect, ids)
+		}
+
+		if !strings.Contains(b.String(), tt.log) || (tt.log == "" && b.Len() != 0) {
+			t.Errorf("%s: expected log %q, got %q", tt.policy, tt.log, b)
+		}
+	}
+
+	if len(hooks) != 5 {
+		t.Errorf("expected the loaded hooks to be unchanged")
+	}
+}
+
 func TestWebhook(t *testing.T) {
 	hookecho, cleanupHookecho := buildHookecho(t)
 	defer cleanupHookecho()
@@ -165,6 +296,176 @@ func TestWebhook(t *testing.T) {
 	}
 }
 
+func TestIncomingPath(t *testing.T) {
+	defer func(h map[string]hook.Hooks) { loadedHooksFromFiles = h }(loadedHooksFromFiles)
+	loadedHooksFromFiles = map[string]hook.Hooks{"hooks.json": {
+		{ID: "github", ExecuteCommand: "/bin/true", ResponseMessage: "github", IncomingPath: "/integrations/github", HideID: true},
+		{ID: "gitlab", ExecuteCommand: "/bin/true", ResponseMessage: "gitlab", IncomingPath: "/integrations/gitlab"},
+	}}
+
+	prefix := "hooks"
+
+	r := mux.NewRouter()
+	registerIncomingPaths(r)
+	r.HandleFunc(makeRoutePattern(&prefix), hookHandler)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/integrations/github", http.StatusOK, "github"},
+		{"/hooks/github", http.StatusNotFound, "Hook not found."},
+		{"/integrations/gitlab", http.StatusOK, "gitlab"},
+		{"/hooks/gitlab", http.StatusOK, "gitlab"},
+		{"/integrations/gitea", http.StatusNotFound, "404 page not found\n"},
+	} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("POST", tt.path, strings.NewReader("{}")))
+
+		if rec.Code != tt.status || rec.Body.String() != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.path, tt.status, tt.body, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	defer func(h map[string]hook.Hooks) { loadedHooksFromFiles = h }(loadedHooksFromFiles)
+	loadedHooksFromFiles = map[string]hook.Hooks{"hooks.json": {
+		{ID: "deploy", ExecuteCommand: "/bin/true", ResponseMessage: "deploy"},
+		{ID: "github", ExecuteCommand: "/bin/true", ResponseMessage: "github", IncomingPath: "/integrations/github"},
+	}}
+
+	defer func(base, prefix string) { *basePath, *hooksURLPrefix = base, prefix }(*basePath, *hooksURLPrefix)
+	*basePath, *hooksURLPrefix = "/api/webhooks/", "/hooks/"
+
+	r := mux.NewRouter()
+	registerIncomingPaths(r)
+	r.HandleFunc(makeRoutePattern(hooksURLPrefix), hookHandler)
+
+	h := middleware.BasePath(*basePath)(r)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/api/webhooks/hooks/deploy", http.StatusOK},
+		{"/api/webhooks/integrations/github", http.StatusOK},
+		{"/hooks/deploy", http.StatusNotFound},
+		{"/api/webhookshooks/deploy", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", tt.path, strings.NewReader("{}")))
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rec.Code)
+		}
+	}
+
+	for id, expect := range map[string]string{"deploy": "/api/webhooks/hooks/deploy", "github": "/api/webhooks/integrations/github"} {
+		if url := makeHookURL(matchLoadedHook(id)); url != expect {
+			t.Errorf("expected URL %s for %s, got %s", expect, id, url)
+		}
+	}
+}
+
+func TestHookReadTimeout(t *testing.T) {
+	hookecho, cleanupHookecho := buildHookecho(t)
+	defer cleanupHookecho()
+
+	webhook, cleanupWebhookFn := buildWebhook(t)
+	defer cleanupWebhookFn()
+
+	configPath, cleanupConfigFn := genConfig(t, hookecho, "test/hooks.json.tmpl")
+	defer cleanupConfigFn()
+
+	ip, port := serverAddress(t)
+	args := []string{fmt.Sprintf("-hooks=%s", configPath), fmt.Sprintf("-ip=%s", ip), fmt.Sprintf("-port=%s", port), "-verbose"}
+
+	cmd := exec.Command(webhook, args...)
+	cmd.Env = webhookEnv()
+	cmd.Args[0] = "webhook"
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start webhook: %s", err)
+	}
+	defer killAndWait(cmd)
+
+	waitForServerReady(t, ip, port)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	// Promise a body that never fully arrives.
+	fmt.Fprintf(conn, "POST /hooks/read-timeout HTTP/1.1\r\nHost: %s\r\nContent-Type: text/plain\r\nContent-Length: 100\r\n\r\npartial", ip)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("expected status %d, got %d", http.StatusRequestTimeout, res.StatusCode)
+	}
+}
+
+// deadlineConn is a net.Conn recording the read deadlines set on it.
+type deadlineConn struct {
+	net.Conn
+	deadlines []time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return nil
+}
+
+func TestHookReadDeadline(t *testing.T) {
+	defer func(h map[string]hook.Hooks) { loadedHooksFromFiles = h }(loadedHooksFromFiles)
+	loadedHooksFromFiles = map[string]hook.Hooks{"hooks.json": {
+		{ID: "slow", ExecuteCommand: "/bin/true", ReadTimeout: "5s"},
+	}}
+
+	defer func(d time.Duration) { *readTimeout = d }(*readTimeout)
+
+	prefix := "hooks"
+
+	r := mux.NewRouter()
+	r.HandleFunc(makeRoutePattern(&prefix), hookHandler)
+
+	for _, server := range []time.Duration{0, time.Minute} {
+		*readTimeout = server
+
+		conn := &deadlineConn{}
+		req := httptest.NewRequest("POST", "/hooks/slow", strings.NewReader("{}"))
+		req = req.WithContext(context.WithValue(req.Context(), connContextKey, net.Conn(conn)))
+
+		started := time.Now()
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		if len(conn.deadlines) != 2 {
+			t.Fatalf("read-timeout %s: expected 2 read deadlines, got %v", server, conn.deadlines)
+		}
+
+		if d := conn.deadlines[0].Sub(started); d < 5*time.Second || d > 6*time.Second {
+			t.Errorf("read-timeout %s: expected the hook deadline in 5s, got %s", server, d)
+		}
+
+		restored := conn.deadlines[1]
+		if server == 0 && !restored.IsZero() {
+			t.Errorf("read-timeout %s: expected the deadline to be cleared, got %s", server, restored)
+		}
+		if d := restored.Sub(started); server != 0 && (d < server || d > server+time.Second) {
+			t.Errorf("read-timeout %s: expected the server deadline to be restored, got %s", server, d)
+		}
+	}
+}
+
 func buildHookecho(t *testing.T) (binPath string, cleanupFn func()) {
 	tmp, err := ioutil.TempDir("", "hookecho-test-")
 	if err != nil {
@@ -1144,10 +1445,19 @@ env: HOOK_head_commit.timestamp=2013-03-12T08:14:29-07:00
 	{"capture output on error with extra flag set", "capture-command-output-on-error-yes-with-extra-flag", nil, "POST", nil, "application/json", `{}`, false, http.StatusInternalServerError, `arg: exit=1
 `, ``},
 
+	// test success criteria
+	{"success criteria exit codes", "success-criteria-exit-codes", nil, "POST", nil, "application/json", `{}`, false, http.StatusOK, "arg: exit=3\n", ``},
+	{"success criteria output regex", "success-criteria-output-regex", nil, "POST", nil, "application/json", `{}`, false, http.StatusInternalServerError, "arg: exit=0\n", `(?s)command output doesn't match`},
+
 	// Check logs
 	{"static params should pass", "static-params-ok", nil, "POST", nil, "application/json", `{}`, false, http.StatusOK, "arg: passed\n", `(?s)command output: arg: passed`},
 	{"command with space logs warning", "warn-on-space", nil, "POST", nil, "application/json", `{}`, false, http.StatusInternalServerError, "Error occurred while executing the hook's command. Please check your logs for more details.", `(?s)error in exec:.*use 'pass[-]arguments[-]to[-]command' to specify args`},
 	{"unsupported content type error", "github", nil, "POST", map[string]string{"Content-Type": "nonexistent/format"}, "application/json", `{}`, false, http.StatusBadRequest, `Hook rules were not satisfied.`, `(?s)error parsing body payload due to unsupported content type header:`},
+	{"json payload detected", "detect-payload", nil, "POST", nil, "text/plain", `{"ref": "main"}`, false, http.StatusOK, "arg: main\n", `(?s)sent with Content-Type "text/plain" detected as application/json`},
+	{"form payload detected", "detect-payload", nil, "POST", nil, "application/octet-stream", `ref=main&x=1`, false, http.StatusOK, "arg: main\n", `(?s)detected as application/x-www-form-urlencoded`},
+	{"group member", "grouped/echo", nil, "POST", nil, "application/json", `{"ref": "main"}`, false, http.StatusOK, "arg: main\nenv: HOOK_GROUP=grouped\n", ``},
+	{"group trigger rule", "grouped/echo", nil, "POST", nil, "application/json", `{"ref": "dev"}`, false, http.StatusOK, "Hook rules were not satisfied.", ``},
+	{"ack first", "ack-first", nil, "POST", nil, "application/json", `{}`, false, http.StatusAccepted, "accepted", `(?s)acknowledging ack-first before executing its command`},
 }
 
 // buffer provides a concurrency-safe bytes.Buffer to tests above.
@@ -1179,3 +1489,57 @@ func (b *buffer) Reset() {
 	defer b.m.Unlock()
 	b.b.Reset()
 }
+
+func TestReplayProtectionRetry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	replay := &hook.ReplayProtection{Keys: []hook.Argument{{Source: hook.SourceHeader, Name: "X-Delivery"}}}
+
+	defer func(h map[string]hook.Hooks) { loadedHooksFromFiles = h }(loadedHooksFromFiles)
+	loadedHooksFromFiles = map[string]hook.Hooks{"hooks.json": {
+		{ID: "sync-fail", ExecuteCommand: "/bin/false", CaptureCommandOutput: true, ReplayProtection: replay},
+		{ID: "sync-ok", ExecuteCommand: "/bin/true", CaptureCommandOutput: true, ReplayProtection: replay},
+		{ID: "deadline-fail", ExecuteCommand: "/bin/sh", PassArgumentsToCommand: []hook.Argument{{Source: "string", Name: "-c"}, {Source: "string", Name: "sleep 0.2; exit 1"}}, CaptureCommandOutput: true, ResponseDeadline: "10ms", ReplayProtection: replay},
+		{ID: "background-fail", ExecuteCommand: "/bin/false", ReplayProtection: replay},
+		{ID: "background-ok", ExecuteCommand: "/bin/true", ReplayProtection: replay},
+	}}
+
+	prefix := "hooks"
+
+	r := mux.NewRouter()
+	r.HandleFunc(makeRoutePattern(&prefix), hookHandler)
+
+	deliver := func(id string) int {
+		req := httptest.NewRequest("POST", "/hooks/"+id, strings.NewReader("{}"))
+		req.Header.Set("X-Delivery", "d-"+id)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		running.Wait()
+
+		return rec.Code
+	}
+
+	for _, tt := range []struct {
+		id     string
+		status int
+		retry  int
+	}{
+		{"sync-fail", http.StatusInternalServerError, http.StatusInternalServerError},
+		{"sync-ok", http.StatusOK, http.StatusConflict},
+		{"deadline-fail", http.StatusAccepted, http.StatusAccepted},
+		{"background-fail", http.StatusOK, http.StatusOK},
+		{"background-ok", http.StatusOK, http.StatusConflict},
+	} {
+		if status := deliver(tt.id); status != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.id, tt.status, status)
+		}
+
+		if status := deliver(tt.id); status != tt.retry {
+			t.Errorf("%s: expected status %d for the retried delivery, got %d", tt.id, tt.retry, status)
+		}
+	}
+}